@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/database"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// runCommand runs a maintenance command instead of the API server
+func runCommand(name string, args []string) error {
+	switch name {
+	case "migrate-keys":
+		return migrateKeys(args)
+	case "migrate-storage":
+		return migrateStorage(args)
+	case "worker":
+		return runWorker(args)
+	case "api-keys":
+		return manageAPIKeys(args)
+	case "organizations":
+		return manageOrganizations(args)
+	default:
+		return fmt.Errorf("unknown command %q (available: migrate-keys, migrate-storage, worker, api-keys, organizations)", name)
+	}
+}
+
+// migrateKeys moves the stored objects to the layout of STORAGE_KEY_TEMPLATE and updates their medias
+func migrateKeys(args []string) error {
+	flags := flag.NewFlagSet("migrate-keys", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print the new object keys without moving any object")
+	flags.Parse(args)
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	storageOptions, err := services.LoadStorageOptions("STORAGE")
+	if err != nil {
+		return err
+	}
+	storageService, err := services.NewStorage(context.Background(), storageOptions)
+	if err != nil {
+		return err
+	}
+
+	migration := services.NewObjectKeyMigration(repositories.NewMediaRepository(db), storageService, storageOptions.KeyTemplate)
+	result, err := migration.Run(context.Background(), *dryRun)
+	log.Printf("%d media(s) moved, %d already following %s, %d failed\n", result.Moved, result.Skipped, storageOptions.KeyTemplate, result.Failed)
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("unable to move %d media(s)", result.Failed)
+	}
+	return nil
+}
+
+// migrateStorage copies the objects of every media to another storage, configured by environment variables
+// with another prefix (example: TARGET_STORAGE_DRIVER, TARGET_STORAGE_ENDPOINT...), and points medias to the copies
+func migrateStorage(args []string) error {
+	flags := flag.NewFlagSet("migrate-storage", flag.ExitOnError)
+	from := flags.String("from", "STORAGE", "environment variables prefix of the source storage")
+	to := flags.String("to", "TARGET_STORAGE", "environment variables prefix of the target storage")
+	name := flags.String("name", "", "name of the migration, used to resume it (default: <source>-><target>)")
+	workers := flags.Int("workers", 4, "number of medias copied concurrently")
+	keyTemplate := flags.String("key-template", "", "layout of the object keys in the target storage (default: keep the keys)")
+	flags.Parse(args)
+
+	sourceOptions, err := services.LoadStorageOptions(*from)
+	if err != nil {
+		return err
+	}
+	targetOptions, err := services.LoadStorageOptions(*to)
+	if err != nil {
+		return err
+	}
+	options := services.StorageMigrationOptions{Name: *name, Workers: *workers}
+	if options.Name == "" {
+		options.Name = fmt.Sprintf("%s:%s/%s->%s:%s/%s", sourceOptions.Driver, sourceOptions.Endpoint, sourceOptions.BucketName,
+			targetOptions.Driver, targetOptions.Endpoint, targetOptions.BucketName)
+	}
+	if *keyTemplate != "" {
+		if options.KeyTemplate, err = services.ParseObjectKeyTemplate(*keyTemplate); err != nil {
+			return err
+		}
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	source, err := services.NewStorage(context.Background(), sourceOptions)
+	if err != nil {
+		return err
+	}
+	target, err := services.NewStorage(context.Background(), targetOptions)
+	if err != nil {
+		return err
+	}
+
+	migration := services.NewStorageMigration(repositories.NewMediaRepository(db), repositories.NewStorageMigrationRepository(db), source, target, options)
+	log.Printf("running storage migration %s\n", options.Name)
+	result, err := migration.Run(context.Background())
+	log.Printf("%d media(s) copied, %d already copied, %d failed\n", result.Copied, result.Skipped, result.Failed)
+	if err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("unable to copy %d media(s), run the migration again to retry", result.Failed)
+	}
+	return nil
+}
+
+// runWorker runs the jobs of the queue until the process is interrupted, next to API servers started with JOB_WORKERS=0
+func runWorker(args []string) error {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	workers := flags.Int("workers", 0, "number of jobs run concurrently (default: JOB_WORKERS)")
+	flags.Parse(args)
+
+	jobQueueOptions, err := services.LoadJobQueueOptions()
+	if err != nil {
+		return err
+	}
+	if *workers > 0 {
+		jobQueueOptions.Workers = *workers
+	}
+	if jobQueueOptions.Workers == 0 {
+		return fmt.Errorf("the worker needs at least one worker: set JOB_WORKERS or -workers")
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	mediaRepository := repositories.NewMediaRepository(db)
+	storageService, _, replicationService, err := newStorage(mediaRepository)
+	if err != nil {
+		return err
+	}
+	if err := services.ConfigureImageDecoding(); err != nil {
+		return err
+	}
+	renditionPresets, err := services.LoadRenditionPresets()
+	if err != nil {
+		return err
+	}
+	renditionService := services.NewRenditionService(mediaRepository, storageService, renditionPresets)
+	jobQueue := services.NewJobQueue(repositories.NewJobRepository(db), jobQueueOptions)
+	mediaService := services.NewMediaService(mediaRepository, repositories.NewTagRepository(db), storageService, renditionService, replicationService, jobQueue, nil, nil)
+	jobQueue.Handle(services.JobProcessMedia, mediaService.HandleProcessMedia)
+
+	// no job is started once the process is interrupted, running jobs are given the time to complete
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("running %d worker(s)\n", jobQueue.Workers())
+	jobQueue.Run(ctx)
+	log.Println("workers stopped")
+	return nil
+}
+
+// manageAPIKeys issues, lists and revokes the API keys of an organization (example: api-keys issue -name admin
+// -scopes keys:admin -organization fc-nantes), the first key granting access to the /api/keys endpoints is issued this way
+func manageAPIKeys(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing api-keys action (available: issue, list, revoke)")
+	}
+	flags := flag.NewFlagSet("api-keys "+args[0], flag.ExitOnError)
+	name := flags.String("name", "", "name of the key to issue")
+	scopes := flags.String("scopes", "", "comma separated scopes of the key to issue: "+strings.Join(services.Scopes, ", "))
+	id := flags.Uint("id", 0, "id of the key to revoke")
+	slug := flags.String("organization", models.DefaultOrganization, "slug of the organization of the keys")
+	flags.Parse(args[1:])
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	organization, err := services.NewOrganizationService(repositories.NewOrganizationRepository(db)).GetOrganization(*slug)
+	if err != nil {
+		return err
+	}
+	ctx := repositories.WithTenant(context.Background(), organization.ID)
+	service := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+	switch args[0] {
+	case "issue":
+		parsedScopes, err := services.ParseScopes(*scopes)
+		if err != nil {
+			return err
+		}
+		key, apiKey, err := service.Issue(ctx, *name, parsedScopes)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("API key %d issued, it is not shown again:\n%s\n", apiKey.ID, key)
+	case "list":
+		keys, err := service.GetAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tNAME\tPREFIX\tSCOPES\tLAST USED\tREVOKED")
+		for _, key := range keys {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","),
+				formatTime(key.LastUsedAt), formatTime(key.RevokedAt))
+		}
+		return writer.Flush()
+	case "revoke":
+		if err := service.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Printf("API key %d revoked\n", *id)
+	default:
+		return fmt.Errorf("unknown api-keys action %q (available: issue, list, revoke)", args[0])
+	}
+	return nil
+}
+
+// manageOrganizations creates and lists the organizations (example: organizations create -slug fc-nantes -name "FC Nantes")
+func manageOrganizations(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing organizations action (available: create, list, quota)")
+	}
+	flags := flag.NewFlagSet("organizations "+args[0], flag.ExitOnError)
+	name := flags.String("name", "", "name of the organization to create")
+	slug := flags.String("slug", "", "slug of the organization to create, sent in the org claim of the tokens of its users")
+	storageQuota := flags.String("storage", "0", "bytes the organization can store (example: 500GB), 0 for unlimited")
+	mediaQuota := flags.Int64("medias", 0, "number of medias the organization can store, 0 for unlimited")
+	flags.Parse(args[1:])
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	service := services.NewOrganizationService(repositories.NewOrganizationRepository(db))
+	switch args[0] {
+	case "create":
+		organization, err := service.CreateOrganization(*name, *slug)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Organization %d created\n", organization.ID)
+	case "list":
+		organizations, err := service.GetOrganizations()
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "ID\tSLUG\tNAME\tSTORAGE QUOTA\tMEDIA QUOTA\tCREATED")
+		for _, organization := range organizations {
+			fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\n", organization.ID, organization.Slug, organization.Name,
+				formatQuota(organization.StorageQuota), formatQuota(organization.MediaQuota), formatTime(&organization.CreatedAt))
+		}
+		return writer.Flush()
+	case "quota":
+		bytes, err := parseSize(*storageQuota)
+		if err != nil {
+			return err
+		}
+		organization, err := service.SetQuotas(*slug, bytes, *mediaQuota)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Organization %s can store %s bytes and %s medias\n", organization.Slug,
+			formatQuota(organization.StorageQuota), formatQuota(organization.MediaQuota))
+	default:
+		return fmt.Errorf("unknown organizations action %q (available: create, list, quota)", args[0])
+	}
+	return nil
+}
+
+// Units of the sizes accepted by parseSize
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+// parseSize parses a number of bytes, optionally followed by a unit among B, KB, MB, GB and TB (powers of 1024)
+func parseSize(value string) (int64, error) {
+	number, multiplier := strings.ToUpper(strings.TrimSpace(value)), int64(1)
+	for _, unit := range sizeUnits {
+		if trimmed, found := strings.CutSuffix(number, unit.suffix); found {
+			number, multiplier = strings.TrimSpace(trimmed), unit.bytes
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes like 500GB", value)
+	}
+	if size > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q, too large", value)
+	}
+	return size * multiplier, nil
+}
+
+func formatQuota(quota int64) string {
+	if quota == 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(quota, 10)
+}
+
+func formatTime(value *time.Time) string {
+	if value == nil {
+		return "-"
+	}
+	return value.Format(time.RFC3339)
+}
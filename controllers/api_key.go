@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+type APIKeyController struct {
+	service services.APIKeyService
+}
+
+func NewAPIKeyController(service services.APIKeyService) *APIKeyController {
+	return &APIKeyController{
+		service,
+	}
+}
+
+// GetAPIKeys godoc
+//
+//	@Summary		List API keys
+//	@Description	List the API keys, revoked keys included. Keys themselves are not returned, only their prefix.
+//	@Tags			API key
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Success		200	{object}	controllers.GetAPIKeys.response	"Returns success true and the API keys"
+//	@Failure		500	{object}	controllers.GetAPIKeys.response	"Returns error for internal server error"
+//	@Router			/api/keys [GET]
+func (ctrl APIKeyController) GetAPIKeys(c *fiber.Ctx) error {
+	type response struct {
+		Success bool            `json:"success"`
+		Data    []models.APIKey `json:"data"`
+		Message string          `json:"message"`
+	}
+	keys, err := ctrl.service.GetAPIKeys(c.UserContext())
+	if err != nil {
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    keys,
+	})
+}
+
+// IssueAPIKey godoc
+//
+//	@Summary		Issue an API key
+//	@Description	Issue an API key holding scopes among media:read, media:write, tags:admin, keys:admin, audit:read and webhooks:admin, which the caller must hold. The key is only returned in this response.
+//	@Tags			API key
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			key	body		controllers.IssueAPIKey.request	true	"name and scopes of the key"
+//	@Success		201	{object}	controllers.IssueAPIKey.response	"Returns success true, the key and its record"
+//	@Failure		400	{object}	controllers.IssueAPIKey.response	"Returns error for invalid name or scopes"
+//	@Failure		403	{object}	controllers.IssueAPIKey.response	"Returns error for scopes the caller does not hold"
+//	@Failure		500	{object}	controllers.IssueAPIKey.response	"Returns error for internal server error"
+//	@Router			/api/keys [POST]
+func (ctrl APIKeyController) IssueAPIKey(c *fiber.Ctx) error {
+	type request struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	type response struct {
+		Success bool           `json:"success"`
+		Key     string         `json:"key,omitempty"`
+		Data    *models.APIKey `json:"data"`
+		Message string         `json:"message"`
+	}
+	input := new(request)
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+	if input.Name == "" {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "name is required",
+		})
+	}
+	key, apiKey, err := ctrl.service.Issue(c.UserContext(), input.Name, input.Scopes)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidScope) {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if errors.Is(err, services.ErrScopeNotGranted) {
+			return c.Status(403).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(201).JSON(response{
+		Success: true,
+		Key:     key,
+		Data:    apiKey,
+	})
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revoke an API key
+//	@Description	Revoke an API key, requests sending it are rejected from now on
+//	@Tags			API key
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		int	true	"API key id"
+//	@Success		200	{object}	controllers.RevokeAPIKey.response	"Returns success true"
+//	@Failure		400	{object}	controllers.RevokeAPIKey.response	"Returns error for invalid id"
+//	@Failure		404	{object}	controllers.RevokeAPIKey.response	"Returns error when the key is not found or already revoked"
+//	@Failure		500	{object}	controllers.RevokeAPIKey.response	"Returns error for internal server error"
+//	@Router			/api/keys/{id} [DELETE]
+func (ctrl APIKeyController) RevokeAPIKey(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "invalid id: " + c.Params("id"),
+		})
+	}
+	if err := ctrl.service.Revoke(c.UserContext(), uint(id)); err != nil {
+		if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Message: "API key revoked",
+	})
+}
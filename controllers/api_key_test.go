@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockAPIKeyRepository struct {
+	mock.Mock
+}
+
+func (m *mockAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *mockAPIKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	args := m.Called(ctx, keyHash)
+	return args.Get(0).(*models.APIKey), args.Error(1)
+}
+
+func (m *mockAPIKeyRepository) Find(ctx context.Context) ([]models.APIKey, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.APIKey), args.Error(1)
+}
+
+func (m *mockAPIKeyRepository) Revoke(ctx context.Context, id uint, now time.Time) error {
+	args := m.Called(ctx, id, now)
+	return args.Error(0)
+}
+
+func (m *mockAPIKeyRepository) UpdateLastUsed(ctx context.Context, id uint, now time.Time) error {
+	args := m.Called(ctx, id, now)
+	return args.Error(0)
+}
+
+func TestIssueAPIKey(t *testing.T) {
+	tests := []struct {
+		description        string
+		body               string
+		callerScopes       []string
+		expectedScopes     []string
+		expectedStatusCode int
+		expectedMessage    string
+	}{
+		{
+			description:        "Issue api key should return the key once and HTTP status code 201",
+			body:               `{"name":"gallery","scopes":["media:read"]}`,
+			expectedScopes:     []string{services.ScopeMediaRead},
+			expectedStatusCode: 201,
+		},
+		{
+			description:        "Issue api key should return HTTP status code 403 for a scope the caller does not hold",
+			body:               `{"name":"escalation","scopes":["media:read","audit:read"]}`,
+			callerScopes:       []string{services.ScopeKeysAdmin, services.ScopeMediaRead},
+			expectedStatusCode: 403,
+			expectedMessage:    "scope not granted: audit:read is not granted to the caller",
+		},
+		{
+			description:        "Issue api key should return HTTP status code 400 for an unknown scope",
+			body:               `{"name":"gallery","scopes":["media:delete"]}`,
+			expectedStatusCode: 400,
+			expectedMessage:    "invalid scope: media:delete (available: media:read, media:write, tags:admin, keys:admin, audit:read, webhooks:admin)",
+		},
+		{
+			description:        "Issue api key should return HTTP status code 400 without name",
+			body:               `{"scopes":["media:read"]}`,
+			expectedStatusCode: 400,
+			expectedMessage:    "name is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockAPIKeyRepository := new(mockAPIKeyRepository)
+			mockAPIKeyRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.APIKey")).
+				Run(func(args mock.Arguments) { args.Get(1).(*models.APIKey).ID = 1 }).
+				Return(nil)
+			apiKeyController := NewAPIKeyController(*services.NewAPIKeyService(mockAPIKeyRepository))
+			callerScopes := services.Scopes
+			if tt.callerScopes != nil {
+				callerScopes = tt.callerScopes
+			}
+			app.Use(func(c *fiber.Ctx) error {
+				c.SetUserContext(services.WithIdentity(c.UserContext(), &services.Identity{Subject: "api-key:2", OrganizationID: 1, Scopes: callerScopes}))
+				return c.Next()
+			})
+			app.Post("/api/keys", apiKeyController.IssueAPIKey)
+
+			req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			var body struct {
+				Key     string         `json:"key"`
+				Data    *models.APIKey `json:"data"`
+				Message string         `json:"message"`
+			}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.expectedMessage, body.Message)
+			if tt.expectedStatusCode != 201 {
+				mockAPIKeyRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+				return
+			}
+			assert.True(t, strings.HasPrefix(body.Key, body.Data.Prefix))
+			assert.Equal(t, tt.expectedScopes, []string(body.Data.Scopes))
+			stored := mockAPIKeyRepository.Calls[0].Arguments.Get(1).(*models.APIKey)
+			assert.NotEmpty(t, stored.KeyHash)
+			assert.NotContains(t, stored.KeyHash, body.Key)
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	tests := []struct {
+		description          string
+		id                   string
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:          "Revoke api key should return HTTP status code 200",
+			id:                   "1",
+			expectedStatusCode:   200,
+			expectedBodyResponse: `{"success":true,"message":"API key revoked"}`,
+		},
+		{
+			description:          "Revoke api key should return HTTP status code 404 for an unknown or revoked key",
+			id:                   "1",
+			mockError:            repositories.ErrAPIKeyNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"api key not found"}`,
+		},
+		{
+			description:          "Revoke api key should return HTTP status code 400 for an invalid id",
+			id:                   "gallery",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid id: gallery"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockAPIKeyRepository := new(mockAPIKeyRepository)
+			mockAPIKeyRepository.On("Revoke", mock.Anything, uint(1), mock.AnythingOfType("time.Time")).Return(tt.mockError)
+			apiKeyController := NewAPIKeyController(*services.NewAPIKeyService(mockAPIKeyRepository))
+			app.Delete("/api/keys/:id", apiKeyController.RevokeAPIKey)
+
+			resp, _ := app.Test(httptest.NewRequest("DELETE", "/api/keys/"+tt.id, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Content type of the audit log exports
+const ndjsonContentType = "application/x-ndjson"
+
+// Number of audit events per page by default
+const defaultAuditEvents = 100
+
+type AuditController struct {
+	service services.AuditService
+}
+
+func NewAuditController(service services.AuditService) *AuditController {
+	return &AuditController{
+		service,
+	}
+}
+
+// GetAuditEvents godoc
+//
+//	@Summary		Get the audit log
+//	@Description	Get the creations, updates and deletions of the medias, tags and tags of medias of the organization of the caller, newest first, with their actor, request and the entity before and after the change. Pages are read with the before parameter, set to the next field of the previous page. With format=ndjson, or the Accept header application/x-ndjson, every matching event is exported as newline delimited JSON.
+//	@Tags			Audit
+//	@Produce		json
+//	@Produce		application/x-ndjson
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			actor		query		string	false	"subject of the user, or api-key:<id>"
+//	@Param			action		query		string	false	"create, update, delete, restore or purge"
+//	@Param			entityType	query		string	false	"media, tag or media_tag"
+//	@Param			entityId	query		string	false	"id of the entity, <media id>:<tag id> for the tags of a media"
+//	@Param			requestId	query		string	false	"id of the request (X-Request-ID header)"
+//	@Param			from		query		string	false	"events created at or after this time (RFC 3339 or YYYY-MM-DD)"
+//	@Param			to			query		string	false	"events created before this time (RFC 3339, or YYYY-MM-DD included)"
+//	@Param			limit		query		int		false	"events per page, 100 by default, 1000 at most"
+//	@Param			before		query		int		false	"events older than this event id"
+//	@Param			format		query		string	false	"ndjson to export every matching event"
+//	@Success		200			{object}	controllers.GetAuditEvents.response	"Returns success true and the events"
+//	@Failure		400			{object}	controllers.GetAuditEvents.response	"Returns error for an invalid filter"
+//	@Failure		500			{object}	controllers.GetAuditEvents.response	"Returns error for internal server error"
+//	@Router			/api/audit [GET]
+func (ctrl AuditController) GetAuditEvents(c *fiber.Ctx) error {
+	type response struct {
+		Success bool                `json:"success"`
+		Data    []models.AuditEvent `json:"data"`
+		// Id to send as the before parameter to read the next page, absent on the last page
+		Next    uint   `json:"next,omitempty"`
+		Message string `json:"message"`
+	}
+	failed := func(err error) error {
+		if errors.Is(err, services.ErrInvalidAuditFilter) {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	if c.Query("format") == "ndjson" || strings.Contains(c.Get(fiber.HeaderAccept), ndjsonContentType) {
+		// the export is checked before streaming, its errors cannot change the status code once started
+		if _, err := ctrl.service.GetEvents(c.UserContext(), filter, 1); err != nil {
+			return failed(err)
+		}
+		ctx := c.UserContext()
+		c.Set(fiber.HeaderContentType, ndjsonContentType)
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.ndjson"`)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			if err := ctrl.service.ExportEvents(ctx, filter, w); err != nil {
+				log.Printf("unable to export the audit log: %s", err)
+			}
+			w.Flush()
+		})
+		return nil
+	}
+
+	limit := c.QueryInt("limit", defaultAuditEvents)
+	events, err := ctrl.service.GetEvents(c.UserContext(), filter, limit)
+	if err != nil {
+		return failed(err)
+	}
+	result := response{
+		Success: true,
+		Data:    events,
+	}
+	if len(events) == limit {
+		result.Next = events[len(events)-1].ID
+	}
+	return c.Status(200).JSON(result)
+}
+
+// parseAuditFilter reads the filters of the audit log from the query parameters
+func parseAuditFilter(c *fiber.Ctx) (repositories.AuditFilter, error) {
+	filter := repositories.AuditFilter{
+		Actor:      c.Query("actor"),
+		Action:     c.Query("action"),
+		EntityType: c.Query("entityType"),
+		EntityID:   c.Query("entityId"),
+		RequestID:  c.Query("requestId"),
+	}
+	var err error
+	if filter.From, err = parseAuditTime(c.Query("from"), false); err != nil {
+		return filter, errors.New("invalid from, expected RFC 3339 or YYYY-MM-DD: " + c.Query("from"))
+	}
+	if filter.To, err = parseAuditTime(c.Query("to"), true); err != nil {
+		return filter, errors.New("invalid to, expected RFC 3339 or YYYY-MM-DD: " + c.Query("to"))
+	}
+	if value := c.Query("before"); value != "" {
+		before, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, errors.New("invalid before, expected an event id: " + value)
+		}
+		filter.Before = uint(before)
+	}
+	return filter, nil
+}
+
+// parseAuditTime parses an RFC 3339 time or a date, the end of the date when end is set. Empty values are nil.
+func parseAuditTime(value string, end bool) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		if end {
+			date = date.AddDate(0, 0, 1)
+		}
+		return &date, nil
+	}
+	instant, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &instant, nil
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockAuditRepository struct {
+	mock.Mock
+}
+
+func (m *mockAuditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	args := m.Called(ctx, event)
+	return args.Error(0)
+}
+
+func (m *mockAuditRepository) Find(ctx context.Context, filter repositories.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	args := m.Called(ctx, filter, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.AuditEvent), args.Error(1)
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	createdAt := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	events := []models.AuditEvent{
+		{ID: 12, Actor: "api-key:1", ActorName: "backoffice", Action: models.AuditDelete, EntityType: models.AuditTag, EntityID: "3", Before: []byte(`{"id":3,"name":"goal"}`), RequestID: "req-2", IP: "10.0.0.1", CreatedAt: createdAt},
+		{ID: 11, Actor: "api-key:1", ActorName: "backoffice", Action: models.AuditCreate, EntityType: models.AuditTag, EntityID: "3", After: []byte(`{"id":3,"name":"goal"}`), RequestID: "req-1", IP: "10.0.0.1", CreatedAt: createdAt},
+	}
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, time.March, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description          string
+		query                string
+		expectedFilter       repositories.AuditFilter
+		expectedLimit        int
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Get audit events should return the events matching the filters and HTTP status code 200",
+			query:              "?entityType=tag&entityId=3&from=2024-03-01&to=2024-03-09",
+			expectedFilter:     repositories.AuditFilter{EntityType: models.AuditTag, EntityID: "3", From: &from, To: &to},
+			expectedLimit:      100,
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":[
+					{"id":12,"actor":"api-key:1","actorName":"backoffice","action":"delete","entityType":"tag","entityId":"3","before":{"id":3,"name":"goal"},"requestId":"req-2","ip":"10.0.0.1","createdAt":"2024-03-09T21:00:00Z"},
+					{"id":11,"actor":"api-key:1","actorName":"backoffice","action":"create","entityType":"tag","entityId":"3","after":{"id":3,"name":"goal"},"requestId":"req-1","ip":"10.0.0.1","createdAt":"2024-03-09T21:00:00Z"}
+				]}`,
+		},
+		{
+			description:        "Get audit events should return the cursor of the next page when the page is full",
+			query:              "?actor=api-key:1&limit=2&before=13",
+			expectedFilter:     repositories.AuditFilter{Actor: "api-key:1", Before: 13},
+			expectedLimit:      2,
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"next":11,
+				"data":[
+					{"id":12,"actor":"api-key:1","actorName":"backoffice","action":"delete","entityType":"tag","entityId":"3","before":{"id":3,"name":"goal"},"requestId":"req-2","ip":"10.0.0.1","createdAt":"2024-03-09T21:00:00Z"},
+					{"id":11,"actor":"api-key:1","actorName":"backoffice","action":"create","entityType":"tag","entityId":"3","after":{"id":3,"name":"goal"},"requestId":"req-1","ip":"10.0.0.1","createdAt":"2024-03-09T21:00:00Z"}
+				]}`,
+		},
+		{
+			description:          "Get audit events should return HTTP status code 400 for an unknown action",
+			query:                "?action=rename",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid audit filter: unknown action rename","data":null}`,
+		},
+		{
+			description:          "Get audit events should return HTTP status code 400 for an invalid date",
+			query:                "?from=yesterday",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid from, expected RFC 3339 or YYYY-MM-DD: yesterday","data":null}`,
+		},
+		{
+			description:          "Get audit events should return HTTP status code 400 for a page too large",
+			query:                "?limit=5000",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid audit filter: limit must be between 1 and 1000","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockAuditRepository := new(mockAuditRepository)
+			mockAuditRepository.On("Find", mock.Anything, tt.expectedFilter, tt.expectedLimit).Return(events, nil)
+			auditController := NewAuditController(*services.NewAuditService(mockAuditRepository))
+			app.Get("/api/audit", auditController.GetAuditEvents)
+
+			resp, _ := app.Test(httptest.NewRequest("GET", "/api/audit"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+			if tt.expectedStatusCode == 200 {
+				mockAuditRepository.AssertExpectations(t)
+			}
+		})
+	}
+}
+
+func TestExportAuditEvents(t *testing.T) {
+	app := fiber.New()
+	mockAuditRepository := new(mockAuditRepository)
+	filter := repositories.AuditFilter{EntityType: models.AuditMedia}
+	mockAuditRepository.On("Find", mock.Anything, filter, 1).Return([]models.AuditEvent{{ID: 2}}, nil)
+	mockAuditRepository.On("Find", mock.Anything, filter, 500).Return([]models.AuditEvent{
+		{ID: 2, Actor: "auth0|42", Action: models.AuditUpdate, EntityType: models.AuditMedia, EntityID: "1"},
+		{ID: 1, Actor: "auth0|42", Action: models.AuditCreate, EntityType: models.AuditMedia, EntityID: "1"},
+	}, nil)
+	auditController := NewAuditController(*services.NewAuditService(mockAuditRepository))
+	app.Get("/api/audit", auditController.GetAuditEvents)
+
+	req := httptest.NewRequest("GET", "/api/audit?entityType=media", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := app.Test(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `{"id":2,"actor":"auth0|42","action":"update","entityType":"media","entityId":"1","createdAt":"0001-01-01T00:00:00Z"}
+{"id":1,"actor":"auth0|42","action":"create","entityType":"media","entityId":"1","createdAt":"0001-01-01T00:00:00Z"}
+`, string(body))
+}
+
+func TestExportAuditEventsError(t *testing.T) {
+	app := fiber.New()
+	mockAuditRepository := new(mockAuditRepository)
+	mockAuditRepository.On("Find", mock.Anything, mock.Anything, mock.Anything).Return(nil, errors.New("database unreachable"))
+	auditController := NewAuditController(*services.NewAuditService(mockAuditRepository))
+	app.Get("/api/audit", auditController.GetAuditEvents)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/audit?format=ndjson", nil))
+
+	assert.Equal(t, 500, resp.StatusCode, "the export fails before streaming")
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{"success":false,"message":"internal server error","data":null}`, string(body))
+}
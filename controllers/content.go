@@ -0,0 +1,277 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Maximum number of ranges served in a single response, requests with more ranges get the whole file
+const maxByteRanges = 16
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is a range of bytes of an object
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// GetMediaContent godoc
+//
+//	@Summary		Download the file of a media
+//	@Description	Stream the original file of a media through the API and count the download. Range requests are supported so video players can seek.
+//	@Tags			Media
+//	@Produce		octet-stream
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id				path		string	true	"Media id"
+//	@Param			disposition		query		string	false	"attachment (default) or inline"
+//	@Param			Range			header		string	false	"byte ranges (example: bytes=0-1023)"
+//	@Param			If-None-Match	header		string	false	"etag of a cached copy"
+//	@Success		200				{file}		binary	"Returns the file"
+//	@Success		206				{file}		binary	"Returns the requested range, or a multipart/byteranges body for several ranges"
+//	@Success		304				{string}	string	"Returns no content when the cached copy is up to date"
+//	@Failure		404				{object}	controllers.GetMediaContent.response	"Returns error when media or file is not found"
+//	@Failure		416				{object}	controllers.GetMediaContent.response	"Returns error when no range can be served"
+//	@Failure		500				{object}	controllers.GetMediaContent.response	"Returns error for internal server error"
+//	@Failure		503				{object}	controllers.GetMediaContent.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/content [GET]
+func (ctrl MediaController) GetMediaContent(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	media, info, err := ctrl.service.GetMediaContent(c.UserContext(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrMediaNotFound) || errors.Is(err, services.ErrObjectNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if storageUnavailable(c, err) {
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+
+	etag := `"` + info.ETag + `"`
+	lastModified := info.LastModified.UTC().Format(http.TimeFormat)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, lastModified)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderContentDisposition, contentDisposition(c.Query("disposition"), media))
+	if header := c.Get(fiber.HeaderIfNoneMatch); header != "" && etagMatches(header, etag) {
+		return c.SendStatus(304)
+	}
+
+	var ranges []byteRange
+	if header := c.Get(fiber.HeaderRange); header != "" {
+		// ranges of a modified file are not combined with a cached copy, the whole file is sent instead
+		if ifRange := c.Get(fiber.HeaderIfRange); ifRange == "" || ifRange == etag || ifRange == lastModified {
+			ranges, err = parseByteRanges(header, info.Size)
+		}
+		if err != nil {
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+			return c.Status(416).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	if c.Method() == fiber.MethodHead {
+		c.Set(fiber.HeaderContentType, info.ContentType)
+		c.Response().Header.SetContentLength(int(info.Size))
+		return c.SendStatus(200)
+	}
+	// seeking in a video sends many range requests, only those starting from the beginning are downloads
+	if len(ranges) == 0 || ranges[0].start == 0 {
+		if err := ctrl.service.CountDownload(c.UserContext(), media); err != nil {
+			fmt.Printf("unable to count download of media %d: %s\n", media.ID, err.Error())
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		c.Set(fiber.HeaderContentType, info.ContentType)
+		if info.Size == 0 {
+			return c.Status(200).Send(nil)
+		}
+		object, err := ctrl.service.ReadMediaContent(c.UserContext(), media, 0, info.Size)
+		if err != nil {
+			if storageUnavailable(c, err) {
+				return c.Status(503).JSON(response{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+		return c.Status(200).SendStream(object, int(info.Size))
+	case 1:
+		object, err := ctrl.service.ReadMediaContent(c.UserContext(), media, ranges[0].start, ranges[0].length)
+		if err != nil {
+			if storageUnavailable(c, err) {
+				return c.Status(503).JSON(response{
+					Success: false,
+					Message: err.Error(),
+				})
+			}
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+		c.Set(fiber.HeaderContentType, info.ContentType)
+		c.Set(fiber.HeaderContentRange, ranges[0].contentRange(info.Size))
+		return c.Status(206).SendStream(object, int(ranges[0].length))
+	default:
+		// parts are streamed after the handler returns, the request context can no longer be used by then
+		ctx := c.UserContext()
+		reader, writer := io.Pipe()
+		parts := multipart.NewWriter(writer)
+		go func() {
+			writer.CloseWithError(ctrl.writeByteRanges(ctx, parts, media, info, ranges))
+		}()
+		c.Set(fiber.HeaderContentType, "multipart/byteranges; boundary="+parts.Boundary())
+		return c.Status(206).SendStream(reader)
+	}
+}
+
+// writeByteRanges writes the ranges of the file of a media as a multipart/byteranges body
+func (ctrl MediaController) writeByteRanges(ctx context.Context, parts *multipart.Writer, media *models.Media, info services.ObjectInfo, ranges []byteRange) error {
+	for _, r := range ranges {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			fiber.HeaderContentType:  {info.ContentType},
+			fiber.HeaderContentRange: {r.contentRange(info.Size)},
+		})
+		if err != nil {
+			return err
+		}
+		object, err := ctrl.service.ReadMediaContent(ctx, media, r.start, r.length)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(part, object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return parts.Close()
+}
+
+// parseByteRanges parses the byte ranges of a Range header (RFC 9110).
+// Nil ranges are returned for headers which must be ignored, the whole file is then sent.
+func parseByteRanges(header string, size int64) ([]byteRange, error) {
+	unit, specs, found := strings.Cut(header, "=")
+	if !found || strings.TrimSpace(unit) != "bytes" {
+		return nil, nil
+	}
+	var ranges []byteRange
+	parsed := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		parsed++
+		first, last, found := strings.Cut(spec, "-")
+		if !found {
+			return nil, nil
+		}
+		if first == "" {
+			// suffix range: the last bytes of the file
+			length, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || length < 0 {
+				return nil, nil
+			}
+			if length > 0 && size > 0 {
+				length = min(length, size)
+				ranges = append(ranges, byteRange{start: size - length, length: length})
+			}
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, nil
+		}
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return nil, nil
+			}
+			end = min(end, size-1)
+		}
+		if start < size {
+			ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+		}
+	}
+	if parsed == 0 || parsed > maxByteRanges {
+		return nil, nil
+	}
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+	return ranges, nil
+}
+
+// etagMatches reports whether an If-None-Match header matches an etag, using the weak comparison
+func etagMatches(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// contentDisposition returns the Content-Disposition header of a media file, with its original filename.
+// Files uploaded before filenames were kept are named after the media.
+func contentDisposition(disposition string, media *models.Media) string {
+	if disposition != "inline" {
+		disposition = "attachment"
+	}
+	filename := media.FileName
+	if filename == "" {
+		filename = media.Name
+		if filename == "" {
+			filename = path.Base(media.ObjectKey)
+		} else if extension := path.Ext(media.ObjectKey); !strings.EqualFold(path.Ext(filename), extension) {
+			filename += extension
+		}
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); header != "" {
+		return header
+	}
+	return disposition
+}
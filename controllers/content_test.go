@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMediaContent(t *testing.T) {
+	storage, err := services.NewStorage(context.Background(), services.StorageOptions{Driver: "memory", BucketName: "medias"})
+	require.NoError(t, err)
+	require.NoError(t, storage.PutObject(context.Background(), "goal.mp4", strings.NewReader("0123456789"), 10, "video/mp4"))
+	info, err := storage.StatObject(context.Background(), "goal.mp4")
+	require.NoError(t, err)
+	media := &models.Media{ID: 7, Name: "Late winner", MediaFiles: models.MediaFiles{ObjectKey: "goal.mp4"}, ContentType: "video/mp4"}
+
+	tests := []struct {
+		description          string
+		id                   string
+		headers              map[string]string
+		expectedStatusCode   int
+		expectedBody         string
+		expectedContentRange string
+		expectedParts        []string
+		expectedDownload     bool
+	}{
+		{
+			description:        "Get media content should return the whole file and HTTP status code 200",
+			id:                 "7",
+			expectedStatusCode: 200,
+			expectedBody:       "0123456789",
+			expectedDownload:   true,
+		},
+		{
+			description:          "Get media content should return a range and HTTP status code 206",
+			id:                   "7",
+			headers:              map[string]string{"Range": "bytes=2-5"},
+			expectedStatusCode:   206,
+			expectedBody:         "2345",
+			expectedContentRange: "bytes 2-5/10",
+		},
+		{
+			description:          "Get media content should return the first bytes and count the download",
+			id:                   "7",
+			headers:              map[string]string{"Range": "bytes=0-"},
+			expectedStatusCode:   206,
+			expectedBody:         "0123456789",
+			expectedContentRange: "bytes 0-9/10",
+			expectedDownload:     true,
+		},
+		{
+			description:          "Get media content should return the last bytes for a suffix range",
+			id:                   "7",
+			headers:              map[string]string{"Range": "bytes=-3"},
+			expectedStatusCode:   206,
+			expectedBody:         "789",
+			expectedContentRange: "bytes 7-9/10",
+		},
+		{
+			description:        "Get media content should return a multipart body for several ranges",
+			id:                 "7",
+			headers:            map[string]string{"Range": "bytes=1-2, 8-20"},
+			expectedStatusCode: 206,
+			expectedParts:      []string{"bytes 1-2/10:12", "bytes 8-9/10:89"},
+		},
+		{
+			description:          "Get media content should return HTTP status code 416 for a range after the end of the file",
+			id:                   "7",
+			headers:              map[string]string{"Range": "bytes=10-12"},
+			expectedStatusCode:   416,
+			expectedContentRange: "bytes */10",
+		},
+		{
+			description:        "Get media content should ignore a malformed range",
+			id:                 "7",
+			headers:            map[string]string{"Range": "bytes=abc"},
+			expectedStatusCode: 200,
+			expectedBody:       "0123456789",
+			expectedDownload:   true,
+		},
+		{
+			description:        "Get media content should return the whole file when If-Range does not match",
+			id:                 "7",
+			headers:            map[string]string{"Range": "bytes=2-5", "If-Range": `"outdated"`},
+			expectedStatusCode: 200,
+			expectedBody:       "0123456789",
+			expectedDownload:   true,
+		},
+		{
+			description:        "Get media content should return HTTP status code 304 when the etag matches",
+			id:                 "7",
+			headers:            map[string]string{"If-None-Match": `W/"other", "` + info.ETag + `"`},
+			expectedStatusCode: 304,
+		},
+		{
+			description:        "Get media content should return HTTP status code 404 when media is not found",
+			id:                 "8",
+			expectedStatusCode: 404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "7").Return(media, nil)
+			mockMediaRepository.On("FindByID", mock.Anything, "8").Return((*models.Media)(nil), repositories.ErrMediaNotFound)
+			mockMediaRepository.On("IncrementDownloadCount", mock.Anything, uint(7)).Return(nil)
+			mediaService := services.NewMediaService(mockMediaRepository, nil, storage, nil, nil, nil, nil, nil)
+			mediaController := NewMediaController(*mediaService)
+			app := fiber.New()
+			app.Get("/api/medias/:id/content", mediaController.GetMediaContent)
+
+			req := httptest.NewRequest("GET", "/api/medias/"+tt.id+"/content", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			assert.Equal(t, tt.expectedContentRange, resp.Header.Get("Content-Range"))
+			if tt.expectedDownload {
+				mockMediaRepository.AssertCalled(t, "IncrementDownloadCount", mock.Anything, uint(7))
+			} else {
+				mockMediaRepository.AssertNotCalled(t, "IncrementDownloadCount", mock.Anything, mock.Anything)
+			}
+			if tt.id == "7" {
+				assert.Equal(t, `"`+info.ETag+`"`, resp.Header.Get("ETag"))
+				assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+			}
+			if tt.expectedBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, tt.expectedBody, string(body))
+				assert.Equal(t, "video/mp4", resp.Header.Get("Content-Type"))
+				assert.Equal(t, `attachment; filename="Late winner.mp4"`, resp.Header.Get("Content-Disposition"))
+			}
+			if tt.expectedParts != nil {
+				mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+				require.NoError(t, err)
+				assert.Equal(t, "multipart/byteranges", mediaType)
+				reader := multipart.NewReader(resp.Body, params["boundary"])
+				var parts []string
+				for {
+					part, err := reader.NextPart()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					data, _ := io.ReadAll(part)
+					assert.Equal(t, "video/mp4", part.Header.Get("Content-Type"))
+					parts = append(parts, part.Header.Get("Content-Range")+":"+string(data))
+				}
+				assert.Equal(t, tt.expectedParts, parts)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		description string
+		disposition string
+		media       models.Media
+		expected    string
+	}{
+		{
+			description: "Content disposition should add the file extension to the media name",
+			media:       models.Media{Name: "kick-off", MediaFiles: models.MediaFiles{ObjectKey: "a.JPG"}},
+			expected:    `attachment; filename=kick-off.JPG`,
+		},
+		{
+			description: "Content disposition should keep a media name with the file extension",
+			disposition: "inline",
+			media:       models.Media{Name: "kick-off.jpg", MediaFiles: models.MediaFiles{ObjectKey: "a.JPG"}},
+			expected:    `inline; filename=kick-off.jpg`,
+		},
+		{
+			description: "Content disposition should use the original filename",
+			media:       models.Media{Name: "kick-off", FileName: "IMG_0042.JPG", MediaFiles: models.MediaFiles{ObjectKey: "a.JPG"}},
+			expected:    `attachment; filename=IMG_0042.JPG`,
+		},
+		{
+			description: "Content disposition should encode non ASCII names",
+			media:       models.Media{Name: "but de Mbappé", MediaFiles: models.MediaFiles{ObjectKey: "a.mp4"}},
+			expected:    `attachment; filename*=utf-8''but%20de%20Mbapp%C3%A9.mp4`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assert.Equal(t, tt.expected, contentDisposition(tt.disposition, &tt.media))
+		})
+	}
+}
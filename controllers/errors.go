@@ -0,0 +1,21 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// storageUnavailable sets the Retry-After header and returns true when err comes from a storage whose circuit
+// breaker is open. Such errors are answered with HTTP status code 503.
+func storageUnavailable(c *fiber.Ctx, err error) bool {
+	var circuitErr *services.CircuitOpenError
+	if !errors.As(err, &circuitErr) {
+		return false
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(circuitErr.RetryAfter.Seconds()))))
+	return true
+}
@@ -3,6 +3,8 @@ package controllers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mich31/scoreplay-media-api/models"
@@ -23,14 +25,25 @@ func NewMediaController(service services.MediaService) *MediaController {
 // GetMedias godoc
 //
 //	@Summary		Get media files by tag id
-//	@Description	Get medias by tag id
+//	@Description	Get medias by tag id and video properties
 //	@Tags			Media
 //	@Accept			json
 //	@Produce		json
-//	@Param			tag	query		string	false	"search by tag id"
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			tag			query		string	false	"search by tag id"
+//	@Param			minDuration	query		number	false	"minimum duration in seconds"
+//	@Param			maxDuration	query		number	false	"maximum duration in seconds (exclusive)"
+//	@Param			minWidth	query		int		false	"minimum width in pixels (example: 3840 for 4K)"
+//	@Param			minHeight	query		int		false	"minimum height in pixels"
+//	@Param			videoCodec	query		string	false	"video codec (example: h264, hevc, prores)"
+//	@Param			hasAudio	query		bool	false	"with or without an audio track"
+//	@Param			status		query		string	false	"processing status: uploading, processing, ready or failed (default: ready)"
 //	@Success		200	{object}	controllers.GetMedias.response	"Returns success true and array of medias"
 //	@Success		404	{object}	controllers.GetMedias.response	"Returns success true with empty data when no media found"
+//	@Failure		400	{object}	controllers.GetMedias.response	"Returns error for invalid filters"
 //	@Failure		500	{object}	controllers.GetMedias.response	"Returns error for internal server error"
+//	@Failure		503	{object}	controllers.GetMedias.response	"Returns error when the storage is unavailable"
 //	@Router			/api/medias [GET]
 func (ctrl MediaController) GetMedias(c *fiber.Ctx) error {
 	type response struct {
@@ -38,9 +51,21 @@ func (ctrl MediaController) GetMedias(c *fiber.Ctx) error {
 		Data    []models.MediaWithTagNames `json:"data"`
 		Message string                     `json:"message"`
 	}
-	tag := c.Query("tag")
-	results, err := ctrl.service.GetMediasByTag(tag)
+	filter, err := parseMediaFilter(c)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+	results, err := ctrl.service.GetMedias(c.UserContext(), filter)
 	if err != nil {
+		if storageUnavailable(c, err) {
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
 		return c.Status(500).JSON(response{
 			Success: false,
 			Message: err.Error(),
@@ -58,6 +83,64 @@ func (ctrl MediaController) GetMedias(c *fiber.Ctx) error {
 	})
 }
 
+func parseMediaFilter(c *fiber.Ctx) (repositories.MediaFilter, error) {
+	filter := repositories.MediaFilter{
+		Tag:        c.Query("tag"),
+		VideoCodec: c.Query("videoCodec"),
+		Status:     c.Query("status", models.MediaReady),
+	}
+	switch filter.Status {
+	case models.MediaUploading, models.MediaProcessing, models.MediaReady, models.MediaFailed:
+	default:
+		return filter, fmt.Errorf("invalid status: %s", filter.Status)
+	}
+	var err error
+	if filter.MinDuration, err = queryFloat(c, "minDuration"); err != nil {
+		return filter, err
+	}
+	if filter.MaxDuration, err = queryFloat(c, "maxDuration"); err != nil {
+		return filter, err
+	}
+	if filter.MinWidth, err = queryInt(c, "minWidth"); err != nil {
+		return filter, err
+	}
+	if filter.MinHeight, err = queryInt(c, "minHeight"); err != nil {
+		return filter, err
+	}
+	if value := c.Query("hasAudio"); value != "" {
+		hasAudio, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid hasAudio: %s", value)
+		}
+		filter.HasAudio = &hasAudio
+	}
+	return filter, nil
+}
+
+func queryFloat(c *fiber.Ctx, key string) (*float64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return &result, nil
+}
+
+func queryInt(c *fiber.Ctx, key string) (*int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return &result, nil
+}
+
 // CreateMedia godoc
 //
 //	@Summary		Upload a new media file
@@ -65,12 +148,18 @@ func (ctrl MediaController) GetMedias(c *fiber.Ctx) error {
 //	@Tags			Media
 //	@Accept			multipart/form-data
 //	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
 //	@Param			file	formData	file	true	"Media file to upload"
 //	@Param			name	formData	string	true	"Media name"
 //	@Param			tags	formData	string	true	"Array of tag IDs (example: [123, 75, 18873])"
 //	@Success		201	{object}	controllers.CreateMedia.response	"Returns success true when file is uploaded and a new media is created"
 //	@Failure		400	{object}	controllers.CreateMedia.response	"Returns error for missing file or existing media"
+//	@Failure		402	{object}	controllers.CreateMedia.response	"Returns error when the organization stores as many medias as its quota"
+//	@Failure		413	{object}	controllers.CreateMedia.response	"Returns error when the file exceeds the storage quota of the organization"
+//	@Failure		429	{object}	controllers.CreateMedia.response	"Returns error when the rate limit of uploads is exceeded"
 //	@Failure		500	{object}	controllers.CreateMedia.response	"Returns error for internal server error"
+//	@Failure		503	{object}	controllers.CreateMedia.response	"Returns error when the storage is unavailable"
 //	@Router			/api/medias [POST]
 func (ctrl MediaController) CreateMedia(c *fiber.Ctx) error {
 	type response struct {
@@ -102,7 +191,7 @@ func (ctrl MediaController) CreateMedia(c *fiber.Ctx) error {
 		})
 	}
 
-	_, err = ctrl.service.CreateMedia(c.Context(), name, tags, file)
+	_, err = ctrl.service.CreateMedia(c.UserContext(), name, tags, file)
 	if err != nil {
 		switch {
 		case errors.Is(err, repositories.ErrMediaExists):
@@ -110,11 +199,26 @@ func (ctrl MediaController) CreateMedia(c *fiber.Ctx) error {
 				Success: false,
 				Message: "Failed to create media: " + err.Error(),
 			})
+		case errors.Is(err, repositories.ErrMediaQuotaExceeded):
+			return c.Status(402).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrStorageQuotaExceeded):
+			return c.Status(413).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
 		case errors.Is(err, repositories.ErrMediaCreation), errors.Is(err, repositories.ErrMediaDBOperation):
 			return c.Status(500).JSON(response{
 				Success: false,
 				Message: "Failed to create media: " + err.Error(),
 			})
+		case storageUnavailable(c, err):
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
 		default:
 			return c.Status(500).JSON(response{
 				Success: false,
@@ -129,3 +233,359 @@ func (ctrl MediaController) CreateMedia(c *fiber.Ctx) error {
 		Message: "File uploaded",
 	})
 }
+
+// SetFocalPoint godoc
+//
+//	@Summary		Set the focal point of a media
+//	@Description	Set the focal point (normalized x, y) and optional crop boxes per aspect ratio used to crop renditions and resized images
+//	@Tags			Media
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id			path		string				true	"Media id"
+//	@Param			focalPoint	body		models.FocalPoint	true	"focal point and crop boxes per aspect ratio"
+//	@Success		200			{object}	controllers.SetFocalPoint.response	"Returns success true and the updated media"
+//	@Failure		400			{object}	controllers.SetFocalPoint.response	"Returns error for invalid input"
+//	@Failure		404			{object}	controllers.SetFocalPoint.response	"Returns error when media is not found"
+//	@Failure		500			{object}	controllers.SetFocalPoint.response	"Returns error for internal server error"
+//	@Failure		503			{object}	controllers.SetFocalPoint.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/focal-point [PUT]
+func (ctrl MediaController) SetFocalPoint(c *fiber.Ctx) error {
+	type response struct {
+		Success bool          `json:"success"`
+		Data    *models.Media `json:"data"`
+		Message string        `json:"message"`
+	}
+	input := models.FocalPoint{}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	media, err := ctrl.service.SetFocalPoint(c.UserContext(), c.Params("id"), input)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidFocalPoint):
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrMediaNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case storageUnavailable(c, err):
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    media,
+	})
+}
+
+// ClearFocalPoint godoc
+//
+//	@Summary		Clear the focal point of a media
+//	@Description	Remove the focal point and crop boxes of a media, crops fall back on automatic detection
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		string	true	"Media id"
+//	@Success		200	{object}	controllers.ClearFocalPoint.response	"Returns success true and the updated media"
+//	@Failure		404	{object}	controllers.ClearFocalPoint.response	"Returns error when media is not found"
+//	@Failure		500	{object}	controllers.ClearFocalPoint.response	"Returns error for internal server error"
+//	@Failure		503	{object}	controllers.ClearFocalPoint.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/focal-point [DELETE]
+func (ctrl MediaController) ClearFocalPoint(c *fiber.Ctx) error {
+	type response struct {
+		Success bool          `json:"success"`
+		Data    *models.Media `json:"data"`
+		Message string        `json:"message"`
+	}
+	media, err := ctrl.service.ClearFocalPoint(c.UserContext(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrMediaNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if storageUnavailable(c, err) {
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    media,
+	})
+}
+
+// RetryProcessing godoc
+//
+//	@Summary		Retry the processing of a media
+//	@Description	Process again the stored file of a media whose processing failed. The media is returned with the processing status while it is processed by a background job.
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		string	true	"Media id"
+//	@Success		200	{object}	controllers.RetryProcessing.response	"Returns success true and the media being processed"
+//	@Failure		404	{object}	controllers.RetryProcessing.response	"Returns error when media is not found"
+//	@Failure		409	{object}	controllers.RetryProcessing.response	"Returns error when the processing of the media has not failed"
+//	@Failure		500	{object}	controllers.RetryProcessing.response	"Returns error for internal server error"
+//	@Failure		503	{object}	controllers.RetryProcessing.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/retry [POST]
+func (ctrl MediaController) RetryProcessing(c *fiber.Ctx) error {
+	type response struct {
+		Success bool          `json:"success"`
+		Data    *models.Media `json:"data"`
+		Message string        `json:"message"`
+	}
+	media, err := ctrl.service.RetryProcessing(c.UserContext(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repositories.ErrMediaNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if errors.Is(err, services.ErrMediaNotFailed) {
+			return c.Status(409).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		if storageUnavailable(c, err) {
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    media,
+	})
+}
+
+// GetSimilarMedias godoc
+//
+//	@Summary		Get near-duplicates of a media
+//	@Description	Get medias whose perceptual hash is within a Hamming distance of the hash of an image media, closest first
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id			path		string	true	"Media id"
+//	@Param			distance	query		int		false	"maximum Hamming distance between hashes (default: 10)"
+//	@Success		200			{object}	controllers.GetSimilarMedias.response	"Returns success true and array of similar medias"
+//	@Failure		400			{object}	controllers.GetSimilarMedias.response	"Returns error for invalid distance or media without hash"
+//	@Failure		404			{object}	controllers.GetSimilarMedias.response	"Returns error when media is not found"
+//	@Failure		500			{object}	controllers.GetSimilarMedias.response	"Returns error for internal server error"
+//	@Failure		503			{object}	controllers.GetSimilarMedias.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/similar [GET]
+func (ctrl MediaController) GetSimilarMedias(c *fiber.Ctx) error {
+	type response struct {
+		Success bool                  `json:"success"`
+		Data    []models.SimilarMedia `json:"data"`
+		Message string                `json:"message"`
+	}
+	distance := c.QueryInt("distance", services.DefaultSimilarityDistance)
+	if distance < 0 || distance > 64 {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "distance must be between 0 and 64",
+		})
+	}
+
+	results, err := ctrl.service.GetSimilarMedias(c.UserContext(), c.Params("id"), distance)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNoPerceptualHash):
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrMediaNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case storageUnavailable(c, err):
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    results,
+	})
+}
+
+// GetDuplicates godoc
+//
+//	@Summary		Get groups of near-duplicate medias in a tag
+//	@Description	Group the image medias associated to a tag whose perceptual hashes are within a Hamming distance, to cull bursts
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			tag			query		string	true	"tag id"
+//	@Param			distance	query		int		false	"maximum Hamming distance between hashes (default: 10)"
+//	@Success		200			{object}	controllers.GetDuplicates.response	"Returns success true and groups of near-duplicate medias"
+//	@Failure		400			{object}	controllers.GetDuplicates.response	"Returns error for invalid parameters"
+//	@Failure		500			{object}	controllers.GetDuplicates.response	"Returns error for internal server error"
+//	@Failure		503			{object}	controllers.GetDuplicates.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/duplicates [GET]
+func (ctrl MediaController) GetDuplicates(c *fiber.Ctx) error {
+	type response struct {
+		Success bool             `json:"success"`
+		Data    [][]models.Media `json:"data"`
+		Message string           `json:"message"`
+	}
+	tag := c.Query("tag")
+	distance := c.QueryInt("distance", services.DefaultSimilarityDistance)
+	if tag == "" || distance < 0 || distance > 64 {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "tag is required and distance must be between 0 and 64",
+		})
+	}
+
+	results, err := ctrl.service.GetDuplicatesByTag(c.UserContext(), tag, distance)
+	if err != nil {
+		if storageUnavailable(c, err) {
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    results,
+	})
+}
+
+// DeleteMedia godoc
+//
+//	@Summary		Delete a media
+//	@Description	Moves a media to the trash. It is restored with POST /api/medias/{id}/restore until it is deleted permanently, with its files, after the retention of the trash.
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		string	true	"Media id"
+//	@Success		200	{object}	controllers.DeleteMedia.response	"Returns success true"
+//	@Failure		404	{object}	controllers.DeleteMedia.response	"Returns error when media is not found"
+//	@Failure		500	{object}	controllers.DeleteMedia.response	"Returns error for internal server error"
+//	@Router			/api/medias/{id} [DELETE]
+func (ctrl MediaController) DeleteMedia(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := ctrl.service.DeleteMedia(c.UserContext(), c.Params("id")); err != nil {
+		if errors.Is(err, repositories.ErrMediaNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+	})
+}
+
+// RestoreMedia godoc
+//
+//	@Summary		Restore a media
+//	@Description	Moves a media out of the trash, with its tags
+//	@Tags			Media
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		string	true	"Media id"
+//	@Success		200	{object}	controllers.RestoreMedia.response	"Returns success true and the restored media"
+//	@Failure		404	{object}	controllers.RestoreMedia.response	"Returns error when media is not in the trash"
+//	@Failure		409	{object}	controllers.RestoreMedia.response	"Returns error when another media took its name"
+//	@Failure		500	{object}	controllers.RestoreMedia.response	"Returns error for internal server error"
+//	@Failure		503	{object}	controllers.RestoreMedia.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/restore [POST]
+func (ctrl MediaController) RestoreMedia(c *fiber.Ctx) error {
+	type response struct {
+		Success bool          `json:"success"`
+		Data    *models.Media `json:"data"`
+		Message string        `json:"message"`
+	}
+	media, err := ctrl.service.RestoreMedia(c.UserContext(), c.Params("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrMediaNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrMediaExists):
+			return c.Status(409).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case storageUnavailable(c, err):
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    media,
+	})
+}
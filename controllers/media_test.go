@@ -3,12 +3,18 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mich31/scoreplay-media-api/models"
@@ -26,16 +32,124 @@ type mockStorageService struct {
 	mock.Mock
 }
 
-func (r *mockMediaRepository) Create(media *models.Media, tagIDs []uint) (uint, error) {
-	args := r.Called(media, tagIDs)
+func (r *mockMediaRepository) Create(ctx context.Context, media *models.Media, tagIDs []uint) (uint, error) {
+	args := r.Called(ctx, media, tagIDs)
 	return args.Get(0).(uint), args.Error(1)
 }
 
-func (r *mockMediaRepository) FindByTag(tag string) ([]models.MediaWithTagNames, error) {
-	args := r.Called(tag)
+func (r *mockMediaRepository) FindByID(ctx context.Context, id string) (*models.Media, error) {
+	args := r.Called(ctx, id)
+	return args.Get(0).(*models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) Find(ctx context.Context, filter repositories.MediaFilter) ([]models.MediaWithTagNames, error) {
+	args := r.Called(ctx, filter)
 	return args.Get(0).([]models.MediaWithTagNames), args.Error(1)
 }
 
+func (r *mockMediaRepository) FindWithStaleRenditions(ctx context.Context, version string) ([]models.Media, error) {
+	args := r.Called(ctx, version)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) UpdateRenditions(ctx context.Context, id uint, renditions models.RenditionMap, version string) error {
+	args := r.Called(ctx, id, renditions, version)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) UpdateFocalPoint(ctx context.Context, id uint, focalPoint models.FocalPoint) error {
+	args := r.Called(ctx, id, focalPoint)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) UpdatePerceptualHash(ctx context.Context, id uint, hash int64) error {
+	args := r.Called(ctx, id, hash)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) FindWithoutPerceptualHash(ctx context.Context) ([]models.Media, error) {
+	args := r.Called(ctx)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) FindSimilar(ctx context.Context, id uint, hash int64, distance int) ([]models.SimilarMedia, error) {
+	args := r.Called(ctx, id, hash, distance)
+	return args.Get(0).([]models.SimilarMedia), args.Error(1)
+}
+
+func (r *mockMediaRepository) FindHashesByTag(ctx context.Context, tag string) ([]models.Media, error) {
+	args := r.Called(ctx, tag)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) UpdateVideoMetadata(ctx context.Context, id uint, metadata models.VideoMetadata) error {
+	args := r.Called(ctx, id, metadata)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) IncrementDownloadCount(ctx context.Context, id uint) error {
+	args := r.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) FindAfter(ctx context.Context, id uint, limit int) ([]models.Media, error) {
+	args := r.Called(ctx, id, limit)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) UpdateObjectKey(ctx context.Context, id uint, objectKey string, renditions models.RenditionMap) error {
+	args := r.Called(ctx, id, objectKey, renditions)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) ClaimReplicationDue(ctx context.Context, maxAttempts int, now time.Time, lockedUntil time.Time, limit int) ([]models.Media, error) {
+	args := r.Called(ctx, maxAttempts, now, lockedUntil, limit)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) UpdateReplication(ctx context.Context, id uint, replication models.Replication) error {
+	args := r.Called(ctx, id, replication)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) FindNotEncryptedWith(ctx context.Context, keyID string, afterID uint, limit int) ([]models.Media, error) {
+	args := r.Called(ctx, keyID, afterID, limit)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) UpdateEncryptionKeyID(ctx context.Context, id uint, keyID string) error {
+	args := r.Called(ctx, id, keyID)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) UpdateProcessing(ctx context.Context, id uint, processing models.Processing) error {
+	args := r.Called(ctx, id, processing)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) Delete(ctx context.Context, id uint) error {
+	args := r.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (r *mockMediaRepository) Restore(ctx context.Context, id string) (*models.Media, error) {
+	args := r.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Media, error) {
+	args := r.Called(ctx, before, limit)
+	return args.Get(0).([]models.Media), args.Error(1)
+}
+
+func (r *mockMediaRepository) Purge(ctx context.Context, id uint) error {
+	args := r.Called(ctx, id)
+	return args.Error(0)
+}
+
 func (s *mockStorageService) CreateBucket(ctx context.Context, bucketName string) error {
 	args := s.Called(ctx)
 	return args.Error(1)
@@ -46,6 +160,41 @@ func (s *mockStorageService) UploadObject(ctx context.Context, fileHeader *multi
 	return args.Get(0).(string), args.Error(1)
 }
 
+func (s *mockStorageService) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	args := s.Called(ctx, objectName, reader, size, contentType)
+	return args.Error(0)
+}
+
+func (s *mockStorageService) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	args := s.Called(ctx, objectName)
+	return args.Get(0).(string), args.Error(1)
+}
+
+func (s *mockStorageService) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	args := s.Called(ctx, objectName)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (s *mockStorageService) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	args := s.Called(ctx, objectName, offset, length)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (s *mockStorageService) StatObject(ctx context.Context, objectName string) (services.ObjectInfo, error) {
+	args := s.Called(ctx, objectName)
+	return args.Get(0).(services.ObjectInfo), args.Error(1)
+}
+
+func (s *mockStorageService) DeleteObject(ctx context.Context, objectName string) error {
+	args := s.Called(ctx, objectName)
+	return args.Error(0)
+}
+
+func (s *mockStorageService) ListObjects(ctx context.Context, prefix string) ([]services.ObjectInfo, error) {
+	args := s.Called(ctx, prefix)
+	return args.Get(0).([]services.ObjectInfo), args.Error(1)
+}
+
 func TestGetMedias(t *testing.T) {
 	tests := []struct {
 		description          string
@@ -63,8 +212,9 @@ func TestGetMedias(t *testing.T) {
 					ID:          1,
 					Name:        "lucas_hernandez",
 					Description: "Lucas Hernandez",
-					FileUrl:     "http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
+					MediaFiles:  models.MediaFiles{ObjectKey: "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png"},
 					TagNames:    []string{"hernandez", "football", "france"},
+					Status:      models.MediaReady,
 				},
 			},
 			mockError:          nil,
@@ -73,7 +223,7 @@ func TestGetMedias(t *testing.T) {
 				"success":true,
 				"message":"",
 				"data":[
-					{"id":1,"name":"lucas_hernandez", "description":"Lucas Hernandez", "fileUrl":"http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png", "tagNames": ["hernandez", "football", "france"] }
+					{"id":1,"name":"lucas_hernandez", "description":"Lucas Hernandez", "fileUrl":"http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png?X-Amz-Signature=abc", "tagNames": ["hernandez", "football", "france"], "status":"ready" }
 				]}`,
 		},
 		{
@@ -106,10 +256,12 @@ func TestGetMedias(t *testing.T) {
 			api := app.Group("/api")
 
 			mockMediaRepository := new(mockMediaRepository)
-			mockMediaRepository.On("FindByTag", tt.tag).Return(tt.mockReturn, tt.mockError)
+			mockMediaRepository.On("Find", mock.Anything, repositories.MediaFilter{Status: models.MediaReady, Tag: tt.tag}).Return(tt.mockReturn, tt.mockError)
 			mockTagRepository := new(mockTagRepository)
 			mockStorageService := new(mockStorageService)
-			mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService)
+			mockStorageService.On("PresignedGetObject", mock.Anything, "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png").
+				Return("http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png?X-Amz-Signature=abc", nil)
+			mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService, nil, nil, nil, nil, nil)
 			mediaController := NewMediaController(*mediaService)
 
 			// routes
@@ -128,12 +280,64 @@ func TestGetMedias(t *testing.T) {
 	}
 }
 
+func TestGetMediasFilters(t *testing.T) {
+	tests := []struct {
+		description        string
+		query              string
+		expectedFilter     repositories.MediaFilter
+		expectedStatusCode int
+	}{
+		{
+			description:        "Get medias should filter 4K clips shorter than 30s with audio",
+			query:              "?tag=4&maxDuration=30&minWidth=3840&hasAudio=true",
+			expectedFilter:     repositories.MediaFilter{Status: models.MediaReady, Tag: "4", MaxDuration: ptr(30.0), MinWidth: ptr(3840), HasAudio: ptr(true)},
+			expectedStatusCode: 200,
+		},
+		{
+			description:        "Get medias should filter failed medias by codec and duration range",
+			query:              "?videoCodec=hevc&minDuration=5.5&minHeight=1080&status=failed",
+			expectedFilter:     repositories.MediaFilter{Status: models.MediaFailed, VideoCodec: "hevc", MinDuration: ptr(5.5), MinHeight: ptr(1080)},
+			expectedStatusCode: 200,
+		},
+		{
+			description:        "Get medias should return HTTP status code 400 for an invalid filter",
+			query:              "?maxDuration=short",
+			expectedStatusCode: 400,
+		},
+		{
+			description:        "Get medias should return HTTP status code 400 for an unknown status",
+			query:              "?status=deleted",
+			expectedStatusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("Find", mock.Anything, tt.expectedFilter).Return([]models.MediaWithTagNames{{ID: 1, Name: "kickoff"}}, nil)
+			mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), new(mockStorageService), nil, nil, nil, nil, nil)
+			mediaController := NewMediaController(*mediaService)
+			app.Get("/api/medias", mediaController.GetMedias)
+
+			req := httptest.NewRequest("GET", "/api/medias"+tt.query, nil)
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode == 200 {
+				mockMediaRepository.AssertExpectations(t)
+			}
+		})
+	}
+}
+
 func TestCreateMedia(t *testing.T) {
 	tests := []struct {
 		description          string
 		setupRequest         func() (*http.Request, error)
 		mockTagIDs           []uint
-		mockFileUrl          string
+		mockObjectKey        string
 		mockId               uint
 		mockRepositoryError  error
 		mockStorageError     error
@@ -155,7 +359,7 @@ func TestCreateMedia(t *testing.T) {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 				return req, nil
 			},
-			mockFileUrl:          "http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
+			mockObjectKey:        "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
 			mockTagIDs:           []uint{1, 2},
 			mockId:               1,
 			mockRepositoryError:  nil,
@@ -178,7 +382,7 @@ func TestCreateMedia(t *testing.T) {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 				return req, nil
 			},
-			mockFileUrl:          "",
+			mockObjectKey:        "",
 			mockTagIDs:           nil,
 			mockId:               0,
 			mockRepositoryError:  nil,
@@ -199,7 +403,7 @@ func TestCreateMedia(t *testing.T) {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 				return req, nil
 			},
-			mockFileUrl:          "",
+			mockObjectKey:        "",
 			mockTagIDs:           nil,
 			mockId:               0,
 			mockRepositoryError:  nil,
@@ -222,7 +426,7 @@ func TestCreateMedia(t *testing.T) {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 				return req, nil
 			},
-			mockFileUrl:          "http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
+			mockObjectKey:        "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
 			mockTagIDs:           []uint{1, 2},
 			mockId:               1,
 			mockRepositoryError:  repositories.ErrMediaExists,
@@ -245,7 +449,7 @@ func TestCreateMedia(t *testing.T) {
 				req.Header.Set("Content-Type", writer.FormDataContentType())
 				return req, nil
 			},
-			mockFileUrl:          "http://localhost:9000/medias/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
+			mockObjectKey:        "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.png",
 			mockTagIDs:           []uint{1, 2},
 			mockId:               1,
 			mockRepositoryError:  repositories.ErrMediaCreation,
@@ -261,15 +465,18 @@ func TestCreateMedia(t *testing.T) {
 			api := app.Group("/api")
 
 			mockMediaRepository := new(mockMediaRepository)
-			mockMediaRepository.On("Create", mock.AnythingOfType("*models.Media"), tt.mockTagIDs).Return(tt.mockId, tt.mockRepositoryError)
+			mockMediaRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Media"), tt.mockTagIDs).Return(tt.mockId, tt.mockRepositoryError)
+			mockMediaRepository.On("UpdateObjectKey", mock.Anything, mock.Anything, tt.mockObjectKey, models.RenditionMap(nil)).Return(nil)
+			mockMediaRepository.On("UpdateProcessing", mock.Anything, mock.Anything, mock.AnythingOfType("models.Processing")).Return(nil)
+			mockMediaRepository.On("Purge", mock.Anything, mock.Anything).Return(nil)
 			mockTagRepository := new(mockTagRepository)
 			mockStorageService := new(mockStorageService)
 			mockStorageService.On(
 				"UploadObject",
 				mock.Anything,
 				mock.AnythingOfType("*multipart.FileHeader")).
-				Return(tt.mockFileUrl, tt.mockStorageError)
-			mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService)
+				Return(tt.mockObjectKey, tt.mockStorageError)
+			mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService, nil, nil, nil, nil, nil)
 			mediaController := NewMediaController(*mediaService)
 
 			// routes
@@ -287,3 +494,579 @@ func TestCreateMedia(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateMediaQuotas(t *testing.T) {
+	tests := []struct {
+		description          string
+		reserveError         error
+		uploadError          error
+		expectedStatusCode   int
+		expectedBodyResponse string
+		expectedRelease      bool
+		expectedRecord       bool
+	}{
+		{
+			description:          "Create media should count the uploaded file in the usage of the organization",
+			expectedStatusCode:   201,
+			expectedBodyResponse: `{"success":true,"message":"File uploaded"}`,
+			expectedRecord:       true,
+		},
+		{
+			description:          "Create media should return HTTP status code 413 when the file exceeds the storage quota",
+			reserveError:         fmt.Errorf("%w: the organization stores 990 bytes out of 1000, 13 more bytes cannot be stored", repositories.ErrStorageQuotaExceeded),
+			expectedStatusCode:   413,
+			expectedBodyResponse: `{"success":false,"message":"storage quota exceeded: the organization stores 990 bytes out of 1000, 13 more bytes cannot be stored"}`,
+		},
+		{
+			description:          "Create media should return HTTP status code 402 when the organization stores as many medias as its quota",
+			reserveError:         fmt.Errorf("%w: the organization stores 100 medias out of 100", repositories.ErrMediaQuotaExceeded),
+			expectedStatusCode:   402,
+			expectedBodyResponse: `{"success":false,"message":"media quota exceeded: the organization stores 100 medias out of 100"}`,
+		},
+		{
+			description:          "Create media should release the usage of a file which could not be uploaded",
+			uploadError:          errors.New("connection refused"),
+			expectedStatusCode:   500,
+			expectedBodyResponse: `{"success":false,"message":"internal server error"}`,
+			expectedRelease:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("file", "baseball.png")
+			part.Write([]byte("baseball game"))
+			writer.WriteField("name", "baseball")
+			writer.WriteField("tags", "[1,3]")
+			writer.Close()
+
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Media"), []uint{1, 3}).Return(uint(1), nil)
+			mockMediaRepository.On("UpdateObjectKey", mock.Anything, mock.Anything, "611e175c.png", models.RenditionMap(nil)).Return(nil)
+			mockMediaRepository.On("UpdateProcessing", mock.Anything, mock.Anything, mock.AnythingOfType("models.Processing")).Return(nil)
+			mockMediaRepository.On("Purge", mock.Anything, mock.Anything).Return(nil)
+			mockTagRepository := new(mockTagRepository)
+			mockTagRepository.On("Find", mock.Anything).Return([]*models.Tag{{ID: 1, Name: "baseball"}, {ID: 2, Name: "rugby"}, {ID: 3, Name: "final"}}, nil)
+			mockStorageService := new(mockStorageService)
+			mockStorageService.On("UploadObject", mock.Anything, mock.AnythingOfType("*multipart.FileHeader")).Return("611e175c.png", tt.uploadError)
+			mockUsageRepository := new(mockUsageRepository)
+			mockUsageRepository.On("Reserve", mock.Anything, int64(13)).Return(tt.reserveError)
+			mockUsageRepository.On("Release", mock.Anything, int64(13)).Return(nil)
+			mockUsageRepository.On("Record", mock.Anything, mock.Anything).Return(nil)
+			mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService, nil, nil, nil, services.NewUsageService(mockUsageRepository), nil)
+			app.Post("/api/medias", NewMediaController(*mediaService).CreateMedia)
+
+			req := httptest.NewRequest("POST", "/api/medias", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			responseBody, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(responseBody))
+			if tt.reserveError != nil {
+				mockMediaRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything)
+			}
+			if tt.expectedRelease {
+				mockUsageRepository.AssertCalled(t, "Release", mock.Anything, int64(13))
+			} else {
+				mockUsageRepository.AssertNotCalled(t, "Release", mock.Anything, mock.Anything)
+			}
+			if tt.expectedRecord {
+				counters := mockUsageRepository.Calls[len(mockUsageRepository.Calls)-1].Arguments.Get(1).([]models.UsageCounter)
+				var keys []string
+				for _, counter := range counters {
+					assert.Equal(t, int64(13), counter.Bytes)
+					assert.Equal(t, int64(1), counter.Medias)
+					keys = append(keys, counter.Dimension+":"+counter.Key)
+				}
+				assert.Equal(t, []string{"total:", "content_type:image/png", "tag:baseball", "tag:final"}, keys)
+			} else {
+				mockUsageRepository.AssertNotCalled(t, "Record", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestCreateMediaGeneratesRenditions(t *testing.T) {
+	app := fiber.New()
+	api := app.Group("/api")
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	var content bytes.Buffer
+	assert.NoError(t, png.Encode(&content, img))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "stadium.png")
+	part.Write(content.Bytes())
+	writer.WriteField("name", "stadium")
+	writer.WriteField("tags", "[1]")
+	writer.Close()
+
+	presets, err := services.ParseRenditionPresets("thumb:100x100,small:400x400")
+	assert.NoError(t, err)
+
+	mockMediaRepository := new(mockMediaRepository)
+	mockMediaRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Media"), []uint{1}).
+		Run(func(args mock.Arguments) { args.Get(1).(*models.Media).ID = 1 }).
+		Return(uint(1), nil)
+	mockMediaRepository.On("UpdateObjectKey", mock.Anything, uint(1), "611e175c.png", models.RenditionMap(nil)).Return(nil)
+	mockMediaRepository.On("UpdateProcessing", mock.Anything, uint(1), mock.MatchedBy(func(processing models.Processing) bool {
+		return processing.Status == models.MediaProcessing && processing.UploadedAt != nil
+	})).Return(nil).Once()
+	mockMediaRepository.On("UpdateProcessing", mock.Anything, uint(1), mock.MatchedBy(func(processing models.Processing) bool {
+		return processing.Status == models.MediaReady && processing.ReadyAt != nil
+	})).Return(nil).Once()
+	mockMediaRepository.On("UpdatePerceptualHash", mock.Anything, uint(1), mock.AnythingOfType("int64")).Return(nil)
+	mockMediaRepository.On("UpdateRenditions", mock.Anything, uint(1), models.RenditionMap{
+		"thumb": "renditions/611e175c/thumb.png",
+		"small": "renditions/611e175c/small.png",
+	}, "thumb:100x100,small:400x400").Return(nil)
+	mockTagRepository := new(mockTagRepository)
+	mockStorageService := new(mockStorageService)
+	mockStorageService.On("UploadObject", mock.Anything, mock.AnythingOfType("*multipart.FileHeader")).
+		Return("611e175c.png", nil)
+	mockStorageService.On("PutObject", mock.Anything, "renditions/611e175c/thumb.png", mock.Anything, mock.Anything, "image/png").
+		Return(nil)
+	mockStorageService.On("PutObject", mock.Anything, "renditions/611e175c/small.png", mock.Anything, mock.Anything, "image/png").
+		Return(nil)
+	renditionService := services.NewRenditionService(mockMediaRepository, mockStorageService, presets)
+	mediaService := services.NewMediaService(mockMediaRepository, mockTagRepository, mockStorageService, renditionService, nil, nil, nil, nil)
+	mediaController := NewMediaController(*mediaService)
+
+	api.Route("medias", func(router fiber.Router) {
+		router.Post("/", mediaController.CreateMedia)
+	})
+
+	req := httptest.NewRequest("POST", "/api/medias", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, _ := app.Test(req)
+
+	assert.Equal(t, 201, resp.StatusCode)
+	mockMediaRepository.AssertExpectations(t)
+	mockStorageService.AssertExpectations(t)
+}
+
+func TestGetDuplicates(t *testing.T) {
+	app := fiber.New()
+	api := app.Group("/api")
+
+	mockMediaRepository := new(mockMediaRepository)
+	mockMediaRepository.On("FindHashesByTag", mock.Anything, "3").Return([]models.Media{
+		{ID: 1, Name: "burst_1", PerceptualHash: ptr(int64(0b1111_0000))},
+		{ID: 2, Name: "portrait", PerceptualHash: ptr(int64(-1))},
+		{ID: 3, Name: "burst_2", PerceptualHash: ptr(int64(0b1111_0001))},
+		{ID: 4, Name: "burst_3", PerceptualHash: ptr(int64(0b1111_0011))},
+	}, nil)
+	mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), new(mockStorageService), nil, nil, nil, nil, nil)
+	mediaController := NewMediaController(*mediaService)
+
+	api.Route("medias", func(router fiber.Router) {
+		router.Get("/duplicates", mediaController.GetDuplicates)
+	})
+
+	req := httptest.NewRequest("GET", "/api/medias/duplicates?tag=3&distance=1", nil)
+	resp, _ := app.Test(req)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	var body struct {
+		Data [][]models.Media `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body.Data, 1)
+	var ids []uint
+	for _, media := range body.Data[0] {
+		ids = append(ids, media.ID)
+	}
+	assert.Equal(t, []uint{1, 3, 4}, ids)
+}
+
+func TestSetFocalPoint(t *testing.T) {
+	tests := []struct {
+		description          string
+		body                 string
+		mockMedia            *models.Media
+		mockError            error
+		expectedFocalPoint   models.FocalPoint
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Set focal point should save the focal point and crop boxes and return HTTP status code 200",
+			body:               `{"x":0.25,"y":0.75,"crops":{"1:1":{"x":0,"y":0,"width":0.5,"height":1}}}`,
+			mockMedia:          &models.Media{ID: 1, Name: "goal", MediaFiles: models.MediaFiles{ObjectKey: "goal.mp4"}, ContentType: "video/mp4", Processing: models.Processing{Status: models.MediaReady}},
+			expectedFocalPoint: models.FocalPoint{X: ptr(0.25), Y: ptr(0.75), Crops: models.CropMap{"1:1": {X: 0, Y: 0, Width: 0.5, Height: 1}}},
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":{"id":1,"name":"goal","description":"","fileUrl":"http://localhost:9000/medias/goal.mp4?X-Amz-Signature=abc","FileSize":0,"contentType":"video/mp4",
+					"focalX":0.25,"focalY":0.75,"crops":{"1:1":{"x":0,"y":0,"width":0.5,"height":1}},
+					"status":"ready","downloadCount":0,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","Tags":null}}`,
+		},
+		{
+			description:          "Set focal point should return HTTP status code 400 for a focal point outside the image",
+			body:                 `{"x":1.5,"y":0.5}`,
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid focal point: x and y must be between 0 and 1","data":null}`,
+		},
+		{
+			description:          "Set focal point should return HTTP status code 400 for an invalid aspect ratio",
+			body:                 `{"crops":{"square":{"x":0,"y":0,"width":1,"height":1}}}`,
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid focal point: invalid aspect ratio \"square\"","data":null}`,
+		},
+		{
+			description:          "Set focal point should return HTTP status code 404 for an unexisting media",
+			body:                 `{"x":0.5,"y":0.5}`,
+			mockMedia:            (*models.Media)(nil),
+			mockError:            repositories.ErrMediaNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"media not found","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			api := app.Group("/api")
+
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "1").Return(tt.mockMedia, tt.mockError)
+			mockMediaRepository.On("UpdateFocalPoint", mock.Anything, uint(1), tt.expectedFocalPoint).Return(nil)
+			mockStorageService := new(mockStorageService)
+			mockStorageService.On("PresignedGetObject", mock.Anything, "goal.mp4").Return("http://localhost:9000/medias/goal.mp4?X-Amz-Signature=abc", nil)
+			mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), mockStorageService, nil, nil, nil, nil, nil)
+			mediaController := NewMediaController(*mediaService)
+
+			api.Route("medias", func(router fiber.Router) {
+				router.Put("/:id/focal-point", mediaController.SetFocalPoint)
+			})
+
+			req := httptest.NewRequest("PUT", "/api/medias/1/focal-point", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestRetryProcessing(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	var content bytes.Buffer
+	assert.NoError(t, png.Encode(&content, img))
+
+	tests := []struct {
+		description        string
+		mockMedia          *models.Media
+		mockError          error
+		expectedStatus     string
+		expectedStatusCode int
+	}{
+		{
+			description: "Retry processing should process again the stored file of a failed media and return HTTP status code 200",
+			mockMedia: &models.Media{ID: 1, Name: "stadium", MediaFiles: models.MediaFiles{ObjectKey: "stadium.png"}, ContentType: "image/png",
+				Processing: models.Processing{Status: models.MediaFailed, FailureReason: "unable to decode image"}},
+			expectedStatus:     models.MediaReady,
+			expectedStatusCode: 200,
+		},
+		{
+			description:        "Retry processing should return HTTP status code 409 for a media which is ready",
+			mockMedia:          &models.Media{ID: 1, Name: "stadium", MediaFiles: models.MediaFiles{ObjectKey: "stadium.png"}, ContentType: "image/png", Processing: models.Processing{Status: models.MediaReady}},
+			expectedStatusCode: 409,
+		},
+		{
+			description:        "Retry processing should return HTTP status code 404 for an unexisting media",
+			mockMedia:          (*models.Media)(nil),
+			mockError:          repositories.ErrMediaNotFound,
+			expectedStatusCode: 404,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "1").Return(tt.mockMedia, tt.mockError)
+			mockMediaRepository.On("UpdateProcessing", mock.Anything, uint(1), mock.AnythingOfType("models.Processing")).Return(nil)
+			mockMediaRepository.On("UpdatePerceptualHash", mock.Anything, uint(1), mock.AnythingOfType("int64")).Return(nil)
+			mockStorageService := new(mockStorageService)
+			mockStorageService.On("GetObject", mock.Anything, "stadium.png").Return(io.NopCloser(bytes.NewReader(content.Bytes())), nil)
+			mockStorageService.On("PresignedGetObject", mock.Anything, "stadium.png").Return("http://localhost:9000/medias/stadium.png?X-Amz-Signature=abc", nil)
+			mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), mockStorageService, nil, nil, nil, nil, nil)
+			mediaController := NewMediaController(*mediaService)
+			app.Post("/api/medias/:id/retry", mediaController.RetryProcessing)
+
+			resp, _ := app.Test(httptest.NewRequest("POST", "/api/medias/1/retry", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode != 200 {
+				return
+			}
+			var body struct {
+				Data models.Media `json:"data"`
+			}
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.expectedStatus, body.Data.Status)
+			assert.Empty(t, body.Data.FailureReason)
+			assert.NotNil(t, body.Data.ReadyAt)
+			mockMediaRepository.AssertCalled(t, "UpdatePerceptualHash", mock.Anything, uint(1), mock.AnythingOfType("int64"))
+		})
+	}
+}
+
+type mockJobRepository struct {
+	mock.Mock
+}
+
+func (r *mockJobRepository) Enqueue(job *models.Job) error {
+	args := r.Called(job)
+	return args.Error(0)
+}
+
+func (r *mockJobRepository) Claim(types []string, worker string, now time.Time) (*models.Job, error) {
+	args := r.Called(types, worker, now)
+	return args.Get(0).(*models.Job), args.Error(1)
+}
+
+func (r *mockJobRepository) Complete(id uint, worker string, now time.Time) error {
+	args := r.Called(id, worker, now)
+	return args.Error(0)
+}
+
+func (r *mockJobRepository) Retry(id uint, worker string, lastError string, runAt time.Time) error {
+	args := r.Called(id, worker, lastError, runAt)
+	return args.Error(0)
+}
+
+func (r *mockJobRepository) Bury(id uint, worker string, lastError string) error {
+	args := r.Called(id, worker, lastError)
+	return args.Error(0)
+}
+
+func (r *mockJobRepository) RequeueStale(lockedBefore time.Time) (int64, int64, error) {
+	args := r.Called(lockedBefore)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
+}
+
+func TestCreateMediaEnqueuesProcessing(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	var content bytes.Buffer
+	assert.NoError(t, png.Encode(&content, img))
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "stadium.png")
+	part.Write(content.Bytes())
+	writer.WriteField("name", "stadium")
+	writer.WriteField("tags", "[1]")
+	writer.Close()
+
+	var media *models.Media
+	mockMediaRepository := new(mockMediaRepository)
+	mockMediaRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Media"), []uint{1}).
+		Run(func(args mock.Arguments) {
+			media = args.Get(1).(*models.Media)
+			media.ID, media.OrganizationID = 1, 3
+		}).
+		Return(uint(1), nil)
+	mockMediaRepository.On("UpdateObjectKey", mock.Anything, uint(1), "stadium.png", models.RenditionMap(nil)).Return(nil)
+	mockMediaRepository.On("UpdateProcessing", mock.Anything, uint(1), mock.AnythingOfType("models.Processing")).Return(nil)
+	mockStorageService := new(mockStorageService)
+	mockStorageService.On("UploadObject", mock.Anything, mock.AnythingOfType("*multipart.FileHeader")).Return("stadium.png", nil)
+	var job *models.Job
+	mockJobRepository := new(mockJobRepository)
+	mockJobRepository.On("Enqueue", mock.AnythingOfType("*models.Job")).
+		Run(func(args mock.Arguments) {
+			job = args.Get(0).(*models.Job)
+			job.ID = 7
+		}).
+		Return(nil)
+	jobQueue := services.NewJobQueue(mockJobRepository, services.JobQueueOptions{})
+	mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), mockStorageService, nil, nil, jobQueue, nil, nil)
+	jobQueue.Handle(services.JobProcessMedia, mediaService.HandleProcessMedia)
+	mediaController := NewMediaController(*mediaService)
+	app := fiber.New()
+	app.Post("/api/medias", mediaController.CreateMedia)
+
+	req := httptest.NewRequest("POST", "/api/medias", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, _ := app.Test(req)
+
+	// the upload returns before the media is processed
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, services.JobProcessMedia, job.Type)
+	assert.JSONEq(t, `{"mediaId":1,"organizationId":3}`, job.Payload)
+	assert.Equal(t, models.MediaProcessing, media.Status)
+	mockMediaRepository.AssertNotCalled(t, "UpdatePerceptualHash", mock.Anything, mock.Anything, mock.Anything)
+
+	// a worker processes the media from its stored file
+	mockJobRepository.On("Claim", []string{services.JobProcessMedia}, "worker", mock.Anything).Return(job, nil)
+	mockJobRepository.On("Complete", uint(7), "worker", mock.Anything).Return(nil)
+	mockMediaRepository.On("FindByID", mock.Anything, "1").Return(media, nil)
+	mockMediaRepository.On("UpdatePerceptualHash", mock.Anything, uint(1), mock.AnythingOfType("int64")).Return(nil)
+	mockStorageService.On("GetObject", mock.Anything, "stadium.png").Return(io.NopCloser(bytes.NewReader(content.Bytes())), nil)
+
+	ran, err := jobQueue.RunNext(context.Background(), []string{services.JobProcessMedia}, "worker")
+
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, models.MediaReady, media.Status)
+	mockJobRepository.AssertExpectations(t)
+	mockMediaRepository.AssertExpectations(t)
+}
+
+func TestCreateMediaRecordsUploader(t *testing.T) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "stadium.png")
+	part.Write([]byte("stadium"))
+	writer.WriteField("name", "stadium")
+	writer.WriteField("tags", "[1]")
+	writer.Close()
+
+	var media *models.Media
+	mockMediaRepository := new(mockMediaRepository)
+	mockMediaRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Media"), []uint{1}).
+		Run(func(args mock.Arguments) {
+			media = args.Get(1).(*models.Media)
+			media.ID = 1
+		}).
+		Return(uint(1), nil)
+	mockMediaRepository.On("UpdateObjectKey", mock.Anything, uint(1), "stadium.png", models.RenditionMap(nil)).Return(nil)
+	mockMediaRepository.On("UpdateProcessing", mock.Anything, uint(1), mock.AnythingOfType("models.Processing")).Return(nil)
+	mockStorageService := new(mockStorageService)
+	mockStorageService.On("UploadObject", mock.Anything, mock.AnythingOfType("*multipart.FileHeader")).Return("stadium.png", nil)
+	mockJobRepository := new(mockJobRepository)
+	mockJobRepository.On("Enqueue", mock.AnythingOfType("*models.Job")).Return(nil)
+	jobQueue := services.NewJobQueue(mockJobRepository, services.JobQueueOptions{})
+	mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), mockStorageService, nil, nil, jobQueue, nil, nil)
+	mediaController := NewMediaController(*mediaService)
+	app := fiber.New()
+	// the authentication middleware passes the identity of the caller in the context of the request
+	app.Use(func(c *fiber.Ctx) error {
+		identity := services.NewUserIdentity("user-1", "Jane", &models.Organization{ID: 1, Slug: "fc-nantes"}, []string{services.RoleEditor})
+		c.SetUserContext(services.WithIdentity(c.UserContext(), identity))
+		return c.Next()
+	})
+	app.Post("/api/medias", mediaController.CreateMedia)
+
+	req := httptest.NewRequest("POST", "/api/medias", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, _ := app.Test(req)
+
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Equal(t, "user-1", media.UploadedBy)
+}
+
+func TestDeleteMedia(t *testing.T) {
+	tests := []struct {
+		description          string
+		mockMedia            *models.Media
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:          "Delete media should move the media to the trash and return HTTP status code 200",
+			mockMedia:            &models.Media{ID: 1, Name: "goal"},
+			expectedStatusCode:   200,
+			expectedBodyResponse: `{"success":true,"message":""}`,
+		},
+		{
+			description:          "Delete media should return HTTP status code 404 if the media does not exist",
+			mockError:            repositories.ErrMediaNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"media not found"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "1").Return(tt.mockMedia, tt.mockError)
+			mockMediaRepository.On("Delete", mock.Anything, uint(1)).Return(nil)
+			mockAuditRepository := new(mockAuditRepository)
+			mockAuditRepository.On("Create", mock.Anything, mock.Anything).Return(nil)
+			mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), new(mockStorageService), nil, nil, nil, nil, services.NewAuditService(mockAuditRepository))
+			app.Delete("/api/medias/:id", NewMediaController(*mediaService).DeleteMedia)
+
+			resp, _ := app.Test(httptest.NewRequest("DELETE", "/api/medias/1", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+			if tt.mockMedia == nil {
+				mockMediaRepository.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+				return
+			}
+			mockMediaRepository.AssertCalled(t, "Delete", mock.Anything, uint(1))
+			mockAuditRepository.AssertNumberOfCalls(t, "Create", 1)
+			event := mockAuditRepository.Calls[0].Arguments.Get(1).(*models.AuditEvent)
+			assert.Equal(t, models.AuditDelete, event.Action)
+			assert.Equal(t, models.AuditMedia, event.EntityType)
+		})
+	}
+}
+
+func TestRestoreMedia(t *testing.T) {
+	tests := []struct {
+		description          string
+		mockMedia            *models.Media
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Restore media should move the media out of the trash and return HTTP status code 200",
+			mockMedia:          &models.Media{ID: 1, Name: "stadium", MediaFiles: models.MediaFiles{ObjectKey: "stadium.png"}, ContentType: "image/png", Processing: models.Processing{Status: models.MediaReady}},
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":{"id":1,"name":"stadium","description":"","fileUrl":"http://localhost:9000/medias/stadium.png?X-Amz-Signature=abc","FileSize":0,"contentType":"image/png",
+					"status":"ready","downloadCount":0,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","Tags":null}}`,
+		},
+		{
+			description:          "Restore media should return HTTP status code 404 if the media is not in the trash",
+			mockError:            repositories.ErrMediaNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"media not found","data":null}`,
+		},
+		{
+			description:          "Restore media should return HTTP status code 409 if another media took its name",
+			mockError:            repositories.ErrMediaExists,
+			expectedStatusCode:   409,
+			expectedBodyResponse: `{"success":false,"message":"a media with the same name already exists","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("Restore", mock.Anything, "1").Return(tt.mockMedia, tt.mockError)
+			mockStorageService := new(mockStorageService)
+			mockStorageService.On("PresignedGetObject", mock.Anything, "stadium.png").Return("http://localhost:9000/medias/stadium.png?X-Amz-Signature=abc", nil)
+			mediaService := services.NewMediaService(mockMediaRepository, new(mockTagRepository), mockStorageService, nil, nil, nil, nil, nil)
+			app.Post("/api/medias/:id/restore", NewMediaController(*mediaService).RestoreMedia)
+
+			resp, _ := app.Test(httptest.NewRequest("POST", "/api/medias/1/restore", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
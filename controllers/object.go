@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// ObjectController serves the signed object urls of the storage drivers without presigned urls
+type ObjectController struct {
+	storage    services.IStorageService
+	signer     *services.ObjectUrlSigner
+	bucketName string
+}
+
+func NewObjectController(storage services.IStorageService, signer *services.ObjectUrlSigner, bucketName string) *ObjectController {
+	return &ObjectController{
+		storage:    storage,
+		signer:     signer,
+		bucketName: bucketName,
+	}
+}
+
+// GetObject godoc
+//
+//	@Summary		Download a stored object
+//	@Description	Download an object with a time-limited url returned in media responses (filesystem and memory storage drivers, SSE-C encrypted objects)
+//	@Tags			Media
+//	@Param			bucket		path	string	true	"Bucket name"
+//	@Param			key			path	string	true	"Object key"
+//	@Param			expires		query	int		true	"Expiration unix timestamp"
+//	@Param			signature	query	string	true	"Url signature"
+//	@Success		200			{file}	binary	"Returns the object content"
+//	@Failure		403			{object}	controllers.GetObject.response	"Returns error for invalid or expired url"
+//	@Failure		404			{object}	controllers.GetObject.response	"Returns error when object is not found"
+//	@Failure		503			{object}	controllers.GetObject.response	"Returns error when the storage is unavailable"
+//	@Router			/objects/{bucket}/{key} [GET]
+func (ctrl ObjectController) GetObject(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	objectName, err := url.PathUnescape(c.Params("*"))
+	if err != nil || c.Params("bucket") != ctrl.bucketName {
+		return c.Status(404).JSON(response{
+			Success: false,
+			Message: "object not found",
+		})
+	}
+	if err := ctrl.signer.Verify(ctrl.bucketName, objectName, c.Query("expires"), c.Query("signature")); err != nil {
+		return c.Status(403).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+
+	info, err := ctrl.storage.StatObject(c.UserContext(), objectName)
+	if err == nil {
+		object, err := ctrl.storage.GetObject(c.UserContext(), objectName)
+		if err == nil {
+			c.Set(fiber.HeaderContentType, info.ContentType)
+			c.Set(fiber.HeaderETag, `"`+info.ETag+`"`)
+			return c.Status(200).SendStream(object, int(info.Size))
+		}
+	}
+	if errors.Is(err, services.ErrObjectNotFound) {
+		return c.Status(404).JSON(response{
+			Success: false,
+			Message: "object not found",
+		})
+	}
+	if storageUnavailable(c, err) {
+		return c.Status(503).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+	return c.Status(500).JSON(response{
+		Success: false,
+		Message: "internal server error",
+	})
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetObject(t *testing.T) {
+	options := services.StorageOptions{
+		Driver:        "memory",
+		BucketName:    "medias",
+		PublicUrl:     "http://localhost:3000/objects",
+		UrlSigningKey: "secret",
+		PresignTTL:    time.Minute,
+	}
+	storage, err := services.NewStorage(context.Background(), options)
+	require.NoError(t, err)
+	require.NoError(t, storage.PutObject(context.Background(), "renditions/goal/thumb.jpg", strings.NewReader("thumbnail"), 9, "image/jpeg"))
+	presignedUrl, err := storage.PresignedGetObject(context.Background(), "renditions/goal/thumb.jpg")
+	require.NoError(t, err)
+	parsed, err := url.Parse(presignedUrl)
+	require.NoError(t, err)
+
+	tests := []struct {
+		description        string
+		target             string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			description:        "Get object should return the object content for a signed url and HTTP status code 200",
+			target:             parsed.RequestURI(),
+			expectedStatusCode: 200,
+			expectedBody:       "thumbnail",
+		},
+		{
+			description:        "Get object should return HTTP status code 403 for a tampered url",
+			target:             strings.Replace(parsed.RequestURI(), "thumb.jpg", "small.jpg", 1),
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Get object should return HTTP status code 403 for an expired url",
+			target:             "/objects/medias/renditions/goal/thumb.jpg?expires=1&signature=" + parsed.Query().Get("signature"),
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Get object should return HTTP status code 403 without signature",
+			target:             "/objects/medias/renditions/goal/thumb.jpg",
+			expectedStatusCode: 403,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			objectController := NewObjectController(storage, services.NewObjectUrlSigner(options), "medias")
+			app.Get("/objects/:bucket/*", objectController.GetObject)
+
+			resp, _ := app.Test(httptest.NewRequest("GET", tt.target, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode == 200 {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, tt.expectedBody, string(body))
+				assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestGetObjectStorageUnavailable(t *testing.T) {
+	mockStorage := new(mockStorageService)
+	mockStorage.On("StatObject", mock.Anything, "goal.jpg").Return(services.ObjectInfo{}, errors.New("connection refused"))
+	storage := services.NewResilientStorage(t.Name(), mockStorage, services.ResilienceOptions{BreakerThreshold: 1, BreakerCooldown: time.Minute})
+	options := services.StorageOptions{UrlSigningKey: "secret", PresignTTL: time.Minute}
+	signer := services.NewObjectUrlSigner(options)
+	parsed, err := url.Parse(signer.Sign("medias", "goal.jpg"))
+	require.NoError(t, err)
+
+	app := fiber.New()
+	objectController := NewObjectController(storage, signer, "medias")
+	app.Get("/objects/:bucket/*", objectController.GetObject)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", parsed.RequestURI(), nil))
+	assert.Equal(t, 500, resp.StatusCode)
+
+	// the breaker is now open: the storage is not called anymore
+	resp, _ = app.Test(httptest.NewRequest("GET", parsed.RequestURI(), nil))
+	assert.Equal(t, 503, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+	mockStorage.AssertNumberOfCalls(t, "StatObject", 1)
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+type RenderController struct {
+	service services.RenderService
+}
+
+func NewRenderController(service services.RenderService) *RenderController {
+	return &RenderController{
+		service,
+	}
+}
+
+// RenderMedia godoc
+//
+//	@Summary		Render a resized image
+//	@Description	Resize and crop an image media. Results are cached. Parameters must be signed with the render signing key. Responses carry an etag that changes with the focal point and crop boxes of the media.
+//	@Tags			Media
+//	@Produce		image/jpeg,image/png,image/gif
+//	@Param			id		path		string	true	"Media id"
+//	@Param			w		query		int		false	"Output width"
+//	@Param			h		query		int		false	"Output height"
+//	@Param			fit		query		string	false	"contain (default), cover or fill"
+//	@Param			format	query		string	false	"jpeg, png or gif (default: original format)"
+//	@Param			sig		query		string	true	"HMAC-SHA256 signature of the parameters"
+//	@Param			If-None-Match	header		string	false	"etag of a cached copy"
+//	@Success		200		{file}		binary	"Returns the rendered image"
+//	@Success		304		{string}	string	"Returns no content when the cached copy is up to date"
+//	@Failure		400		{object}	controllers.RenderMedia.response	"Returns error for invalid parameters"
+//	@Failure		403		{object}	controllers.RenderMedia.response	"Returns error for invalid signature"
+//	@Failure		404		{object}	controllers.RenderMedia.response	"Returns error when media is not found"
+//	@Failure		415		{object}	controllers.RenderMedia.response	"Returns error when media is not an image"
+//	@Failure		500		{object}	controllers.RenderMedia.response	"Returns error for internal server error"
+//	@Failure		503		{object}	controllers.RenderMedia.response	"Returns error when the storage is unavailable"
+//	@Router			/api/medias/{id}/render [GET]
+func (ctrl RenderController) RenderMedia(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	params := services.RenderParams{
+		Width:     c.QueryInt("w"),
+		Height:    c.QueryInt("h"),
+		Fit:       c.Query("fit"),
+		Format:    c.Query("format"),
+		Signature: c.Query("sig"),
+	}
+
+	result, err := ctrl.service.Render(c.UserContext(), c.Params("id"), params)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidRenderParams), errors.Is(err, services.ErrRenderSizeNotAllowed):
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, services.ErrInvalidSignature):
+			return c.Status(403).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrMediaNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, services.ErrMediaNotRenderable):
+			return c.Status(415).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case storageUnavailable(c, err):
+			return c.Status(503).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+	}
+
+	// the url stays the same when the focal point or the crop boxes change: cached copies are revalidated with the etag
+	etag := `"` + result.ETag + `"`
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	if header := c.Get(fiber.HeaderIfNoneMatch); header != "" && etagMatches(header, etag) {
+		result.Reader.Close()
+		return c.SendStatus(304)
+	}
+	c.Set(fiber.HeaderContentType, result.ContentType)
+	return c.Status(200).SendStream(result.Reader, int(result.Size))
+}
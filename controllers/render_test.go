@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderMedia(t *testing.T) {
+	var original bytes.Buffer
+	assert.NoError(t, png.Encode(&original, image.NewRGBA(image.Rect(0, 0, 1200, 800))))
+	options := services.RenderOptions{SigningKey: "secret", MaxWidth: 2000, MaxHeight: 2000}
+
+	tests := []struct {
+		description        string
+		params             services.RenderParams
+		signature          string
+		cached             bool
+		expectedStatusCode int
+		expectedWidth      int
+		expectedHeight     int
+	}{
+		{
+			description:        "Render media should return a cropped image and HTTP status code 200",
+			params:             services.RenderParams{Width: 300, Height: 300, Fit: "cover"},
+			expectedStatusCode: 200,
+			expectedWidth:      300,
+			expectedHeight:     300,
+		},
+		{
+			description:        "Render media should keep the aspect ratio when only the width is set",
+			params:             services.RenderParams{Width: 600},
+			expectedStatusCode: 200,
+			expectedWidth:      600,
+			expectedHeight:     400,
+		},
+		{
+			description:        "Render media should return a cached image",
+			params:             services.RenderParams{Width: 300, Height: 300, Fit: "cover"},
+			cached:             true,
+			expectedStatusCode: 200,
+			expectedWidth:      1200,
+			expectedHeight:     800,
+		},
+		{
+			description:        "Render media should return HTTP status code 403 for an invalid signature",
+			params:             services.RenderParams{Width: 300, Height: 300},
+			signature:          "invalid",
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Render media should return HTTP status code 400 when the size exceeds the maximum",
+			params:             services.RenderParams{Width: 5000},
+			expectedStatusCode: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			api := app.Group("/api")
+
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "1").Return(&models.Media{
+				ID:          1,
+				MediaFiles:  models.MediaFiles{ObjectKey: "611e175c.png"},
+				ContentType: "image/png",
+			}, nil)
+			mockStorageService := new(mockStorageService)
+			if tt.cached {
+				mockStorageService.On("StatObject", mock.Anything, mock.Anything).
+					Return(services.ObjectInfo{Size: int64(original.Len())}, nil)
+				mockStorageService.On("GetObject", mock.Anything, mock.Anything).
+					Return(io.NopCloser(bytes.NewReader(original.Bytes())), nil)
+			} else {
+				mockStorageService.On("StatObject", mock.Anything, mock.Anything).
+					Return(services.ObjectInfo{}, services.ErrObjectNotFound)
+				mockStorageService.On("GetObject", mock.Anything, "611e175c.png").
+					Return(io.NopCloser(bytes.NewReader(original.Bytes())), nil)
+				mockStorageService.On("PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, "image/png").
+					Return(nil)
+			}
+			renderService := services.NewRenderService(mockMediaRepository, mockStorageService, options)
+			renderController := NewRenderController(*renderService)
+
+			api.Route("medias", func(router fiber.Router) {
+				router.Get("/:id/render", renderController.RenderMedia)
+			})
+
+			signature := tt.signature
+			if signature == "" {
+				signature = renderService.Sign("1", tt.params)
+			}
+			query := fmt.Sprintf("?w=%d&h=%d&fit=%s&sig=%s", tt.params.Width, tt.params.Height, tt.params.Fit, signature)
+			req := httptest.NewRequest("GET", "/api/medias/1/render"+query, nil)
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode == 200 {
+				assert.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+				img, _, err := image.Decode(resp.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedWidth, img.Bounds().Dx())
+				assert.Equal(t, tt.expectedHeight, img.Bounds().Dy())
+			}
+		})
+	}
+}
+
+func TestRenderMediaUsesFocalPoint(t *testing.T) {
+	// left half red, right half blue
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+	var original bytes.Buffer
+	assert.NoError(t, png.Encode(&original, img))
+
+	tests := []struct {
+		description   string
+		media         *models.Media
+		expectedColor color.RGBA
+	}{
+		{
+			description:   "Render media should center the crop on the focal point",
+			media:         &models.Media{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "a.png"}, ContentType: "image/png", FocalX: ptr(0.9), FocalY: ptr(0.5)},
+			expectedColor: color.RGBA{B: 255, A: 255},
+		},
+		{
+			description: "Render media should use the crop box of the aspect ratio",
+			media: &models.Media{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "a.png"}, ContentType: "image/png", FocalX: ptr(0.9), FocalY: ptr(0.5),
+				Crops: models.CropMap{"1:1": {X: 0, Y: 0, Width: 0.25, Height: 1}}},
+			expectedColor: color.RGBA{R: 255, A: 255},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindByID", mock.Anything, "1").Return(tt.media, nil)
+			mockStorageService := new(mockStorageService)
+			mockStorageService.On("StatObject", mock.Anything, mock.Anything).Return(services.ObjectInfo{}, services.ErrObjectNotFound)
+			mockStorageService.On("GetObject", mock.Anything, "a.png").Return(io.NopCloser(bytes.NewReader(original.Bytes())), nil)
+			mockStorageService.On("PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, "image/png").Return(nil)
+			renderService := services.NewRenderService(mockMediaRepository, mockStorageService, services.RenderOptions{SigningKey: "secret", MaxWidth: 1000, MaxHeight: 1000})
+			renderController := NewRenderController(*renderService)
+			app.Get("/api/medias/:id/render", renderController.RenderMedia)
+
+			params := services.RenderParams{Width: 50, Height: 50, Fit: "cover"}
+			req := httptest.NewRequest("GET", fmt.Sprintf("/api/medias/1/render?w=50&h=50&fit=cover&sig=%s", renderService.Sign("1", params)), nil)
+			resp, err := app.Test(req, -1)
+			require.NoError(t, err)
+
+			assert.Equal(t, 200, resp.StatusCode)
+			rendered, _, err := image.Decode(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedColor, color.RGBAModel.Convert(rendered.At(25, 25)))
+		})
+	}
+}
+
+func TestRenderMediaETag(t *testing.T) {
+	var original bytes.Buffer
+	assert.NoError(t, png.Encode(&original, image.NewRGBA(image.Rect(0, 0, 400, 100))))
+	params := services.RenderParams{Width: 50, Height: 50, Fit: "cover"}
+
+	render := func(t *testing.T, media *models.Media, ifNoneMatch string) *http.Response {
+		app := fiber.New()
+		mockMediaRepository := new(mockMediaRepository)
+		mockMediaRepository.On("FindByID", mock.Anything, "1").Return(media, nil)
+		mockStorageService := new(mockStorageService)
+		mockStorageService.On("StatObject", mock.Anything, mock.Anything).Return(services.ObjectInfo{}, services.ErrObjectNotFound)
+		mockStorageService.On("GetObject", mock.Anything, "a.png").Return(io.NopCloser(bytes.NewReader(original.Bytes())), nil)
+		mockStorageService.On("PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, "image/png").Return(nil)
+		renderService := services.NewRenderService(mockMediaRepository, mockStorageService, services.RenderOptions{SigningKey: "secret", MaxWidth: 1000, MaxHeight: 1000})
+		renderController := NewRenderController(*renderService)
+		app.Get("/api/medias/:id/render", renderController.RenderMedia)
+
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/medias/1/render?w=50&h=50&fit=cover&sig=%s", renderService.Sign("1", params)), nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+		return resp
+	}
+	centered := &models.Media{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "a.png"}, ContentType: "image/png"}
+	moved := &models.Media{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "a.png"}, ContentType: "image/png", FocalX: ptr(0.9), FocalY: ptr(0.5)}
+
+	resp := render(t, centered, "")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "public, max-age=300", resp.Header.Get("Cache-Control"))
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	t.Run("Render media should return HTTP status code 304 when the cached copy is up to date", func(t *testing.T) {
+		assert.Equal(t, 304, render(t, centered, etag).StatusCode)
+	})
+
+	t.Run("Render media should change the etag when the focal point changes", func(t *testing.T) {
+		resp := render(t, moved, etag)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+	})
+}
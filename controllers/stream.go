@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Delay between two comments sent to keep idle streams open through proxies
+const streamHeartbeatInterval = 15 * time.Second
+
+type StreamController struct {
+	stream *services.MediaStream
+}
+
+func NewStreamController(stream *services.MediaStream) *StreamController {
+	return &StreamController{
+		stream,
+	}
+}
+
+// StreamMedias godoc
+//
+//	@Summary		Stream medias
+//	@Description	Stream the medias created and updated in the organization of the caller as Server-Sent Events named media.created and media.updated, whose data is the media and whose id resumes the stream. Clients reconnecting with the Last-Event-ID header receive the events they missed within the history of the stream (STREAM_HISTORY), and again the events added within 30 seconds before it, which may have been committed after it. Clients too slow to read the events are disconnected and resume the same way.
+//	@Tags			Media
+//	@Produce		text/event-stream
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			tags			query		string	false	"comma-separated tag ids, only the medias with one of them are streamed"
+//	@Param			Last-Event-ID	header		int		false	"id of the last event received, to resume the stream"
+//	@Success		200	{string}	string								"Returns the stream of events"
+//	@Failure		400	{object}	controllers.StreamMedias.response	"Returns error for invalid tags or Last-Event-ID"
+//	@Failure		500	{object}	controllers.StreamMedias.response	"Returns error for internal server error"
+//	@Router			/api/medias/stream [GET]
+func (ctrl StreamController) StreamMedias(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Data    any    `json:"data"`
+		Message string `json:"message"`
+	}
+	tagIDs, err := parseIDs(c.Query("tags"))
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "invalid tags: " + c.Query("tags"),
+		})
+	}
+	var lastEventID uint64
+	if value := c.Get("Last-Event-ID", c.Query("lastEventId")); value != "" {
+		if lastEventID, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: "invalid Last-Event-ID: " + value,
+			})
+		}
+	}
+	subscription, err := ctrl.stream.Subscribe(c.UserContext(), tagIDs, uint(lastEventID))
+	if err != nil {
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Status(200).Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer subscription.Close()
+		heartbeat := time.NewTicker(streamHeartbeatInterval)
+		defer heartbeat.Stop()
+		send := func(event services.MediaEvent) error {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+			return w.Flush()
+		}
+		// the response is sent as soon as the stream is open
+		if err := w.Flush(); err != nil {
+			return
+		}
+		// events published while the backlog was read are received twice
+		backlog := map[uint]bool{}
+		for _, event := range subscription.Backlog {
+			backlog[event.ID] = true
+			if err := send(event); err != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case event, open := <-subscription.Events:
+				if !open {
+					return
+				}
+				if backlog[event.ID] {
+					continue
+				}
+				if err := send(event); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// parseIDs parses comma-separated ids, nil when value is empty
+func parseIDs(value string) ([]uint, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var ids []uint
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockEventRepository struct {
+	mock.Mock
+}
+
+func (m *mockEventRepository) FindAfter(ctx context.Context, afterID uint, types []string, limit int) ([]models.OutboxEvent, error) {
+	args := m.Called(ctx, afterID, types, limit)
+	return args.Get(0).([]models.OutboxEvent), args.Error(1)
+}
+
+func (m *mockEventRepository) FindSince(ctx context.Context, lastID uint, window time.Duration, types []string, limit int) ([]models.OutboxEvent, error) {
+	args := m.Called(ctx, lastID, window, types, limit)
+	return args.Get(0).([]models.OutboxEvent), args.Error(1)
+}
+
+func (m *mockEventRepository) LastID(ctx context.Context) (uint, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(uint), args.Error(1)
+}
+
+func (m *mockEventRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	args := m.Called(ctx, before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestStreamMedias(t *testing.T) {
+	tests := []struct {
+		description          string
+		path                 string
+		lastEventID          string
+		mockBacklog          []models.OutboxEvent
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description: "Stream medias should send the events missed since Last-Event-ID then the new events of the medias with one of the tags",
+			path:        "/api/medias/stream?tags=2,5",
+			lastEventID: "1",
+			mockBacklog: []models.OutboxEvent{
+				{ID: 2, OrganizationID: 7, Type: models.WebhookMediaCreated, Payload: []byte(`{"id":1,"Tags":[{"id":2}]}`)},
+				{ID: 3, OrganizationID: 7, Type: models.WebhookMediaCreated, Payload: []byte(`{"id":2,"Tags":[{"id":3}]}`)},
+			},
+			expectedStatusCode: 200,
+			expectedBodyResponse: "id: 2\nevent: media.created\ndata: {\"id\":1,\"Tags\":[{\"id\":2}]}\n\n" +
+				"id: 4\nevent: media.updated\ndata: {\"id\":1,\"Tags\":[{\"id\":5}]}\n\n",
+		},
+		{
+			description:          "Stream medias should return HTTP status code 400 for invalid tags",
+			path:                 "/api/medias/stream?tags=2,goals",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid tags: 2,goals","data":null}`,
+		},
+		{
+			description:          "Stream medias should return HTTP status code 400 for an invalid Last-Event-ID",
+			path:                 "/api/medias/stream",
+			lastEventID:          "latest",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid Last-Event-ID: latest","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			subscribed := make(chan struct{})
+			broadcast := make(chan struct{})
+			var broadcastOnce sync.Once
+			mockEventRepository := new(mockEventRepository)
+			mockEventRepository.On("LastID", mock.Anything).Return(uint(3), nil)
+			mockEventRepository.On("FindSince", mock.Anything, uint(1), mock.Anything, mock.Anything, mock.Anything).
+				Run(func(mock.Arguments) { close(subscribed) }).Return(tt.mockBacklog, nil)
+			mockEventRepository.On("FindAfter", mock.Anything, uint(3), mock.Anything, mock.Anything).
+				Run(func(mock.Arguments) { broadcastOnce.Do(func() { close(broadcast) }) }).Return([]models.OutboxEvent{
+				{ID: 4, OrganizationID: 7, Type: models.WebhookMediaUpdated, Payload: []byte(`{"id":1,"Tags":[{"id":5}]}`)},
+				{ID: 5, OrganizationID: 8, Type: models.WebhookMediaUpdated, Payload: []byte(`{"id":9,"Tags":[{"id":5}]}`)},
+			}, nil)
+			mockEventRepository.On("Prune", mock.Anything, mock.Anything).Return(int64(0), nil)
+			stream := services.NewMediaStream(mockEventRepository, nil, services.MediaStreamOptions{PollInterval: time.Millisecond})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// once subscribed, the new events are sent then the stream is stopped, which ends the response
+			go func() {
+				select {
+				case <-subscribed:
+				case <-ctx.Done():
+					return
+				}
+				running, stop := context.WithCancel(ctx)
+				go func() {
+					<-broadcast
+					stop()
+				}()
+				stream.Run(running)
+			}()
+
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				c.SetUserContext(repositories.WithTenant(c.UserContext(), 7))
+				return c.Next()
+			})
+			streamController := NewStreamController(stream)
+			app.Get("/api/medias/stream", streamController.StreamMedias)
+
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			resp, _ := app.Test(req, -1)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			if tt.expectedStatusCode != 200 {
+				assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+				return
+			}
+			assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+			assert.Equal(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
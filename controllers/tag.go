@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
 	"github.com/mich31/scoreplay-media-api/services"
 )
 
@@ -23,6 +26,8 @@ func NewTagController(service services.TagService) *TagController {
 //	@Tags			Tag
 //	@Accept			json
 //	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
 //	@Param  name  query     string  false "search by tag name"
 //	@Success		200	{object}	controllers.GetTags.response "Returns success true and a list of tags found"
 //	@Failure		500	{object}	controllers.GetTags.response "Returns error for internal server error"
@@ -34,7 +39,7 @@ func (ctrl TagController) GetTags(c *fiber.Ctx) error {
 		Message string        `json:"message"`
 	}
 	name := c.Query("name")
-	results, err := ctrl.service.GetTags(name)
+	results, err := ctrl.service.GetTags(c.UserContext(), name)
 	if err != nil {
 		return c.Status(500).JSON(response{
 			Success: false,
@@ -54,6 +59,8 @@ func (ctrl TagController) GetTags(c *fiber.Ctx) error {
 //	@Tags			Tag
 //	@Accept			json
 //	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
 //	@Param			tag	body		models.Tag	true	"tag object to be created"
 //	@Success		201	{object}	controllers.CreateTag.response	"Returns success true and created tag ID"
 //	@Failure		400	{object}	controllers.CreateTag.response	"Returns error for invalid input"
@@ -73,7 +80,7 @@ func (ctrl TagController) CreateTag(c *fiber.Ctx) error {
 		})
 	}
 
-	id, err := ctrl.service.CreateTag(input)
+	id, err := ctrl.service.CreateTag(c.UserContext(), input)
 	if err != nil {
 		return c.Status(500).JSON(response{
 			Success: false,
@@ -89,10 +96,12 @@ func (ctrl TagController) CreateTag(c *fiber.Ctx) error {
 // DeleteTag godoc
 //
 //	@Summary		Delete a tag
-//	@Description	Deletes a tag by its id
+//	@Description	Moves a tag to the trash. It is restored with POST /api/tags/{id}/restore, with its associations to medias, until it is deleted permanently after the retention of the trash.
 //	@Tags			Tag
 //	@Accept			json
 //	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
 //	@Param			id	path		string	true	"Tag id"
 //	@Success		200	{object}	controllers.DeleteTag.response	"Returns success true"
 //	@Failure		500	{object}	controllers.DeleteTag.response	"Returns error for internal server error"
@@ -103,7 +112,7 @@ func (ctrl TagController) DeleteTag(c *fiber.Ctx) error {
 		Message string `json:"message"`
 	}
 	id := c.Params("id")
-	err := ctrl.service.DeleteTag(id)
+	err := ctrl.service.DeleteTag(c.UserContext(), id)
 	if err != nil {
 		return c.Status(500).JSON(response{
 			Success: false,
@@ -114,3 +123,49 @@ func (ctrl TagController) DeleteTag(c *fiber.Ctx) error {
 		Success: true,
 	})
 }
+
+// RestoreTag godoc
+//
+//	@Summary		Restore a tag
+//	@Description	Moves a tag out of the trash, with its associations to medias
+//	@Tags			Tag
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		string	true	"Tag id"
+//	@Success		200	{object}	controllers.RestoreTag.response	"Returns success true and the restored tag"
+//	@Failure		404	{object}	controllers.RestoreTag.response	"Returns error when tag is not in the trash"
+//	@Failure		409	{object}	controllers.RestoreTag.response	"Returns error when another tag took its name"
+//	@Failure		500	{object}	controllers.RestoreTag.response	"Returns error for internal server error"
+//	@Router			/api/tags/{id}/restore [POST]
+func (ctrl TagController) RestoreTag(c *fiber.Ctx) error {
+	type response struct {
+		Success bool        `json:"success"`
+		Data    *models.Tag `json:"data"`
+		Message string      `json:"message"`
+	}
+	tag, err := ctrl.service.RestoreTag(c.UserContext(), c.Params("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrTagNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrTagExists):
+			return c.Status(409).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    tag,
+	})
+}
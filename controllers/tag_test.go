@@ -1,14 +1,17 @@
 package controllers
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
 	"github.com/mich31/scoreplay-media-api/services"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -18,23 +21,49 @@ type mockTagRepository struct {
 	mock.Mock
 }
 
-func (m *mockTagRepository) Create(tag *models.Tag) (uint, error) {
-	args := m.Called(tag)
-	return args.Get(0).(uint), args.Error(1)
+func (m *mockTagRepository) Create(ctx context.Context, tag *models.Tag) (uint, bool, error) {
+	args := m.Called(ctx, tag)
+	return args.Get(0).(uint), args.Bool(1), args.Error(2)
 }
 
-func (m *mockTagRepository) Find() ([]*models.Tag, error) {
-	args := m.Called()
+func (m *mockTagRepository) Find(ctx context.Context) ([]*models.Tag, error) {
+	args := m.Called(ctx)
 	return args.Get(0).([]*models.Tag), args.Error(1)
 }
 
-func (m *mockTagRepository) FindByName(name string) ([]*models.Tag, error) {
-	args := m.Called(name)
+func (m *mockTagRepository) FindByID(ctx context.Context, id string) (*models.Tag, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Tag), args.Error(1)
+}
+
+func (m *mockTagRepository) FindByName(ctx context.Context, name string) ([]*models.Tag, error) {
+	args := m.Called(ctx, name)
 	return args.Get(0).([]*models.Tag), args.Error(1)
 }
 
-func (m *mockTagRepository) Delete(id string) error {
-	args := m.Called(id)
+func (m *mockTagRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *mockTagRepository) Restore(ctx context.Context, id string) (*models.Tag, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Tag), args.Error(1)
+}
+
+func (m *mockTagRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Tag, error) {
+	args := m.Called(ctx, before, limit)
+	return args.Get(0).([]models.Tag), args.Error(1)
+}
+
+func (m *mockTagRepository) Purge(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
@@ -114,13 +143,13 @@ func TestGetTags(t *testing.T) {
 
 			mockTagRepository := new(mockTagRepository)
 			if tt.mockError != nil {
-				mockTagRepository.On("Find").Return(tt.mockTags, tt.mockError)
+				mockTagRepository.On("Find", mock.Anything).Return(tt.mockTags, tt.mockError)
 			} else if tt.tagName != "" {
-				mockTagRepository.On("FindByName", tt.tagName).Return(tt.mockTags, tt.mockError)
+				mockTagRepository.On("FindByName", mock.Anything, tt.tagName).Return(tt.mockTags, tt.mockError)
 			} else {
-				mockTagRepository.On("Find").Return(tt.mockTags, tt.mockError)
+				mockTagRepository.On("Find", mock.Anything).Return(tt.mockTags, tt.mockError)
 			}
-			tagService := services.NewTagService(mockTagRepository)
+			tagService := services.NewTagService(mockTagRepository, nil)
 			tagController := NewTagController(*tagService)
 
 			// routes
@@ -192,8 +221,8 @@ func TestCreateTag(t *testing.T) {
 			api := app.Group("/api")
 
 			mockTagRepository := new(mockTagRepository)
-			mockTagRepository.On("Create", mock.AnythingOfType("*models.Tag")).Return(tt.mockId, tt.mockError)
-			tagService := services.NewTagService(mockTagRepository)
+			mockTagRepository.On("Create", mock.Anything, mock.AnythingOfType("*models.Tag")).Return(tt.mockId, tt.mockError == nil, tt.mockError)
+			tagService := services.NewTagService(mockTagRepository, nil)
 			tagController := NewTagController(*tagService)
 
 			// routes
@@ -214,3 +243,132 @@ func TestCreateTag(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteTag(t *testing.T) {
+	tests := []struct {
+		description          string
+		mockTag              *models.Tag
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+		expectedAudit        bool
+	}{
+		{
+			description:          "Delete tag should delete the tag, record it in the audit log and return HTTP status code 200",
+			mockTag:              &models.Tag{ID: 3, Name: "goal"},
+			expectedStatusCode:   200,
+			expectedBodyResponse: `{"success":true,"message":""}`,
+			expectedAudit:        true,
+		},
+		{
+			description:          "Delete tag should do nothing for a missing tag and return HTTP status code 200",
+			mockError:            repositories.ErrTagNotFound,
+			expectedStatusCode:   200,
+			expectedBodyResponse: `{"success":true,"message":""}`,
+		},
+		{
+			description:          "Delete tag should return HTTP status code 500 if an unexpected error occurs",
+			mockError:            errors.New("database unreachable"),
+			expectedStatusCode:   500,
+			expectedBodyResponse: `{"success":false,"message":"database unreachable"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				ctx := services.WithIdentity(c.UserContext(), &services.Identity{Subject: "api-key:1", Name: "backoffice", OrganizationID: 1})
+				c.SetUserContext(services.WithRequest(ctx, services.Request{ID: "req-1", IP: "10.0.0.1"}))
+				return c.Next()
+			})
+
+			mockTagRepository := new(mockTagRepository)
+			mockTagRepository.On("FindByID", mock.Anything, "3").Return(tt.mockTag, tt.mockError)
+			mockTagRepository.On("Delete", mock.Anything, "3").Return(nil)
+			mockAuditRepository := new(mockAuditRepository)
+			mockAuditRepository.On("Create", mock.Anything, mock.Anything).Return(nil)
+			tagService := services.NewTagService(mockTagRepository, services.NewAuditService(mockAuditRepository))
+			tagController := NewTagController(*tagService)
+			app.Delete("/api/tags/:id", tagController.DeleteTag)
+
+			resp, _ := app.Test(httptest.NewRequest("DELETE", "/api/tags/3", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+			if !tt.expectedAudit {
+				mockTagRepository.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+				mockAuditRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+				return
+			}
+			mockAuditRepository.AssertNumberOfCalls(t, "Create", 1)
+			event := mockAuditRepository.Calls[0].Arguments.Get(1).(*models.AuditEvent)
+			assert.Equal(t, "api-key:1", event.Actor)
+			assert.Equal(t, "backoffice", event.ActorName)
+			assert.Equal(t, models.AuditDelete, event.Action)
+			assert.Equal(t, models.AuditTag, event.EntityType)
+			assert.Equal(t, "3", event.EntityID)
+			assert.Equal(t, "req-1", event.RequestID)
+			assert.Equal(t, "10.0.0.1", event.IP)
+			assert.JSONEq(t, `{"id":3,"name":"goal","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}`, string(event.Before))
+			assert.Nil(t, event.After)
+		})
+	}
+}
+
+func TestRestoreTag(t *testing.T) {
+	tests := []struct {
+		description          string
+		mockTag              *models.Tag
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:          "Restore tag should move the tag out of the trash and return HTTP status code 200",
+			mockTag:              &models.Tag{ID: 3, Name: "goal"},
+			expectedStatusCode:   200,
+			expectedBodyResponse: `{"success":true,"message":"","data":{"id":3,"name":"goal","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}}`,
+		},
+		{
+			description:          "Restore tag should return HTTP status code 404 if the tag is not in the trash",
+			mockError:            repositories.ErrTagNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"tag not found","data":null}`,
+		},
+		{
+			description:          "Restore tag should return HTTP status code 409 if another tag took its name",
+			mockError:            repositories.ErrTagExists,
+			expectedStatusCode:   409,
+			expectedBodyResponse: `{"success":false,"message":"a tag with the same name already exists","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockTagRepository := new(mockTagRepository)
+			mockTagRepository.On("Restore", mock.Anything, "3").Return(tt.mockTag, tt.mockError)
+			mockAuditRepository := new(mockAuditRepository)
+			mockAuditRepository.On("Create", mock.Anything, mock.Anything).Return(nil)
+			tagService := services.NewTagService(mockTagRepository, services.NewAuditService(mockAuditRepository))
+			tagController := NewTagController(*tagService)
+			app.Post("/api/tags/:id/restore", tagController.RestoreTag)
+
+			resp, _ := app.Test(httptest.NewRequest("POST", "/api/tags/3/restore", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+			if tt.expectedStatusCode != 200 {
+				mockAuditRepository.AssertNotCalled(t, "Create", mock.Anything, mock.Anything)
+				return
+			}
+			mockAuditRepository.AssertNumberOfCalls(t, "Create", 1)
+			event := mockAuditRepository.Calls[0].Arguments.Get(1).(*models.AuditEvent)
+			assert.Equal(t, models.AuditRestore, event.Action)
+			assert.Nil(t, event.Before)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+type TrashController struct {
+	service services.TrashService
+}
+
+func NewTrashController(service services.TrashService) *TrashController {
+	return &TrashController{
+		service,
+	}
+}
+
+// GetTrash godoc
+//
+//	@Summary		Get the trash
+//	@Description	Get the medias and tags deleted by the organization of the caller, last deleted first, with the time they are deleted permanently. They are restored with POST /api/medias/{id}/restore and POST /api/tags/{id}/restore.
+//	@Tags			Trash
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Success		200	{object}	controllers.GetTrash.response	"Returns success true and the items of the trash"
+//	@Failure		500	{object}	controllers.GetTrash.response	"Returns error for internal server error"
+//	@Router			/api/trash [GET]
+func (ctrl TrashController) GetTrash(c *fiber.Ctx) error {
+	type response struct {
+		Success bool               `json:"success"`
+		Data    []models.TrashItem `json:"data"`
+		Message string             `json:"message"`
+	}
+	items, err := ctrl.service.GetTrash(c.UserContext())
+	if err != nil {
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    items,
+	})
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func TestGetTrash(t *testing.T) {
+	deletedAt := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description          string
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Get trash should return the deleted medias and tags with their purge time and HTTP status code 200",
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":[
+					{"type":"tag","id":3,"name":"goal","deletedAt":"2024-03-09T22:00:00Z","purgeAt":"2024-04-08T22:00:00Z"},
+					{"type":"media","id":1,"name":"stadium","deletedAt":"2024-03-09T21:00:00Z","purgeAt":"2024-04-08T21:00:00Z"}
+				]}`,
+		},
+		{
+			description:          "Get trash should return HTTP status code 500 if an unexpected error occurs",
+			mockError:            errors.New("database unreachable"),
+			expectedStatusCode:   500,
+			expectedBodyResponse: `{"success":false,"message":"internal server error","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockMediaRepository := new(mockMediaRepository)
+			mockMediaRepository.On("FindDeleted", mock.Anything, mock.Anything, 1000).
+				Return([]models.Media{{ID: 1, Name: "stadium", DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}}, tt.mockError)
+			mockTagRepository := new(mockTagRepository)
+			mockTagRepository.On("FindDeleted", mock.Anything, mock.Anything, 1000).
+				Return([]models.Tag{{ID: 3, Name: "goal", DeletedAt: gorm.DeletedAt{Time: deletedAt.Add(time.Hour), Valid: true}}}, nil)
+			trashService := services.NewTrashService(mockMediaRepository, mockTagRepository, nil, nil, nil, services.TrashOptions{Retention: 30 * 24 * time.Hour})
+			app.Get("/api/trash", NewTrashController(*trashService).GetTrash)
+
+			resp, _ := app.Test(httptest.NewRequest("GET", "/api/trash", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Number of days of the usage reports by default
+const defaultUsageDays = 30
+
+type UsageController struct {
+	service services.UsageService
+}
+
+func NewUsageController(service services.UsageService) *UsageController {
+	return &UsageController{
+		service,
+	}
+}
+
+// GetUsage godoc
+//
+//	@Summary		Get the storage usage
+//	@Description	Get the bytes and number of medias stored by the organization of the caller and its quotas (0 for unlimited), with their value at the end of each day of a period (last 30 days by default, 366 days at most) in total, by tag and by content type
+//	@Tags			Usage
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			from	query		string	false	"first day of the period (example: 2024-03-01)"
+//	@Param			to		query		string	false	"last day of the period (example: 2024-03-31), today by default"
+//	@Success		200		{object}	controllers.GetUsage.response	"Returns success true and the usage"
+//	@Failure		400		{object}	controllers.GetUsage.response	"Returns error for an invalid period"
+//	@Failure		500		{object}	controllers.GetUsage.response	"Returns error for internal server error"
+//	@Router			/api/usage [GET]
+func (ctrl UsageController) GetUsage(c *fiber.Ctx) error {
+	type response struct {
+		Success bool                `json:"success"`
+		Data    *models.UsageReport `json:"data"`
+		Message string              `json:"message"`
+	}
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: "invalid to date, expected YYYY-MM-DD: " + value,
+			})
+		}
+		to = date
+	}
+	from := to.AddDate(0, 0, 1-defaultUsageDays)
+	if value := c.Query("from"); value != "" {
+		date, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: "invalid from date, expected YYYY-MM-DD: " + value,
+			})
+		}
+		from = date
+	}
+
+	report, err := ctrl.service.GetUsage(c.UserContext(), from, to)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUsagePeriod) {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    report,
+	})
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockUsageRepository struct {
+	mock.Mock
+}
+
+func (m *mockUsageRepository) Reserve(ctx context.Context, bytes int64) error {
+	args := m.Called(ctx, bytes)
+	return args.Error(0)
+}
+
+func (m *mockUsageRepository) Release(ctx context.Context, bytes int64) error {
+	args := m.Called(ctx, bytes)
+	return args.Error(0)
+}
+
+func (m *mockUsageRepository) Record(ctx context.Context, counters []models.UsageCounter) error {
+	args := m.Called(ctx, counters)
+	return args.Error(0)
+}
+
+func (m *mockUsageRepository) Find(ctx context.Context) (*models.OrganizationUsage, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OrganizationUsage), args.Error(1)
+}
+
+func (m *mockUsageRepository) FindCounters(ctx context.Context, until time.Time) ([]models.UsageCounter, error) {
+	args := m.Called(ctx, until)
+	return args.Get(0).([]models.UsageCounter), args.Error(1)
+}
+
+func TestGetUsage(t *testing.T) {
+	day := func(date string) time.Time {
+		value, _ := time.Parse(time.DateOnly, date)
+		return value
+	}
+	usage := &models.OrganizationUsage{
+		Bytes:        3000,
+		Medias:       3,
+		Organization: &models.Organization{Slug: "fc-nantes", StorageQuota: 10000},
+	}
+	counters := []models.UsageCounter{
+		{Day: day("2024-02-20"), Dimension: models.UsageContentType, Key: "image/png", Bytes: 1000, Medias: 1},
+		{Day: day("2024-03-02"), Dimension: models.UsageContentType, Key: "video/mp4", Bytes: 2000, Medias: 2},
+		{Day: day("2024-02-20"), Dimension: models.UsageTag, Key: "kick-off", Bytes: 1000, Medias: 1},
+		{Day: day("2024-02-20"), Dimension: models.UsageTotal, Bytes: 1000, Medias: 1},
+		{Day: day("2024-03-02"), Dimension: models.UsageTotal, Bytes: 2000, Medias: 2},
+	}
+
+	tests := []struct {
+		description          string
+		query                string
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Get usage should return the usage at the end of each day of the period and HTTP status code 200",
+			query:              "?from=2024-03-01&to=2024-03-03",
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":{
+					"organization":"fc-nantes","bytes":3000,"medias":3,"storageQuota":10000,"mediaQuota":0,
+					"total":[
+						{"date":"2024-03-01","bytes":1000,"medias":1},
+						{"date":"2024-03-02","bytes":3000,"medias":3},
+						{"date":"2024-03-03","bytes":3000,"medias":3}
+					],
+					"tags":[{"key":"kick-off","points":[
+						{"date":"2024-03-01","bytes":1000,"medias":1},
+						{"date":"2024-03-02","bytes":1000,"medias":1},
+						{"date":"2024-03-03","bytes":1000,"medias":1}
+					]}],
+					"contentTypes":[
+						{"key":"image/png","points":[
+							{"date":"2024-03-01","bytes":1000,"medias":1},
+							{"date":"2024-03-02","bytes":1000,"medias":1},
+							{"date":"2024-03-03","bytes":1000,"medias":1}
+						]},
+						{"key":"video/mp4","points":[
+							{"date":"2024-03-01","bytes":0,"medias":0},
+							{"date":"2024-03-02","bytes":2000,"medias":2},
+							{"date":"2024-03-03","bytes":2000,"medias":2}
+						]}
+					]
+				}}`,
+		},
+		{
+			description:          "Get usage should return HTTP status code 400 for an invalid date",
+			query:                "?from=03/01/2024&to=2024-03-03",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid from date, expected YYYY-MM-DD: 03/01/2024","data":null}`,
+		},
+		{
+			description:          "Get usage should return HTTP status code 400 for a period ending before it starts",
+			query:                "?from=2024-03-03&to=2024-03-01",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid usage period: 2024-03-01 is before 2024-03-03","data":null}`,
+		},
+		{
+			description:          "Get usage should return HTTP status code 400 for a period longer than a year",
+			query:                "?from=2022-03-01&to=2024-03-01",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid usage period: 732 days requested, at most 366","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockUsageRepository := new(mockUsageRepository)
+			mockUsageRepository.On("Find", mock.Anything).Return(usage, nil)
+			mockUsageRepository.On("FindCounters", mock.Anything, day("2024-03-03")).Return(counters, nil)
+			usageController := NewUsageController(*services.NewUsageService(mockUsageRepository))
+			app.Get("/api/usage", usageController.GetUsage)
+
+			resp, _ := app.Test(httptest.NewRequest("GET", "/api/usage"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
+
+func TestGetUsageError(t *testing.T) {
+	app := fiber.New()
+	mockUsageRepository := new(mockUsageRepository)
+	mockUsageRepository.On("Find", mock.Anything).Return(nil, errors.New("database unreachable"))
+	usageController := NewUsageController(*services.NewUsageService(mockUsageRepository))
+	app.Get("/api/usage", usageController.GetUsage)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/usage", nil))
+
+	assert.Equal(t, 500, resp.StatusCode)
+	mockUsageRepository.AssertNotCalled(t, "FindCounters", mock.Anything, mock.Anything)
+}
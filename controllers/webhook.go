@@ -0,0 +1,249 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Number of deliveries returned when no limit is given
+const defaultWebhookDeliveries = 100
+
+type WebhookController struct {
+	service services.WebhookService
+}
+
+func NewWebhookController(service services.WebhookService) *WebhookController {
+	return &WebhookController{
+		service,
+	}
+}
+
+// GetWebhooks godoc
+//
+//	@Summary		List webhooks
+//	@Description	List the webhooks of the organization of the caller. Their secrets are not returned.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Success		200	{object}	controllers.GetWebhooks.response	"Returns success true and the webhooks"
+//	@Failure		500	{object}	controllers.GetWebhooks.response	"Returns error for internal server error"
+//	@Router			/api/webhooks [GET]
+func (ctrl WebhookController) GetWebhooks(c *fiber.Ctx) error {
+	type response struct {
+		Success bool             `json:"success"`
+		Data    []models.Webhook `json:"data"`
+		Message string           `json:"message"`
+	}
+	webhooks, err := ctrl.service.GetWebhooks(c.UserContext())
+	if err != nil {
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    webhooks,
+	})
+}
+
+// CreateWebhook godoc
+//
+//	@Summary		Create a webhook
+//	@Description	Subscribe a URL to events among media.created, media.updated, media.deleted, media.restored, tag.created, tag.deleted and tag.restored. Events are posted as JSON with their type, id and the media or tag, signed with the HMAC-SHA256 of "<X-Webhook-Timestamp>.<body>" keyed by the secret of the webhook, sent in the X-Webhook-Signature header as sha256=<hex>. A secret is generated when none is given, it is only returned in this response.
+//	@Tags			Webhook
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			webhook	body		controllers.CreateWebhook.request	true	"url, events and secret of the webhook"
+//	@Success		201		{object}	controllers.CreateWebhook.response	"Returns success true and the webhook with its secret"
+//	@Failure		400		{object}	controllers.CreateWebhook.response	"Returns error for invalid url, events or secret"
+//	@Failure		500		{object}	controllers.CreateWebhook.response	"Returns error for internal server error"
+//	@Router			/api/webhooks [POST]
+func (ctrl WebhookController) CreateWebhook(c *fiber.Ctx) error {
+	type request struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	type response struct {
+		Success bool            `json:"success"`
+		Data    *models.Webhook `json:"data"`
+		Message string          `json:"message"`
+	}
+	input := new(request)
+	if err := c.BodyParser(input); err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: err.Error(),
+		})
+	}
+	webhook, err := ctrl.service.CreateWebhook(c.UserContext(), input.URL, input.Events, input.Secret)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidWebhook) {
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(201).JSON(response{
+		Success: true,
+		Data:    webhook,
+	})
+}
+
+// DeleteWebhook godoc
+//
+//	@Summary		Delete a webhook
+//	@Description	Delete a webhook and its delivery log, its pending deliveries are not sent
+//	@Tags			Webhook
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		int	true	"Webhook id"
+//	@Success		200	{object}	controllers.DeleteWebhook.response	"Returns success true"
+//	@Failure		400	{object}	controllers.DeleteWebhook.response	"Returns error for invalid id"
+//	@Failure		404	{object}	controllers.DeleteWebhook.response	"Returns error when the webhook is not found"
+//	@Failure		500	{object}	controllers.DeleteWebhook.response	"Returns error for internal server error"
+//	@Router			/api/webhooks/{id} [DELETE]
+func (ctrl WebhookController) DeleteWebhook(c *fiber.Ctx) error {
+	type response struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "invalid id: " + c.Params("id"),
+		})
+	}
+	if err := ctrl.service.DeleteWebhook(c.UserContext(), uint(id)); err != nil {
+		if errors.Is(err, repositories.ErrWebhookNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Message: "webhook deleted",
+	})
+}
+
+// GetWebhookDeliveries godoc
+//
+//	@Summary		Get the delivery log of a webhook
+//	@Description	Get the last deliveries of a webhook, newest first, with their status, number of attempts, time of the next attempt and result of the last attempt. Failed attempts are retried with a delay doubled at each attempt.
+//	@Tags			Webhook
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id		path		int	true	"Webhook id"
+//	@Param			limit	query		int	false	"number of deliveries, 100 by default, 1000 at most"
+//	@Success		200		{object}	controllers.GetWebhookDeliveries.response	"Returns success true and the deliveries"
+//	@Failure		400		{object}	controllers.GetWebhookDeliveries.response	"Returns error for invalid id or limit"
+//	@Failure		404		{object}	controllers.GetWebhookDeliveries.response	"Returns error when the webhook is not found"
+//	@Failure		500		{object}	controllers.GetWebhookDeliveries.response	"Returns error for internal server error"
+//	@Router			/api/webhooks/{id}/deliveries [GET]
+func (ctrl WebhookController) GetWebhookDeliveries(c *fiber.Ctx) error {
+	type response struct {
+		Success bool                     `json:"success"`
+		Data    []models.WebhookDelivery `json:"data"`
+		Message string                   `json:"message"`
+	}
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "invalid id: " + c.Params("id"),
+		})
+	}
+	deliveries, err := ctrl.service.GetDeliveries(c.UserContext(), uint(id), c.QueryInt("limit", defaultWebhookDeliveries))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidWebhook):
+			return c.Status(400).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		case errors.Is(err, repositories.ErrWebhookNotFound):
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		default:
+			return c.Status(500).JSON(response{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+	}
+	return c.Status(200).JSON(response{
+		Success: true,
+		Data:    deliveries,
+	})
+}
+
+// ReplayWebhookDelivery godoc
+//
+//	@Summary		Replay a webhook delivery
+//	@Description	Send the event of a delivery again to its webhook, as a new delivery keeping the id of the event
+//	@Tags			Webhook
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Security		BearerAuth
+//	@Param			id	path		int	true	"Delivery id"
+//	@Success		202	{object}	controllers.ReplayWebhookDelivery.response	"Returns success true and the new delivery"
+//	@Failure		400	{object}	controllers.ReplayWebhookDelivery.response	"Returns error for invalid id"
+//	@Failure		404	{object}	controllers.ReplayWebhookDelivery.response	"Returns error when the delivery is not found"
+//	@Failure		500	{object}	controllers.ReplayWebhookDelivery.response	"Returns error for internal server error"
+//	@Router			/api/webhooks/deliveries/{id}/replay [POST]
+func (ctrl WebhookController) ReplayWebhookDelivery(c *fiber.Ctx) error {
+	type response struct {
+		Success bool                    `json:"success"`
+		Data    *models.WebhookDelivery `json:"data"`
+		Message string                  `json:"message"`
+	}
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(response{
+			Success: false,
+			Message: "invalid id: " + c.Params("id"),
+		})
+	}
+	delivery, err := ctrl.service.ReplayDelivery(c.UserContext(), uint(id))
+	if err != nil {
+		if errors.Is(err, repositories.ErrDeliveryNotFound) {
+			return c.Status(404).JSON(response{
+				Success: false,
+				Message: err.Error(),
+			})
+		}
+		return c.Status(500).JSON(response{
+			Success: false,
+			Message: "internal server error",
+		})
+	}
+	return c.Status(202).JSON(response{
+		Success: true,
+		Data:    delivery,
+	})
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockWebhookRepository struct {
+	mock.Mock
+}
+
+func (m *mockWebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	args := m.Called(ctx, webhook)
+	webhook.ID = 1
+	return args.Error(0)
+}
+
+func (m *mockWebhookRepository) Find(ctx context.Context) ([]models.Webhook, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]models.Webhook), args.Error(1)
+}
+
+func (m *mockWebhookRepository) Delete(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *mockWebhookRepository) FindDeliveries(ctx context.Context, webhookID uint, limit int) ([]models.WebhookDelivery, error) {
+	args := m.Called(ctx, webhookID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.WebhookDelivery), args.Error(1)
+}
+
+func (m *mockWebhookRepository) Replay(ctx context.Context, deliveryID uint, now time.Time) (*models.WebhookDelivery, error) {
+	args := m.Called(ctx, deliveryID, now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WebhookDelivery), args.Error(1)
+}
+
+func (m *mockWebhookRepository) Dispatch(ctx context.Context, limit int, now time.Time) (int, error) {
+	args := m.Called(ctx, limit, now)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *mockWebhookRepository) ClaimDue(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	args := m.Called(ctx, now, lockedUntil, limit)
+	return args.Get(0).([]models.WebhookDelivery), args.Error(1)
+}
+
+func (m *mockWebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	args := m.Called(ctx, delivery)
+	return args.Error(0)
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		description          string
+		body                 string
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Create webhook should subscribe the url and return the webhook with its secret and HTTP status code 201",
+			body:               `{"url":"https://cms.example.com/hooks","events":["media.created","tag.deleted"],"secret":"0123456789abcdef"}`,
+			expectedStatusCode: 201,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":{"id":1,"url":"https://cms.example.com/hooks","events":["media.created","tag.deleted"],"secret":"0123456789abcdef","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}}`,
+		},
+		{
+			description:          "Create webhook should return HTTP status code 400 for an unknown event",
+			body:                 `{"url":"https://cms.example.com/hooks","events":["media.renamed"]}`,
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid webhook: unknown event media.renamed (available: media.created, media.updated, media.deleted, media.restored, tag.created, tag.deleted, tag.restored)","data":null}`,
+		},
+		{
+			description:          "Create webhook should return HTTP status code 400 for an invalid url",
+			body:                 `{"url":"cms.example.com","events":["media.created"]}`,
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid webhook: url must be an absolute http or https url","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockWebhookRepository := new(mockWebhookRepository)
+			mockWebhookRepository.On("Create", mock.Anything, mock.Anything).Return(nil)
+			webhookController := NewWebhookController(*services.NewWebhookService(mockWebhookRepository, services.WebhookOptions{}))
+			app.Post("/api/webhooks", webhookController.CreateWebhook)
+
+			req := httptest.NewRequest("POST", "/api/webhooks", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, _ := app.Test(req)
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
+
+func TestGetWebhooks(t *testing.T) {
+	app := fiber.New()
+	mockWebhookRepository := new(mockWebhookRepository)
+	mockWebhookRepository.On("Find", mock.Anything).Return([]models.Webhook{
+		{ID: 1, URL: "https://cms.example.com/hooks", Events: []string{models.WebhookMediaCreated}, Secret: "0123456789abcdef"},
+	}, nil)
+	webhookController := NewWebhookController(*services.NewWebhookService(mockWebhookRepository, services.WebhookOptions{}))
+	app.Get("/api/webhooks", webhookController.GetWebhooks)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/webhooks", nil))
+
+	assert.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.JSONEq(t, `{
+		"success":true,
+		"message":"",
+		"data":[{"id":1,"url":"https://cms.example.com/hooks","events":["media.created"],"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]}`,
+		string(body), "secrets are not listed")
+}
+
+func TestGetWebhookDeliveries(t *testing.T) {
+	nextAttemptAt := time.Date(2024, time.March, 9, 21, 0, 30, 0, time.UTC)
+	occurredAt := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description          string
+		path                 string
+		mockDeliveries       []models.WebhookDelivery
+		mockError            error
+		expectedLimit        int
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description: "Get webhook deliveries should return the delivery log and HTTP status code 200",
+			path:        "/api/webhooks/1/deliveries",
+			mockDeliveries: []models.WebhookDelivery{{
+				ID: 3, WebhookID: 1, EventID: 42, EventType: models.WebhookMediaCreated, Payload: []byte(`{"id":1}`), OccurredAt: occurredAt,
+				Status: models.DeliveryPending, Attempts: 1, NextAttemptAt: &nextAttemptAt, ResponseStatus: 503, LastError: "unexpected status 503",
+			}},
+			expectedLimit:      100,
+			expectedStatusCode: 200,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":[{"id":3,"webhookId":1,"eventId":42,"eventType":"media.created","payload":{"id":1},"occurredAt":"2024-03-09T21:00:00Z",
+					"status":"pending","attempts":1,"nextAttemptAt":"2024-03-09T21:00:30Z","responseStatus":503,"lastError":"unexpected status 503",
+					"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}]}`,
+		},
+		{
+			description:          "Get webhook deliveries should return HTTP status code 404 for an unknown webhook",
+			path:                 "/api/webhooks/9/deliveries?limit=10",
+			mockError:            repositories.ErrWebhookNotFound,
+			expectedLimit:        10,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"webhook not found","data":null}`,
+		},
+		{
+			description:          "Get webhook deliveries should return HTTP status code 400 for a limit too large",
+			path:                 "/api/webhooks/1/deliveries?limit=5000",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid webhook: limit must be between 1 and 1000","data":null}`,
+		},
+		{
+			description:          "Get webhook deliveries should return HTTP status code 400 for an invalid id",
+			path:                 "/api/webhooks/cms/deliveries",
+			expectedStatusCode:   400,
+			expectedBodyResponse: `{"success":false,"message":"invalid id: cms","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockWebhookRepository := new(mockWebhookRepository)
+			mockWebhookRepository.On("FindDeliveries", mock.Anything, mock.Anything, tt.expectedLimit).Return(tt.mockDeliveries, tt.mockError)
+			webhookController := NewWebhookController(*services.NewWebhookService(mockWebhookRepository, services.WebhookOptions{}))
+			app.Get("/api/webhooks/:id/deliveries", webhookController.GetWebhookDeliveries)
+
+			resp, _ := app.Test(httptest.NewRequest("GET", tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
+
+func TestReplayWebhookDelivery(t *testing.T) {
+	replayOf := uint(3)
+
+	tests := []struct {
+		description          string
+		mockDelivery         *models.WebhookDelivery
+		mockError            error
+		expectedStatusCode   int
+		expectedBodyResponse string
+	}{
+		{
+			description:        "Replay webhook delivery should create a new delivery of the event and return HTTP status code 202",
+			mockDelivery:       &models.WebhookDelivery{ID: 4, WebhookID: 1, EventID: 42, EventType: models.WebhookTagDeleted, Payload: []byte(`{"id":3}`), Status: models.DeliveryPending, ReplayOf: &replayOf},
+			expectedStatusCode: 202,
+			expectedBodyResponse: `{
+				"success":true,
+				"message":"",
+				"data":{"id":4,"webhookId":1,"eventId":42,"eventType":"tag.deleted","payload":{"id":3},"occurredAt":"0001-01-01T00:00:00Z",
+					"status":"pending","attempts":0,"replayOf":3,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}}`,
+		},
+		{
+			description:          "Replay webhook delivery should return HTTP status code 404 for an unknown delivery",
+			mockError:            repositories.ErrDeliveryNotFound,
+			expectedStatusCode:   404,
+			expectedBodyResponse: `{"success":false,"message":"webhook delivery not found","data":null}`,
+		},
+		{
+			description:          "Replay webhook delivery should return HTTP status code 500 if an unexpected error occurs",
+			mockError:            errors.New("database unreachable"),
+			expectedStatusCode:   500,
+			expectedBodyResponse: `{"success":false,"message":"internal server error","data":null}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			app := fiber.New()
+			mockWebhookRepository := new(mockWebhookRepository)
+			mockWebhookRepository.On("Replay", mock.Anything, uint(3), mock.Anything).Return(tt.mockDelivery, tt.mockError)
+			webhookController := NewWebhookController(*services.NewWebhookService(mockWebhookRepository, services.WebhookOptions{}))
+			app.Post("/api/webhooks/deliveries/:id/replay", webhookController.ReplayWebhookDelivery)
+
+			resp, _ := app.Test(httptest.NewRequest("POST", "/api/webhooks/deliveries/3/replay", nil))
+
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			body, _ := io.ReadAll(resp.Body)
+			assert.JSONEq(t, tt.expectedBodyResponse, string(body))
+		})
+	}
+}
@@ -9,15 +9,23 @@ import (
 	"github.com/mich31/scoreplay-media-api/models"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func Connect() (*gorm.DB, error) {
+// DSN returns the connection string of the database configured with the DB_* variables
+func DSN() (string, error) {
 	port, err := strconv.Atoi(config.Config("DB_PORT"))
 	if err != nil {
-		return nil, fmt.Errorf("invalid port format: %v", err)
+		return "", fmt.Errorf("invalid port format: %v", err)
 	}
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", config.Config("DB_HOST"), port, config.Config("DB_USER"), config.Config("DB_PASSWORD"), config.Config("DB_NAME")), nil
+}
 
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable", config.Config("DB_HOST"), port, config.Config("DB_USER"), config.Config("DB_PASSWORD"), config.Config("DB_NAME"))
+func Connect() (*gorm.DB, error) {
+	dsn, err := DSN()
+	if err != nil {
+		return nil, err
+	}
 	db, err := gorm.Open(postgres.New(postgres.Config{
 		DSN: dsn,
 	}), &gorm.Config{})
@@ -26,10 +34,147 @@ func Connect() (*gorm.DB, error) {
 	}
 
 	// Migrate the models
-	if err := db.AutoMigrate(&models.Tag{}, &models.Media{}, &models.MediaTag{}); err != nil {
+	if err := migrateOrganizations(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := db.AutoMigrate(&models.Organization{}, &models.Tag{}, &models.Media{}, &models.MediaTag{}, &models.StorageMigrationProgress{}, &models.Job{}, &models.APIKey{}, &models.RateLimitBucket{}, &models.OutboxEvent{}, &models.Webhook{}, &models.WebhookDelivery{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	// tag names were unique among every tag before tags could be moved to the trash
+	if err := db.Exec("DROP INDEX IF EXISTS idx_tags_organization_name").Error; err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := migrateFileUrls(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := migrateUsage(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := migrateAudit(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+	if err := db.Exec(outboxNotify).Error; err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %v", err)
 	}
 
 	log.Println("Successfully connected to database")
 	return db, nil
 }
+
+// migrateFileUrls replaces the absolute file urls stored before presigned urls by object keys.
+// Renditions are cleared to be generated again with object keys.
+func migrateFileUrls(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&models.Media{}, "file_url") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`UPDATE media SET object_key = regexp_replace(file_url, '^[a-z]+://[^/]+/[^/]+/', ''),
+			renditions = NULL, renditions_version = NULL
+			WHERE object_key IS NULL OR object_key = ''`).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn(&models.Media{}, "file_url")
+	})
+}
+
+// migrateOrganizations creates the default organization and moves the medias, tags and API keys created before
+// organizations to it, before their organization column is made mandatory. Tag names become unique per organization.
+func migrateOrganizations(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Organization{}); err != nil {
+		return err
+	}
+	organization := models.Organization{Slug: models.DefaultOrganization}
+	if err := db.Where(organization).Attrs(models.Organization{Name: "Default"}).FirstOrCreate(&organization).Error; err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&models.Media{}, &models.Tag{}, &models.MediaTag{}, &models.APIKey{}} {
+			if !tx.Migrator().HasTable(model) || tx.Migrator().HasColumn(model, "OrganizationID") {
+				continue
+			}
+			statement := &gorm.Statement{DB: tx}
+			if err := statement.Parse(model); err != nil {
+				return err
+			}
+			table := statement.Schema.Table
+			if err := tx.Exec("ALTER TABLE ? ADD COLUMN organization_id bigint", clause.Table{Name: table}).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE ? SET organization_id = ?", clause.Table{Name: table}, organization.ID).Error; err != nil {
+				return err
+			}
+		}
+		if tx.Migrator().HasTable(&models.Tag{}) {
+			return tx.Exec("ALTER TABLE tags DROP CONSTRAINT IF EXISTS tags_name_key, DROP CONSTRAINT IF EXISTS uni_tags_name").Error
+		}
+		return nil
+	})
+}
+
+// Queries computing the usage of the medias uploaded before usage was counted
+var usageBackfill = []string{
+	`INSERT INTO organization_usages (organization_id, bytes, medias, updated_at)
+		SELECT organization_id, COALESCE(SUM(file_size), 0), COUNT(*), NOW() FROM media GROUP BY organization_id`,
+	`INSERT INTO usage_counters (organization_id, day, dimension, key, bytes, medias)
+		SELECT organization_id, created_at::date, 'total', '', COALESCE(SUM(file_size), 0), COUNT(*)
+		FROM media GROUP BY organization_id, created_at::date`,
+	`INSERT INTO usage_counters (organization_id, day, dimension, key, bytes, medias)
+		SELECT organization_id, created_at::date, 'content_type', COALESCE(content_type, ''), COALESCE(SUM(file_size), 0), COUNT(*)
+		FROM media GROUP BY organization_id, created_at::date, COALESCE(content_type, '')`,
+	`INSERT INTO usage_counters (organization_id, day, dimension, key, bytes, medias)
+		SELECT media.organization_id, media.created_at::date, 'tag', tags.name, COALESCE(SUM(media.file_size), 0), COUNT(*)
+		FROM media JOIN media_tags ON media_tags.media_id = media.id JOIN tags ON tags.id = media_tags.tag_id
+		GROUP BY media.organization_id, media.created_at::date, tags.name`,
+}
+
+// migrateUsage creates the usage tables, counting the medias uploaded before them once. Usage is then updated
+// with each upload.
+func migrateUsage(db *gorm.DB) error {
+	if db.Migrator().HasTable(&models.OrganizationUsage{}) {
+		return db.AutoMigrate(&models.OrganizationUsage{}, &models.UsageCounter{})
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.OrganizationUsage{}, &models.UsageCounter{}); err != nil {
+			return err
+		}
+		for _, query := range usageBackfill {
+			if err := tx.Exec(query).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Trigger rejecting the updates and deletions of the audit log
+const auditAppendOnly = `CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit events cannot be updated or deleted';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();`
+
+// migrateAudit creates the audit log, whose events can only be inserted
+func migrateAudit(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.AuditEvent{}); err != nil {
+			return err
+		}
+		return tx.Exec(auditAppendOnly).Error
+	})
+}
+
+// Trigger notifying the instances listening to the outbox_events channel of the events added to the outbox, once
+// their transaction is committed
+const outboxNotify = `CREATE OR REPLACE FUNCTION outbox_events_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('outbox_events', NEW.id::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS outbox_events_notify ON outbox_events;
+CREATE TRIGGER outbox_events_notify AFTER INSERT ON outbox_events
+	FOR EACH ROW EXECUTE FUNCTION outbox_events_notify();`
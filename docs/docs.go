@@ -15,9 +15,112 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/api/audit": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the creations, updates and deletions of the medias, tags and tags of medias of the organization of the caller, newest first, with their actor, request and the entity before and after the change. Pages are read with the before parameter, set to the next field of the previous page. With format=ndjson, or the Accept header application/x-ndjson, every matching event is exported as newline delimited JSON.",
+                "produces": [
+                    "application/json",
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "Audit"
+                ],
+                "summary": "Get the audit log",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "subject of the user, or api-key:\u003cid\u003e",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "create, update, delete, restore or purge",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "media, tag or media_tag",
+                        "name": "entityType",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id of the entity, \u003cmedia id\u003e:\u003ctag id\u003e for the tags of a media",
+                        "name": "entityId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "id of the request (X-Request-ID header)",
+                        "name": "requestId",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "events created at or after this time (RFC 3339 or YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "events created before this time (RFC 3339, or YYYY-MM-DD included)",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "events per page, 100 by default, 1000 at most",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "events older than this event id",
+                        "name": "before",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ndjson to export every matching event",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the events",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetAuditEvents.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for an invalid filter",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetAuditEvents.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetAuditEvents.response"
+                        }
+                    }
+                }
+            }
+        },
         "/api/health": {
             "get": {
-                "description": "Healthcheck endpoint",
+                "description": "Healthcheck endpoint, with the state of the circuit breakers of the storages. The status is DEGRADED while a breaker is not closed.",
                 "produces": [
                     "application/json"
                 ],
@@ -35,9 +138,164 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/keys": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the API keys, revoked keys included. Keys themselves are not returned, only their prefix.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "API key"
+                ],
+                "summary": "List API keys",
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the API keys",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetAPIKeys.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetAPIKeys.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Issue an API key holding scopes among media:read, media:write, tags:admin, keys:admin, audit:read and webhooks:admin, which the caller must hold. The key is only returned in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "API key"
+                ],
+                "summary": "Issue an API key",
+                "parameters": [
+                    {
+                        "description": "name and scopes of the key",
+                        "name": "key",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.IssueAPIKey.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Returns success true, the key and its record",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.IssueAPIKey.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid name or scopes",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.IssueAPIKey.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Returns error for scopes the caller does not hold",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.IssueAPIKey.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.IssueAPIKey.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revoke an API key, requests sending it are rejected from now on",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "API key"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "API key id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RevokeAPIKey.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RevokeAPIKey.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when the key is not found or already revoked",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RevokeAPIKey.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RevokeAPIKey.response"
+                        }
+                    }
+                }
+            }
+        },
         "/api/medias": {
             "get": {
-                "description": "Get medias by tag id",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get medias by tag id and video properties",
                 "consumes": [
                     "application/json"
                 ],
@@ -54,6 +312,48 @@ const docTemplate = `{
                         "description": "search by tag id",
                         "name": "tag",
                         "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "minimum duration in seconds",
+                        "name": "minDuration",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "maximum duration in seconds (exclusive)",
+                        "name": "maxDuration",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum width in pixels (example: 3840 for 4K)",
+                        "name": "minWidth",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum height in pixels",
+                        "name": "minHeight",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "video codec (example: h264, hevc, prores)",
+                        "name": "videoCodec",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "with or without an audio track",
+                        "name": "hasAudio",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "processing status: uploading, processing, ready or failed (default: ready)",
+                        "name": "status",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -63,6 +363,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/controllers.GetMedias.response"
                         }
                     },
+                    "400": {
+                        "description": "Returns error for invalid filters",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMedias.response"
+                        }
+                    },
                     "404": {
                         "description": "Returns success true with empty data when no media found",
                         "schema": {
@@ -74,10 +380,24 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/controllers.GetMedias.response"
                         }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMedias.response"
+                        }
                     }
                 }
             },
             "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Upload a new media file to storage and creates a new media entry with file url, name and associated tags",
                 "consumes": [
                     "multipart/form-data"
@@ -106,7 +426,7 @@ const docTemplate = `{
                     },
                     {
                         "type": "string",
-                        "description": "Comma-separated list of tags",
+                        "description": "Array of tag IDs (example: [123, 75, 18873])",
                         "name": "tags",
                         "in": "formData",
                         "required": true
@@ -125,53 +445,305 @@ const docTemplate = `{
                             "$ref": "#/definitions/controllers.CreateMedia.response"
                         }
                     },
+                    "402": {
+                        "description": "Returns error when the organization stores as many medias as its quota",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateMedia.response"
+                        }
+                    },
+                    "413": {
+                        "description": "Returns error when the file exceeds the storage quota of the organization",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateMedia.response"
+                        }
+                    },
+                    "429": {
+                        "description": "Returns error when the rate limit of uploads is exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateMedia.response"
+                        }
+                    },
                     "500": {
                         "description": "Returns error for internal server error",
                         "schema": {
                             "$ref": "#/definitions/controllers.CreateMedia.response"
                         }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateMedia.response"
+                        }
                     }
                 }
             }
         },
-        "/api/tags": {
+        "/api/medias/duplicates": {
             "get": {
-                "description": "Get tags (optional: by name)",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Group the image medias associated to a tag whose perceptual hashes are within a Hamming distance, to cull bursts",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Tag"
+                    "Media"
                 ],
-                "summary": "GET tags",
+                "summary": "Get groups of near-duplicate medias in a tag",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "search by tag name",
-                        "name": "name",
+                        "description": "tag id",
+                        "name": "tag",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum Hamming distance between hashes (default: 10)",
+                        "name": "distance",
                         "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Returns success true and a list of tags found",
+                        "description": "Returns success true and groups of near-duplicate medias",
                         "schema": {
-                            "$ref": "#/definitions/controllers.GetTags.response"
+                            "$ref": "#/definitions/controllers.GetDuplicates.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid parameters",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetDuplicates.response"
                         }
                     },
                     "500": {
                         "description": "Returns error for internal server error",
                         "schema": {
-                            "$ref": "#/definitions/controllers.GetTags.response"
+                            "$ref": "#/definitions/controllers.GetDuplicates.response"
                         }
-                    }
-                }
-            },
-            "post": {
-                "description": "Creates a new tag",
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetDuplicates.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/stream": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stream the medias created and updated in the organization of the caller as Server-Sent Events named media.created and media.updated, whose data is the media and whose id resumes the stream. Clients reconnecting with the Last-Event-ID header receive the events they missed within the history of the stream (STREAM_HISTORY), and again the events added within 30 seconds before it, which may have been committed after it. Clients too slow to read the events are disconnected and resume the same way.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Stream medias",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "comma-separated tag ids, only the medias with one of them are streamed",
+                        "name": "tags",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "id of the last event received, to resume the stream",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns the stream of events",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid tags or Last-Event-ID",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.StreamMedias.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.StreamMedias.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Moves a media to the trash. It is restored with POST /api/medias/{id}/restore until it is deleted permanently, with its files, after the retention of the trash.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Delete a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteMedia.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteMedia.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteMedia.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}/content": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stream the original file of a media through the API and count the download. Range requests are supported so video players can seek.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Download the file of a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "attachment (default) or inline",
+                        "name": "disposition",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "byte ranges (example: bytes=0-1023)",
+                        "name": "Range",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "etag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns the file",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Returns the requested range, or a multipart/byteranges body for several ranges",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Returns no content when the cached copy is up to date",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media or file is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMediaContent.response"
+                        }
+                    },
+                    "416": {
+                        "description": "Returns error when no range can be served",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMediaContent.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMediaContent.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetMediaContent.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}/focal-point": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Set the focal point (normalized x, y) and optional crop boxes per aspect ratio used to crop renditions and resized images",
                 "consumes": [
                     "application/json"
                 ],
@@ -179,203 +751,2040 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Tag"
+                    "Media"
                 ],
-                "summary": "Create a new tag",
+                "summary": "Set the focal point of a media",
                 "parameters": [
                     {
-                        "description": "tag object to be created",
-                        "name": "tag",
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "focal point and crop boxes per aspect ratio",
+                        "name": "focalPoint",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Tag"
+                            "$ref": "#/definitions/models.FocalPoint"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Returns success true and created tag ID",
+                    "200": {
+                        "description": "Returns success true and the updated media",
                         "schema": {
-                            "$ref": "#/definitions/controllers.CreateTag.response"
+                            "$ref": "#/definitions/controllers.SetFocalPoint.response"
                         }
                     },
                     "400": {
                         "description": "Returns error for invalid input",
                         "schema": {
-                            "$ref": "#/definitions/controllers.CreateTag.response"
+                            "$ref": "#/definitions/controllers.SetFocalPoint.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.SetFocalPoint.response"
                         }
                     },
                     "500": {
                         "description": "Returns error for internal server error",
                         "schema": {
-                            "$ref": "#/definitions/controllers.CreateTag.response"
+                            "$ref": "#/definitions/controllers.SetFocalPoint.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.SetFocalPoint.response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Remove the focal point and crop boxes of a media, crops fall back on automatic detection",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Clear the focal point of a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the updated media",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ClearFocalPoint.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ClearFocalPoint.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ClearFocalPoint.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ClearFocalPoint.response"
                         }
                     }
                 }
             }
         },
-        "/api/tags/{id}": {
-            "delete": {
-                "description": "Deletes a tag by its id",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "Tag"
-                ],
-                "summary": "Delete a tag",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Tag id",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "Returns success true",
-                        "schema": {
-                            "$ref": "#/definitions/controllers.DeleteTag.response"
-                        }
-                    },
-                    "500": {
-                        "description": "Returns error for internal server error",
-                        "schema": {
-                            "$ref": "#/definitions/controllers.DeleteTag.response"
-                        }
+        "/api/medias/{id}/render": {
+            "get": {
+                "description": "Resize and crop an image media. Results are cached. Parameters must be signed with the render signing key. Responses carry an etag that changes with the focal point and crop boxes of the media.",
+                "produces": [
+                    "image/jpeg",
+                    "image/png",
+                    "image/gif"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Render a resized image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Output width",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Output height",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "contain (default), cover or fill",
+                        "name": "fit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "jpeg, png or gif (default: original format)",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 signature of the parameters",
+                        "name": "sig",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "etag of a cached copy",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns the rendered image",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Returns no content when the cached copy is up to date",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid parameters",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Returns error for invalid signature",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    },
+                    "415": {
+                        "description": "Returns error when media is not an image",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RenderMedia.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Moves a media out of the trash, with its tags",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Restore a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the restored media",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreMedia.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreMedia.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Returns error when another media took its name",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreMedia.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreMedia.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreMedia.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}/retry": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Process again the stored file of a media whose processing failed. The media is returned with the processing status while it is processed by a background job.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Retry the processing of a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the media being processed",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RetryProcessing.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RetryProcessing.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Returns error when the processing of the media has not failed",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RetryProcessing.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RetryProcessing.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RetryProcessing.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/medias/{id}/similar": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get medias whose perceptual hash is within a Hamming distance of the hash of an image media, closest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Get near-duplicates of a media",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Media id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum Hamming distance between hashes (default: 10)",
+                        "name": "distance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and array of similar medias",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetSimilarMedias.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid distance or media without hash",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetSimilarMedias.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when media is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetSimilarMedias.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetSimilarMedias.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetSimilarMedias.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tags": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get tags (optional: by name)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tag"
+                ],
+                "summary": "GET tags",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "search by tag name",
+                        "name": "name",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and a list of tags found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetTags.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetTags.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates a new tag",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tag"
+                ],
+                "summary": "Create a new tag",
+                "parameters": [
+                    {
+                        "description": "tag object to be created",
+                        "name": "tag",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Tag"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Returns success true and created tag ID",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateTag.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid input",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateTag.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateTag.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tags/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Moves a tag to the trash. It is restored with POST /api/tags/{id}/restore, with its associations to medias, until it is deleted permanently after the retention of the trash.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tag"
+                ],
+                "summary": "Delete a tag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteTag.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteTag.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/tags/{id}/restore": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Moves a tag out of the trash, with its associations to medias",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tag"
+                ],
+                "summary": "Restore a tag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the restored tag",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreTag.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when tag is not in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreTag.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Returns error when another tag took its name",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreTag.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.RestoreTag.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/trash": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the medias and tags deleted by the organization of the caller, last deleted first, with the time they are deleted permanently. They are restored with POST /api/medias/{id}/restore and POST /api/tags/{id}/restore.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Trash"
+                ],
+                "summary": "Get the trash",
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the items of the trash",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetTrash.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetTrash.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/usage": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the bytes and number of medias stored by the organization of the caller and its quotas (0 for unlimited), with their value at the end of each day of a period (last 30 days by default, 366 days at most) in total, by tag and by content type",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usage"
+                ],
+                "summary": "Get the storage usage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "first day of the period (example: 2024-03-01)",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "last day of the period (example: 2024-03-31), today by default",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the usage",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetUsage.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for an invalid period",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetUsage.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetUsage.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the webhooks of the organization of the caller. Their secrets are not returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "List webhooks",
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the webhooks",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhooks.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhooks.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Subscribe a URL to events among media.created, media.updated, media.deleted, media.restored, tag.created, tag.deleted and tag.restored. Events are posted as JSON with their type, id and the media or tag, signed with the HMAC-SHA256 of \"\u003cX-Webhook-Timestamp\u003e.\u003cbody\u003e\" keyed by the secret of the webhook, sent in the X-Webhook-Signature header as sha256=\u003chex\u003e. A secret is generated when none is given, it is only returned in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Create a webhook",
+                "parameters": [
+                    {
+                        "description": "url, events and secret of the webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateWebhook.request"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Returns success true and the webhook with its secret",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateWebhook.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid url, events or secret",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateWebhook.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.CreateWebhook.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/deliveries/{id}/replay": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Send the event of a delivery again to its webhook, as a new delivery keeping the id of the event",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Replay a webhook delivery",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Delivery id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Returns success true and the new delivery",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ReplayWebhookDelivery.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ReplayWebhookDelivery.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when the delivery is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ReplayWebhookDelivery.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.ReplayWebhookDelivery.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete a webhook and its delivery log, its pending deliveries are not sent",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Delete a webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteWebhook.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteWebhook.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when the webhook is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteWebhook.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.DeleteWebhook.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the last deliveries of a webhook, newest first, with their status, number of attempts, time of the next attempt and result of the last attempt. Failed attempts are retried with a delay doubled at each attempt.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Webhook"
+                ],
+                "summary": "Get the delivery log of a webhook",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "number of deliveries, 100 by default, 1000 at most",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns success true and the deliveries",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhookDeliveries.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Returns error for invalid id or limit",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhookDeliveries.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when the webhook is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhookDeliveries.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Returns error for internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetWebhookDeliveries.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/objects/{bucket}/{key}": {
+            "get": {
+                "description": "Download an object with a time-limited url returned in media responses (filesystem and memory storage drivers, SSE-C encrypted objects)",
+                "tags": [
+                    "Media"
+                ],
+                "summary": "Download a stored object",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bucket name",
+                        "name": "bucket",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Object key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Expiration unix timestamp",
+                        "name": "expires",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Url signature",
+                        "name": "signature",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Returns the object content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "403": {
+                        "description": "Returns error for invalid or expired url",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetObject.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Returns error when object is not found",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetObject.response"
+                        }
+                    },
+                    "503": {
+                        "description": "Returns error when the storage is unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/controllers.GetObject.response"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "controllers.ClearFocalPoint.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Media"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.CreateMedia.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.CreateTag.response": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.CreateWebhook.request": {
+            "type": "object",
+            "properties": {
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "controllers.CreateWebhook.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Webhook"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.DeleteMedia.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.DeleteTag.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.DeleteWebhook.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetAPIKeys.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.APIKey"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetAuditEvents.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.AuditEvent"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "next": {
+                    "description": "Id to send as the before parameter to read the next page, absent on the last page",
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetDuplicates.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "type": "array",
+                        "items": {
+                            "$ref": "#/definitions/models.Media"
+                        }
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetMediaContent.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetMedias.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.MediaWithTagNames"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetObject.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetSimilarMedias.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.SimilarMedia"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetTags.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Tag"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetTrash.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TrashItem"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetUsage.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.UsageReport"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetWebhookDeliveries.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.WebhookDelivery"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.GetWebhooks.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Webhook"
+                    }
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.IssueAPIKey.request": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "controllers.IssueAPIKey.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.APIKey"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.RenderMedia.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.ReplayWebhookDelivery.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.WebhookDelivery"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.RestoreMedia.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Media"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.RestoreTag.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Tag"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.RetryProcessing.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Media"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.RevokeAPIKey.response": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.SetFocalPoint.response": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/models.Media"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "controllers.StreamMedias.response": {
+            "type": "object",
+            "properties": {
+                "data": {},
+                "message": {
+                    "type": "string"
+                },
+                "success": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "main.HealthCheck.response": {
+            "type": "object",
+            "properties": {
+                "date": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "storages": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.CircuitBreakerStatus"
+                    }
+                }
+            }
+        },
+        "models.APIKey": {
+            "type": "object",
+            "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "lastUsedAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "prefix": {
+                    "description": "First characters of the key, to recognize it",
+                    "type": "string"
+                },
+                "revokedAt": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.AuditEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "actor": {
+                    "description": "Subject of the user, api-key:\u003cid\u003e or system, and the name of the user or API key",
+                    "type": "string"
+                },
+                "actorName": {
+                    "type": "string"
+                },
+                "after": {
+                    "type": "object"
+                },
+                "before": {
+                    "description": "JSON representation of the entity, empty before a create and after a delete",
+                    "type": "object"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "entityId": {
+                    "description": "Id of the entity, \u003cmedia id\u003e:\u003ctag id\u003e for the tags of a media",
+                    "type": "string"
+                },
+                "entityType": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "ip": {
+                    "type": "string"
+                },
+                "requestId": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CropBox": {
+            "type": "object",
+            "properties": {
+                "height": {
+                    "type": "number"
+                },
+                "width": {
+                    "type": "number"
+                },
+                "x": {
+                    "type": "number"
+                },
+                "y": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.CropMap": {
+            "type": "object",
+            "additionalProperties": {
+                "$ref": "#/definitions/models.CropBox"
+            }
+        },
+        "models.FocalPoint": {
+            "type": "object",
+            "properties": {
+                "crops": {
+                    "$ref": "#/definitions/models.CropMap"
+                },
+                "x": {
+                    "type": "number"
+                },
+                "y": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.Media": {
+            "type": "object",
+            "properties": {
+                "audioCodec": {
+                    "type": "string"
+                },
+                "contentType": {
+                    "type": "string"
+                },
+                "createdAt": {
+                    "type": "string"
+                },
+                "crops": {
+                    "$ref": "#/definitions/models.CropMap"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "downloadCount": {
+                    "type": "integer"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "failedAt": {
+                    "type": "string"
+                },
+                "failureReason": {
+                    "type": "string"
+                },
+                "fileName": {
+                    "type": "string"
+                },
+                "fileSize": {
+                    "type": "integer"
+                },
+                "fileUrl": {
+                    "type": "string"
+                },
+                "focalX": {
+                    "type": "number"
+                },
+                "focalY": {
+                    "type": "number"
+                },
+                "frameRate": {
+                    "type": "number"
+                },
+                "hasAudio": {
+                    "type": "boolean"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "perceptualHash": {
+                    "type": "integer"
+                },
+                "processingStartedAt": {
+                    "type": "string"
+                },
+                "readyAt": {
+                    "type": "string"
+                },
+                "recordedAt": {
+                    "type": "string"
+                },
+                "renditions": {
+                    "$ref": "#/definitions/models.RenditionMap"
+                },
+                "replicatedAt": {
+                    "type": "string"
+                },
+                "replicationStatus": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Tag"
+                    }
+                },
+                "updatedAt": {
+                    "type": "string"
+                },
+                "uploadedAt": {
+                    "type": "string"
+                },
+                "uploadedBy": {
+                    "description": "Subject of the user, or api-key:\u003cid\u003e, who uploaded the media. Empty for medias uploaded before authentication.",
+                    "type": "string"
+                },
+                "videoCodec": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.MediaWithTagNames": {
+            "type": "object",
+            "properties": {
+                "audioCodec": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "failureReason": {
+                    "type": "string"
+                },
+                "fileName": {
+                    "type": "string"
+                },
+                "fileUrl": {
+                    "type": "string"
+                },
+                "frameRate": {
+                    "type": "number"
+                },
+                "hasAudio": {
+                    "type": "boolean"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "recordedAt": {
+                    "type": "string"
+                },
+                "renditions": {
+                    "$ref": "#/definitions/models.RenditionMap"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tagNames": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
+                },
+                "uploadedBy": {
+                    "type": "string"
+                },
+                "videoCodec": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
                 }
             }
-        }
-    },
-    "definitions": {
-        "controllers.CreateMedia.response": {
+        },
+        "models.RenditionMap": {
+            "type": "object",
+            "additionalProperties": {
+                "type": "string"
+            }
+        },
+        "models.SimilarMedia": {
             "type": "object",
             "properties": {
-                "message": {
+                "audioCodec": {
                     "type": "string"
                 },
-                "success": {
+                "description": {
+                    "type": "string"
+                },
+                "distance": {
+                    "type": "integer"
+                },
+                "duration": {
+                    "type": "number"
+                },
+                "failureReason": {
+                    "type": "string"
+                },
+                "fileName": {
+                    "type": "string"
+                },
+                "fileUrl": {
+                    "type": "string"
+                },
+                "frameRate": {
+                    "type": "number"
+                },
+                "hasAudio": {
                     "type": "boolean"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "recordedAt": {
+                    "type": "string"
+                },
+                "renditions": {
+                    "$ref": "#/definitions/models.RenditionMap"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "tagNames": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "uploadedBy": {
+                    "type": "string"
+                },
+                "videoCodec": {
+                    "type": "string"
+                },
+                "width": {
+                    "type": "integer"
                 }
             }
         },
-        "controllers.CreateTag.response": {
+        "models.Tag": {
             "type": "object",
             "properties": {
+                "createdAt": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "integer"
                 },
-                "message": {
+                "name": {
                     "type": "string"
                 },
-                "success": {
-                    "type": "boolean"
+                "updatedAt": {
+                    "type": "string"
                 }
             }
         },
-        "controllers.DeleteTag.response": {
+        "models.TrashItem": {
             "type": "object",
             "properties": {
-                "message": {
+                "deletedAt": {
                     "type": "string"
                 },
-                "success": {
-                    "type": "boolean"
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "purgeAt": {
+                    "description": "Time after which the item and its files are deleted permanently",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
                 }
             }
         },
-        "controllers.GetMedias.response": {
+        "models.UsagePoint": {
             "type": "object",
             "properties": {
-                "data": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/models.MediaWithTagNames"
-                    }
+                "bytes": {
+                    "type": "integer"
                 },
-                "message": {
-                    "type": "string"
+                "date": {
+                    "type": "string",
+                    "example": "2024-03-09"
                 },
-                "success": {
-                    "type": "boolean"
+                "medias": {
+                    "type": "integer"
                 }
             }
         },
-        "controllers.GetTags.response": {
+        "models.UsageReport": {
             "type": "object",
             "properties": {
-                "data": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "contentTypes": {
                     "type": "array",
                     "items": {
-                        "$ref": "#/definitions/models.Tag"
+                        "$ref": "#/definitions/models.UsageSeries"
                     }
                 },
-                "message": {
-                    "type": "string"
+                "mediaQuota": {
+                    "type": "integer"
                 },
-                "success": {
-                    "type": "boolean"
+                "medias": {
+                    "type": "integer"
+                },
+                "organization": {
+                    "type": "string",
+                    "example": "fc-nantes"
+                },
+                "storageQuota": {
+                    "type": "integer"
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UsageSeries"
+                    }
+                },
+                "total": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UsagePoint"
+                    }
                 }
             }
         },
-        "main.HealthCheck.response": {
+        "models.UsageSeries": {
             "type": "object",
             "properties": {
-                "date": {
-                    "type": "string"
+                "key": {
+                    "type": "string",
+                    "example": "image/jpeg"
                 },
-                "status": {
-                    "type": "string"
+                "points": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UsagePoint"
+                    }
                 }
             }
         },
-        "models.MediaWithTagNames": {
+        "models.Webhook": {
             "type": "object",
             "properties": {
-                "description": {
+                "createdAt": {
                     "type": "string"
                 },
-                "fileUrl": {
-                    "type": "string"
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 },
                 "id": {
                     "type": "integer"
                 },
-                "name": {
+                "secret": {
+                    "description": "Key of the HMAC-SHA256 signature of the deliveries, only returned when the webhook is created",
                     "type": "string"
                 },
-                "tagNames": {
-                    "type": "array",
-                    "items": {
-                        "type": "string"
-                    }
+                "updatedAt": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
                 }
             }
         },
-        "models.Tag": {
+        "models.WebhookDelivery": {
             "type": "object",
             "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
                 "createdAt": {
                     "type": "string"
                 },
+                "deliveredAt": {
+                    "type": "string"
+                },
+                "eventId": {
+                    "description": "Id of the event, kept by the replays of the delivery so that receivers can ignore the events already handled",
+                    "type": "integer"
+                },
+                "eventType": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "integer"
                 },
-                "name": {
+                "lastError": {
+                    "type": "string"
+                },
+                "nextAttemptAt": {
+                    "description": "Time of the next attempt of a pending delivery",
+                    "type": "string"
+                },
+                "occurredAt": {
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "replayOf": {
+                    "description": "Id of the delivery this one replays",
+                    "type": "integer"
+                },
+                "responseStatus": {
+                    "description": "HTTP status code answered to the last attempt, empty when no response was received",
+                    "type": "integer"
+                },
+                "status": {
                     "type": "string"
                 },
                 "updatedAt": {
                     "type": "string"
+                },
+                "webhookId": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.CircuitBreakerStatus": {
+            "type": "object",
+            "properties": {
+                "failures": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "openedAt": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -0,0 +1,145 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+// CoverAt scales and crops an image so that it fills exactly width x height, keeping
+// the focal point (normalized x, y between 0 and 1) as close as possible to the center of the crop.
+func CoverAt(img image.Image, width, height int, focalX, focalY float64) image.Image {
+	return Resize(Crop(img, coverRect(img.Bounds(), width, height, focalX, focalY)), width, height)
+}
+
+// SmartCover scales and crops an image so that it fills exactly width x height.
+// The crop is placed on the most detailed part of the image, scored on edges and entropy.
+func SmartCover(img image.Image, width, height int) image.Image {
+	return Resize(Crop(img, smartCoverRect(img, width, height)), width, height)
+}
+
+// coverRect returns the crop of CoverAt: the largest rectangle with the aspect ratio of width x height fitting in
+// bounds, centered on the focal point unless it would overflow the bounds
+func coverRect(bounds image.Rectangle, width, height int, focalX, focalY float64) image.Rectangle {
+	cropWidth, cropHeight := coverSize(bounds, width, height)
+	x := int(focalX*float64(bounds.Dx())) - cropWidth/2
+	y := int(focalY*float64(bounds.Dy())) - cropHeight/2
+	x = bounds.Min.X + clamp(x, 0, bounds.Dx()-cropWidth)
+	y = bounds.Min.Y + clamp(y, 0, bounds.Dy()-cropHeight)
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
+
+// smartCoverRect returns the crop of SmartCover
+func smartCoverRect(img image.Image, width, height int) image.Rectangle {
+	bounds := img.Bounds()
+	cropWidth, cropHeight := coverSize(bounds, width, height)
+	if cropWidth == bounds.Dx() && cropHeight == bounds.Dy() {
+		return bounds
+	}
+
+	// work on a small grayscale copy, the crop only slides along one axis
+	scale := math.Min(1, 128/float64(max(bounds.Dx(), bounds.Dy())))
+	sampleWidth := max(1, int(float64(bounds.Dx())*scale))
+	sampleHeight := max(1, int(float64(bounds.Dy())*scale))
+	gray := grayscale(Resize(img, sampleWidth, sampleHeight))
+	edges := edgeMagnitudes(gray)
+
+	windowWidth := max(1, int(float64(cropWidth)*scale))
+	windowHeight := max(1, int(float64(cropHeight)*scale))
+	bestX, bestY, bestScore := 0, 0, -1.0
+	for y := 0; y+windowHeight <= sampleHeight; y++ {
+		for x := 0; x+windowWidth <= sampleWidth; x++ {
+			window := image.Rect(x, y, x+windowWidth, y+windowHeight)
+			score := regionSum(edges, sampleWidth, window) / float64(window.Dx()*window.Dy())
+			score += entropy(gray, window) * 8
+			if score > bestScore {
+				bestX, bestY, bestScore = x, y, score
+			}
+			if windowWidth >= sampleWidth {
+				break
+			}
+		}
+		if windowHeight >= sampleHeight {
+			break
+		}
+	}
+
+	// the last window of the sample is mapped to the edge of the image despite the rounding of the sample
+	x, y := 0, 0
+	if sampleWidth > windowWidth {
+		x = bestX * (bounds.Dx() - cropWidth) / (sampleWidth - windowWidth)
+	}
+	if sampleHeight > windowHeight {
+		y = bestY * (bounds.Dy() - cropHeight) / (sampleHeight - windowHeight)
+	}
+	x = bounds.Min.X + clamp(x, 0, bounds.Dx()-cropWidth)
+	y = bounds.Min.Y + clamp(y, 0, bounds.Dy()-cropHeight)
+	return image.Rect(x, y, x+cropWidth, y+cropHeight)
+}
+
+// coverSize returns the largest size with the aspect ratio of width x height fitting in bounds
+func coverSize(bounds image.Rectangle, width, height int) (int, int) {
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dx()*height/width
+	if cropHeight > bounds.Dy() {
+		cropWidth, cropHeight = bounds.Dy()*width/height, bounds.Dy()
+	}
+	return max(1, cropWidth), max(1, cropHeight)
+}
+
+func clamp(value, low, high int) int {
+	return max(low, min(value, high))
+}
+
+func grayscale(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			gray.Set(x, y, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return gray
+}
+
+// edgeMagnitudes returns the gradient magnitude of each pixel, row by row. Pixels on the borders are compared
+// with their inner neighbours, so that details at the edges of an image score like the others.
+func edgeMagnitudes(gray *image.Gray) []float64 {
+	width, height := gray.Rect.Dx(), gray.Rect.Dy()
+	edges := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx := float64(gray.GrayAt(min(x+1, width-1), y).Y) - float64(gray.GrayAt(max(x-1, 0), y).Y)
+			dy := float64(gray.GrayAt(x, min(y+1, height-1)).Y) - float64(gray.GrayAt(x, max(y-1, 0)).Y)
+			edges[y*width+x] = math.Sqrt(dx*dx + dy*dy)
+		}
+	}
+	return edges
+}
+
+func regionSum(values []float64, width int, region image.Rectangle) float64 {
+	sum := 0.0
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			sum += values[y*width+x]
+		}
+	}
+	return sum
+}
+
+// entropy returns the Shannon entropy of the luminance histogram of a region
+func entropy(gray *image.Gray, region image.Rectangle) float64 {
+	var histogram [256]int
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			histogram[gray.GrayAt(x, y).Y]++
+		}
+	}
+	total := float64(region.Dx() * region.Dy())
+	result := 0.0
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / total
+			result -= p * math.Log2(p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flat draws a uniform gray image with a checkerboard of 8x8 squares inside detail
+func flat(width, height int, detail image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			level := uint8(128)
+			if (image.Point{x, y}).In(detail) {
+				level = uint8(255 * ((x/8 + y/8) % 2))
+			}
+			img.Set(x, y, color.Gray{Y: level})
+		}
+	}
+	return img
+}
+
+func TestCoverRect(t *testing.T) {
+	landscape := image.Rect(0, 0, 400, 200)
+	portrait := image.Rect(0, 0, 200, 400)
+
+	tests := []struct {
+		description    string
+		bounds         image.Rectangle
+		width, height  int
+		focalX, focalY float64
+		expected       image.Rectangle
+	}{
+		{description: "The crop should be centered on a centered focal point", bounds: landscape, width: 100, height: 100, focalX: 0.5, focalY: 0.5, expected: image.Rect(100, 0, 300, 200)},
+		{description: "The crop should be centered on the focal point", bounds: landscape, width: 100, height: 100, focalX: 0.4, focalY: 0.5, expected: image.Rect(60, 0, 260, 200)},
+		{description: "The crop should start at the left edge for a focal point on it", bounds: landscape, width: 100, height: 100, focalX: 0, focalY: 0.5, expected: image.Rect(0, 0, 200, 200)},
+		{description: "The crop should end at the right edge for a focal point on it", bounds: landscape, width: 100, height: 100, focalX: 1, focalY: 0.5, expected: image.Rect(200, 0, 400, 200)},
+		{description: "The crop should stay inside the image for a focal point close to an edge", bounds: landscape, width: 100, height: 100, focalX: 0.9, focalY: 0, expected: image.Rect(200, 0, 400, 200)},
+		{description: "The crop should start at the top edge for a focal point on it", bounds: portrait, width: 200, height: 100, focalX: 0.5, focalY: 0, expected: image.Rect(0, 0, 200, 100)},
+		{description: "The crop should end at the bottom edge for a focal point on it", bounds: portrait, width: 200, height: 100, focalX: 0.5, focalY: 1, expected: image.Rect(0, 300, 200, 400)},
+		{description: "The crop should be the whole image for the same aspect ratio", bounds: landscape, width: 200, height: 100, focalX: 0.1, focalY: 0.9, expected: landscape},
+		{description: "The crop should be offset with the bounds of the image", bounds: image.Rect(50, 20, 450, 220), width: 100, height: 100, focalX: 1, focalY: 1, expected: image.Rect(250, 20, 450, 220)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assert.Equal(t, tt.expected, coverRect(tt.bounds, tt.width, tt.height, tt.focalX, tt.focalY))
+		})
+	}
+}
+
+func TestSmartCoverRect(t *testing.T) {
+	tests := []struct {
+		description   string
+		image         image.Image
+		width, height int
+		expected      image.Rectangle
+	}{
+		{description: "The crop should hold the detail at the left edge", image: flat(400, 200, image.Rect(0, 40, 120, 160)), width: 100, height: 100, expected: image.Rect(0, 0, 200, 200)},
+		{description: "The crop should hold the detail at the right edge", image: flat(400, 200, image.Rect(280, 40, 400, 160)), width: 100, height: 100, expected: image.Rect(200, 0, 400, 200)},
+		{description: "The crop should hold the detail at the bottom edge", image: flat(200, 400, image.Rect(40, 300, 160, 400)), width: 200, height: 100, expected: image.Rect(0, 300, 200, 400)},
+		{description: "The crop should be the whole image for the same aspect ratio", image: flat(400, 200, image.Rect(0, 0, 50, 50)), width: 200, height: 100, expected: image.Rect(0, 0, 400, 200)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			assert.Equal(t, tt.expected, smartCoverRect(tt.image, tt.width, tt.height))
+		})
+	}
+
+	t.Run("The crop should cover the detail in the middle", func(t *testing.T) {
+		detail := image.Rect(160, 60, 240, 140)
+		crop := smartCoverRect(flat(400, 200, detail), 100, 100)
+		assert.Equal(t, 200, crop.Dx())
+		assert.Equal(t, 200, crop.Dy())
+		assert.True(t, detail.In(crop), "crop %v misses the detail %v", crop, detail)
+	})
+}
+
+func TestCoverAtCropsAroundFocalPoint(t *testing.T) {
+	// the left half of the image is red and the right half blue
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			if x < 200 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+
+	left := CoverAt(img, 50, 50, 0, 0.5)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), left.Bounds())
+	assert.Equal(t, color.RGBA{255, 0, 0, 255}, left.At(25, 25))
+	right := CoverAt(img, 50, 50, 1, 0.5)
+	assert.Equal(t, color.RGBA{0, 0, 255, 255}, right.At(25, 25))
+}
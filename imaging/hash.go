@@ -0,0 +1,28 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+)
+
+// DHash computes the difference hash of an image: the image is reduced to 9x8 grayscale pixels
+// and each bit tells whether a pixel is brighter than its right neighbour.
+// Near-identical images have hashes with a small Hamming distance.
+func DHash(img image.Image) uint64 {
+	gray := grayscale(Resize(img, 9, 8))
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of different bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
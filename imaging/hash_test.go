@@ -0,0 +1,115 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Hamming distances below which images are near-identical, and above which they are different.
+// The API reports medias within 10 bits (DefaultSimilarityDistance) as similar.
+const (
+	nearIdenticalDistance = 4
+	differentDistance     = 20
+)
+
+// scene draws a smooth grayscale pattern, shaped by its frequencies fx and fy
+func scene(width, height int, fx, fy float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			value := 128 + 60*math.Sin(u*fx*2*math.Pi+1)*math.Cos(v*fy*2*math.Pi) + 50*(u-v)
+			level := uint8(math.Max(0, math.Min(255, value)))
+			img.Set(x, y, color.RGBA{level, level / 2, 255 - level, 255})
+		}
+	}
+	return img
+}
+
+func brighter(img *image.RGBA, delta uint8) *image.RGBA {
+	result := image.NewRGBA(img.Bounds())
+	for i, value := range img.Pix {
+		if i%4 == 3 {
+			result.Pix[i] = value
+			continue
+		}
+		result.Pix[i] = uint8(min(255, int(value)+int(delta)))
+	}
+	return result
+}
+
+func mirrored(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			result.Set(bounds.Dx()-1-x, y, img.At(x, y))
+		}
+	}
+	return result
+}
+
+// noise draws random pixels, the same for a seed
+func noise(width, height int, seed int64) *image.RGBA {
+	random := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	random.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+	return img
+}
+
+func reencoded(t *testing.T, img image.Image, quality int) image.Image {
+	var buffer bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality}))
+	decoded, err := jpeg.Decode(&buffer)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestDHash(t *testing.T) {
+	original := scene(320, 240, 1.5, 1)
+
+	tests := []struct {
+		description string
+		image       image.Image
+		different   bool
+	}{
+		{description: "The same image should have the same hash", image: original},
+		{description: "A copy should have the same hash", image: scene(320, 240, 1.5, 1)},
+		{description: "A resized image should be near-identical", image: Resize(original, 160, 120)},
+		{description: "An upscaled image with another aspect ratio should be near-identical", image: Resize(original, 640, 400)},
+		{description: "A brighter image should be near-identical", image: brighter(original, 20)},
+		{description: "A compressed image should be near-identical", image: reencoded(t, original, 30)},
+		{description: "A mirrored image should be different", image: mirrored(original), different: true},
+		{description: "Another pattern should be different", image: scene(320, 240, 3, 2.5), different: true},
+		{description: "Noise should be different", image: noise(320, 240, 1), different: true},
+	}
+
+	hash := DHash(original)
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			distance := HammingDistance(hash, DHash(tt.image))
+			if tt.different {
+				assert.Greater(t, distance, differentDistance)
+			} else {
+				assert.LessOrEqual(t, distance, nearIdenticalDistance)
+			}
+		})
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	assert.Equal(t, 0, HammingDistance(0xf0f0, 0xf0f0))
+	assert.Equal(t, 4, HammingDistance(0xf0f0, 0xf0ff))
+	assert.Equal(t, 64, HammingDistance(0, math.MaxUint64))
+}
@@ -0,0 +1,145 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/image/draw"
+)
+
+// DefaultMaxPixels is the number of pixels of the largest image decoded by default: 50 megapixels
+const DefaultMaxPixels = 50_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrImageTooLarge     = errors.New("image too large")
+)
+
+// maxPixels is the number of pixels of the largest image decoded, larger images are rejected before being
+// allocated
+var maxPixels atomic.Int64
+
+func init() {
+	maxPixels.Store(DefaultMaxPixels)
+}
+
+// SetMaxPixels sets the number of pixels of the largest image decoded
+func SetMaxPixels(pixels int64) {
+	maxPixels.Store(pixels)
+}
+
+// IsImage reports whether the content type is an image format that can be decoded
+func IsImage(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Decode reads an image and returns it with its format name (jpeg, png or gif). Images whose header declares more
+// pixels than the maximum are rejected with ErrImageTooLarge without being decoded.
+func Decode(reader io.Reader) (image.Image, string, error) {
+	// the header is read again to decode the image
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %w", err)
+	}
+	if limit := maxPixels.Load(); int64(config.Width)*int64(config.Height) > limit {
+		return nil, "", fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrImageTooLarge, config.Width, config.Height, limit)
+	}
+	img, format, err := image.Decode(io.MultiReader(&header, reader))
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to decode image: %w", err)
+	}
+	return img, format, nil
+}
+
+// Encode writes an image in the given format (jpeg, png or gif)
+func Encode(writer io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg", "jpg":
+		return jpeg.Encode(writer, img, &jpeg.Options{Quality: 85})
+	case "png":
+		return png.Encode(writer, img)
+	case "gif":
+		return gif.Encode(writer, img, nil)
+	}
+	return fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+}
+
+// ContentType returns the mime type of an image format
+func ContentType(format string) string {
+	switch format {
+	case "jpeg", "jpg":
+		return "image/jpeg"
+	case "png":
+		return "image/png"
+	case "gif":
+		return "image/gif"
+	}
+	return "application/octet-stream"
+}
+
+// Extension returns the file extension of an image format
+func Extension(format string) string {
+	switch format {
+	case "jpeg", "jpg":
+		return ".jpg"
+	case "png":
+		return ".png"
+	case "gif":
+		return ".gif"
+	}
+	return ""
+}
+
+// Fit scales an image down so that it fits in a width x height box, keeping its aspect ratio.
+// Images already smaller than the box are returned unchanged.
+func Fit(img image.Image, width, height int) image.Image {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	if srcWidth <= width && srcHeight <= height {
+		return img
+	}
+
+	ratio := min(float64(width)/float64(srcWidth), float64(height)/float64(srcHeight))
+	dstWidth := max(1, int(float64(srcWidth)*ratio+0.5))
+	dstHeight := max(1, int(float64(srcHeight)*ratio+0.5))
+	return Resize(img, dstWidth, dstHeight)
+}
+
+// Resize scales an image to exactly width x height
+func Resize(img image.Image, width, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Over, nil)
+	return dst
+}
+
+// Cover scales and crops an image so that it fills exactly width x height.
+// The crop is centered on the middle of the image.
+func Cover(img image.Image, width, height int) image.Image {
+	return CoverAt(img, width, height, 0.5, 0.5)
+}
+
+// Crop returns the part of an image inside a rectangle
+func Crop(img image.Image, rect image.Rectangle) image.Image {
+	rect = rect.Intersect(img.Bounds())
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Copy(dst, image.Point{}, img, rect, draw.Src, nil)
+	return dst
+}
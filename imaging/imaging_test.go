@@ -0,0 +1,52 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oversized encodes a small PNG whose header declares width x height pixels
+func oversized(t *testing.T, width, height uint32) []byte {
+	var buffer bytes.Buffer
+	require.NoError(t, png.Encode(&buffer, noise(8, 8, 1)))
+	content := buffer.Bytes()
+	// the IHDR chunk follows the 8 bytes signature: length (4), type (4), width (4), height (4)... and its CRC
+	binary.BigEndian.PutUint32(content[16:20], width)
+	binary.BigEndian.PutUint32(content[20:24], height)
+	binary.BigEndian.PutUint32(content[29:33], crc32.ChecksumIEEE(content[12:29]))
+	return content
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("An image should be decoded after its header", func(t *testing.T) {
+		var buffer bytes.Buffer
+		require.NoError(t, png.Encode(&buffer, noise(64, 32, 1)))
+
+		img, format, err := Decode(&buffer)
+		require.NoError(t, err)
+		assert.Equal(t, "png", format)
+		assert.Equal(t, 64, img.Bounds().Dx())
+		assert.Equal(t, 32, img.Bounds().Dy())
+	})
+
+	t.Run("An image whose header declares too many pixels should be rejected", func(t *testing.T) {
+		_, _, err := Decode(bytes.NewReader(oversized(t, 60000, 60000)))
+		assert.ErrorIs(t, err, ErrImageTooLarge)
+	})
+
+	t.Run("An image above the configured maximum should be rejected", func(t *testing.T) {
+		SetMaxPixels(1000)
+		defer SetMaxPixels(DefaultMaxPixels)
+		var buffer bytes.Buffer
+		require.NoError(t, png.Encode(&buffer, noise(64, 32, 1)))
+
+		_, _, err := Decode(&buffer)
+		assert.ErrorIs(t, err, ErrImageTooLarge)
+	})
+}
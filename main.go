@@ -1,39 +1,160 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/contrib/swagger"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/healthcheck"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/mich31/scoreplay-media-api/config"
 	"github.com/mich31/scoreplay-media-api/controllers"
 	"github.com/mich31/scoreplay-media-api/database"
+	"github.com/mich31/scoreplay-media-api/middlewares"
 	"github.com/mich31/scoreplay-media-api/repositories"
 	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
 )
 
+//	@securityDefinitions.apikey	ApiKeyAuth
+//	@in							header
+//	@name						X-API-Key
+//	@securityDefinitions.apikey	BearerAuth
+//	@in							header
+//	@name						Authorization
 func main() {
+	// Maintenance commands (example: go run . migrate-keys)
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Connect to database
 	db, err := database.Connect()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Connections of the pool of the database are exposed on /metrics
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(sqlDB, config.Config("DB_NAME")))
+
 	tagRepository := repositories.NewTagRepository(db)
 	mediaRepository := repositories.NewMediaRepository(db)
-	tagService := services.NewTagService(tagRepository)
-	storageService := services.InitStorageService()
-	mediaService := services.NewMediaService(mediaRepository, tagRepository, storageService)
+	auditService := services.NewAuditService(repositories.NewAuditRepository(db))
+	tagService := services.NewTagService(tagRepository, auditService)
+	storageService, storageOptions, replicationService, err := newStorage(mediaRepository)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if replicationService != nil {
+		go replicationService.Run(context.Background())
+	}
+	if err := services.ConfigureImageDecoding(); err != nil {
+		log.Fatal(err)
+	}
+	renditionPresets, err := services.LoadRenditionPresets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	renditionService := services.NewRenditionService(mediaRepository, storageService, renditionPresets)
+	jobQueueOptions, err := services.LoadJobQueueOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	jobQueue := services.NewJobQueue(repositories.NewJobRepository(db), jobQueueOptions)
+	usageService := services.NewUsageService(repositories.NewUsageRepository(db))
+	mediaService := services.NewMediaService(mediaRepository, tagRepository, storageService, renditionService, replicationService, jobQueue, usageService, auditService)
+	jobQueue.Handle(services.JobProcessMedia, mediaService.HandleProcessMedia)
+	trashOptions, err := services.LoadTrashOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	trashService := services.NewTrashService(mediaRepository, tagRepository, storageService, usageService, auditService, trashOptions)
+	// Deleted medias and tags are purged once their retention in the trash is over
+	go trashService.Run(context.Background())
+	webhookOptions, err := services.LoadWebhookOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	webhookService := services.NewWebhookService(repositories.NewWebhookRepository(db), webhookOptions)
+	// Changes of medias and tags recorded in the outbox are sent to the webhooks subscribed to them
+	go webhookService.Run(context.Background())
+	mediaStreamOptions, err := services.LoadMediaStreamOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	dsn, err := database.DSN()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mediaStream := services.NewMediaStream(repositories.NewEventRepository(db), repositories.NewEventListener(dsn), mediaStreamOptions)
+	// Every instance is notified of the changes of medias to stream them to its own clients
+	go mediaStream.Run(context.Background())
+	metricsOptions, err := services.LoadMetricsOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Medias, tags and jobs are counted periodically for the gauges of /metrics
+	go services.NewMetricsService(repositories.NewMetricsRepository(db), metricsOptions).Run(context.Background())
+	// Jobs are run in-process unless JOB_WORKERS=0, when they are run by the worker command
+	go jobQueue.Run(context.Background())
+	renderOptions, err := services.LoadRenderOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	renderService := services.NewRenderService(mediaRepository, storageService, renderOptions)
+	keyRotationService := services.NewKeyRotationService(mediaRepository, storageService)
+	apiKeyService := services.NewAPIKeyService(repositories.NewAPIKeyRepository(db))
+	organizationService := services.NewOrganizationService(repositories.NewOrganizationRepository(db))
+	tokenVerifier, err := newTokenVerifier(organizationService)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rateLimiter, err := newRateLimiter(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go rateLimiter.Run(context.Background())
 	tagController := controllers.NewTagController(*tagService)
+	apiKeyController := controllers.NewAPIKeyController(*apiKeyService)
 	mediaController := controllers.NewMediaController(*mediaService)
+	usageController := controllers.NewUsageController(*usageService)
+	auditController := controllers.NewAuditController(*auditService)
+	trashController := controllers.NewTrashController(*trashService)
+	webhookController := controllers.NewWebhookController(*webhookService)
+	streamController := controllers.NewStreamController(mediaStream)
+	renderController := controllers.NewRenderController(*renderService)
+	objectController := controllers.NewObjectController(storageService, services.NewObjectUrlSigner(storageOptions), storageOptions.BucketName)
+
+	maintenanceOptions, err := services.LoadMaintenanceOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Regenerate renditions created with previous presets, hash images uploaded before hashing
+	// and re-encrypt objects encrypted with a previous key, at startup then every MAINTENANCE_INTERVAL
+	go services.NewMaintenanceService(renditionService, mediaService, keyRotationService, maintenanceOptions).Run(context.Background())
 
 	app := fiber.New(fiber.Config{
 		AppName: "ScorePlay Media API v0.1",
 	})
 
 	app.Use(logger.New())
+	app.Use(middlewares.Metrics())
+	app.Use(middlewares.RequestID())
 	app.Use(healthcheck.New())
 
 	cfg := swagger.Config{
@@ -45,18 +166,69 @@ func main() {
 
 	app.Use(swagger.New(cfg))
 
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+	app.Get("/objects/:bucket/*", objectController.GetObject)
+
 	api := app.Group("/api")
 
 	// routes
 	api.Get("/health", HealthCheck)
+	// renders are authorized by the signature of their parameters, so that they can be embedded in pages
+	api.Get("/medias/:id/render", renderController.RenderMedia)
+
+	// every other route requires a token or an API key granted the scope of the route. Users are granted
+	// the scopes of their roles: viewers read medias, editors upload them and admins manage tags, keys and webhooks
+	// and read the audit log.
+	// requests are limited per IP address before being authenticated, so that requests with invalid credentials are
+	// limited too, and per credential once authenticated. Uploads have their own buckets.
+	api.Use(middlewares.RateLimitIP(rateLimiter, func(c *fiber.Ctx) string {
+		if c.Method() == fiber.MethodPost && strings.TrimSuffix(c.Path(), "/") == "/api/medias" {
+			return services.RateLimitUpload
+		}
+		return services.RateLimitRead
+	}))
+	api.Use(middlewares.Authenticate(apiKeyService, tokenVerifier))
+	mediaRead := middlewares.RequireScope(services.ScopeMediaRead)
+	mediaWrite := middlewares.RequireScope(services.ScopeMediaWrite)
+	tagsAdmin := middlewares.RequireScope(services.ScopeTagsAdmin)
+	keysAdmin := middlewares.RequireScope(services.ScopeKeysAdmin)
+	auditRead := middlewares.RequireScope(services.ScopeAuditRead)
+	webhooksAdmin := middlewares.RequireScope(services.ScopeWebhooksAdmin)
+	readLimit := middlewares.RateLimit(rateLimiter, services.RateLimitRead)
+	uploadLimit := middlewares.RateLimit(rateLimiter, services.RateLimitUpload)
 	api.Route("tags", func(router fiber.Router) {
-		router.Get("/", tagController.GetTags)
-		router.Post("/", tagController.CreateTag)
-		router.Delete("/:id", tagController.DeleteTag)
+		router.Get("/", readLimit, mediaRead, tagController.GetTags)
+		router.Post("/", readLimit, tagsAdmin, tagController.CreateTag)
+		router.Delete("/:id", readLimit, tagsAdmin, tagController.DeleteTag)
+		router.Post("/:id/restore", readLimit, tagsAdmin, tagController.RestoreTag)
 	})
 	api.Route("medias", func(router fiber.Router) {
-		router.Get("/", mediaController.GetMedias)
-		router.Post("/", mediaController.CreateMedia)
+		router.Get("/", readLimit, mediaRead, mediaController.GetMedias)
+		router.Post("/", uploadLimit, mediaWrite, mediaController.CreateMedia)
+		router.Get("/stream", readLimit, mediaRead, streamController.StreamMedias)
+		router.Get("/duplicates", readLimit, mediaRead, mediaController.GetDuplicates)
+		router.Get("/:id/similar", readLimit, mediaRead, mediaController.GetSimilarMedias)
+		router.Get("/:id/content", readLimit, mediaRead, mediaController.GetMediaContent)
+		router.Put("/:id/focal-point", readLimit, mediaWrite, mediaController.SetFocalPoint)
+		router.Delete("/:id/focal-point", readLimit, mediaWrite, mediaController.ClearFocalPoint)
+		router.Post("/:id/retry", readLimit, mediaWrite, mediaController.RetryProcessing)
+		router.Delete("/:id", readLimit, mediaWrite, mediaController.DeleteMedia)
+		router.Post("/:id/restore", readLimit, mediaWrite, mediaController.RestoreMedia)
+	})
+	api.Get("/usage", readLimit, mediaRead, usageController.GetUsage)
+	api.Get("/audit", readLimit, auditRead, auditController.GetAuditEvents)
+	api.Get("/trash", readLimit, mediaWrite, trashController.GetTrash)
+	api.Route("keys", func(router fiber.Router) {
+		router.Get("/", readLimit, keysAdmin, apiKeyController.GetAPIKeys)
+		router.Post("/", readLimit, keysAdmin, apiKeyController.IssueAPIKey)
+		router.Delete("/:id", readLimit, keysAdmin, apiKeyController.RevokeAPIKey)
+	})
+	api.Route("webhooks", func(router fiber.Router) {
+		router.Get("/", readLimit, webhooksAdmin, webhookController.GetWebhooks)
+		router.Post("/", readLimit, webhooksAdmin, webhookController.CreateWebhook)
+		router.Delete("/:id", readLimit, webhooksAdmin, webhookController.DeleteWebhook)
+		router.Get("/:id/deliveries", readLimit, webhooksAdmin, webhookController.GetWebhookDeliveries)
+		router.Post("/deliveries/:id/replay", readLimit, webhooksAdmin, webhookController.ReplayWebhookDelivery)
 	})
 
 	if err := app.Listen(":3000"); err != nil {
@@ -64,21 +236,86 @@ func main() {
 	}
 }
 
+// newTokenVerifier loads the keys of the identity provider configured with the JWT_* variables. The verifier
+// is nil, and bearer tokens are rejected, when JWT_JWKS is not set.
+func newTokenVerifier(organizations *services.OrganizationService) (*services.TokenVerifier, error) {
+	tokenOptions, err := services.LoadTokenOptions()
+	if err != nil {
+		return nil, err
+	}
+	if tokenOptions.JWKS == "" {
+		return nil, nil
+	}
+	return services.NewTokenVerifier(context.Background(), tokenOptions, organizations)
+}
+
+// newRateLimiter returns the rate limiter configured with the RATE_LIMIT_* variables, keeping its buckets in memory
+// or in the database when they are shared by several instances
+func newRateLimiter(db *gorm.DB) (*services.RateLimiter, error) {
+	options, err := services.LoadRateLimitOptions()
+	if err != nil {
+		return nil, err
+	}
+	if options.Store == services.RateLimitStorePostgres {
+		return services.NewRateLimiter(repositories.NewRateLimitRepository(db), options), nil
+	}
+	return services.NewRateLimiter(services.NewMemoryRateLimitStore(), options), nil
+}
+
+// newStorage connects to the storage configured with the STORAGE_* variables. When a secondary storage is
+// configured with the REPLICA_STORAGE_* variables, objects are replicated to it by the returned service.
+func newStorage(mediaRepository repositories.IMediaRepository) (services.IStorageService, services.StorageOptions, *services.ReplicationService, error) {
+	storageOptions, err := services.LoadStorageOptions("STORAGE")
+	if err != nil {
+		return nil, storageOptions, nil, err
+	}
+	storageService, err := services.NewStorage(context.Background(), storageOptions)
+	if err != nil {
+		return nil, storageOptions, nil, err
+	}
+	if config.Config("REPLICA_STORAGE_DRIVER") == "" && config.Config("REPLICA_STORAGE_ENDPOINT") == "" {
+		return storageService, storageOptions, nil, nil
+	}
+	replicaOptions, err := services.LoadStorageOptions("REPLICA_STORAGE")
+	if err != nil {
+		return nil, storageOptions, nil, err
+	}
+	replicaStorage, err := services.NewStorage(context.Background(), replicaOptions)
+	if err != nil {
+		return nil, storageOptions, nil, err
+	}
+	replicationOptions, err := services.LoadReplicationOptions()
+	if err != nil {
+		return nil, storageOptions, nil, err
+	}
+	replicationService := services.NewReplicationService(mediaRepository, storageService, replicaStorage, replicationOptions)
+	return services.NewReplicatedStorage(storageService, replicaStorage), storageOptions, replicationService, nil
+}
+
 // Healthcheck godoc
 //
 //	@Summary		Healthcheck endpoint
-//	@Description	Healthcheck endpoint
+//	@Description	Healthcheck endpoint, with the state of the circuit breakers of the storages. The status is DEGRADED while a breaker is not closed.
 //	@Tags			Health
 //	@Produce		json
 //	@Success		200	{object}	main.HealthCheck.response
 //	@Router			/api/health [get]
 func HealthCheck(c *fiber.Ctx) error {
 	type response struct {
-		Status string `json:"status"`
-		Date   string `json:"date"`
+		Status   string                          `json:"status"`
+		Date     string                          `json:"date"`
+		Storages []services.CircuitBreakerStatus `json:"storages"`
+	}
+	status := "OK"
+	breakers := services.CircuitBreakers()
+	for _, breaker := range breakers {
+		if breaker.State != services.CircuitClosed {
+			status = "DEGRADED"
+		}
 	}
 	return c.Status(200).JSON(response{
-		Status: "OK",
-		Date:   time.Now().Format("2006-01-02 15:04:05"),
+		Status:   status,
+		Date:     time.Now().Format("2006-01-02 15:04:05"),
+		Storages: breakers,
 	})
 }
@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// APIKeyHeader is the header carrying the API key of a request
+const APIKeyHeader = "X-API-Key"
+
+// Key of the identity of the caller in the locals of a request
+const identityLocal = "identity"
+
+type errorResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Authenticate identifies the caller of a request from the bearer token of the Authorization header, signed
+// by the identity provider, or from the API key of the X-API-Key header. Bearer tokens are rejected when tokens
+// is nil. Requests without valid credentials are rejected with HTTP status code 401.
+func Authenticate(apiKeys *services.APIKeyService, tokens *services.TokenVerifier) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var identity *services.Identity
+		var err error
+		if token, found := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); found {
+			if tokens == nil {
+				return unauthorized(c, "bearer tokens are not accepted, send an api key in the "+APIKeyHeader+" header")
+			}
+			identity, err = tokens.Verify(c.UserContext(), strings.TrimSpace(token))
+		} else if key := c.Get(APIKeyHeader); key != "" {
+			var apiKey *models.APIKey
+			if apiKey, err = apiKeys.Authenticate(c.UserContext(), key); err == nil {
+				identity = services.NewAPIKeyIdentity(apiKey)
+			}
+		} else {
+			return unauthorized(c, "missing credentials, send a bearer token in the Authorization header or an api key in the "+APIKeyHeader+" header")
+		}
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrInvalidAPIKey) {
+				return unauthorized(c, err.Error())
+			}
+			return c.Status(500).JSON(errorResponse{
+				Success: false,
+				Message: "internal server error",
+			})
+		}
+		c.Locals(identityLocal, identity)
+		c.SetUserContext(services.WithIdentity(c.UserContext(), identity))
+		return c.Next()
+	}
+}
+
+func unauthorized(c *fiber.Ctx, message string) error {
+	c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	return c.Status(401).JSON(errorResponse{
+		Success: false,
+		Message: message,
+	})
+}
+
+// RequireScope rejects requests whose caller is not granted scope, by its roles or its API key, with HTTP
+// status code 403
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity := Identity(c)
+		if identity == nil || !identity.HasScope(scope) {
+			return c.Status(403).JSON(errorResponse{
+				Success: false,
+				Message: "the " + scope + " scope is required",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// Identity returns the caller of a request, nil without Authenticate
+func Identity(c *fiber.Ctx) *services.Identity {
+	identity, _ := c.Locals(identityLocal).(*services.Identity)
+	return identity
+}
@@ -0,0 +1,310 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRepository keeps API keys in memory
+type apiKeyRepository struct {
+	keys     []*models.APIKey
+	lastUsed int
+}
+
+func (repository *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	organizationID, found := repositories.TenantFromContext(ctx)
+	if !found {
+		return repositories.ErrMissingTenant
+	}
+	key.ID = uint(len(repository.keys) + 1)
+	key.OrganizationID = organizationID
+	key.Organization = &models.Organization{ID: organizationID, Slug: "fc-nantes"}
+	repository.keys = append(repository.keys, key)
+	return nil
+}
+
+func (repository *apiKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	for _, key := range repository.keys {
+		if key.KeyHash == keyHash {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, repositories.ErrAPIKeyNotFound
+}
+
+func (repository *apiKeyRepository) Find(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	for _, key := range repository.keys {
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+func (repository *apiKeyRepository) Revoke(ctx context.Context, id uint, now time.Time) error {
+	repository.keys[id-1].RevokedAt = &now
+	return nil
+}
+
+func (repository *apiKeyRepository) UpdateLastUsed(ctx context.Context, id uint, now time.Time) error {
+	repository.lastUsed++
+	repository.keys[id-1].LastUsedAt = &now
+	return nil
+}
+
+// organizationRepository keeps organizations in memory
+type organizationRepository struct {
+	organizations []models.Organization
+}
+
+func (repository *organizationRepository) Create(organization *models.Organization) error {
+	organization.ID = uint(len(repository.organizations) + 1)
+	repository.organizations = append(repository.organizations, *organization)
+	return nil
+}
+
+func (repository *organizationRepository) FindBySlug(slug string) (*models.Organization, error) {
+	for _, organization := range repository.organizations {
+		if organization.Slug == slug {
+			return &organization, nil
+		}
+	}
+	return nil, repositories.ErrOrganizationNotFound
+}
+
+func (repository *organizationRepository) Find() ([]models.Organization, error) {
+	return repository.organizations, nil
+}
+
+func (repository *organizationRepository) SetQuotas(slug string, storageQuota int64, mediaQuota int64) (*models.Organization, error) {
+	for i := range repository.organizations {
+		if repository.organizations[i].Slug == slug {
+			repository.organizations[i].StorageQuota, repository.organizations[i].MediaQuota = storageQuota, mediaQuota
+			return &repository.organizations[i], nil
+		}
+	}
+	return nil, repositories.ErrOrganizationNotFound
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	repository := &apiKeyRepository{}
+	service := services.NewAPIKeyService(repository)
+	ctx := repositories.WithTenant(context.Background(), 1)
+	reader, _, err := service.Issue(ctx, "gallery", []string{services.ScopeMediaRead})
+	require.NoError(t, err)
+	revoked, revokedKey, err := service.Issue(ctx, "former photographer", []string{services.ScopeMediaRead, services.ScopeMediaWrite})
+	require.NoError(t, err)
+	require.NoError(t, service.Revoke(ctx, revokedKey.ID))
+
+	app := fiber.New()
+	api := app.Group("/api", Authenticate(service, nil))
+	api.Get("/medias", RequireScope(services.ScopeMediaRead), func(c *fiber.Ctx) error {
+		// the queries of the services are restricted to the organization of the key
+		organizationID, _ := repositories.TenantFromContext(c.UserContext())
+		return c.SendString(fmt.Sprintf("%s@%s/%d", Identity(c).Name, Identity(c).Organization, organizationID))
+	})
+	api.Post("/medias", RequireScope(services.ScopeMediaWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+
+	tests := []struct {
+		description        string
+		method             string
+		key                string
+		token              string
+		expectedStatusCode int
+	}{
+		{
+			description:        "Requests holding the scope of the route should be accepted",
+			method:             "GET",
+			key:                reader,
+			expectedStatusCode: 200,
+		},
+		{
+			description:        "Requests without api key should return HTTP status code 401",
+			method:             "GET",
+			expectedStatusCode: 401,
+		},
+		{
+			description:        "Requests with an unknown api key should return HTTP status code 401",
+			method:             "GET",
+			key:                "sp_unknown",
+			expectedStatusCode: 401,
+		},
+		{
+			description:        "Requests with a revoked api key should return HTTP status code 401",
+			method:             "GET",
+			key:                revoked,
+			expectedStatusCode: 401,
+		},
+		{
+			description:        "Requests without the scope of the route should return HTTP status code 403",
+			method:             "POST",
+			key:                reader,
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Requests with a bearer token should return HTTP status code 401 without identity provider",
+			method:             "GET",
+			token:              "eyJhbGciOiJSUzI1NiJ9.e30.c2lnbmF0dXJl",
+			expectedStatusCode: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/medias", nil)
+			if tt.key != "" {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			if tt.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode == 200 {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, "gallery@fc-nantes/1", string(body))
+			}
+		})
+	}
+
+	// the last use of the key is recorded once per minute at most
+	assert.NotNil(t, repository.keys[0].LastUsedAt)
+	assert.Equal(t, 1, repository.lastUsed)
+	assert.NotContains(t, repository.keys[0].KeyHash, reader[3:], "keys are stored hashed")
+}
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "key-1",
+		"n":   base64.RawURLEncoding.EncodeToString(signingKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signingKey.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	organizations := services.NewOrganizationService(&organizationRepository{})
+	_, err = organizations.CreateOrganization("FC Nantes", "fc-nantes")
+	require.NoError(t, err)
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(jwksPath, jwks, 0o600))
+	verifier, err := services.NewTokenVerifier(context.Background(), services.TokenOptions{JWKS: jwksPath}, organizations)
+	require.NoError(t, err)
+	sign := func(roles ...string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"sub":   "user-1",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": roles,
+			"org":   "fc-nantes",
+		})
+		token.Header["kid"] = "key-1"
+		signed, err := token.SignedString(signingKey)
+		require.NoError(t, err)
+		return signed
+	}
+
+	app := fiber.New()
+	api := app.Group("/api", Authenticate(services.NewAPIKeyService(&apiKeyRepository{}), verifier))
+	api.Get("/medias", RequireScope(services.ScopeMediaRead), func(c *fiber.Ctx) error {
+		// the identity is passed to the services through the context of the request
+		identity := services.IdentityFromContext(c.UserContext())
+		organizationID, _ := repositories.TenantFromContext(c.UserContext())
+		return c.SendString(fmt.Sprintf("%s@%s/%d", identity.Subject, identity.Organization, organizationID))
+	})
+	api.Post("/medias", RequireScope(services.ScopeMediaWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+	api.Post("/tags", RequireScope(services.ScopeTagsAdmin), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+
+	tests := []struct {
+		description        string
+		method             string
+		path               string
+		token              string
+		expectedStatusCode int
+	}{
+		{
+			description:        "Viewers should read medias",
+			method:             "GET",
+			path:               "/api/medias",
+			token:              sign(services.RoleViewer),
+			expectedStatusCode: 200,
+		},
+		{
+			description:        "Viewers should not upload medias",
+			method:             "POST",
+			path:               "/api/medias",
+			token:              sign(services.RoleViewer),
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Editors should upload medias",
+			method:             "POST",
+			path:               "/api/medias",
+			token:              sign(services.RoleEditor),
+			expectedStatusCode: 201,
+		},
+		{
+			description:        "Editors should not manage tags",
+			method:             "POST",
+			path:               "/api/tags",
+			token:              sign(services.RoleEditor),
+			expectedStatusCode: 403,
+		},
+		{
+			description:        "Admins should manage tags",
+			method:             "POST",
+			path:               "/api/tags",
+			token:              sign(services.RoleAdmin),
+			expectedStatusCode: 201,
+		},
+		{
+			description:        "Invalid tokens should return HTTP status code 401",
+			method:             "GET",
+			path:               "/api/medias",
+			token:              sign(services.RoleAdmin) + "x",
+			expectedStatusCode: 401,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tt.token)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			if tt.expectedStatusCode == 401 {
+				assert.Equal(t, "Bearer", resp.Header.Get(fiber.HeaderWWWAuthenticate))
+			}
+			if tt.expectedStatusCode == 200 {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, "user-1@fc-nantes/1", string(body))
+			}
+		})
+	}
+}
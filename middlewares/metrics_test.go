@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	app := fiber.New()
+	app.Use(Metrics())
+	app.Get("/api/medias/:id", func(c *fiber.Ctx) error {
+		if c.Params("id") == "0" {
+			return fiber.ErrBadRequest
+		}
+		return c.SendStatus(200)
+	})
+
+	tests := []struct {
+		description   string
+		path          string
+		expectedRoute string
+		expectedCode  string
+	}{
+		{description: "Requests should be counted by route pattern", path: "/api/medias/1", expectedRoute: "/api/medias/:id", expectedCode: "200"},
+		{description: "Errors should be counted with the status set by the error handler", path: "/api/medias/0", expectedRoute: "/api/medias/:id", expectedCode: "400"},
+		{description: "Requests matching no route should share a route label", path: "/api/unknown/1", expectedRoute: unmatchedRoute, expectedCode: "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			counter := httpRequests.WithLabelValues("GET", tt.expectedRoute, tt.expectedCode)
+			before := testutil.ToFloat64(counter)
+
+			resp, err := app.Test(httptest.NewRequest("GET", tt.path, nil))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedCode, resp.Status[:3])
+			assert.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
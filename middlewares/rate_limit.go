@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// Local of the decision of the bucket of the IP address of a request
+const ipRateLimitLocal = "ipRateLimit"
+
+// RateLimitIP limits the requests of each IP address with the bucket of the class of their route, returned by
+// classify. It runs before the authentication, so that the requests with missing or invalid credentials count
+// against their IP address too.
+func RateLimitIP(limiter *services.RateLimiter, classify func(c *fiber.Ctx) string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		decision, err := limiter.Allow(c.UserContext(), classify(c), "", c.IP())
+		if err != nil {
+			log.Printf("unable to check rate limits: %s", err)
+			return c.Next()
+		}
+		c.Locals(ipRateLimitLocal, decision)
+		return limitRequest(c, decision)
+	}
+}
+
+// RateLimit limits the requests of a class of routes with the bucket of their credential, once authenticated.
+// Requests finding a bucket empty are rejected with HTTP status code 429 and a Retry-After header. The limit closest
+// to reject the request, among the bucket of the credential and the one of the IP address taken by RateLimitIP, is
+// described by the RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers. Requests are
+// accepted when the buckets cannot be read.
+func RateLimit(limiter *services.RateLimiter, class string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity := Identity(c)
+		if identity == nil {
+			return c.Next()
+		}
+		decision, err := limiter.Allow(c.UserContext(), class, identity.Organization+"/"+identity.Subject, "")
+		if err != nil {
+			log.Printf("unable to check rate limits: %s", err)
+			return c.Next()
+		}
+		if ipDecision, ok := c.Locals(ipRateLimitLocal).(*services.RateLimitDecision); ok {
+			decision = ipDecision.Closest(decision)
+		}
+		return limitRequest(c, decision)
+	}
+}
+
+// limitRequest describes the limit of a decision in the RateLimit headers, and rejects the request when the decision
+// does not allow it
+func limitRequest(c *fiber.Ctx, decision *services.RateLimitDecision) error {
+	if decision == nil {
+		return c.Next()
+	}
+	c.Set("RateLimit-Limit", strconv.Itoa(decision.Limit.Burst))
+	c.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	c.Set("RateLimit-Reset", seconds(decision.Reset))
+	c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s;burst=%d", decision.Limit.Requests, seconds(decision.Limit.Period), decision.Limit.Burst))
+	if !decision.Allowed {
+		c.Set(fiber.HeaderRetryAfter, seconds(decision.RetryAfter))
+		return c.Status(429).JSON(errorResponse{
+			Success: false,
+			Message: "rate limit exceeded, retry in " + seconds(decision.RetryAfter) + " seconds",
+		})
+	}
+	return c.Next()
+}
+
+// seconds formats a delay in whole seconds, rounded up
+func seconds(delay time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(delay.Seconds())), 10)
+}
@@ -0,0 +1,166 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingRateLimitStore cannot read its buckets
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(ctx context.Context, key string, rate float64, burst float64, now time.Time) (float64, bool, error) {
+	return 0, false, errors.New("database unreachable")
+}
+
+func (failingRateLimitStore) DeleteIdle(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func newRateLimitedApp(store services.RateLimitStore, ipLimit services.RateLimit) *fiber.App {
+	limiter := services.NewRateLimiter(store, services.RateLimitOptions{
+		Limits: map[string]services.RateLimit{
+			services.RateLimitUpload: {Requests: 1, Period: time.Minute, Burst: 2},
+			services.RateLimitRead:   {Requests: 60, Period: time.Minute, Burst: 60},
+		},
+		IPLimit: map[string]services.RateLimit{services.RateLimitRead: ipLimit},
+	})
+	app := fiber.New()
+	app.Use(RateLimitIP(limiter, func(c *fiber.Ctx) string {
+		if c.Method() == fiber.MethodPost {
+			return services.RateLimitUpload
+		}
+		return services.RateLimitRead
+	}))
+	// the caller is identified by the X-Caller header, requests without it are unauthorized
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Get("X-Caller") == "" {
+			return c.SendStatus(401)
+		}
+		c.Locals(identityLocal, &services.Identity{Subject: c.Get("X-Caller"), Organization: "fc-nantes"})
+		return c.Next()
+	})
+	app.Post("/api/medias", RateLimit(limiter, services.RateLimitUpload), func(c *fiber.Ctx) error {
+		return c.SendStatus(201)
+	})
+	app.Get("/api/medias", RateLimit(limiter, services.RateLimitRead), func(c *fiber.Ctx) error {
+		return c.SendStatus(200)
+	})
+	return app
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newRateLimitedApp(services.NewMemoryRateLimitStore(), services.RateLimit{})
+
+	tests := []struct {
+		description        string
+		method             string
+		caller             string
+		expectedStatusCode int
+		expectedHeaders    map[string]string
+	}{
+		{
+			description:        "Requests within the limit should be accepted with the RateLimit headers",
+			method:             "POST",
+			caller:             "api-key:1",
+			expectedStatusCode: 201,
+			expectedHeaders:    map[string]string{"RateLimit-Limit": "2", "RateLimit-Remaining": "1", "RateLimit-Reset": "60", "RateLimit-Policy": "1;w=60;burst=2"},
+		},
+		{
+			description:        "Requests should be accepted until the bucket is empty",
+			method:             "POST",
+			caller:             "api-key:1",
+			expectedStatusCode: 201,
+			expectedHeaders:    map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": "120"},
+		},
+		{
+			description:        "Requests finding the bucket empty should return HTTP status code 429 with Retry-After",
+			method:             "POST",
+			caller:             "api-key:1",
+			expectedStatusCode: 429,
+			expectedHeaders:    map[string]string{"RateLimit-Remaining": "0", "Retry-After": "60"},
+		},
+		{
+			description:        "Read routes should have their own buckets",
+			method:             "GET",
+			caller:             "api-key:1",
+			expectedStatusCode: 200,
+			expectedHeaders:    map[string]string{"RateLimit-Limit": "60", "RateLimit-Remaining": "59"},
+		},
+		{
+			description:        "Each credential should have its own buckets",
+			method:             "POST",
+			caller:             "api-key:2",
+			expectedStatusCode: 201,
+			expectedHeaders:    map[string]string{"RateLimit-Remaining": "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/api/medias", nil)
+			req.Header.Set("X-Caller", tt.caller)
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			for header, value := range tt.expectedHeaders {
+				assert.Equal(t, value, resp.Header.Get(header), header)
+			}
+			if tt.expectedStatusCode == 429 {
+				body, _ := io.ReadAll(resp.Body)
+				assert.JSONEq(t, `{"success":false,"message":"rate limit exceeded, retry in 60 seconds"}`, string(body))
+			}
+		})
+	}
+}
+
+func TestRateLimitAcceptsRequestsWhenBucketsCannotBeRead(t *testing.T) {
+	app := newRateLimitedApp(failingRateLimitStore{}, services.RateLimit{})
+
+	req := httptest.NewRequest("POST", "/api/medias", nil)
+	req.Header.Set("X-Caller", "api-key:1")
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("RateLimit-Limit"))
+}
+
+func TestRateLimitCountsUnauthorizedRequestsAgainstIPAddress(t *testing.T) {
+	app := newRateLimitedApp(services.NewMemoryRateLimitStore(), services.RateLimit{Requests: 3, Period: time.Minute, Burst: 3})
+	request := func(caller string) *http.Response {
+		req := httptest.NewRequest("GET", "/api/medias", nil)
+		if caller != "" {
+			req.Header.Set("X-Caller", caller)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := request("api-key:1")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("RateLimit-Limit"), "the limit of the IP address is the closest")
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Remaining"))
+
+	for i := 0; i < 2; i++ {
+		resp = request("")
+		assert.Equal(t, 401, resp.StatusCode)
+		assert.Equal(t, strconv.Itoa(1-i), resp.Header.Get("RateLimit-Remaining"))
+	}
+
+	resp = request("")
+	assert.Equal(t, 429, resp.StatusCode, "unauthorized requests are rejected once the bucket of the IP address is empty")
+	assert.Equal(t, "20", resp.Header.Get("Retry-After"))
+	resp = request("api-key:1")
+	assert.Equal(t, 429, resp.StatusCode, "the bucket of the IP address is shared by every credential")
+}
@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/mich31/scoreplay-media-api/services"
+)
+
+// RequestIDHeader is the header carrying the id of a request
+const RequestIDHeader = "X-Request-ID"
+
+// Longest request id accepted from the clients and proxies
+const maxRequestIDLength = 128
+
+// RequestID identifies each request by the id of its X-Request-ID header, set by a proxy or the client, or by
+// a new UUID. The id is sent back in the X-Request-ID header and carried with the IP address of the caller by
+// the context of the request, to be recorded in the audit log.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = utils.UUIDv4()
+		}
+		c.Set(RequestIDHeader, id)
+		c.SetUserContext(services.WithRequest(c.UserContext(), services.Request{ID: id, IP: c.IP()}))
+		return c.Next()
+	}
+}
+
+// validRequestID reports whether id is made of at most 128 visible ASCII characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package middlewares
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mich31/scoreplay-media-api/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	app := fiber.New()
+	app.Use(RequestID())
+	app.Get("/", func(c *fiber.Ctx) error {
+		request := services.RequestFromContext(c.UserContext())
+		return c.SendString(request.ID + " " + request.IP)
+	})
+
+	tests := []struct {
+		description string
+		requestID   string
+		expectedID  string
+	}{
+		{description: "The id set by the proxy should be kept", requestID: "f3a1c2d4-proxy", expectedID: "f3a1c2d4-proxy"},
+		{description: "Requests without id should be given a new one"},
+		{description: "Invalid ids should be replaced", requestID: "id with spaces"},
+		{description: "Ids too long should be replaced", requestID: strings.Repeat("a", 129)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+
+			id := resp.Header.Get(RequestIDHeader)
+			if tt.expectedID != "" {
+				assert.Equal(t, tt.expectedID, id)
+			} else {
+				assert.Len(t, id, 36, "a UUID is generated")
+			}
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, id+" 0.0.0.0", string(body), "the id and IP address are carried by the context")
+		})
+	}
+}
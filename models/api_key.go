@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey grants the scopes it holds to the requests sending it. Only the SHA-256 hash of the key is stored,
+// the key itself is shown once when it is issued.
+type APIKey struct {
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	OrganizationID uint          `json:"-" gorm:"not null;index"`
+	Organization   *Organization `json:"-"`
+	Name           string        `json:"name" gorm:"not null"`
+	// First characters of the key, to recognize it
+	Prefix     string         `json:"prefix" gorm:"not null"`
+	KeyHash    string         `json:"-" gorm:"size:64;not null;uniqueIndex"`
+	Scopes     pq.StringArray `json:"scopes" gorm:"type:text[];not null" swaggertype:"array,string"`
+	LastUsedAt *time.Time     `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time     `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time      `json:"createdAt"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded by the audit log
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	// Moves to the trash, restores out of it and permanent deletions of the items of the trash
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+// Types of the entities whose changes are recorded by the audit log
+const (
+	AuditMedia    = "media"
+	AuditTag      = "tag"
+	AuditMediaTag = "media_tag"
+)
+
+// AuditActorSystem is the actor of the changes made without a caller
+const AuditActorSystem = "system"
+
+// AuditEvent records a create, update or delete of an entity of an organization, with its state before and after.
+// Events are only inserted: the database rejects their updates and deletions.
+type AuditEvent struct {
+	ID             uint `json:"id" gorm:"primaryKey"`
+	OrganizationID uint `json:"-" gorm:"not null;index:idx_audit_events_organization_created"`
+	// Subject of the user, api-key:<id> or system, and the name of the user or API key
+	Actor      string `json:"actor" gorm:"not null;index"`
+	ActorName  string `json:"actorName,omitempty"`
+	Action     string `json:"action" gorm:"not null"`
+	EntityType string `json:"entityType" gorm:"not null;index:idx_audit_events_entity"`
+	// Id of the entity, <media id>:<tag id> for the tags of a media
+	EntityID string `json:"entityId" gorm:"not null;index:idx_audit_events_entity"`
+	// JSON representation of the entity, empty before a create and after a delete
+	Before    json.RawMessage `json:"before,omitempty" gorm:"type:jsonb" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" gorm:"type:jsonb" swaggertype:"object"`
+	RequestID string          `json:"requestId,omitempty" gorm:"index"`
+	IP        string          `json:"ip,omitempty"`
+	CreatedAt time.Time       `json:"createdAt" gorm:"index:idx_audit_events_organization_created"`
+}
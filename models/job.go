@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Status of a job
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	// The job failed too many times, it is kept for inspection and is not run anymore
+	JobDead = "dead"
+)
+
+// Job is a unit of background work stored in the jobs table. Pending jobs are claimed by workers
+// from the highest priority, then from the one due first.
+type Job struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Type string `json:"type" gorm:"not null;index"`
+	// JSON encoded arguments of the job
+	Payload     string     `json:"payload" gorm:"type:jsonb;not null"`
+	Status      string     `json:"status" gorm:"not null;default:pending;index:idx_jobs_due,priority:1"`
+	Priority    int        `json:"priority" gorm:"not null;default:0"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"maxAttempts" gorm:"not null"`
+	RunAt       time.Time  `json:"runAt" gorm:"not null;index:idx_jobs_due,priority:2"`
+	LockedAt    *time.Time `json:"lockedAt,omitempty"`
+	LockedBy    string     `json:"lockedBy,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
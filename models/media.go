@@ -1,37 +1,190 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 // Media model
 type Media struct {
-	ID          uint   `json:"id" gorm:"primaryKey"`
-	Name        string `json:"name" gorm:"not null;index:idx_media_name"`
-	Description string `json:"description" gorm:"size:100"`
-	FileUrl     string `json:"fileUrl" gorm:"not null"`
-	FileSize    int64
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
-	Tags        []Tag     `gorm:"many2many:media_tags;"`
+	ID             uint          `json:"id" gorm:"primaryKey"`
+	OrganizationID uint          `json:"-" gorm:"not null;index"`
+	Organization   *Organization `json:"-"`
+	Name           string        `json:"name" gorm:"not null;index:idx_media_name"`
+	Description    string        `json:"description" gorm:"size:100"`
+	MediaFiles
+	FileName          string `json:"fileName,omitempty"`
+	FileSize          int64
+	ContentType       string   `json:"contentType"`
+	RenditionsVersion string   `json:"-"`
+	FocalX            *float64 `json:"focalX,omitempty"`
+	FocalY            *float64 `json:"focalY,omitempty"`
+	Crops             CropMap  `json:"crops,omitempty" gorm:"type:jsonb"`
+	PerceptualHash    *int64   `json:"perceptualHash,omitempty" gorm:"index:idx_media_perceptual_hash"`
+	// Id of the key encrypting the stored objects, empty when they are stored unencrypted
+	EncryptionKeyID string `json:"-" gorm:"index"`
+	// Subject of the user, or api-key:<id>, who uploaded the media. Empty for medias uploaded before authentication.
+	UploadedBy string `json:"uploadedBy,omitempty" gorm:"index"`
+	VideoMetadata
+	Replication
+	Processing
+	DownloadCount int64     `json:"downloadCount" gorm:"not null;default:0"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// Time the media was moved to the trash, deleted medias are excluded from the queries
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	Tags      []Tag          `gorm:"many2many:media_tags;"`
 }
 
 // MediaTag model (junction table)
 type MediaTag struct {
-	MediaID   uint   `gorm:"primaryKey;column:media_id;index:idx_media_tags_media_id"`
-	TagID     uint   `gorm:"primaryKey;column:tag_id;index:idx_media_tags_media_id"`
-	Media     *Media `gorm:"foreignKey:MediaID"`
-	Tag       *Tag   `gorm:"foreignKey:TagID"`
-	CreatedAt time.Time
+	MediaID        uint   `gorm:"primaryKey;column:media_id;index:idx_media_tags_media_id"`
+	TagID          uint   `gorm:"primaryKey;column:tag_id;index:idx_media_tags_media_id"`
+	OrganizationID uint   `gorm:"not null;index"`
+	Media          *Media `gorm:"foreignKey:MediaID"`
+	Tag            *Tag   `gorm:"foreignKey:TagID"`
+	CreatedAt      time.Time
 }
 
 // Custom model to hold media with just tag names
 type MediaWithTagNames struct {
-	ID          uint           `json:"id"`
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	FileUrl     string         `json:"fileUrl"`
-	TagNames    pq.StringArray `json:"tagNames" gorm:"column:tag_names;type:text"`
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	MediaFiles
+	FileName      string `json:"fileName,omitempty"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failureReason,omitempty"`
+	UploadedBy    string `json:"uploadedBy,omitempty"`
+	VideoMetadata
+	TagNames pq.StringArray `json:"tagNames" gorm:"column:tag_names;type:text"`
+}
+
+// MediaFiles holds the storage keys of the original file and renditions of a media.
+// Their presigned urls are set before responding.
+type MediaFiles struct {
+	ObjectKey     string       `json:"-" gorm:"index:idx_media_object_key"`
+	FileUrl       string       `json:"fileUrl" gorm:"-"`
+	Renditions    RenditionMap `json:"-" gorm:"type:jsonb"`
+	RenditionUrls RenditionMap `json:"renditions,omitempty" gorm:"-"`
+}
+
+// VideoMetadata holds the properties read from a video container
+type VideoMetadata struct {
+	Duration   *float64   `json:"duration,omitempty" gorm:"index:idx_media_duration"`
+	Width      *int       `json:"width,omitempty" gorm:"index:idx_media_resolution"`
+	Height     *int       `json:"height,omitempty" gorm:"index:idx_media_resolution"`
+	FrameRate  *float64   `json:"frameRate,omitempty"`
+	VideoCodec string     `json:"videoCodec,omitempty"`
+	AudioCodec string     `json:"audioCodec,omitempty"`
+	HasAudio   *bool      `json:"hasAudio,omitempty"`
+	RecordedAt *time.Time `json:"recordedAt,omitempty"`
+}
+
+// Status of the replication of the objects of a media to the secondary storage
+const (
+	ReplicationPending    = "pending"
+	ReplicationReplicated = "replicated"
+	ReplicationFailed     = "failed"
+)
+
+// Replication tracks the copy of the objects of a media to the secondary storage.
+// The status is empty when replication is disabled.
+type Replication struct {
+	ReplicationStatus   string     `json:"replicationStatus,omitempty" gorm:"index:idx_media_replication"`
+	ReplicationAttempts int        `json:"-" gorm:"not null;default:0"`
+	ReplicationError    string     `json:"-"`
+	NextReplicationAt   *time.Time `json:"-"`
+	ReplicatedAt        *time.Time `json:"replicatedAt,omitempty"`
+}
+
+// Processing statuses of a media
+const (
+	// The media is created, its file is being uploaded to the storage
+	MediaUploading = "uploading"
+	// The file is stored, its metadata is being extracted and its renditions generated
+	MediaProcessing = "processing"
+	MediaReady      = "ready"
+	// The processing failed, it can be retried
+	MediaFailed = "failed"
+)
+
+// Processing tracks the lifecycle of a media from the upload of its file to the end of its processing.
+// Medias created before the lifecycle was tracked are ready.
+type Processing struct {
+	Status              string     `json:"status" gorm:"not null;default:ready;index:idx_media_status"`
+	FailureReason       string     `json:"failureReason,omitempty"`
+	UploadedAt          *time.Time `json:"uploadedAt,omitempty"`
+	ProcessingStartedAt *time.Time `json:"processingStartedAt,omitempty"`
+	ReadyAt             *time.Time `json:"readyAt,omitempty"`
+	FailedAt            *time.Time `json:"failedAt,omitempty"`
+}
+
+// Media with its distance to another media, used for near-duplicate detection
+type SimilarMedia struct {
+	MediaWithTagNames
+	Distance int `json:"distance" gorm:"column:distance"`
+}
+
+// RenditionMap maps a rendition size name (thumb, small, medium...) to its object key or url
+type RenditionMap map[string]string
+
+func (m RenditionMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *RenditionMap) Scan(value interface{}) error {
+	return scanJSON(value, m)
+}
+
+// FocalPoint holds the focal point (normalized x, y between 0 and 1) and crop boxes set by an editor
+type FocalPoint struct {
+	X     *float64 `json:"x"`
+	Y     *float64 `json:"y"`
+	Crops CropMap  `json:"crops"`
+}
+
+// CropBox is a crop region set by an editor, in coordinates normalized between 0 and 1
+type CropBox struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// CropMap maps an aspect ratio (1:1, 4:5, 9:16...) to its crop box
+type CropMap map[string]CropBox
+
+func (m CropMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *CropMap) Scan(value interface{}) error {
+	return scanJSON(value, m)
+}
+
+func scanJSON(value interface{}, dest interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for json column")
+	}
+	return json.Unmarshal(data, dest)
 }
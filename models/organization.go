@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// DefaultOrganization is the slug of the organization owning the data created before organizations
+const DefaultOrganization = "default"
+
+// Organization is a tenant of the API: a club whose medias, tags and API keys are isolated from other organizations
+type Organization struct {
+	ID   uint   `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"not null"`
+	// Identifier of the organization in the org claim of the tokens of its users
+	Slug string `json:"slug" gorm:"not null;uniqueIndex"`
+	// Maximum bytes stored and number of medias of the organization, 0 for unlimited
+	StorageQuota int64     `json:"storageQuota" gorm:"not null;default:0"`
+	MediaQuota   int64     `json:"mediaQuota" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
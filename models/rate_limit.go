@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RateLimitBucket is a token bucket of the rate limits shared by the instances of the API
+type RateLimitBucket struct {
+	// Class of routes, credential or IP address of the bucket
+	Key    string  `gorm:"primaryKey"`
+	Tokens float64 `gorm:"not null"`
+	// Whether the last request took a token
+	Allowed   bool      `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Status of a media in a storage migration
+const (
+	StorageMigrationCopied = "copied"
+	StorageMigrationFailed = "failed"
+)
+
+// StorageMigrationProgress records the medias processed by a storage migration, so that it can be resumed
+type StorageMigrationProgress struct {
+	Migration string `gorm:"primaryKey"`
+	MediaID   uint   `gorm:"primaryKey"`
+	Status    string `gorm:"not null"`
+	Checksum  string `gorm:"size:64"`
+	Error     string
+	UpdatedAt time.Time
+}
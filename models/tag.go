@@ -1,11 +1,19 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Tag model
 type Tag struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Name      string    `json:"name" gorm:"unique;not null;index:idx_tags_name"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID uint `json:"id" gorm:"primaryKey"`
+	// Tag names are unique among the tags of an organization which are not in the trash
+	OrganizationID uint      `json:"-" gorm:"not null;uniqueIndex:idx_tags_organization_name_active,where:deleted_at IS NULL"`
+	Name           string    `json:"name" gorm:"not null;uniqueIndex:idx_tags_organization_name_active;index:idx_tags_name"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	// Time the tag was moved to the trash, deleted tags are excluded from the queries
+	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
 }
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Types of the items of the trash
+const (
+	TrashMedia = "media"
+	TrashTag   = "tag"
+)
+
+// TrashItem is a media or a tag moved to the trash, restored until it is purged
+type TrashItem struct {
+	Type      string    `json:"type"`
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deletedAt"`
+	// Time after which the item and its files are deleted permanently
+	PurgeAt time.Time `json:"purgeAt"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// Dimensions of the usage counters
+const (
+	UsageTotal       = "total"
+	UsageTag         = "tag"
+	UsageContentType = "content_type"
+)
+
+// OrganizationUsage holds the bytes and number of medias stored by an organization. It is updated with each
+// upload, so that quotas are checked without summing the sizes of the medias.
+type OrganizationUsage struct {
+	OrganizationID uint      `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	Bytes          int64     `json:"bytes" gorm:"not null;default:0"`
+	Medias         int64     `json:"medias" gorm:"not null;default:0"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	// Organization holding the quotas
+	Organization *Organization `json:"-"`
+}
+
+// UsageCounter holds the bytes and medias added to an organization on a day (removed when negative), in total,
+// for a tag or for a content type. The usage at a date is the sum of the counters of the previous days.
+type UsageCounter struct {
+	OrganizationID uint      `gorm:"primaryKey;autoIncrement:false"`
+	Day            time.Time `gorm:"primaryKey;type:date"`
+	// Dimension of the counter: total, tag or content_type
+	Dimension string `gorm:"primaryKey"`
+	// Tag name or content type, empty for the total
+	Key    string `gorm:"primaryKey"`
+	Bytes  int64  `gorm:"not null;default:0"`
+	Medias int64  `gorm:"not null;default:0"`
+}
+
+// UsagePoint is the usage of an organization at the end of a day
+type UsagePoint struct {
+	Date   string `json:"date" example:"2024-03-09"`
+	Bytes  int64  `json:"bytes"`
+	Medias int64  `json:"medias"`
+}
+
+// UsageSeries is the usage of a tag or a content type over time
+type UsageSeries struct {
+	Key    string       `json:"key" example:"image/jpeg"`
+	Points []UsagePoint `json:"points"`
+}
+
+// UsageReport is the usage of an organization: its current usage and quotas, and its usage over a period,
+// in total, by tag and by content type
+type UsageReport struct {
+	Organization string        `json:"organization" example:"fc-nantes"`
+	Bytes        int64         `json:"bytes"`
+	Medias       int64         `json:"medias"`
+	StorageQuota int64         `json:"storageQuota"`
+	MediaQuota   int64         `json:"mediaQuota"`
+	Total        []UsagePoint  `json:"total"`
+	Tags         []UsageSeries `json:"tags"`
+	ContentTypes []UsageSeries `json:"contentTypes"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Types of the events sent to the webhooks
+const (
+	WebhookMediaCreated  = "media.created"
+	WebhookMediaUpdated  = "media.updated"
+	WebhookMediaDeleted  = "media.deleted"
+	WebhookMediaRestored = "media.restored"
+	WebhookTagCreated    = "tag.created"
+	WebhookTagDeleted    = "tag.deleted"
+	WebhookTagRestored   = "tag.restored"
+)
+
+// WebhookEvents lists every type of event a webhook can subscribe to
+var WebhookEvents = []string{
+	WebhookMediaCreated, WebhookMediaUpdated, WebhookMediaDeleted, WebhookMediaRestored,
+	WebhookTagCreated, WebhookTagDeleted, WebhookTagRestored,
+}
+
+// Status of a delivery
+const (
+	// The delivery is sent as soon as its next attempt is due
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	// Every attempt failed, the delivery is not sent anymore unless it is replayed
+	DeliveryFailed = "failed"
+)
+
+// OutboxEvent is a change of a media or a tag, inserted in the transaction of the change so that no event is lost.
+// Once dispatched to the deliveries of the webhooks subscribed to it, it is kept for the history of the live feed.
+type OutboxEvent struct {
+	ID             uint   `gorm:"primaryKey"`
+	OrganizationID uint   `gorm:"not null"`
+	Type           string `gorm:"not null"`
+	// JSON representation of the media or tag after the change, before it for deletions
+	Payload      json.RawMessage `gorm:"type:jsonb;not null"`
+	CreatedAt    time.Time       `gorm:"index"`
+	DispatchedAt *time.Time      `gorm:"index:idx_outbox_events_pending,where:dispatched_at IS NULL"`
+}
+
+// Webhook subscribes a URL to events of an organization
+type Webhook struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	OrganizationID uint           `json:"-" gorm:"not null;index"`
+	URL            string         `json:"url" gorm:"not null"`
+	Events         pq.StringArray `json:"events" gorm:"type:text[];not null" swaggertype:"array,string"`
+	// Key of the HMAC-SHA256 signature of the deliveries, only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty" gorm:"not null"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookDelivery is the sending of an event to a webhook, retried until it succeeds or its attempts are exhausted.
+// Deliveries are kept as the delivery log of their webhook.
+type WebhookDelivery struct {
+	ID             uint     `json:"id" gorm:"primaryKey"`
+	OrganizationID uint     `json:"-" gorm:"not null;index"`
+	WebhookID      uint     `json:"webhookId" gorm:"not null;index"`
+	Webhook        *Webhook `json:"-"`
+	// Id of the event, kept by the replays of the delivery so that receivers can ignore the events already handled
+	EventID    uint            `json:"eventId" gorm:"not null"`
+	EventType  string          `json:"eventType" gorm:"not null"`
+	Payload    json.RawMessage `json:"payload" gorm:"type:jsonb;not null" swaggertype:"object"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Status     string          `json:"status" gorm:"not null;index:idx_webhook_deliveries_due,priority:1"`
+	Attempts   int             `json:"attempts" gorm:"not null;default:0"`
+	// Time of the next attempt of a pending delivery
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty" gorm:"index:idx_webhook_deliveries_due,priority:2"`
+	// HTTP status code answered to the last attempt, empty when no response was received
+	ResponseStatus int        `json:"responseStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	// Id of the delivery this one replays
+	ReplayOf  *uint     `json:"replayOf,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,271 @@
+// Package mp4 reads the metadata of MP4 and QuickTime (MOV) files from their box structure.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrInvalidFile = errors.New("invalid mp4 file")
+
+// Metadata of a video file
+type Metadata struct {
+	Duration   float64 // seconds
+	Width      int
+	Height     int
+	FrameRate  float64
+	VideoCodec string
+	AudioCodec string
+	HasAudio   bool
+	CreatedAt  time.Time
+}
+
+// Seconds between the QuickTime epoch (1904-01-01) and the Unix epoch
+const quickTimeEpochOffset = 2082844800
+
+// Maximum size of a box read in memory, media data boxes are skipped
+const maxBoxSize = 64 << 20
+
+// Maximum nesting of the container boxes (moov > trak > mdia > minf > stbl in valid files), so that crafted files
+// cannot exhaust the stack
+const maxBoxDepth = 8
+
+// Maximum number of tracks of a file
+const maxTracks = 64
+
+type track struct {
+	handler     string
+	width       int
+	height      int
+	timescale   uint32
+	duration    uint64
+	codec       string
+	sampleCount uint64
+}
+
+type parser struct {
+	reader    io.ReadSeeker
+	timescale uint32
+	duration  uint64
+	created   uint64
+	tracks    []*track
+	foundMoov bool
+}
+
+// Parse reads the metadata of a MP4 or MOV file. Only the headers are read, media data is skipped.
+func Parse(reader io.ReadSeeker) (*Metadata, error) {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	p := &parser{reader: reader}
+	if err := p.parseBoxes(0, size, nil, 0); err != nil {
+		return nil, err
+	}
+	if !p.foundMoov {
+		return nil, fmt.Errorf("%w: missing moov box", ErrInvalidFile)
+	}
+	return p.metadata(), nil
+}
+
+func (p *parser) metadata() *Metadata {
+	metadata := &Metadata{}
+	if p.timescale > 0 {
+		metadata.Duration = float64(p.duration) / float64(p.timescale)
+	}
+	if p.created > quickTimeEpochOffset {
+		metadata.CreatedAt = time.Unix(int64(p.created-quickTimeEpochOffset), 0).UTC()
+	}
+
+	for _, t := range p.tracks {
+		switch t.handler {
+		case "vide":
+			if metadata.VideoCodec != "" {
+				continue
+			}
+			metadata.VideoCodec = codecName(t.codec)
+			metadata.Width, metadata.Height = t.width, t.height
+			if t.timescale > 0 && t.duration > 0 {
+				metadata.FrameRate = float64(t.sampleCount) * float64(t.timescale) / float64(t.duration)
+			}
+		case "soun":
+			metadata.HasAudio = true
+			if metadata.AudioCodec == "" {
+				metadata.AudioCodec = codecName(t.codec)
+			}
+		}
+	}
+	return metadata
+}
+
+// parseBoxes reads the boxes between start and end offsets, nested in depth container boxes
+func (p *parser) parseBoxes(start, end int64, current *track, depth int) error {
+	if depth > maxBoxDepth {
+		return fmt.Errorf("%w: boxes nested deeper than %d levels", ErrInvalidFile, maxBoxDepth)
+	}
+	offset := start
+	for offset+8 <= end {
+		if _, err := p.reader.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		header := make([]byte, 8)
+		if _, err := io.ReadFull(p.reader, header); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidFile, err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[0:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			large := make([]byte, 8)
+			if _, err := io.ReadFull(p.reader, large); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidFile, err)
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return fmt.Errorf("%w: invalid size for box %q", ErrInvalidFile, boxType)
+		}
+
+		if err := p.parseBox(boxType, offset+headerSize, offset+size, current, depth); err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+func (p *parser) parseBox(boxType string, start, end int64, current *track, depth int) error {
+	switch boxType {
+	case "moov":
+		p.foundMoov = true
+		return p.parseBoxes(start, end, nil, depth+1)
+	case "trak":
+		if len(p.tracks) >= maxTracks {
+			return fmt.Errorf("%w: more than %d tracks", ErrInvalidFile, maxTracks)
+		}
+		t := &track{}
+		p.tracks = append(p.tracks, t)
+		return p.parseBoxes(start, end, t, depth+1)
+	case "mdia", "minf", "stbl":
+		return p.parseBoxes(start, end, current, depth+1)
+	case "mvhd", "tkhd", "mdhd", "hdlr", "stsd", "stts":
+		if end-start > maxBoxSize {
+			return fmt.Errorf("%w: box %q is too large", ErrInvalidFile, boxType)
+		}
+		data := make([]byte, end-start)
+		if _, err := io.ReadFull(p.reader, data); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidFile, err)
+		}
+		return p.parseFullBox(boxType, data, current)
+	}
+	return nil
+}
+
+func (p *parser) parseFullBox(boxType string, data []byte, current *track) error {
+	if len(data) < 4 {
+		return fmt.Errorf("%w: box %q is too short", ErrInvalidFile, boxType)
+	}
+	version := data[0]
+	data = data[4:]
+
+	switch boxType {
+	case "mvhd":
+		if version == 1 && len(data) >= 28 {
+			p.created = binary.BigEndian.Uint64(data[0:8])
+			p.timescale = binary.BigEndian.Uint32(data[16:20])
+			p.duration = binary.BigEndian.Uint64(data[20:28])
+		} else if len(data) >= 16 {
+			p.created = uint64(binary.BigEndian.Uint32(data[0:4]))
+			p.timescale = binary.BigEndian.Uint32(data[8:12])
+			p.duration = uint64(binary.BigEndian.Uint32(data[12:16]))
+		}
+	case "tkhd":
+		if current == nil {
+			return nil
+		}
+		// width and height are the last two 16.16 fixed-point values of the box
+		if len(data) >= 8 {
+			current.width = int(binary.BigEndian.Uint32(data[len(data)-8:]) >> 16)
+			current.height = int(binary.BigEndian.Uint32(data[len(data)-4:]) >> 16)
+		}
+	case "mdhd":
+		if current == nil {
+			return nil
+		}
+		if version == 1 && len(data) >= 28 {
+			current.timescale = binary.BigEndian.Uint32(data[16:20])
+			current.duration = binary.BigEndian.Uint64(data[20:28])
+		} else if len(data) >= 16 {
+			current.timescale = binary.BigEndian.Uint32(data[8:12])
+			current.duration = uint64(binary.BigEndian.Uint32(data[12:16]))
+		}
+	case "hdlr":
+		if current != nil && len(data) >= 8 {
+			current.handler = string(data[4:8])
+		}
+	case "stsd":
+		// first sample entry: size (4 bytes) followed by the codec four-character code
+		if current == nil || len(data) < 12 {
+			return nil
+		}
+		entry := data[4:]
+		current.codec = string(entry[4:8])
+		// visual sample entries hold their own dimensions, used when the track header has none
+		if len(entry) >= 36 && current.width == 0 && current.height == 0 {
+			current.width = int(binary.BigEndian.Uint16(entry[32:34]))
+			current.height = int(binary.BigEndian.Uint16(entry[34:36]))
+		}
+	case "stts":
+		if current == nil || len(data) < 4 {
+			return nil
+		}
+		count := int(binary.BigEndian.Uint32(data[0:4]))
+		for i := 0; i < count && 4+i*8+8 <= len(data); i++ {
+			current.sampleCount += uint64(binary.BigEndian.Uint32(data[4+i*8 : 8+i*8]))
+		}
+	}
+	return nil
+}
+
+func codecName(code string) string {
+	switch code {
+	case "avc1", "avc3":
+		return "h264"
+	case "hvc1", "hev1":
+		return "hevc"
+	case "av01":
+		return "av1"
+	case "vp08":
+		return "vp8"
+	case "vp09":
+		return "vp9"
+	case "mp4v":
+		return "mpeg4"
+	case "apch", "apcn", "apcs", "apco", "ap4h", "ap4x":
+		return "prores"
+	case "mp4a":
+		return "aac"
+	case "ac-3":
+		return "ac3"
+	case "ec-3":
+		return "eac3"
+	case "Opus":
+		return "opus"
+	case "fLaC":
+		return "flac"
+	case "lpcm", "sowt", "twos", "in24", "in32":
+		return "pcm"
+	}
+	return code
+}
@@ -0,0 +1,155 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func box(boxType string, payloads ...[]byte) []byte {
+	var body []byte
+	for _, payload := range payloads {
+		body = append(body, payload...)
+	}
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(body)+8))
+	copy(header[4:], boxType)
+	return append(header, body...)
+}
+
+func u32(values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, value := range values {
+		binary.BigEndian.PutUint32(data[i*4:], value)
+	}
+	return data
+}
+
+func mvhd(created, timescale, duration uint32) []byte {
+	return box("mvhd", u32(0, created, created, timescale, duration), make([]byte, 80))
+}
+
+func tkhd(width, height uint32) []byte {
+	payload := make([]byte, 80)
+	binary.BigEndian.PutUint32(payload[72:], width<<16)
+	binary.BigEndian.PutUint32(payload[76:], height<<16)
+	return box("tkhd", u32(0), payload)
+}
+
+func trak(handler, codec string, width, height, timescale, duration, samples uint32) []byte {
+	sampleEntry := make([]byte, 28)
+	binary.BigEndian.PutUint16(sampleEntry[24:], uint16(width))
+	binary.BigEndian.PutUint16(sampleEntry[26:], uint16(height))
+	return box("trak",
+		tkhd(width, height),
+		box("mdia",
+			box("mdhd", u32(0, 0, 0, timescale, duration, 0)),
+			box("hdlr", u32(0, 0), []byte(handler), make([]byte, 12)),
+			box("minf",
+				box("stbl",
+					box("stsd", u32(0, 1), box(codec, sampleEntry)),
+					box("stts", u32(0, 1, samples, duration/samples)),
+				),
+			),
+		),
+	)
+}
+
+// nested returns depth boxes of boxType, each containing the next one
+func nested(boxType string, depth int) []byte {
+	file := []byte{}
+	for i := 0; i < depth; i++ {
+		file = box(boxType, file)
+	}
+	return file
+}
+
+func TestParse(t *testing.T) {
+	created := time.Date(2024, 5, 12, 18, 30, 0, 0, time.UTC)
+	createdQuickTime := uint32(created.Unix() + quickTimeEpochOffset)
+
+	tests := []struct {
+		description string
+		file        []byte
+		expected    *Metadata
+		expectedErr error
+	}{
+		{
+			description: "Parse should read a 4K h264 video with an aac audio track",
+			file: bytes.Join([][]byte{
+				box("ftyp", []byte("isom"), u32(512), []byte("isomiso2avc1mp41")),
+				box("mdat", make([]byte, 1024)),
+				box("moov",
+					mvhd(createdQuickTime, 1000, 12500),
+					trak("vide", "avc1", 3840, 2160, 25000, 312500, 312),
+					trak("soun", "mp4a", 0, 0, 48000, 600000, 586),
+				),
+			}, nil),
+			expected: &Metadata{
+				Duration:   12.5,
+				Width:      3840,
+				Height:     2160,
+				FrameRate:  24.96,
+				VideoCodec: "h264",
+				AudioCodec: "aac",
+				HasAudio:   true,
+				CreatedAt:  created,
+			},
+		},
+		{
+			description: "Parse should read a mov video without audio",
+			file: bytes.Join([][]byte{
+				box("ftyp", []byte("qt  "), u32(0)),
+				box("moov",
+					mvhd(0, 600, 16200),
+					trak("vide", "apch", 1920, 1080, 30000, 810000, 810),
+				),
+			}, nil),
+			expected: &Metadata{
+				Duration:   27,
+				Width:      1920,
+				Height:     1080,
+				FrameRate:  30,
+				VideoCodec: "prores",
+			},
+		},
+		{
+			description: "Parse should return an error when the moov box is missing",
+			file:        box("ftyp", []byte("isom"), u32(512)),
+			expectedErr: ErrInvalidFile,
+		},
+		{
+			description: "Parse should return an error for boxes nested too deeply",
+			file:        nested("moov", 100),
+			expectedErr: ErrInvalidFile,
+		},
+		{
+			description: "Parse should return an error for too many tracks",
+			file:        box("moov", bytes.Repeat(trak("vide", "avc1", 1920, 1080, 30000, 810000, 810), maxTracks+1)),
+			expectedErr: ErrInvalidFile,
+		},
+		{
+			description: "Parse should return an error for a truncated box",
+			file:        box("moov", mvhd(0, 1000, 1000))[:40],
+			expectedErr: ErrInvalidFile,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			metadata, err := Parse(bytes.NewReader(tt.file))
+			if tt.expectedErr != nil {
+				assert.True(t, errors.Is(err, tt.expectedErr), "unexpected error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.expected.FrameRate, metadata.FrameRate, 0.01)
+			metadata.FrameRate = tt.expected.FrameRate
+			assert.Equal(t, tt.expected, metadata)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrAPIKeyDBOperation = errors.New("api key database operation failed")
+)
+
+type IAPIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error)
+	Find(ctx context.Context) ([]models.APIKey, error)
+	Revoke(ctx context.Context, id uint, now time.Time) error
+	UpdateLastUsed(ctx context.Context, id uint, now time.Time) error
+}
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// scoped returns a query restricted to the keys of the organization of ctx
+func (repository *APIKeyRepository) scoped(ctx context.Context) *gorm.DB {
+	return repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "api_keys"))
+}
+
+func (repository *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	key.OrganizationID = organizationID
+	if err := repository.db.WithContext(ctx).Create(key).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrAPIKeyDBOperation, err)
+	}
+	return nil
+}
+
+// FindByHash returns the key of any organization with a hash: keys are looked up to find the organization
+// of a request
+func (repository *APIKeyRepository) FindByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := repository.db.WithContext(ctx).Preload("Organization").Where("key_hash = ?", keyHash).Take(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAPIKeyDBOperation, err)
+	}
+	return &key, nil
+}
+
+// Find returns every key, revoked keys included, from the most recent
+func (repository *APIKeyRepository) Find(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := repository.scoped(ctx).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAPIKeyDBOperation, err)
+	}
+	return keys, nil
+}
+
+func (repository *APIKeyRepository) Revoke(ctx context.Context, id uint, now time.Time) error {
+	result := repository.scoped(ctx).Model(&models.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", now)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %w", ErrAPIKeyDBOperation, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (repository *APIKeyRepository) UpdateLastUsed(ctx context.Context, id uint, now time.Time) error {
+	if err := repository.scoped(ctx).Model(&models.APIKey{ID: id}).Update("last_used_at", now).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrAPIKeyDBOperation, err)
+	}
+	return nil
+}
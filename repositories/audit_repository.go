@@ -0,0 +1,76 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+// AuditFilter selects audit events, the empty fields match every event
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	RequestID  string
+	// Events created at or after From and before To
+	From *time.Time
+	To   *time.Time
+	// Events older than the event Before, to read the next page
+	Before uint
+}
+
+type IAuditRepository interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	Find(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEvent, error)
+}
+
+// AuditRepository appends events to the audit log. It never updates nor deletes them.
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (repository *AuditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	event.OrganizationID = organizationID
+	return repository.db.WithContext(ctx).Create(event).Error
+}
+
+// Find returns the events matching filter, newest first
+func (repository *AuditRepository) Find(ctx context.Context, filter AuditFilter, limit int) ([]models.AuditEvent, error) {
+	query := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "audit_events"))
+	for _, condition := range []struct{ column, value string }{
+		{"actor", filter.Actor},
+		{"action", filter.Action},
+		{"entity_type", filter.EntityType},
+		{"entity_id", filter.EntityID},
+		{"request_id", filter.RequestID},
+	} {
+		if condition.value != "" {
+			query = query.Where(condition.column+" = ?", condition.value)
+		}
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if filter.Before > 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+	var events []models.AuditEvent
+	if err := query.Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,111 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+// OutboxChannel is the channel notified by Postgres with the id of each event added to the outbox
+const OutboxChannel = "outbox_events"
+
+type IEventRepository interface {
+	FindAfter(ctx context.Context, afterID uint, types []string, limit int) ([]models.OutboxEvent, error)
+	FindSince(ctx context.Context, lastID uint, window time.Duration, types []string, limit int) ([]models.OutboxEvent, error)
+	LastID(ctx context.Context) (uint, error)
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+// EventRepository reads the history of the events of the outbox
+type EventRepository struct {
+	db *gorm.DB
+}
+
+func NewEventRepository(db *gorm.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// FindAfter returns at most limit events of the given types added after the event afterID, oldest first
+func (repository *EventRepository) FindAfter(ctx context.Context, afterID uint, types []string, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "outbox_events")).
+		Where("id > ? AND type IN ?", afterID, types).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// FindSince returns at most limit events of the given types added after the event lastID, or added within window
+// before it, oldest first. Events inserted before lastID may have been committed after it.
+func (repository *EventRepository) FindSince(ctx context.Context, lastID uint, window time.Duration, types []string, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "outbox_events")).
+		Where("(outbox_events.id > ? OR outbox_events.created_at >= "+
+			"(SELECT previous.created_at - make_interval(secs => ?) FROM outbox_events AS previous WHERE previous.id = ?))",
+			lastID, window.Seconds(), lastID).
+		Where("outbox_events.id <> ? AND outbox_events.type IN ?", lastID, types).
+		Order("outbox_events.id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// LastID returns the id of the last event of the outbox, 0 when it is empty
+func (repository *EventRepository) LastID(ctx context.Context) (uint, error) {
+	var lastID uint
+	err := repository.db.WithContext(ctx).Model(&models.OutboxEvent{}).Scopes(scopeTenant(ctx, "outbox_events")).
+		Select("COALESCE(MAX(id), 0)").
+		Scan(&lastID).Error
+	return lastID, err
+}
+
+// Prune deletes the events dispatched to the webhooks and added before before, and returns their number
+func (repository *EventRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "outbox_events")).
+		Where("dispatched_at IS NOT NULL AND created_at < ?", before).
+		Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// EventListener waits for the notifications of OutboxChannel on a dedicated connection, opened again when it is lost
+type EventListener struct {
+	dsn  string
+	conn *pgx.Conn
+}
+
+func NewEventListener(dsn string) *EventListener {
+	return &EventListener{dsn: dsn}
+}
+
+// Wait returns when an event is added to the outbox, and as soon as the connection is opened since events may have
+// been added while it was closed. It is not safe for concurrent use.
+func (listener *EventListener) Wait(ctx context.Context) error {
+	if listener.conn == nil {
+		conn, err := pgx.Connect(ctx, listener.dsn)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Exec(ctx, "LISTEN "+OutboxChannel); err != nil {
+			conn.Close(context.Background())
+			return err
+		}
+		listener.conn = conn
+		return nil
+	}
+	if _, err := listener.conn.WaitForNotification(ctx); err != nil {
+		listener.conn.Close(context.Background())
+		listener.conn = nil
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrJobDBOperation = errors.New("job database operation failed")
+	ErrJobNotLocked   = errors.New("job is not locked by the worker anymore")
+)
+
+type IJobRepository interface {
+	Enqueue(job *models.Job) error
+	Claim(types []string, worker string, now time.Time) (*models.Job, error)
+	// Complete, Retry and Bury only update a job still locked by the worker
+	Complete(id uint, worker string, now time.Time) error
+	Retry(id uint, worker string, lastError string, runAt time.Time) error
+	Bury(id uint, worker string, lastError string) error
+	RequeueStale(lockedBefore time.Time) (requeued int64, buried int64, err error)
+}
+
+type JobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+func (repository *JobRepository) Enqueue(job *models.Job) error {
+	if err := repository.db.Create(job).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrJobDBOperation, err)
+	}
+	return nil
+}
+
+// Claim locks the next due job of one of the given types for a worker, or returns nil when none is due.
+// Rows locked by other workers are skipped so that workers never wait for each other.
+func (repository *JobRepository) Claim(types []string, worker string, now time.Time) (*models.Job, error) {
+	var job models.Job
+	err := repository.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ? AND type IN ?", models.JobPending, now, types).
+			Order("priority DESC, run_at, id").
+			Take(&job).Error
+		if err != nil {
+			return err
+		}
+		job.Status, job.Attempts, job.LockedAt, job.LockedBy = models.JobRunning, job.Attempts+1, &now, worker
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":    job.Status,
+			"attempts":  job.Attempts,
+			"locked_at": job.LockedAt,
+			"locked_by": job.LockedBy,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJobDBOperation, err)
+	}
+	return &job, nil
+}
+
+// Complete marks a job run by worker as completed
+func (repository *JobRepository) Complete(id uint, worker string, now time.Time) error {
+	return repository.release(id, worker, map[string]interface{}{
+		"status":       models.JobCompleted,
+		"completed_at": now,
+		"last_error":   "",
+	})
+}
+
+// Retry puts a failed job run by worker back in the queue, to be run again at runAt
+func (repository *JobRepository) Retry(id uint, worker string, lastError string, runAt time.Time) error {
+	return repository.release(id, worker, map[string]interface{}{
+		"status":     models.JobPending,
+		"run_at":     runAt,
+		"last_error": lastError,
+	})
+}
+
+// Bury moves a job run by worker to the dead letters, it is not run anymore
+func (repository *JobRepository) Bury(id uint, worker string, lastError string) error {
+	return repository.release(id, worker, map[string]interface{}{
+		"status":     models.JobDead,
+		"last_error": lastError,
+	})
+}
+
+// release unlocks a job with the given updates, unless its lock expired and it was requeued for another worker:
+// the result of the stale run is then dropped with ErrJobNotLocked
+func (repository *JobRepository) release(id uint, worker string, updates map[string]interface{}) error {
+	updates["locked_at"], updates["locked_by"] = nil, ""
+	result := repository.db.Model(&models.Job{ID: id}).
+		Where("status = ? AND locked_by = ?", models.JobRunning, worker).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %w", ErrJobDBOperation, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: job %d by %s", ErrJobNotLocked, id, worker)
+	}
+	return nil
+}
+
+// RequeueStale puts back in the queue the jobs locked before lockedBefore, left running by a stopped or hanging
+// worker. Their run counts as an attempt, so the jobs that used all their attempts are moved to the dead letters.
+func (repository *JobRepository) RequeueStale(lockedBefore time.Time) (requeued int64, buried int64, err error) {
+	err = repository.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Job{}).
+			Where("status = ? AND locked_at < ? AND attempts >= max_attempts", models.JobRunning, lockedBefore).
+			Updates(map[string]interface{}{
+				"status":     models.JobDead,
+				"locked_at":  nil,
+				"locked_by":  "",
+				"last_error": "worker stopped while running the job, no attempt left",
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		buried = result.RowsAffected
+		result = tx.Model(&models.Job{}).
+			Where("status = ? AND locked_at < ?", models.JobRunning, lockedBefore).
+			Updates(map[string]interface{}{
+				"status":     models.JobPending,
+				"locked_at":  nil,
+				"locked_by":  "",
+				"last_error": "worker stopped while running the job",
+			})
+		requeued = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %w", ErrJobDBOperation, err)
+	}
+	return requeued, buried, nil
+}
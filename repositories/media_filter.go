@@ -0,0 +1,47 @@
+package repositories
+
+import "gorm.io/gorm"
+
+// MediaFilter holds the optional criteria to search medias
+type MediaFilter struct {
+	// Processing status, every status when empty
+	Status      string
+	Tag         string
+	MinDuration *float64
+	MaxDuration *float64
+	MinWidth    *int
+	MinHeight   *int
+	VideoCodec  string
+	HasAudio    *bool
+}
+
+func (filter MediaFilter) apply(query *gorm.DB) *gorm.DB {
+	if filter.Status != "" {
+		query = query.Where("media.status = ?", filter.Status)
+	}
+	if filter.Tag != "" {
+		query = query.Where("media.id IN (?)", query.Session(&gorm.Session{NewDB: true}).
+			Table("media_tags").Select("media_id").
+			Joins("JOIN tags ON tags.id = media_tags.tag_id AND tags.deleted_at IS NULL").
+			Where("tag_id = ?", filter.Tag))
+	}
+	if filter.MinDuration != nil {
+		query = query.Where("media.duration >= ?", *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		query = query.Where("media.duration < ?", *filter.MaxDuration)
+	}
+	if filter.MinWidth != nil {
+		query = query.Where("media.width >= ?", *filter.MinWidth)
+	}
+	if filter.MinHeight != nil {
+		query = query.Where("media.height >= ?", *filter.MinHeight)
+	}
+	if filter.VideoCodec != "" {
+		query = query.Where("media.video_codec = ?", filter.VideoCodec)
+	}
+	if filter.HasAudio != nil {
+		query = query.Where("media.has_audio = ?", *filter.HasAudio)
+	}
+	return query
+}
@@ -1,24 +1,50 @@
 package repositories
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/mich31/scoreplay-media-api/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ErrMediaCreation    = errors.New("failed to create media record")
 	ErrMediaExists      = errors.New("a media with the same name already exists")
 	ErrMediaDBOperation = errors.New("database operation failed")
-	ErrMediaRetrieval   = errors.New("failed to fetch media(s) associated with a tag")
+	ErrMediaRetrieval   = errors.New("failed to fetch media(s)")
+	ErrMediaNotFound    = errors.New("media not found")
 )
 
+var imageContentTypes = []string{"image/jpeg", "image/jpg", "image/png", "image/gif"}
+
 type IMediaRepository interface {
-	Create(media *models.Media, tagIDs []uint) (uint, error)
-	FindByTag(tag string) ([]models.MediaWithTagNames, error)
+	Create(ctx context.Context, media *models.Media, tagIDs []uint) (uint, error)
+	FindByID(ctx context.Context, id string) (*models.Media, error)
+	Find(ctx context.Context, filter MediaFilter) ([]models.MediaWithTagNames, error)
+	FindWithStaleRenditions(ctx context.Context, version string) ([]models.Media, error)
+	UpdateRenditions(ctx context.Context, id uint, renditions models.RenditionMap, version string) error
+	UpdateFocalPoint(ctx context.Context, id uint, focalPoint models.FocalPoint) error
+	UpdatePerceptualHash(ctx context.Context, id uint, hash int64) error
+	UpdateVideoMetadata(ctx context.Context, id uint, metadata models.VideoMetadata) error
+	FindWithoutPerceptualHash(ctx context.Context) ([]models.Media, error)
+	FindSimilar(ctx context.Context, id uint, hash int64, distance int) ([]models.SimilarMedia, error)
+	FindHashesByTag(ctx context.Context, tag string) ([]models.Media, error)
+	IncrementDownloadCount(ctx context.Context, id uint) error
+	FindAfter(ctx context.Context, id uint, limit int) ([]models.Media, error)
+	UpdateObjectKey(ctx context.Context, id uint, objectKey string, renditions models.RenditionMap) error
+	ClaimReplicationDue(ctx context.Context, maxAttempts int, now time.Time, lockedUntil time.Time, limit int) ([]models.Media, error)
+	UpdateReplication(ctx context.Context, id uint, replication models.Replication) error
+	FindNotEncryptedWith(ctx context.Context, keyID string, afterID uint, limit int) ([]models.Media, error)
+	UpdateEncryptionKeyID(ctx context.Context, id uint, keyID string) error
+	UpdateProcessing(ctx context.Context, id uint, processing models.Processing) error
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, id string) (*models.Media, error)
+	FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Media, error)
+	Purge(ctx context.Context, id uint) error
 }
 
 type MediaRepository struct {
@@ -29,72 +55,420 @@ func NewMediaRepository(db *gorm.DB) *MediaRepository {
 	return &MediaRepository{db: db}
 }
 
-func (repository *MediaRepository) Create(media *models.Media, tagIDs []uint) (uint, error) {
-	result := repository.db.Where(models.Media{Name: media.Name}).FirstOrCreate(media)
-	if result.Error != nil {
-		return 0, fmt.Errorf("%w: %v", ErrMediaCreation, result.Error)
-	}
-	//If the media already exists
-	if result.RowsAffected == 0 {
-		return 0, fmt.Errorf("%w: media with name '%s'", ErrMediaExists, media.Name)
+// Create stores a media with its tags and records a media.created event in the same transaction
+func (repository *MediaRepository) Create(ctx context.Context, media *models.Media, tagIDs []uint) (uint, error) {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrMediaCreation, err)
 	}
+	media.OrganizationID = organizationID
+	err = repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(models.Media{Name: media.Name, OrganizationID: organizationID}).FirstOrCreate(media)
+		if result.Error != nil {
+			return fmt.Errorf("%w: %v", ErrMediaCreation, result.Error)
+		}
+		//If the media already exists
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: media with name '%s'", ErrMediaExists, media.Name)
+		}
 
-	err := repository.db.Transaction(func(tx *gorm.DB) error {
-		// Verify all tags exist
-		var tags []*models.Tag
-		if err := tx.Model(&models.Tag{}).Where("id IN ?", tagIDs).Find(&tags).Error; err != nil {
-			return fmt.Errorf("unable to check tags: %w", err)
+		// Verify all tags exist in the organization
+		var tags []models.Tag
+		if err := tx.Model(&models.Tag{}).Scopes(scopeTenant(ctx, "tags")).Where("id IN ?", tagIDs).Find(&tags).Error; err != nil {
+			return fmt.Errorf("%w: unable to check tags: %w", ErrMediaDBOperation, err)
 		}
 		if len(tags) != len(tagIDs) {
-			return fmt.Errorf("some tags do not exist")
+			return fmt.Errorf("%w: some tags do not exist", ErrMediaDBOperation)
 		}
 
 		for _, tag := range tags {
 			mediaTag := models.MediaTag{
-				MediaID: media.ID,
-				TagID:   tag.ID,
+				MediaID:        media.ID,
+				TagID:          tag.ID,
+				OrganizationID: organizationID,
 			}
 
 			if err := tx.Create(&mediaTag).Error; err != nil {
-				return fmt.Errorf("an error occured creating media-tag association: %w", err)
+				return fmt.Errorf("%w: an error occured creating media-tag association: %w", ErrMediaDBOperation, err)
 			}
 		}
 
+		created := *media
+		created.Tags = tags
+		if err := addOutboxEvent(tx, organizationID, models.WebhookMediaCreated, &created); err != nil {
+			return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+		}
 		return nil
 	})
 	if err != nil {
-		return 0, fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+		return 0, err
+	}
+	return media.ID, nil
+}
+
+// scoped returns a query restricted to the medias of the organization of ctx
+func (repository *MediaRepository) scoped(ctx context.Context) *gorm.DB {
+	return repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "media"))
+}
+
+func (repository *MediaRepository) FindByID(ctx context.Context, id string) (*models.Media, error) {
+	media := &models.Media{}
+	if err := repository.scoped(ctx).Where("id = ?", id).First(media).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, id)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return media, nil
+}
+
+// Find returns the medias matching a filter with their tag names
+func (repository *MediaRepository) Find(ctx context.Context, filter MediaFilter) ([]models.MediaWithTagNames, error) {
+	query := repository.scoped(ctx).Model(&models.Media{}).
+		Select("media.id, media.name, media.description, media.object_key, media.file_name, media.renditions, " +
+			"media.status, media.failure_reason, media.uploaded_by, " +
+			"media.duration, media.width, media.height, media.frame_rate, media.video_codec, media.audio_codec, media.has_audio, media.recorded_at, " +
+			"array_remove(array_agg(tags.name), NULL) as tag_names").
+		Joins("LEFT JOIN media_tags ON media_tags.media_id = media.id").
+		Joins("LEFT JOIN tags ON tags.id = media_tags.tag_id AND tags.deleted_at IS NULL")
+	query = filter.apply(query)
+
+	medias := []models.MediaWithTagNames{}
+	if err := query.Group("media.id").Order("media.id").Find(&medias).Error; err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) FindWithStaleRenditions(ctx context.Context, version string) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).
+		Where("content_type IN ?", imageContentTypes).
+		Where("renditions_version IS DISTINCT FROM ?", version).
+		Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) UpdateRenditions(ctx context.Context, id uint, renditions models.RenditionMap, version string) error {
+	err := repository.scoped(ctx).Model(&models.Media{ID: id}).
+		Updates(map[string]interface{}{"renditions": renditions, "renditions_version": version}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *MediaRepository) UpdateFocalPoint(ctx context.Context, id uint, focalPoint models.FocalPoint) error {
+	err := repository.updateWithEvent(ctx, id, map[string]interface{}{"focal_x": focalPoint.X, "focal_y": focalPoint.Y, "crops": focalPoint.Crops})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
 	}
-	return media.ID, err
+	return nil
 }
 
-func (repository *MediaRepository) FindByTag(tag string) ([]models.MediaWithTagNames, error) {
-	var mediaIDs []uint
-	err := repository.db.Model(&models.MediaTag{}).
-		Select("media_id").
-		Where("tag_id = ?", tag).
-		Find(&mediaIDs).Error
+func (repository *MediaRepository) UpdatePerceptualHash(ctx context.Context, id uint, hash int64) error {
+	err := repository.scoped(ctx).Model(&models.Media{ID: id}).Update("perceptual_hash", hash).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *MediaRepository) FindWithoutPerceptualHash(ctx context.Context) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).
+		Where("content_type IN ?", imageContentTypes).
+		Where("perceptual_hash IS NULL").
+		Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return medias, nil
+}
 
+// FindSimilar returns the medias whose perceptual hash differs from hash by at most distance bits, closest first
+func (repository *MediaRepository) FindSimilar(ctx context.Context, id uint, hash int64, distance int) ([]models.SimilarMedia, error) {
+	medias := []models.SimilarMedia{}
+	err := repository.scoped(ctx).Model(&models.Media{}).
+		Select("media.id, media.name, media.description, media.object_key, media.file_name, media.renditions, "+
+			"array_remove(array_agg(tags.name), NULL) as tag_names, "+
+			"bit_count((media.perceptual_hash # ?)::bit(64)) as distance", hash).
+		Joins("LEFT JOIN media_tags ON media_tags.media_id = media.id").
+		Joins("LEFT JOIN tags ON tags.id = media_tags.tag_id AND tags.deleted_at IS NULL").
+		Where("media.id <> ?", id).
+		Where("media.perceptual_hash IS NOT NULL").
+		Where("bit_count((media.perceptual_hash # ?)::bit(64)) <= ?", hash, distance).
+		Group("media.id").
+		Order("distance, media.id").
+		Find(&medias).Error
 	if err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
 	}
+	return medias, nil
+}
 
-	medias := []models.MediaWithTagNames{}
-	for _, mediaId := range mediaIDs {
-		media := models.MediaWithTagNames{}
-		err = repository.db.Model(&models.Media{}).
-			Select("DISTINCT media.id, media.name, media.description, media.file_url, array_agg(tags.name) as tag_names").
-			Joins("JOIN media_tags ON media_tags.media_id = media.id").
-			Joins("JOIN tags ON tags.id = media_tags.tag_id").
-			Where("media_tags.media_id = ?", mediaId).
-			Group("media.id").
-			First(&media).Error
+func (repository *MediaRepository) FindHashesByTag(ctx context.Context, tag string) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).Model(&models.Media{}).
+		Select("media.id, media.name, media.description, media.object_key, media.file_name, media.content_type, media.renditions, media.perceptual_hash, media.created_at, media.updated_at").
+		Joins("JOIN media_tags ON media_tags.media_id = media.id").
+		Joins("JOIN tags ON tags.id = media_tags.tag_id AND tags.deleted_at IS NULL").
+		Where("media_tags.tag_id = ?", tag).
+		Where("media.perceptual_hash IS NOT NULL").
+		Order("media.created_at, media.id").
+		Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) UpdateVideoMetadata(ctx context.Context, id uint, metadata models.VideoMetadata) error {
+	err := repository.scoped(ctx).Model(&models.Media{ID: id}).Updates(map[string]interface{}{
+		"duration":    metadata.Duration,
+		"width":       metadata.Width,
+		"height":      metadata.Height,
+		"frame_rate":  metadata.FrameRate,
+		"video_codec": metadata.VideoCodec,
+		"audio_codec": metadata.AudioCodec,
+		"has_audio":   metadata.HasAudio,
+		"recorded_at": metadata.RecordedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *MediaRepository) IncrementDownloadCount(ctx context.Context, id uint) error {
+	err := repository.scoped(ctx).Model(&models.Media{ID: id}).
+		UpdateColumn("download_count", gorm.Expr("download_count + 1")).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+// FindAfter returns at most limit medias with an id greater than id and their organization, to go through every
+// media in batches. Medias in the trash are included, their objects are kept until they are purged.
+func (repository *MediaRepository) FindAfter(ctx context.Context, id uint, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).Unscoped().Preload("Organization").Where("id > ?", id).Order("id").Limit(limit).Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) UpdateObjectKey(ctx context.Context, id uint, objectKey string, renditions models.RenditionMap) error {
+	err := repository.scoped(ctx).Unscoped().Model(&models.Media{ID: id}).
+		Updates(map[string]interface{}{"object_key": objectKey, "renditions": renditions}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+// ClaimReplicationDue returns at most limit medias whose objects must be copied to the secondary storage at now:
+// new medias, medias uploaded before replication was enabled and failed copies whose retry delay is over. Medias in
+// the trash are included. Medias locked by another instance are skipped, and the next replication of the returned
+// ones is moved to lockedUntil so that other instances do not copy them meanwhile, and so that they are copied again
+// if the instance stops before recording the copy.
+func (repository *MediaRepository) ClaimReplicationDue(ctx context.Context, maxAttempts int, now time.Time, lockedUntil time.Time, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Scopes(scopeTenant(ctx, "media")).Unscoped().
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(next_replication_at IS NULL OR next_replication_at <= ?) AND "+
+				"(replication_status IS NULL OR replication_status IN ? OR (replication_status = ? AND replication_attempts < ?))",
+				now, []string{"", models.ReplicationPending}, models.ReplicationFailed, maxAttempts).
+			Order("id").
+			Limit(limit).
+			Find(&medias).Error
+		if err != nil || len(medias) == 0 {
+			return err
+		}
+		ids := make([]uint, 0, len(medias))
+		for _, media := range medias {
+			ids = append(ids, media.ID)
+		}
+		return tx.Unscoped().Model(&models.Media{}).Where("id IN ?", ids).UpdateColumn("next_replication_at", lockedUntil).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) UpdateReplication(ctx context.Context, id uint, replication models.Replication) error {
+	err := repository.scoped(ctx).Unscoped().Model(&models.Media{ID: id}).Updates(map[string]interface{}{
+		"replication_status":   replication.ReplicationStatus,
+		"replication_attempts": replication.ReplicationAttempts,
+		"replication_error":    replication.ReplicationError,
+		"next_replication_at":  replication.NextReplicationAt,
+		"replicated_at":        replication.ReplicatedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+// FindNotEncryptedWith returns at most limit medias with an id greater than afterID whose objects are not encrypted
+// with the key keyID, medias in the trash included
+func (repository *MediaRepository) FindNotEncryptedWith(ctx context.Context, keyID string, afterID uint, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).Unscoped().
+		Where("id > ? AND (encryption_key_id IS NULL OR encryption_key_id <> ?)", afterID, keyID).
+		Order("id").
+		Limit(limit).
+		Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
+	}
+	return medias, nil
+}
+
+func (repository *MediaRepository) UpdateEncryptionKeyID(ctx context.Context, id uint, keyID string) error {
+	err := repository.scoped(ctx).Unscoped().Model(&models.Media{ID: id}).UpdateColumn("encryption_key_id", keyID).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *MediaRepository) UpdateProcessing(ctx context.Context, id uint, processing models.Processing) error {
+	err := repository.updateWithEvent(ctx, id, map[string]interface{}{
+		"status":                processing.Status,
+		"failure_reason":        processing.FailureReason,
+		"uploaded_at":           processing.UploadedAt,
+		"processing_started_at": processing.ProcessingStartedAt,
+		"ready_at":              processing.ReadyAt,
+		"failed_at":             processing.FailedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+// updateWithEvent updates the columns of a media and records a media.updated event with the updated media and its
+// tags in the same transaction. Medias of other organizations are left untouched, without event.
+func (repository *MediaRepository) updateWithEvent(ctx context.Context, id uint, updates map[string]interface{}) error {
+	return repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		media := models.Media{ID: id}
+		result := tx.Scopes(scopeTenant(ctx, "media")).Model(&media).Clauses(clause.Returning{}).Updates(updates)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		// the tags let the receivers filter the events
+		if err := tx.Model(&media).Association("Tags").Find(&media.Tags); err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, media.OrganizationID, models.WebhookMediaUpdated, &media)
+	})
+}
+
+// Delete moves a media to the trash and records a media.deleted event. Its associations to tags are kept to be
+// restored with it.
+func (repository *MediaRepository) Delete(ctx context.Context, id uint) error {
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var media models.Media
+		err := tx.Scopes(scopeTenant(ctx, "media")).Where("id = ?", id).Take(&media).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err := tx.Scopes(scopeTenant(ctx, "media")).Delete(&models.Media{ID: id}).Error; err != nil {
+			return err
+		}
+		// deleting a missing media does nothing
+		if media.ID == 0 {
+			return nil
+		}
+		return addOutboxEvent(tx, media.OrganizationID, models.WebhookMediaDeleted, &media)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
+}
+
+// Restore moves a media out of the trash, with its tags, and records a media.restored event. It fails with
+// ErrMediaExists when another media took its name meanwhile.
+func (repository *MediaRepository) Restore(ctx context.Context, id string) (*models.Media, error) {
+	media := &models.Media{}
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Scopes(scopeTenant(ctx, "media")).Unscoped().
+			Where("id = ? AND deleted_at IS NOT NULL", id).First(media).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrMediaNotFound, id)
+		}
 		if err != nil {
-			log.Printf("unable to fetch media %d", mediaId)
-			continue
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Media{}).Scopes(scopeTenant(ctx, "media")).Where("name = ?", media.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: media with name '%s'", ErrMediaExists, media.Name)
+		}
+		media.DeletedAt = gorm.DeletedAt{}
+		if err := tx.Scopes(scopeTenant(ctx, "media")).Unscoped().Model(media).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, media.OrganizationID, models.WebhookMediaRestored, media)
+	})
+	if err != nil {
+		if errors.Is(err, ErrMediaNotFound) || errors.Is(err, ErrMediaExists) {
+			return nil, err
 		}
-		medias = append(medias, media)
+		return nil, fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return media, nil
+}
+
+// FindDeleted returns at most limit medias moved to the trash before before, with every tag they had, first
+// deleted first
+func (repository *MediaRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	err := repository.scoped(ctx).Unscoped().
+		Preload("Tags", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at, id").
+		Limit(limit).
+		Find(&medias).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMediaRetrieval, err)
 	}
+	return medias, nil
+}
 
-	return medias, err
+// Purge deletes a media and its associations to tags permanently. A media.deleted event is recorded unless the
+// media was in the trash, its deletion being already sent.
+func (repository *MediaRepository) Purge(ctx context.Context, id uint) error {
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var media models.Media
+		err := tx.Scopes(scopeTenant(ctx, "media")).Unscoped().Where("id = ?", id).Take(&media).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err := tx.Scopes(scopeTenant(ctx, "media_tags")).Where("media_id = ?", id).Delete(&models.MediaTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Scopes(scopeTenant(ctx, "media")).Unscoped().Delete(&models.Media{ID: id}).Error; err != nil {
+			return err
+		}
+		if media.ID == 0 || media.DeletedAt.Valid {
+			return nil
+		}
+		return addOutboxEvent(tx, media.OrganizationID, models.WebhookMediaDeleted, &media)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMediaDBOperation, err)
+	}
+	return nil
 }
@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+// StatusCount is a number of rows by type and status
+type StatusCount struct {
+	Type   string
+	Status string
+	Count  int64
+}
+
+type IMetricsRepository interface {
+	// CountMedias returns the number of medias which are not in the trash, by processing status
+	CountMedias(ctx context.Context) ([]StatusCount, error)
+	// CountTags returns the number of tags which are not in the trash
+	CountTags(ctx context.Context) (int64, error)
+	// CountJobs returns the number of pending and running jobs, by type and status
+	CountJobs(ctx context.Context) ([]StatusCount, error)
+}
+
+// MetricsRepository counts the rows exposed as gauges on /metrics
+type MetricsRepository struct {
+	db *gorm.DB
+}
+
+func NewMetricsRepository(db *gorm.DB) *MetricsRepository {
+	return &MetricsRepository{db: db}
+}
+
+func (repository *MetricsRepository) CountMedias(ctx context.Context) ([]StatusCount, error) {
+	var counts []StatusCount
+	err := repository.db.WithContext(ctx).Model(&models.Media{}).Scopes(scopeTenant(ctx, "media")).
+		Select("status, COUNT(*) AS count").
+		Group("status").
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (repository *MetricsRepository) CountTags(ctx context.Context) (int64, error) {
+	var count int64
+	err := repository.db.WithContext(ctx).Model(&models.Tag{}).Scopes(scopeTenant(ctx, "tags")).Count(&count).Error
+	return count, err
+}
+
+// CountJobs counts the jobs of every organization, the queue is shared by the organizations
+func (repository *MetricsRepository) CountJobs(ctx context.Context) ([]StatusCount, error) {
+	var counts []StatusCount
+	err := repository.db.WithContext(ctx).Model(&models.Job{}).
+		Select("type, status, COUNT(*) AS count").
+		Where("status IN ?", []string{models.JobPending, models.JobRunning}).
+		Group("type, status").
+		Scan(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOrganizationNotFound    = errors.New("organization not found")
+	ErrOrganizationExists      = errors.New("an organization with the same slug already exists")
+	ErrOrganizationDBOperation = errors.New("organization database operation failed")
+)
+
+// IOrganizationRepository stores the organizations. They are not scoped by tenant, they are the tenants.
+type IOrganizationRepository interface {
+	Create(organization *models.Organization) error
+	FindBySlug(slug string) (*models.Organization, error)
+	Find() ([]models.Organization, error)
+	// SetQuotas updates the storage and media quotas of an organization
+	SetQuotas(slug string, storageQuota int64, mediaQuota int64) (*models.Organization, error)
+}
+
+type OrganizationRepository struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) *OrganizationRepository {
+	return &OrganizationRepository{db: db}
+}
+
+func (repository *OrganizationRepository) Create(organization *models.Organization) error {
+	result := repository.db.Where(models.Organization{Slug: organization.Slug}).FirstOrCreate(organization)
+	if result.Error != nil {
+		return fmt.Errorf("%w: %w", ErrOrganizationDBOperation, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrOrganizationExists, organization.Slug)
+	}
+	return nil
+}
+
+func (repository *OrganizationRepository) FindBySlug(slug string) (*models.Organization, error) {
+	var organization models.Organization
+	err := repository.db.Where("slug = ?", slug).Take(&organization).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrOrganizationNotFound, slug)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOrganizationDBOperation, err)
+	}
+	return &organization, nil
+}
+
+func (repository *OrganizationRepository) Find() ([]models.Organization, error) {
+	var organizations []models.Organization
+	if err := repository.db.Order("id").Find(&organizations).Error; err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOrganizationDBOperation, err)
+	}
+	return organizations, nil
+}
+
+func (repository *OrganizationRepository) SetQuotas(slug string, storageQuota int64, mediaQuota int64) (*models.Organization, error) {
+	result := repository.db.Model(&models.Organization{}).Where("slug = ?", slug).
+		Updates(map[string]interface{}{"storage_quota": storageQuota, "media_quota": mediaQuota})
+	if result.Error != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOrganizationDBOperation, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrOrganizationNotFound, slug)
+	}
+	return repository.FindBySlug(slug)
+}
@@ -0,0 +1,22 @@
+package repositories
+
+import (
+	"encoding/json"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+// addOutboxEvent records an event of an organization in the transaction tx of the change it describes, it is
+// dispatched to the webhooks once the transaction is committed
+func addOutboxEvent(tx *gorm.DB, organizationID uint, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&models.OutboxEvent{
+		OrganizationID: organizationID,
+		Type:           eventType,
+		Payload:        payload,
+	}).Error
+}
@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+)
+
+var ErrRateLimitDBOperation = errors.New("rate limit database operation failed")
+
+// Tokens of a bucket refilled since its last use, up to its burst
+const refilledTokens = `LEAST(CAST(@burst AS double precision), rate_limit_buckets.tokens +
+	GREATEST(0, EXTRACT(EPOCH FROM CAST(@now AS timestamptz) - rate_limit_buckets.updated_at)) * CAST(@rate AS double precision))`
+
+// The bucket is created full, or refilled, and a token is taken when there is one, in a single statement
+// so that the instances sharing the bucket never take the same token
+var takeToken = strings.ReplaceAll(`INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at)
+	VALUES (@key, CAST(@burst AS double precision) - 1, true, @now)
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE WHEN {refilled} >= 1 THEN {refilled} - 1 ELSE {refilled} END,
+		allowed = {refilled} >= 1,
+		updated_at = GREATEST(rate_limit_buckets.updated_at, @now)
+	RETURNING tokens, allowed`, "{refilled}", refilledTokens)
+
+// RateLimitRepository stores the token buckets of the rate limits in the database, to share them between the
+// instances of the API. Buckets are not scoped by tenant, their keys identify credentials and IP addresses.
+type RateLimitRepository struct {
+	db *gorm.DB
+}
+
+func NewRateLimitRepository(db *gorm.DB) *RateLimitRepository {
+	return &RateLimitRepository{db: db}
+}
+
+func (repository *RateLimitRepository) Take(ctx context.Context, key string, rate float64, burst float64, now time.Time) (float64, bool, error) {
+	var bucket models.RateLimitBucket
+	err := repository.db.WithContext(ctx).Raw(takeToken,
+		sql.Named("key", key), sql.Named("rate", rate), sql.Named("burst", burst), sql.Named("now", now)).
+		Scan(&bucket).Error
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %w", ErrRateLimitDBOperation, err)
+	}
+	return bucket.Tokens, bucket.Allowed, nil
+}
+
+func (repository *RateLimitRepository) DeleteIdle(ctx context.Context, before time.Time) error {
+	if err := repository.db.WithContext(ctx).Where("updated_at < ?", before).Delete(&models.RateLimitBucket{}).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrRateLimitDBOperation, err)
+	}
+	return nil
+}
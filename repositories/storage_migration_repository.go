@@ -0,0 +1,67 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrStorageMigrationDBOperation = errors.New("storage migration database operation failed")
+
+type IStorageMigrationRepository interface {
+	FindCopied(migration string, mediaIDs []uint) ([]uint, error)
+	Complete(progress *models.StorageMigrationProgress, objectKey string, renditions models.RenditionMap) error
+	Fail(progress *models.StorageMigrationProgress) error
+}
+
+type StorageMigrationRepository struct {
+	db *gorm.DB
+}
+
+func NewStorageMigrationRepository(db *gorm.DB) *StorageMigrationRepository {
+	return &StorageMigrationRepository{db: db}
+}
+
+// FindCopied returns the ids of the medias already copied by a migration among mediaIDs
+func (repository *StorageMigrationRepository) FindCopied(migration string, mediaIDs []uint) ([]uint, error) {
+	var ids []uint
+	err := repository.db.Model(&models.StorageMigrationProgress{}).
+		Where("migration = ? AND status = ? AND media_id IN ?", migration, models.StorageMigrationCopied, mediaIDs).
+		Pluck("media_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStorageMigrationDBOperation, err)
+	}
+	return ids, nil
+}
+
+// Complete points a media to its copied objects and records its progress in a single transaction.
+// Trashed medias are migrated too, they can still be restored.
+func (repository *StorageMigrationRepository) Complete(progress *models.StorageMigrationProgress, objectKey string, renditions models.RenditionMap) error {
+	progress.Status, progress.Error = models.StorageMigrationCopied, ""
+	return repository.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.Media{ID: progress.MediaID}).
+			Updates(map[string]interface{}{"object_key": objectKey, "renditions": renditions})
+		if result.Error != nil {
+			return fmt.Errorf("%w: %w", ErrStorageMigrationDBOperation, result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %d", ErrMediaNotFound, progress.MediaID)
+		}
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(progress).Error; err != nil {
+			return fmt.Errorf("%w: %w", ErrStorageMigrationDBOperation, err)
+		}
+		return nil
+	})
+}
+
+// Fail records the failure of the copy of a media, it is copied again when the migration is resumed
+func (repository *StorageMigrationRepository) Fail(progress *models.StorageMigrationProgress) error {
+	progress.Status = models.StorageMigrationFailed
+	if err := repository.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(progress).Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrStorageMigrationDBOperation, err)
+	}
+	return nil
+}
@@ -1,15 +1,29 @@
 package repositories
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/mich31/scoreplay-media-api/models"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrTagNotFound = errors.New("tag not found")
+	ErrTagExists   = errors.New("a tag with the same name already exists")
+)
+
 type ITagRepository interface {
-	Create(tag *models.Tag) (uint, error)
-	Delete(id string) error
-	Find() ([]*models.Tag, error)
-	FindByName(name string) ([]*models.Tag, error)
+	Create(ctx context.Context, tag *models.Tag) (uint, bool, error)
+	Delete(ctx context.Context, id string) error
+	Find(ctx context.Context) ([]*models.Tag, error)
+	FindByID(ctx context.Context, id string) (*models.Tag, error)
+	FindByName(ctx context.Context, name string) ([]*models.Tag, error)
+	Restore(ctx context.Context, id string) (*models.Tag, error)
+	FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Tag, error)
+	Purge(ctx context.Context, id uint) error
 }
 
 type TagRepository struct {
@@ -20,30 +34,129 @@ func NewTagRepository(db *gorm.DB) *TagRepository {
 	return &TagRepository{db: db}
 }
 
-func (repository *TagRepository) Create(tag *models.Tag) (uint, error) {
-	result := repository.db.Where(models.Tag{Name: tag.Name}).FirstOrCreate(tag)
-	return tag.ID, result.Error
+// scoped returns a query restricted to the tags of the organization of ctx
+func (repository *TagRepository) scoped(ctx context.Context) *gorm.DB {
+	return repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "tags"))
+}
+
+// Create loads the tag of the organization named tag.Name, created when there is none with a tag.created event,
+// and reports whether it was created
+func (repository *TagRepository) Create(ctx context.Context, tag *models.Tag) (uint, bool, error) {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	tag.OrganizationID = organizationID
+	created := false
+	err = repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where(models.Tag{Name: tag.Name, OrganizationID: organizationID}).FirstOrCreate(tag)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		created = true
+		return addOutboxEvent(tx, organizationID, models.WebhookTagCreated, tag)
+	})
+	return tag.ID, created, err
 }
 
-func (repository *TagRepository) Find() ([]*models.Tag, error) {
+func (repository *TagRepository) Find(ctx context.Context) ([]*models.Tag, error) {
 	var tags []*models.Tag
-	if err := repository.db.Find(&tags).Error; err != nil {
+	if err := repository.scoped(ctx).Find(&tags).Error; err != nil {
 		return nil, err
 	}
 	return tags, nil
 }
 
-func (repository *TagRepository) FindByName(name string) ([]*models.Tag, error) {
+func (repository *TagRepository) FindByID(ctx context.Context, id string) (*models.Tag, error) {
+	var tag models.Tag
+	if err := repository.scoped(ctx).Where("id = ?", id).First(&tag).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrTagNotFound, id)
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (repository *TagRepository) FindByName(ctx context.Context, name string) ([]*models.Tag, error) {
 	var tags []*models.Tag
-	if err := repository.db.Where("name ILIKE ?", "%"+name+"%").Find(&tags).Error; err != nil {
+	if err := repository.scoped(ctx).Where("name ILIKE ?", "%"+name+"%").Find(&tags).Error; err != nil {
 		return nil, err
 	}
 	return tags, nil
 }
 
-func (repository *TagRepository) Delete(id string) error {
-	if err := repository.db.Delete(&models.Tag{}, id).Error; err != nil {
-		return err
+// Delete moves a tag to the trash and records a tag.deleted event. Its associations to medias are kept to be
+// restored with it.
+func (repository *TagRepository) Delete(ctx context.Context, id string) error {
+	return repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tag models.Tag
+		err := tx.Scopes(scopeTenant(ctx, "tags")).Where("id = ?", id).Take(&tag).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err := tx.Scopes(scopeTenant(ctx, "tags")).Where("id = ?", id).Delete(&models.Tag{}).Error; err != nil {
+			return err
+		}
+		// deleting a missing tag does nothing
+		if tag.ID == 0 {
+			return nil
+		}
+		return addOutboxEvent(tx, tag.OrganizationID, models.WebhookTagDeleted, &tag)
+	})
+}
+
+// Restore moves a tag out of the trash, with its associations to medias, and records a tag.restored event. It fails
+// with ErrTagExists when another tag took its name meanwhile.
+func (repository *TagRepository) Restore(ctx context.Context, id string) (*models.Tag, error) {
+	var tag models.Tag
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Scopes(scopeTenant(ctx, "tags")).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&tag).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %s", ErrTagNotFound, id)
+		}
+		if err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Tag{}).Scopes(scopeTenant(ctx, "tags")).Where("name = ?", tag.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%w: tag with name '%s'", ErrTagExists, tag.Name)
+		}
+		tag.DeletedAt = gorm.DeletedAt{}
+		if err := tx.Scopes(scopeTenant(ctx, "tags")).Unscoped().Model(&tag).UpdateColumn("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return addOutboxEvent(tx, tag.OrganizationID, models.WebhookTagRestored, &tag)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// FindDeleted returns at most limit tags moved to the trash before before, first deleted first
+func (repository *TagRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Tag, error) {
+	var tags []models.Tag
+	err := repository.scoped(ctx).Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Order("deleted_at, id").
+		Limit(limit).
+		Find(&tags).Error
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return tags, nil
+}
+
+// Purge deletes a tag and its associations to medias permanently
+func (repository *TagRepository) Purge(ctx context.Context, id uint) error {
+	return repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(scopeTenant(ctx, "media_tags")).Where("tag_id = ?", id).Delete(&models.MediaTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Scopes(scopeTenant(ctx, "tags")).Unscoped().Delete(&models.Tag{ID: id}).Error
+	})
 }
@@ -0,0 +1,65 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrMissingTenant is returned by the queries run without an organization in their context
+var ErrMissingTenant = errors.New("missing tenant")
+
+type tenantContextKey struct{}
+
+// tenant is the organization whose data the queries of a context can read and write.
+// Maintenance work across organizations uses every organization.
+type tenant struct {
+	organizationID uint
+	all            bool
+}
+
+// WithTenant returns a context restricting the queries of the repositories to the data of an organization
+func WithTenant(ctx context.Context, organizationID uint) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant{organizationID: organizationID})
+}
+
+// WithAllTenants returns a context whose queries read and write the data of every organization. It is reserved
+// to maintenance work (renditions, replication, key rotation...) and to the renders authorized by their signature.
+func WithAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant{all: true})
+}
+
+// TenantFromContext returns the organization of a context, false when the context has none or every organization
+func TenantFromContext(ctx context.Context) (uint, bool) {
+	tenant, found := ctx.Value(tenantContextKey{}).(tenant)
+	if !found || tenant.all {
+		return 0, false
+	}
+	return tenant.organizationID, true
+}
+
+// scopeTenant filters the rows of a query by the organization of ctx, on the organization_id column of table.
+// Queries fail with ErrMissingTenant when ctx has no organization, so that no query reads every organization by mistake.
+func scopeTenant(ctx context.Context, table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		tenant, found := ctx.Value(tenantContextKey{}).(tenant)
+		if !found {
+			db.AddError(ErrMissingTenant)
+			return db
+		}
+		if tenant.all {
+			return db
+		}
+		return db.Where(table+".organization_id = ?", tenant.organizationID)
+	}
+}
+
+// tenantID returns the organization owning the rows created with ctx
+func tenantID(ctx context.Context) (uint, error) {
+	organizationID, found := TenantFromContext(ctx)
+	if !found {
+		return 0, ErrMissingTenant
+	}
+	return organizationID, nil
+}
@@ -0,0 +1,373 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// statement is a query sent to the database
+type statement struct {
+	query string
+	args  []driver.Value
+}
+
+// recorder is a database driver recording the queries it receives. Queries return no rows.
+type recorder struct {
+	mu         sync.Mutex
+	statements []statement
+}
+
+func (recorder *recorder) Connect(context.Context) (driver.Conn, error) {
+	return recorderConn{recorder}, nil
+}
+
+func (recorder *recorder) Driver() driver.Driver {
+	return nil
+}
+
+func (recorder *recorder) record(query string, args []driver.NamedValue) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	recorder.statements = append(recorder.statements, statement{query: query, args: values})
+}
+
+// reset returns the queries recorded so far and forgets them
+func (recorder *recorder) reset() []statement {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	statements := recorder.statements
+	recorder.statements = nil
+	return statements
+}
+
+type recorderConn struct {
+	recorder *recorder
+}
+
+func (conn recorderConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (conn recorderConn) Close() error {
+	return nil
+}
+
+func (conn recorderConn) Begin() (driver.Tx, error) {
+	return recorderTx{}, nil
+}
+
+func (conn recorderConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn.recorder.record(query, args)
+	return emptyRows{}, nil
+}
+
+func (conn recorderConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	conn.recorder.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+type recorderTx struct{}
+
+func (recorderTx) Commit() error   { return nil }
+func (recorderTx) Rollback() error { return nil }
+
+type emptyRows struct{}
+
+func (emptyRows) Columns() []string         { return nil }
+func (emptyRows) Close() error              { return nil }
+func (emptyRows) Next([]driver.Value) error { return io.EOF }
+
+func newRecordedDB(t *testing.T) (*gorm.DB, *recorder) {
+	recorder := &recorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(recorder)}), &gorm.Config{})
+	require.NoError(t, err)
+	return db, recorder
+}
+
+func TestRepositoriesAreScopedByTenant(t *testing.T) {
+	db, recorder := newRecordedDB(t)
+	medias := NewMediaRepository(db)
+	tags := NewTagRepository(db)
+	apiKeys := NewAPIKeyRepository(db)
+	usage := NewUsageRepository(db)
+	audit := NewAuditRepository(db)
+	webhooks := NewWebhookRepository(db)
+	events := NewEventRepository(db)
+	metrics := NewMetricsRepository(db)
+	const organizationID = 7
+
+	tests := []struct {
+		description string
+		call        func(ctx context.Context) error
+	}{
+		{"Creating a media", func(ctx context.Context) error {
+			_, err := medias.Create(ctx, &models.Media{Name: "kick-off"}, []uint{1, 2})
+			return err
+		}},
+		{"Finding a media", func(ctx context.Context) error {
+			_, err := medias.FindByID(ctx, "1")
+			return err
+		}},
+		{"Searching medias", func(ctx context.Context) error {
+			_, err := medias.Find(ctx, MediaFilter{Tag: "goal"})
+			return err
+		}},
+		{"Finding similar medias", func(ctx context.Context) error {
+			_, err := medias.FindSimilar(ctx, 1, 42, 8)
+			return err
+		}},
+		{"Finding the hashes of a tag", func(ctx context.Context) error {
+			_, err := medias.FindHashesByTag(ctx, "1")
+			return err
+		}},
+		{"Counting a download", func(ctx context.Context) error {
+			return medias.IncrementDownloadCount(ctx, 1)
+		}},
+		{"Updating the processing of a media", func(ctx context.Context) error {
+			return medias.UpdateProcessing(ctx, 1, models.Processing{Status: models.MediaReady})
+		}},
+		{"Deleting a media", func(ctx context.Context) error {
+			return medias.Delete(ctx, 1)
+		}},
+		{"Restoring a media", func(ctx context.Context) error {
+			_, err := medias.Restore(ctx, "1")
+			return err
+		}},
+		{"Listing deleted medias", func(ctx context.Context) error {
+			_, err := medias.FindDeleted(ctx, time.Now(), 100)
+			return err
+		}},
+		{"Purging a media", func(ctx context.Context) error {
+			return medias.Purge(ctx, 1)
+		}},
+		{"Creating a tag", func(ctx context.Context) error {
+			_, _, err := tags.Create(ctx, &models.Tag{Name: "goal"})
+			return err
+		}},
+		{"Finding a tag", func(ctx context.Context) error {
+			_, err := tags.FindByID(ctx, "1")
+			return err
+		}},
+		{"Listing tags", func(ctx context.Context) error {
+			_, err := tags.Find(ctx)
+			return err
+		}},
+		{"Searching tags", func(ctx context.Context) error {
+			_, err := tags.FindByName(ctx, "go")
+			return err
+		}},
+		{"Deleting a tag", func(ctx context.Context) error {
+			return tags.Delete(ctx, "1")
+		}},
+		{"Restoring a tag", func(ctx context.Context) error {
+			_, err := tags.Restore(ctx, "1")
+			return err
+		}},
+		{"Listing deleted tags", func(ctx context.Context) error {
+			_, err := tags.FindDeleted(ctx, time.Now(), 100)
+			return err
+		}},
+		{"Purging a tag", func(ctx context.Context) error {
+			return tags.Purge(ctx, 1)
+		}},
+		{"Creating an API key", func(ctx context.Context) error {
+			return apiKeys.Create(ctx, &models.APIKey{Name: "gallery"})
+		}},
+		{"Listing API keys", func(ctx context.Context) error {
+			_, err := apiKeys.Find(ctx)
+			return err
+		}},
+		{"Revoking an API key", func(ctx context.Context) error {
+			return apiKeys.Revoke(ctx, 1, time.Now())
+		}},
+		{"Reserving usage", func(ctx context.Context) error {
+			return usage.Reserve(ctx, 1024)
+		}},
+		{"Releasing usage", func(ctx context.Context) error {
+			return usage.Release(ctx, 1024)
+		}},
+		{"Counting usage", func(ctx context.Context) error {
+			return usage.Record(ctx, []models.UsageCounter{{Day: time.Now(), Dimension: models.UsageTotal, Bytes: 1024, Medias: 1}})
+		}},
+		{"Finding usage", func(ctx context.Context) error {
+			_, err := usage.Find(ctx)
+			return err
+		}},
+		{"Finding usage counters", func(ctx context.Context) error {
+			_, err := usage.FindCounters(ctx, time.Now())
+			return err
+		}},
+		{"Recording an audit event", func(ctx context.Context) error {
+			return audit.Create(ctx, &models.AuditEvent{Actor: "api-key:1", Action: models.AuditDelete, EntityType: models.AuditTag, EntityID: "1"})
+		}},
+		{"Searching the audit log", func(ctx context.Context) error {
+			_, err := audit.Find(ctx, AuditFilter{EntityType: models.AuditTag, Before: 42}, 100)
+			return err
+		}},
+		{"Creating a webhook", func(ctx context.Context) error {
+			return webhooks.Create(ctx, &models.Webhook{URL: "https://cms.example.com/hooks", Events: []string{models.WebhookMediaCreated}})
+		}},
+		{"Listing webhooks", func(ctx context.Context) error {
+			_, err := webhooks.Find(ctx)
+			return err
+		}},
+		{"Deleting a webhook", func(ctx context.Context) error {
+			return webhooks.Delete(ctx, 1)
+		}},
+		{"Listing the deliveries of a webhook", func(ctx context.Context) error {
+			_, err := webhooks.FindDeliveries(ctx, 1, 100)
+			return err
+		}},
+		{"Replaying a delivery", func(ctx context.Context) error {
+			_, err := webhooks.Replay(ctx, 1, time.Now())
+			return err
+		}},
+		{"Recording a delivery attempt", func(ctx context.Context) error {
+			return webhooks.UpdateDelivery(ctx, &models.WebhookDelivery{ID: 1, Status: models.DeliverySucceeded, Attempts: 1})
+		}},
+		{"Resuming the stream of events", func(ctx context.Context) error {
+			_, err := events.FindAfter(ctx, 1, []string{models.WebhookMediaCreated}, 100)
+			return err
+		}},
+		{"Reading the last event", func(ctx context.Context) error {
+			_, err := events.LastID(ctx)
+			return err
+		}},
+		{"Pruning the history of events", func(ctx context.Context) error {
+			_, err := events.Prune(ctx, time.Now())
+			return err
+		}},
+		{"Counting medias", func(ctx context.Context) error {
+			_, err := metrics.CountMedias(ctx)
+			return err
+		}},
+		{"Counting tags", func(ctx context.Context) error {
+			_, err := metrics.CountTags(ctx)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description+" should be restricted to the organization of the caller", func(t *testing.T) {
+			recorder.reset()
+			tt.call(WithTenant(context.Background(), organizationID))
+			statements := recorder.reset()
+			require.NotEmpty(t, statements)
+			for _, statement := range statements {
+				assert.Contains(t, statement.query, "organization_id", statement.query)
+				assert.Contains(t, statement.args, int64(organizationID), statement.query)
+			}
+		})
+		t.Run(tt.description+" should fail without organization", func(t *testing.T) {
+			recorder.reset()
+			err := tt.call(context.Background())
+			assert.ErrorIs(t, err, ErrMissingTenant)
+			for _, statement := range recorder.reset() {
+				assert.NotContains(t, strings.ToUpper(statement.query), "SELECT", "no data is read without organization")
+			}
+		})
+	}
+}
+
+func TestMaintenanceQueriesReadEveryTenant(t *testing.T) {
+	db, recorder := newRecordedDB(t)
+	medias := NewMediaRepository(db)
+
+	_, err := medias.FindAfter(WithAllTenants(context.Background()), 0, 100)
+	require.NoError(t, err)
+	statements := recorder.reset()
+	require.NotEmpty(t, statements)
+	assert.NotContains(t, statements[0].query, "organization_id")
+
+	_, err = medias.FindAfter(context.Background(), 0, 100)
+	assert.ErrorIs(t, err, ErrMissingTenant)
+}
+
+func TestWebhookQueuesSkipLockedRows(t *testing.T) {
+	db, recorder := newRecordedDB(t)
+	webhooks := NewWebhookRepository(db)
+	ctx := WithAllTenants(context.Background())
+
+	_, err := webhooks.Dispatch(ctx, 100, time.Now())
+	require.NoError(t, err)
+	statements := recorder.reset()
+	require.NotEmpty(t, statements)
+	assert.Contains(t, statements[0].query, `FROM "outbox_events"`)
+	assert.Contains(t, statements[0].query, "FOR UPDATE SKIP LOCKED", "events are dispatched once by concurrent instances")
+	assert.NotContains(t, statements[0].query, "organization_id", "the events of every organization are dispatched")
+
+	_, err = webhooks.ClaimDue(ctx, time.Now(), time.Now().Add(time.Minute), 100)
+	require.NoError(t, err)
+	statements = recorder.reset()
+	require.NotEmpty(t, statements)
+	assert.Contains(t, statements[0].query, `FROM "webhook_deliveries"`)
+	assert.Contains(t, statements[0].query, "FOR UPDATE SKIP LOCKED", "deliveries are sent once by concurrent instances")
+
+	_, err = webhooks.Dispatch(context.Background(), 100, time.Now())
+	assert.ErrorIs(t, err, ErrMissingTenant)
+}
+
+func TestDeletedMediasAndTagsAreExcluded(t *testing.T) {
+	db, recorder := newRecordedDB(t)
+	medias := NewMediaRepository(db)
+	tags := NewTagRepository(db)
+	ctx := WithTenant(context.Background(), 7)
+
+	tests := []struct {
+		description string
+		call        func() error
+		expected    []string
+	}{
+		{"Deleting a media should move it to the trash", func() error {
+			return medias.Delete(ctx, 1)
+		}, []string{`UPDATE "media" SET "deleted_at"=`}},
+		{"Deleting a tag should move it to the trash", func() error {
+			return tags.Delete(ctx, "1")
+		}, []string{`UPDATE "tags" SET "deleted_at"=`}},
+		{"Finding a media should exclude the trash", func() error {
+			_, err := medias.FindByID(ctx, "1")
+			return err
+		}, []string{`"media"."deleted_at" IS NULL`}},
+		{"Searching medias should exclude the trash and the tags in the trash", func() error {
+			_, err := medias.Find(ctx, MediaFilter{Tag: "1"})
+			return err
+		}, []string{`"media"."deleted_at" IS NULL`, "tags.deleted_at IS NULL"}},
+		{"Listing tags should exclude the trash", func() error {
+			_, err := tags.Find(ctx)
+			return err
+		}, []string{`"tags"."deleted_at" IS NULL`}},
+		{"Purging a media should delete it", func() error {
+			return medias.Purge(ctx, 1)
+		}, []string{`DELETE FROM "media_tags"`, `DELETE FROM "media"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			recorder.reset()
+			tt.call()
+			queries := ""
+			for _, statement := range recorder.reset() {
+				queries += statement.query + "\n"
+			}
+			for _, expected := range tt.expected {
+				assert.Contains(t, queries, expected)
+			}
+		})
+	}
+}
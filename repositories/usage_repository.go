@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+	ErrMediaQuotaExceeded   = errors.New("media quota exceeded")
+	ErrUsageDBOperation     = errors.New("usage database operation failed")
+)
+
+type IUsageRepository interface {
+	// Reserve adds a media of size bytes to the usage of the organization of ctx, unless it exceeds one of its quotas
+	Reserve(ctx context.Context, bytes int64) error
+	// Release removes a media of size bytes from the usage of the organization of ctx
+	Release(ctx context.Context, bytes int64) error
+	// Record adds counters to the usage counters of the organization of ctx
+	Record(ctx context.Context, counters []models.UsageCounter) error
+	// Find returns the usage of the organization of ctx, loaded with its organization
+	Find(ctx context.Context) (*models.OrganizationUsage, error)
+	// FindCounters returns the usage counters of the organization of ctx until a day, by dimension, key and day
+	FindCounters(ctx context.Context, until time.Time) ([]models.UsageCounter, error)
+}
+
+type UsageRepository struct {
+	db *gorm.DB
+}
+
+func NewUsageRepository(db *gorm.DB) *UsageRepository {
+	return &UsageRepository{db: db}
+}
+
+// Reserve checks the quotas and updates the usage in a single statement, so that concurrent uploads cannot
+// exceed the quotas together
+func (repository *UsageRepository) Reserve(ctx context.Context, bytes int64) error {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	if err := repository.create(ctx, organizationID); err != nil {
+		return err
+	}
+	result := repository.db.WithContext(ctx).Exec(`UPDATE organization_usages
+		SET bytes = organization_usages.bytes + @bytes, medias = organization_usages.medias + 1, updated_at = @now
+		FROM organizations
+		WHERE organizations.id = organization_usages.organization_id AND organization_usages.organization_id = @organization
+		AND (organizations.storage_quota = 0 OR organization_usages.bytes + @bytes <= organizations.storage_quota)
+		AND (organizations.media_quota = 0 OR organization_usages.medias < organizations.media_quota)`,
+		sql.Named("bytes", bytes), sql.Named("now", time.Now()), sql.Named("organization", organizationID))
+	if result.Error != nil {
+		return fmt.Errorf("%w: %w", ErrUsageDBOperation, result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	usage, err := repository.Find(ctx)
+	if err != nil {
+		return err
+	}
+	if quota := usage.Organization.MediaQuota; quota > 0 && usage.Medias >= quota {
+		return fmt.Errorf("%w: the organization stores %d medias out of %d", ErrMediaQuotaExceeded, usage.Medias, quota)
+	}
+	return fmt.Errorf("%w: the organization stores %d bytes out of %d, %d more bytes cannot be stored",
+		ErrStorageQuotaExceeded, usage.Bytes, usage.Organization.StorageQuota, bytes)
+}
+
+func (repository *UsageRepository) Release(ctx context.Context, bytes int64) error {
+	err := repository.db.WithContext(ctx).Model(&models.OrganizationUsage{}).Scopes(scopeTenant(ctx, "organization_usages")).
+		Updates(map[string]interface{}{
+			"bytes":  gorm.Expr("bytes - ?", bytes),
+			"medias": gorm.Expr("medias - 1"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUsageDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *UsageRepository) Record(ctx context.Context, counters []models.UsageCounter) error {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range counters {
+		counters[i].OrganizationID = organizationID
+	}
+	err = repository.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "organization_id"}, {Name: "day"}, {Name: "dimension"}, {Name: "key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"bytes":  gorm.Expr("usage_counters.bytes + excluded.bytes"),
+			"medias": gorm.Expr("usage_counters.medias + excluded.medias"),
+		}),
+	}).Create(&counters).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUsageDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *UsageRepository) Find(ctx context.Context) (*models.OrganizationUsage, error) {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.create(ctx, organizationID); err != nil {
+		return nil, err
+	}
+	usage := &models.OrganizationUsage{}
+	err = repository.db.WithContext(ctx).Preload("Organization").
+		Where("organization_id = ?", organizationID).Take(usage).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsageDBOperation, err)
+	}
+	return usage, nil
+}
+
+// create creates the usage of an organization storing nothing, unless it exists
+func (repository *UsageRepository) create(ctx context.Context, organizationID uint) error {
+	err := repository.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.OrganizationUsage{OrganizationID: organizationID}).Error
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUsageDBOperation, err)
+	}
+	return nil
+}
+
+func (repository *UsageRepository) FindCounters(ctx context.Context, until time.Time) ([]models.UsageCounter, error) {
+	var counters []models.UsageCounter
+	err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "usage_counters")).
+		Where("day <= ?", until.Format(time.DateOnly)).
+		Order("dimension, key, day").
+		Find(&counters).Error
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsageDBOperation, err)
+	}
+	return counters, nil
+}
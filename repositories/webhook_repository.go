@@ -0,0 +1,238 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+type IWebhookRepository interface {
+	Create(ctx context.Context, webhook *models.Webhook) error
+	Find(ctx context.Context) ([]models.Webhook, error)
+	Delete(ctx context.Context, id uint) error
+	FindDeliveries(ctx context.Context, webhookID uint, limit int) ([]models.WebhookDelivery, error)
+	Replay(ctx context.Context, deliveryID uint, now time.Time) (*models.WebhookDelivery, error)
+	Dispatch(ctx context.Context, limit int, now time.Time) (int, error)
+	ClaimDue(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]models.WebhookDelivery, error)
+	UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+}
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (repository *WebhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	organizationID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	webhook.OrganizationID = organizationID
+	return repository.db.WithContext(ctx).Create(webhook).Error
+}
+
+func (repository *WebhookRepository) Find(ctx context.Context) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	if err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "webhooks")).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// Delete deletes a webhook and its delivery log, its pending deliveries are not sent
+func (repository *WebhookRepository) Delete(ctx context.Context, id uint) error {
+	return repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Scopes(scopeTenant(ctx, "webhook_deliveries")).Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Scopes(scopeTenant(ctx, "webhooks")).Where("id = ?", id).Delete(&models.Webhook{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %d", ErrWebhookNotFound, id)
+		}
+		return nil
+	})
+}
+
+// FindDeliveries returns the last limit deliveries of a webhook, newest first
+func (repository *WebhookRepository) FindDeliveries(ctx context.Context, webhookID uint, limit int) ([]models.WebhookDelivery, error) {
+	var webhook models.Webhook
+	err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "webhooks")).Where("id = ?", webhookID).Take(&webhook).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrWebhookNotFound, webhookID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []models.WebhookDelivery{}
+	err = repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "webhook_deliveries")).
+		Where("webhook_id = ?", webhook.ID).
+		Order("id DESC").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Replay creates a new delivery of the event of a delivery, sent at now to its webhook
+func (repository *WebhookRepository) Replay(ctx context.Context, deliveryID uint, now time.Time) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "webhook_deliveries")).Where("id = ?", deliveryID).Take(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrDeliveryNotFound, deliveryID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	replay := &models.WebhookDelivery{
+		OrganizationID: delivery.OrganizationID,
+		WebhookID:      delivery.WebhookID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Payload:        delivery.Payload,
+		OccurredAt:     delivery.OccurredAt,
+		Status:         models.DeliveryPending,
+		NextAttemptAt:  &now,
+		ReplayOf:       &delivery.ID,
+	}
+	if err := repository.db.WithContext(ctx).Create(replay).Error; err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
+
+// Dispatch moves at most limit events of the outbox to the deliveries of the webhooks subscribed to them, due at
+// now, and returns the number of events dispatched. Events locked by another dispatch are skipped, events
+// dispatched are kept for the history of the live feed.
+func (repository *WebhookRepository) Dispatch(ctx context.Context, limit int, now time.Time) (int, error) {
+	dispatched := 0
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var events []models.OutboxEvent
+		err := tx.Scopes(scopeTenant(ctx, "outbox_events")).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("dispatched_at IS NULL").
+			Order("id").
+			Limit(limit).
+			Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		organizationIDs := []uint{}
+		eventIDs := make([]uint, 0, len(events))
+		for _, event := range events {
+			if !slices.Contains(organizationIDs, event.OrganizationID) {
+				organizationIDs = append(organizationIDs, event.OrganizationID)
+			}
+			eventIDs = append(eventIDs, event.ID)
+		}
+		var webhooks []models.Webhook
+		if err := tx.Where("organization_id IN ?", organizationIDs).Order("id").Find(&webhooks).Error; err != nil {
+			return err
+		}
+		deliveries := []models.WebhookDelivery{}
+		for _, event := range events {
+			for _, webhook := range webhooks {
+				if webhook.OrganizationID != event.OrganizationID || !slices.Contains(webhook.Events, event.Type) {
+					continue
+				}
+				deliveries = append(deliveries, models.WebhookDelivery{
+					OrganizationID: event.OrganizationID,
+					WebhookID:      webhook.ID,
+					EventID:        event.ID,
+					EventType:      event.Type,
+					Payload:        event.Payload,
+					OccurredAt:     event.CreatedAt,
+					Status:         models.DeliveryPending,
+					NextAttemptAt:  &now,
+				})
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.Create(&deliveries).Error; err != nil {
+				return err
+			}
+		}
+		dispatched = len(events)
+		return tx.Model(&models.OutboxEvent{}).Where("id IN ?", eventIDs).UpdateColumn("dispatched_at", now).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return dispatched, nil
+}
+
+// ClaimDue returns at most limit pending deliveries whose next attempt is due at now, with their webhook. Their
+// next attempt is moved to lockedUntil so that other instances do not send them meanwhile, and so that they are
+// sent again if the instance stops before recording the attempt.
+func (repository *WebhookRepository) ClaimDue(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := repository.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Scopes(scopeTenant(ctx, "webhook_deliveries")).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+			Order("next_attempt_at, id").
+			Limit(limit).
+			Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		deliveryIDs := make([]uint, 0, len(deliveries))
+		webhookIDs := []uint{}
+		for _, delivery := range deliveries {
+			deliveryIDs = append(deliveryIDs, delivery.ID)
+			if !slices.Contains(webhookIDs, delivery.WebhookID) {
+				webhookIDs = append(webhookIDs, delivery.WebhookID)
+			}
+		}
+		var webhooks []models.Webhook
+		if err := tx.Where("id IN ?", webhookIDs).Find(&webhooks).Error; err != nil {
+			return err
+		}
+		for i := range deliveries {
+			for j := range webhooks {
+				if webhooks[j].ID == deliveries[i].WebhookID {
+					deliveries[i].Webhook = &webhooks[j]
+				}
+			}
+			deliveries[i].NextAttemptAt = &lockedUntil
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", deliveryIDs).UpdateColumn("next_attempt_at", lockedUntil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// UpdateDelivery records the result of an attempt of a delivery
+func (repository *WebhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return repository.db.WithContext(ctx).Scopes(scopeTenant(ctx, "webhook_deliveries")).
+		Model(&models.WebhookDelivery{ID: delivery.ID}).
+		Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"response_status": delivery.ResponseStatus,
+			"last_error":      delivery.LastError,
+			"delivered_at":    delivery.DeliveredAt,
+		}).Error
+}
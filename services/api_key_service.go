@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Scopes granted by API keys
+const (
+	ScopeMediaRead  = "media:read"
+	ScopeMediaWrite = "media:write"
+	ScopeTagsAdmin  = "tags:admin"
+	// Issue, list and revoke API keys
+	ScopeKeysAdmin = "keys:admin"
+	// Read and export the audit log
+	ScopeAuditRead = "audit:read"
+	// Manage the webhooks and read their delivery log
+	ScopeWebhooksAdmin = "webhooks:admin"
+)
+
+// Scopes lists every scope an API key can hold
+var Scopes = []string{ScopeMediaRead, ScopeMediaWrite, ScopeTagsAdmin, ScopeKeysAdmin, ScopeAuditRead, ScopeWebhooksAdmin}
+
+const (
+	apiKeyPrefix = "sp_"
+	// Number of characters of a key stored to recognize it
+	apiKeyDisplayLength = 8
+	// The last use of a key is recorded at most once per interval, to avoid a write on every request
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey   = errors.New("invalid api key")
+	ErrInvalidScope    = errors.New("invalid scope")
+	ErrScopeNotGranted = errors.New("scope not granted")
+)
+
+type APIKeyService struct {
+	repository repositories.IAPIKeyRepository
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewAPIKeyService(repository repositories.IAPIKeyRepository) *APIKeyService {
+	return &APIKeyService{repository: repository, now: time.Now}
+}
+
+// ParseScopes parses a comma separated list of scopes
+func ParseScopes(value string) ([]string, error) {
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if err := validateScopes(scopes); err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("%w: %s (available: %s)", ErrInvalidScope, scope, strings.Join(Scopes, ", "))
+		}
+	}
+	return nil
+}
+
+// Issue creates a key of the organization of ctx holding the given scopes. The returned key is not stored and
+// cannot be retrieved later. Callers identified in ctx cannot grant scopes they do not hold, with
+// ErrScopeNotGranted; keys issued from the command line are not restricted.
+func (service *APIKeyService) Issue(ctx context.Context, name string, scopes []string) (string, *models.APIKey, error) {
+	if name == "" {
+		return "", nil, errors.New("api key name is required")
+	}
+	if err := validateScopes(scopes); err != nil {
+		return "", nil, err
+	}
+	if identity := IdentityFromContext(ctx); identity != nil {
+		for _, scope := range scopes {
+			if !identity.HasScope(scope) {
+				return "", nil, fmt.Errorf("%w: %s is not granted to the caller", ErrScopeNotGranted, scope)
+			}
+		}
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	apiKey := &models.APIKey{
+		Name:    name,
+		Prefix:  key[:len(apiKeyPrefix)+apiKeyDisplayLength],
+		KeyHash: hashAPIKey(key),
+		Scopes:  scopes,
+	}
+	if err := service.repository.Create(ctx, apiKey); err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// Authenticate returns the key matching a key sent by a client, of any organization, and records its use.
+// Unknown and revoked keys are rejected with ErrInvalidAPIKey.
+func (service *APIKeyService) Authenticate(ctx context.Context, key string) (*models.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey, err := service.repository.FindByHash(ctx, hashAPIKey(key))
+	if errors.Is(err, repositories.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	now := service.now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedInterval {
+		if err := service.repository.UpdateLastUsed(repositories.WithTenant(ctx, apiKey.OrganizationID), apiKey.ID, now); err != nil {
+			log.Printf("unable to record the use of api key %d: %s\n", apiKey.ID, err)
+		}
+		apiKey.LastUsedAt = &now
+	}
+	return apiKey, nil
+}
+
+func (service *APIKeyService) GetAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	return service.repository.Find(ctx)
+}
+
+// Revoke rejects a key from now on
+func (service *APIKeyService) Revoke(ctx context.Context, id uint) error {
+	return service.repository.Revoke(ctx, id, service.now())
+}
+
+// hashAPIKey hashes a key to look it up. Keys are random 256 bits values, a fast hash is enough.
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
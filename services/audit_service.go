@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Number of events read at once by the exports
+const auditExportBatch = 500
+
+// MaxAuditEvents is the largest page of audit events
+const MaxAuditEvents = 1000
+
+var ErrInvalidAuditFilter = errors.New("invalid audit filter")
+
+type requestContextKey struct{}
+
+// Request identifies the HTTP request running a change, recorded with its audit event
+type Request struct {
+	ID string
+	IP string
+}
+
+// WithRequest returns a context carrying the id and IP address of the request
+func WithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, request)
+}
+
+// RequestFromContext returns the request of a context, empty for background work
+func RequestFromContext(ctx context.Context) Request {
+	request, _ := ctx.Value(requestContextKey{}).(Request)
+	return request
+}
+
+// AuditService records the changes of the medias, tags and their associations in the audit log
+type AuditService struct {
+	repository repositories.IAuditRepository
+}
+
+func NewAuditService(repository repositories.IAuditRepository) *AuditService {
+	return &AuditService{repository: repository}
+}
+
+// Record appends an event to the audit log, with the caller and request of ctx and the JSON representation of
+// the entity before and after the change (nil before a create and after a delete). The change is already
+// made: failures are logged. A nil service records nothing.
+func (service *AuditService) Record(ctx context.Context, action string, entityType string, entityID string, before any, after any) {
+	if service == nil {
+		return
+	}
+	event := &models.AuditEvent{
+		Actor:      models.AuditActorSystem,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+	}
+	if identity := IdentityFromContext(ctx); identity != nil {
+		event.Actor, event.ActorName = identity.Subject, identity.Name
+	}
+	request := RequestFromContext(ctx)
+	event.RequestID, event.IP = request.ID, request.IP
+	var err error
+	if event.Before, err = auditSnapshot(before); err == nil {
+		event.After, err = auditSnapshot(after)
+	}
+	if err == nil {
+		err = service.repository.Create(ctx, event)
+	}
+	if err != nil {
+		fmt.Printf("unable to record %s of %s %s in the audit log: %s\n", action, entityType, entityID, err.Error())
+	}
+}
+
+// auditID formats the id of an entity of the audit log
+func auditID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func auditSnapshot(entity any) (json.RawMessage, error) {
+	if entity == nil {
+		return nil, nil
+	}
+	return json.Marshal(entity)
+}
+
+// GetEvents returns a page of the events matching filter, newest first
+func (service *AuditService) GetEvents(ctx context.Context, filter repositories.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	if err := validateAuditFilter(filter); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > MaxAuditEvents {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidAuditFilter, MaxAuditEvents)
+	}
+	return service.repository.Find(ctx, filter, limit)
+}
+
+// ExportEvents writes every event matching filter to w, newest first, as newline delimited JSON
+func (service *AuditService) ExportEvents(ctx context.Context, filter repositories.AuditFilter, w io.Writer) error {
+	if err := validateAuditFilter(filter); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for {
+		events, err := service.repository.Find(ctx, filter, auditExportBatch)
+		if err != nil {
+			return err
+		}
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+		if len(events) < auditExportBatch {
+			return nil
+		}
+		filter.Before = events[len(events)-1].ID
+	}
+}
+
+func validateAuditFilter(filter repositories.AuditFilter) error {
+	if filter.Action != "" && !slices.Contains([]string{models.AuditCreate, models.AuditUpdate, models.AuditDelete, models.AuditRestore, models.AuditPurge}, filter.Action) {
+		return fmt.Errorf("%w: unknown action %s", ErrInvalidAuditFilter, filter.Action)
+	}
+	if filter.EntityType != "" && !slices.Contains([]string{models.AuditMedia, models.AuditTag, models.AuditMediaTag}, filter.EntityType) {
+		return fmt.Errorf("%w: unknown entity type %s", ErrInvalidAuditFilter, filter.EntityType)
+	}
+	if filter.From != nil && filter.To != nil && !filter.To.After(*filter.From) {
+		return fmt.Errorf("%w: to must be after from", ErrInvalidAuditFilter)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditRepository keeps the audit log in memory, events of every organization and filters other than the
+// entity type are ignored
+type auditRepository struct {
+	events []models.AuditEvent
+}
+
+func (repository *auditRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	event.ID = uint(len(repository.events) + 1)
+	repository.events = append(repository.events, *event)
+	return nil
+}
+
+func (repository *auditRepository) Find(ctx context.Context, filter repositories.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	for i := len(repository.events) - 1; i >= 0 && len(events) < limit; i-- {
+		event := repository.events[i]
+		if (filter.Before == 0 || event.ID < filter.Before) && (filter.EntityType == "" || event.EntityType == filter.EntityType) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func TestAuditServiceRecordsCallerAndRequest(t *testing.T) {
+	repository := &auditRepository{}
+	service := NewAuditService(repository)
+	ctx := WithIdentity(context.Background(), &Identity{Subject: "auth0|42", Name: "zizou@fc-nantes.fr", OrganizationID: 1})
+	ctx = WithRequest(ctx, Request{ID: "req-1", IP: "10.0.0.1"})
+
+	service.Record(ctx, models.AuditDelete, models.AuditTag, "3", &models.Tag{ID: 3, Name: "goal"}, nil)
+	service.Record(WithRequest(context.Background(), Request{}), models.AuditUpdate, models.AuditMedia, "1", nil, nil)
+
+	require.Len(t, repository.events, 2)
+	event := repository.events[0]
+	assert.Equal(t, "auth0|42", event.Actor)
+	assert.Equal(t, "zizou@fc-nantes.fr", event.ActorName)
+	assert.Equal(t, "req-1", event.RequestID)
+	assert.Equal(t, "10.0.0.1", event.IP)
+	assert.JSONEq(t, `{"id":3,"name":"goal","createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z"}`, string(event.Before))
+	assert.Nil(t, event.After, "nothing is left after a delete")
+	assert.Equal(t, models.AuditActorSystem, repository.events[1].Actor, "changes without caller are made by the system")
+
+	var disabled *AuditService
+	assert.NotPanics(t, func() { disabled.Record(ctx, models.AuditCreate, models.AuditTag, "4", nil, nil) }, "a nil service records nothing")
+}
+
+func TestAuditServiceExportsEveryEvent(t *testing.T) {
+	repository := &auditRepository{}
+	service := NewAuditService(repository)
+	ctx := context.Background()
+	for i := 0; i < auditExportBatch+2; i++ {
+		service.Record(ctx, models.AuditCreate, models.AuditMedia, auditID(uint(i+1)), nil, nil)
+	}
+	service.Record(ctx, models.AuditDelete, models.AuditTag, "1", nil, nil)
+
+	var output bytes.Buffer
+	require.NoError(t, service.ExportEvents(ctx, repositories.AuditFilter{EntityType: models.AuditMedia}, &output))
+
+	var ids []string
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var event models.AuditEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		ids = append(ids, event.EntityID)
+	}
+	require.Len(t, ids, auditExportBatch+2, "events are read in batches until the last one")
+	assert.Equal(t, auditID(auditExportBatch+2), ids[0], "newest events are exported first")
+	assert.Equal(t, "1", ids[len(ids)-1])
+}
+
+func TestAuditServiceRejectsInvalidFilters(t *testing.T) {
+	service := NewAuditService(&auditRepository{})
+
+	_, err := service.GetEvents(context.Background(), repositories.AuditFilter{Action: "rename"}, 10)
+	assert.ErrorIs(t, err, ErrInvalidAuditFilter)
+	_, err = service.GetEvents(context.Background(), repositories.AuditFilter{EntityType: "api_key"}, 10)
+	assert.ErrorIs(t, err, ErrInvalidAuditFilter)
+	_, err = service.GetEvents(context.Background(), repositories.AuditFilter{}, MaxAuditEvents+1)
+	assert.ErrorIs(t, err, ErrInvalidAuditFilter)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// States of a circuit breaker
+const (
+	// Calls go through
+	CircuitClosed = "closed"
+	// Calls fail fast until the end of the cooldown
+	CircuitOpen = "open"
+	// A single call goes through to check whether the service is back
+	CircuitHalfOpen = "half-open"
+)
+
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// CircuitOpenError is returned without calling the storage while its circuit breaker is open
+type CircuitOpenError struct {
+	Name string
+	// Delay before the storage is called again
+	RetryAfter time.Duration
+}
+
+func (err *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: circuit breaker of %s is open, retry in %s", ErrStorageUnavailable, err.Name, err.RetryAfter.Round(time.Second))
+}
+
+func (err *CircuitOpenError) Unwrap() error {
+	return ErrStorageUnavailable
+}
+
+// CircuitBreaker stops calling a service after consecutive failures. Once the cooldown is over, one call
+// is let through: the breaker closes when it succeeds and opens again when it fails.
+type CircuitBreaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// a call is running in the half-open state
+	probing bool
+}
+
+// CircuitBreakerStatus describes the state of a circuit breaker
+type CircuitBreakerStatus struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+}
+
+var (
+	circuitBreakersMu sync.RWMutex
+	circuitBreakers   = map[string]*CircuitBreaker{}
+)
+
+// NewCircuitBreaker creates a closed circuit breaker, opened after threshold consecutive failures for cooldown.
+// It replaces the breaker registered with the same name.
+func NewCircuitBreaker(name string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	breaker := &CircuitBreaker{
+		name:      name,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     CircuitClosed,
+	}
+	circuitBreakersMu.Lock()
+	circuitBreakers[name] = breaker
+	circuitBreakersMu.Unlock()
+	storageCircuitState.WithLabelValues(name).Set(0)
+	return breaker
+}
+
+// CircuitBreakers returns the status of the registered circuit breakers, sorted by name
+func CircuitBreakers() []CircuitBreakerStatus {
+	circuitBreakersMu.RLock()
+	defer circuitBreakersMu.RUnlock()
+	statuses := make([]CircuitBreakerStatus, 0, len(circuitBreakers))
+	for _, breaker := range circuitBreakers {
+		statuses = append(statuses, breaker.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Allow returns a CircuitOpenError when the call must not be made
+func (breaker *CircuitBreaker) Allow() error {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch breaker.state {
+	case CircuitOpen:
+		if elapsed := breaker.now().Sub(breaker.openedAt); elapsed < breaker.cooldown {
+			return &CircuitOpenError{Name: breaker.name, RetryAfter: breaker.cooldown - elapsed}
+		}
+		breaker.setState(CircuitHalfOpen)
+		breaker.probing = true
+	case CircuitHalfOpen:
+		if breaker.probing {
+			return &CircuitOpenError{Name: breaker.name, RetryAfter: time.Second}
+		}
+		breaker.probing = true
+	}
+	return nil
+}
+
+// Success closes the breaker
+func (breaker *CircuitBreaker) Success() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures = 0
+	breaker.probing = false
+	breaker.setState(CircuitClosed)
+}
+
+// Failure counts a failed call and opens the breaker after threshold consecutive failures or a failed probe
+func (breaker *CircuitBreaker) Failure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures++
+	breaker.probing = false
+	if breaker.state == CircuitHalfOpen || breaker.failures >= breaker.threshold {
+		breaker.openedAt = breaker.now()
+		breaker.setState(CircuitOpen)
+	}
+}
+
+// Release ends a call which neither succeeded nor failed (example: canceled by the client)
+func (breaker *CircuitBreaker) Release() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.probing = false
+}
+
+func (breaker *CircuitBreaker) Status() CircuitBreakerStatus {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	status := CircuitBreakerStatus{Name: breaker.name, State: breaker.state, Failures: breaker.failures}
+	if breaker.state != CircuitClosed {
+		openedAt := breaker.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func (breaker *CircuitBreaker) setState(state string) {
+	if breaker.state == state {
+		return
+	}
+	breaker.state = state
+	storageCircuitState.WithLabelValues(breaker.name).Set(circuitStateValues[state])
+	if state == CircuitOpen {
+		storageCircuitOpened.WithLabelValues(breaker.name).Inc()
+	}
+}
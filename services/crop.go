@@ -0,0 +1,75 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mich31/scoreplay-media-api/imaging"
+	"github.com/mich31/scoreplay-media-api/models"
+)
+
+// cropToFill scales and crops an image to fill exactly width x height. It uses, in order of priority,
+// the crop box set for the aspect ratio, the focal point of the media, or a smart crop heuristic.
+func cropToFill(img image.Image, media *models.Media, width, height int) image.Image {
+	if box, found := matchingCropBox(media.Crops, width, height); found {
+		bounds := img.Bounds()
+		rect := image.Rect(
+			bounds.Min.X+int(box.X*float64(bounds.Dx())),
+			bounds.Min.Y+int(box.Y*float64(bounds.Dy())),
+			bounds.Min.X+int((box.X+box.Width)*float64(bounds.Dx())),
+			bounds.Min.Y+int((box.Y+box.Height)*float64(bounds.Dy())),
+		)
+		if !rect.Empty() {
+			return imaging.Cover(imaging.Crop(img, rect), width, height)
+		}
+	}
+	if media.FocalX != nil && media.FocalY != nil {
+		return imaging.CoverAt(img, width, height, *media.FocalX, *media.FocalY)
+	}
+	return imaging.SmartCover(img, width, height)
+}
+
+// matchingCropBox returns the crop box whose aspect ratio matches width x height (1% tolerance)
+func matchingCropBox(crops models.CropMap, width, height int) (models.CropBox, bool) {
+	ratio := float64(width) / float64(height)
+	for aspect, box := range crops {
+		value, err := parseAspectRatio(aspect)
+		if err == nil && math.Abs(value-ratio)/ratio < 0.01 {
+			return box, true
+		}
+	}
+	return models.CropBox{}, false
+}
+
+// parseAspectRatio parses an aspect ratio like 16:9
+func parseAspectRatio(aspect string) (float64, error) {
+	widthStr, heightStr, found := strings.Cut(aspect, ":")
+	if !found {
+		return 0, fmt.Errorf("invalid aspect ratio %q", aspect)
+	}
+	width, err := strconv.ParseFloat(widthStr, 64)
+	if err != nil || width <= 0 {
+		return 0, fmt.Errorf("invalid aspect ratio %q", aspect)
+	}
+	height, err := strconv.ParseFloat(heightStr, 64)
+	if err != nil || height <= 0 {
+		return 0, fmt.Errorf("invalid aspect ratio %q", aspect)
+	}
+	return width / height, nil
+}
+
+// focusKey identifies the focal point and crop boxes of a media, so that cached crops change with them
+func focusKey(media *models.Media) string {
+	var builder strings.Builder
+	if media.FocalX != nil && media.FocalY != nil {
+		fmt.Fprintf(&builder, "%g,%g", *media.FocalX, *media.FocalY)
+	}
+	if len(media.Crops) > 0 {
+		value, _ := media.Crops.Value()
+		fmt.Fprintf(&builder, "%s", value)
+	}
+	return builder.String()
+}
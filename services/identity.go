@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Roles of the users signed in through the identity provider
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// RoleScopes lists the scopes granted by each role
+var RoleScopes = map[string][]string{
+	RoleViewer: {ScopeMediaRead},
+	RoleEditor: {ScopeMediaRead, ScopeMediaWrite},
+	RoleAdmin:  Scopes,
+}
+
+// Identity is the caller of a request: a user authenticated by a token of the identity provider, or an API key
+type Identity struct {
+	// Subject of the token, or api-key:<id> for API keys
+	Subject string
+	// Display name or email of the user, name of the API key
+	Name string
+	// Organization whose data the caller reads and writes, and its slug
+	OrganizationID uint
+	Organization   string
+	Roles          []string
+	Scopes         []string
+}
+
+// NewUserIdentity returns the identity of a user of an organization, holding the scopes of its roles
+func NewUserIdentity(subject string, name string, organization *models.Organization, roles []string) *Identity {
+	identity := &Identity{
+		Subject:        subject,
+		Name:           name,
+		OrganizationID: organization.ID,
+		Organization:   organization.Slug,
+		Roles:          roles,
+	}
+	for _, role := range roles {
+		for _, scope := range RoleScopes[role] {
+			if !slices.Contains(identity.Scopes, scope) {
+				identity.Scopes = append(identity.Scopes, scope)
+			}
+		}
+	}
+	return identity
+}
+
+// NewAPIKeyIdentity returns the identity of the requests sending an API key, loaded with its organization
+func NewAPIKeyIdentity(apiKey *models.APIKey) *Identity {
+	identity := &Identity{
+		Subject:        "api-key:" + strconv.FormatUint(uint64(apiKey.ID), 10),
+		Name:           apiKey.Name,
+		OrganizationID: apiKey.OrganizationID,
+		Scopes:         apiKey.Scopes,
+	}
+	if apiKey.Organization != nil {
+		identity.Organization = apiKey.Organization.Slug
+	}
+	return identity
+}
+
+// HasScope reports whether the identity is granted a scope
+func (identity *Identity) HasScope(scope string) bool {
+	return slices.Contains(identity.Scopes, scope)
+}
+
+type identityContextKey struct{}
+
+// WithIdentity returns a context carrying the identity of the caller, whose queries are restricted to the data
+// of its organization
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	ctx = repositories.WithTenant(ctx, identity.OrganizationID)
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the caller, nil for unauthenticated calls and background work
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityContextKey{}).(*Identity)
+	return identity
+}
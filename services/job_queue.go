@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultJobWorkers      = 2
+	defaultJobPollInterval = time.Second
+	defaultJobMaxAttempts  = 5
+	defaultJobRetryDelay   = 10 * time.Second
+	defaultJobLockTimeout  = 10 * time.Minute
+	// Maximum delay between two attempts of a job
+	maxJobBackoff = time.Hour
+)
+
+// Priorities of jobs, jobs with a higher priority are run first
+const (
+	JobPriorityNormal = 0
+	JobPriorityHigh   = 10
+)
+
+// ErrPermanentJobFailure is wrapped by handlers for failures which cannot be fixed by running the job again,
+// the job is moved to the dead letters at once
+var ErrPermanentJobFailure = errors.New("permanent job failure")
+
+// JobHandler runs a job of a given type, a returned error makes the job run again later
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+// JobQueueOptions configures the workers of the job queue
+type JobQueueOptions struct {
+	// Number of jobs run concurrently, 0 to only enqueue jobs (workers run by the worker command)
+	Workers int
+	// Delay between two checks of the queue when it is empty
+	PollInterval time.Duration
+	// Number of attempts of a job before it is moved to the dead letters
+	MaxAttempts int
+	// Delay before the first retry of a failed job, doubled at each new attempt
+	RetryDelay time.Duration
+	// Maximum duration of a job, running jobs locked for longer are considered abandoned and run again
+	LockTimeout time.Duration
+}
+
+// JobQueue stores jobs in the database and runs them with the handler registered for their type
+type JobQueue struct {
+	repository repositories.IJobRepository
+	options    JobQueueOptions
+	handlers   map[string]JobHandler
+	wake       chan struct{}
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewJobQueue(repository repositories.IJobRepository, options JobQueueOptions) *JobQueue {
+	if options.Workers < 0 {
+		options.Workers = 0
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultJobPollInterval
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultJobMaxAttempts
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = defaultJobRetryDelay
+	}
+	if options.LockTimeout <= 0 {
+		options.LockTimeout = defaultJobLockTimeout
+	}
+	return &JobQueue{
+		repository: repository,
+		options:    options,
+		handlers:   map[string]JobHandler{},
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// LoadJobQueueOptions reads JOB_WORKERS, JOB_POLL_INTERVAL, JOB_MAX_ATTEMPTS, JOB_RETRY_DELAY and JOB_LOCK_TIMEOUT
+func LoadJobQueueOptions() (JobQueueOptions, error) {
+	options := JobQueueOptions{
+		Workers:      defaultJobWorkers,
+		PollInterval: defaultJobPollInterval,
+		MaxAttempts:  defaultJobMaxAttempts,
+		RetryDelay:   defaultJobRetryDelay,
+		LockTimeout:  defaultJobLockTimeout,
+	}
+	for key, target := range map[string]*int{"JOB_WORKERS": &options.Workers, "JOB_MAX_ATTEMPTS": &options.MaxAttempts} {
+		if value := config.Config(key); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 0 {
+				return options, fmt.Errorf("invalid %s: %s", key, value)
+			}
+			*target = number
+		}
+	}
+	durations := map[string]*time.Duration{
+		"JOB_POLL_INTERVAL": &options.PollInterval,
+		"JOB_RETRY_DELAY":   &options.RetryDelay,
+		"JOB_LOCK_TIMEOUT":  &options.LockTimeout,
+	}
+	for key, target := range durations {
+		if value := config.Config(key); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*target = duration
+		}
+	}
+	return options, nil
+}
+
+// Workers returns the number of jobs run concurrently by Run
+func (queue *JobQueue) Workers() int {
+	return queue.options.Workers
+}
+
+// Handle registers the handler of a type of jobs, handlers must be registered before Run
+func (queue *JobQueue) Handle(jobType string, handler JobHandler) {
+	queue.handlers[jobType] = handler
+}
+
+// Enqueue stores a job running the handler of jobType with payload encoded as JSON
+func (queue *JobQueue) Enqueue(jobType string, payload any, priority int) (*models.Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     string(encoded),
+		Status:      models.JobPending,
+		Priority:    priority,
+		MaxAttempts: queue.options.MaxAttempts,
+		RunAt:       queue.now(),
+	}
+	if err := queue.repository.Enqueue(job); err != nil {
+		return nil, err
+	}
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Run starts the workers, which run due jobs until ctx is done, and waits for them to stop
+func (queue *JobQueue) Run(ctx context.Context) {
+	if queue.options.Workers == 0 || len(queue.handlers) == 0 {
+		return
+	}
+	types := make([]string, 0, len(queue.handlers))
+	for jobType := range queue.handlers {
+		types = append(types, jobType)
+	}
+	sort.Strings(types)
+	hostname, _ := os.Hostname()
+
+	var wg sync.WaitGroup
+	for i := 0; i < queue.options.Workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			queue.work(ctx, types, worker)
+		}(fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), i))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		queue.requeueStale(ctx)
+	}()
+	wg.Wait()
+}
+
+// work runs due jobs one after the other, and waits for new jobs when the queue is empty
+func (queue *JobQueue) work(ctx context.Context, types []string, worker string) {
+	ticker := time.NewTicker(queue.options.PollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		ran, err := queue.RunNext(ctx, types, worker)
+		if err != nil {
+			log.Printf("unable to run job: %s\n", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-queue.wake:
+		}
+	}
+}
+
+// requeueStale periodically puts back in the queue the jobs abandoned by stopped workers
+func (queue *JobQueue) requeueStale(ctx context.Context) {
+	ticker := time.NewTicker(queue.options.LockTimeout / 2)
+	defer ticker.Stop()
+	for {
+		requeued, buried, err := queue.repository.RequeueStale(queue.now().Add(-queue.options.LockTimeout))
+		if err != nil {
+			log.Printf("unable to requeue stale jobs: %s\n", err)
+		} else if requeued > 0 || buried > 0 {
+			log.Printf("%d stale job(s) requeued, %d moved to the dead letters\n", requeued, buried)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunNext claims the next due job of one of the given types and runs it, it returns false when no job is due
+func (queue *JobQueue) RunNext(ctx context.Context, types []string, worker string) (bool, error) {
+	job, err := queue.repository.Claim(types, worker, queue.now())
+	if err != nil || job == nil {
+		return false, err
+	}
+	handler, found := queue.handlers[job.Type]
+	if !found {
+		return true, queue.repository.Bury(job.ID, worker, fmt.Sprintf("no handler for jobs of type %s", job.Type))
+	}
+
+	// a job is not interrupted when the workers are stopped, it runs until its end or its lock expires
+	jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), queue.options.LockTimeout)
+	defer cancel()
+	err = runJob(jobCtx, handler, job)
+	if err == nil {
+		return true, queue.repository.Complete(job.ID, worker, queue.now())
+	}
+	log.Printf("job %d (%s) failed (attempt %d/%d): %s\n", job.ID, job.Type, job.Attempts, job.MaxAttempts, err)
+	if errors.Is(err, ErrPermanentJobFailure) || job.Attempts >= job.MaxAttempts {
+		return true, queue.repository.Bury(job.ID, worker, err.Error())
+	}
+	return true, queue.repository.Retry(job.ID, worker, err.Error(), queue.now().Add(jobBackoff(queue.options.RetryDelay, job.Attempts)))
+}
+
+// runJob runs a handler, a panic is returned as an error so that the worker keeps running
+func runJob(ctx context.Context, handler JobHandler, job *models.Job) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// jobBackoff doubles the delay before each new attempt
+func jobBackoff(delay time.Duration, attempts int) time.Duration {
+	for i := 1; i < attempts && delay < maxJobBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxJobBackoff)
+}
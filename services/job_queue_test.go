@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jobRepository keeps jobs in memory and claims them in the order of the jobs table
+type jobRepository struct {
+	mu   sync.Mutex
+	jobs []*models.Job
+}
+
+func (repository *jobRepository) Enqueue(job *models.Job) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	job.ID = uint(len(repository.jobs) + 1)
+	repository.jobs = append(repository.jobs, job)
+	return nil
+}
+
+func (repository *jobRepository) Claim(types []string, worker string, now time.Time) (*models.Job, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	var due []*models.Job
+	for _, job := range repository.jobs {
+		if job.Status == models.JobPending && !job.RunAt.After(now) && slices.Contains(types, job.Type) {
+			due = append(due, job)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if due[i].Priority != due[j].Priority {
+			return due[i].Priority > due[j].Priority
+		}
+		return due[i].RunAt.Before(due[j].RunAt)
+	})
+	job := due[0]
+	job.Status, job.Attempts, job.LockedAt, job.LockedBy = models.JobRunning, job.Attempts+1, &now, worker
+	claimed := *job
+	return &claimed, nil
+}
+
+// update changes a job still locked by the worker, like the fenced updates of the jobs table
+func (repository *jobRepository) update(id uint, worker string, update func(job *models.Job)) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	job := repository.jobs[id-1]
+	if job.Status != models.JobRunning || job.LockedBy != worker {
+		return fmt.Errorf("%w: job %d by %s", repositories.ErrJobNotLocked, id, worker)
+	}
+	update(job)
+	job.LockedAt, job.LockedBy = nil, ""
+	return nil
+}
+
+func (repository *jobRepository) Complete(id uint, worker string, now time.Time) error {
+	return repository.update(id, worker, func(job *models.Job) {
+		job.Status, job.CompletedAt, job.LastError = models.JobCompleted, &now, ""
+	})
+}
+
+func (repository *jobRepository) Retry(id uint, worker string, lastError string, runAt time.Time) error {
+	return repository.update(id, worker, func(job *models.Job) {
+		job.Status, job.RunAt, job.LastError = models.JobPending, runAt, lastError
+	})
+}
+
+func (repository *jobRepository) Bury(id uint, worker string, lastError string) error {
+	return repository.update(id, worker, func(job *models.Job) {
+		job.Status, job.LastError = models.JobDead, lastError
+	})
+}
+
+func (repository *jobRepository) RequeueStale(lockedBefore time.Time) (int64, int64, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	var requeued, buried int64
+	for _, job := range repository.jobs {
+		if job.Status != models.JobRunning || !job.LockedAt.Before(lockedBefore) {
+			continue
+		}
+		if job.Attempts >= job.MaxAttempts {
+			job.Status = models.JobDead
+			buried++
+		} else {
+			job.Status = models.JobPending
+			requeued++
+		}
+		job.LockedAt, job.LockedBy = nil, ""
+	}
+	return requeued, buried, nil
+}
+
+func TestJobQueue(t *testing.T) {
+	ctx := context.Background()
+	types := []string{"hash", "thumbnail"}
+
+	t.Run("Jobs are run from the highest priority and completed", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{})
+		var ran []string
+		queue.Handle("hash", func(ctx context.Context, job *models.Job) error {
+			ran = append(ran, job.Payload)
+			return nil
+		})
+		_, err := queue.Enqueue("hash", 1, JobPriorityNormal)
+		require.NoError(t, err)
+		_, err = queue.Enqueue("hash", 2, JobPriorityHigh)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			ran, err := queue.RunNext(ctx, types, "worker")
+			require.NoError(t, err)
+			assert.True(t, ran)
+		}
+		next, err := queue.RunNext(ctx, types, "worker")
+		require.NoError(t, err)
+		assert.False(t, next, "no job is left")
+		assert.Equal(t, []string{"2", "1"}, ran)
+		for _, job := range repository.jobs {
+			assert.Equal(t, models.JobCompleted, job.Status)
+			assert.NotNil(t, job.CompletedAt)
+		}
+	})
+
+	t.Run("Failed jobs are retried with a backoff then moved to the dead letters", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{MaxAttempts: 3, RetryDelay: time.Minute})
+		now := time.Now()
+		queue.now = func() time.Time { return now }
+		queue.Handle("thumbnail", func(ctx context.Context, job *models.Job) error {
+			return errors.New("storage unreachable")
+		})
+		job, err := queue.Enqueue("thumbnail", map[string]uint{"mediaId": 1}, JobPriorityNormal)
+		require.NoError(t, err)
+
+		for attempt, delay := range []time.Duration{time.Minute, 2 * time.Minute} {
+			_, err := queue.RunNext(ctx, types, "worker")
+			require.NoError(t, err)
+			assert.Equal(t, models.JobPending, job.Status, "attempt %d", attempt+1)
+			assert.Equal(t, now.Add(delay), job.RunAt)
+			assert.Equal(t, "storage unreachable", job.LastError)
+
+			ran, err := queue.RunNext(ctx, types, "worker")
+			require.NoError(t, err)
+			assert.False(t, ran, "the job is not due before its backoff")
+			now = job.RunAt
+		}
+		_, err = queue.RunNext(ctx, types, "worker")
+		require.NoError(t, err)
+		assert.Equal(t, models.JobDead, job.Status)
+		assert.Equal(t, 3, job.Attempts)
+	})
+
+	t.Run("Permanent failures and panics do not stop the worker", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{MaxAttempts: 3})
+		queue.Handle("hash", func(ctx context.Context, job *models.Job) error {
+			return fmt.Errorf("%w: not an image", ErrPermanentJobFailure)
+		})
+		queue.Handle("thumbnail", func(ctx context.Context, job *models.Job) error {
+			panic("nil image")
+		})
+		permanent, err := queue.Enqueue("hash", nil, JobPriorityNormal)
+		require.NoError(t, err)
+		panicked, err := queue.Enqueue("thumbnail", nil, JobPriorityNormal)
+		require.NoError(t, err)
+
+		for i := 0; i < 2; i++ {
+			_, err := queue.RunNext(ctx, types, "worker")
+			require.NoError(t, err)
+		}
+		assert.Equal(t, models.JobDead, permanent.Status)
+		assert.Equal(t, 1, permanent.Attempts)
+		assert.Equal(t, models.JobPending, panicked.Status)
+		assert.Contains(t, panicked.LastError, "nil image")
+	})
+
+	t.Run("Jobs left running are requeued then moved to the dead letters", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{MaxAttempts: 2, LockTimeout: time.Minute})
+		now := time.Now()
+		queue.now = func() time.Time { return now }
+		job, err := queue.Enqueue("hash", nil, JobPriorityNormal)
+		require.NoError(t, err)
+
+		for attempt := 1; attempt <= 2; attempt++ {
+			_, err := repository.Claim(types, "worker", now)
+			require.NoError(t, err)
+			now = now.Add(2 * time.Minute)
+			requeued, buried, err := repository.RequeueStale(now.Add(-time.Minute))
+			require.NoError(t, err)
+			assert.Equal(t, attempt, job.Attempts)
+			if attempt < 2 {
+				assert.Equal(t, [2]int64{1, 0}, [2]int64{requeued, buried})
+				assert.Equal(t, models.JobPending, job.Status)
+			} else {
+				assert.Equal(t, [2]int64{0, 1}, [2]int64{requeued, buried})
+				assert.Equal(t, models.JobDead, job.Status)
+			}
+		}
+	})
+
+	t.Run("The result of a job requeued while it was running is dropped", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{MaxAttempts: 3, LockTimeout: time.Minute})
+		now := time.Now()
+		queue.now = func() time.Time { return now }
+		queue.Handle("hash", func(ctx context.Context, job *models.Job) error {
+			// the lock expires and another worker claims the job while it runs
+			now = now.Add(2 * time.Minute)
+			_, _, err := repository.RequeueStale(now.Add(-time.Minute))
+			require.NoError(t, err)
+			_, err = repository.Claim(types, "other-worker", now)
+			require.NoError(t, err)
+			return nil
+		})
+		job, err := queue.Enqueue("hash", nil, JobPriorityNormal)
+		require.NoError(t, err)
+
+		_, err = queue.RunNext(ctx, types, "worker")
+		assert.ErrorIs(t, err, repositories.ErrJobNotLocked)
+		assert.Equal(t, models.JobRunning, job.Status)
+		assert.Equal(t, "other-worker", job.LockedBy)
+	})
+
+	t.Run("Workers run the enqueued jobs until they are stopped", func(t *testing.T) {
+		repository := &jobRepository{}
+		queue := NewJobQueue(repository, JobQueueOptions{Workers: 3, PollInterval: time.Hour})
+		var wg sync.WaitGroup
+		wg.Add(10)
+		queue.Handle("hash", func(ctx context.Context, job *models.Job) error {
+			wg.Done()
+			return nil
+		})
+		runCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			queue.Run(runCtx)
+			close(stopped)
+		}()
+
+		for i := 0; i < 10; i++ {
+			_, err := queue.Enqueue("hash", i, JobPriorityNormal)
+			require.NoError(t, err)
+		}
+		wg.Wait()
+		stop()
+		<-stopped
+		for _, job := range repository.jobs {
+			assert.Equal(t, models.JobCompleted, job.Status)
+		}
+	})
+}
+
+func TestJobBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, jobBackoff(10*time.Second, 1))
+	assert.Equal(t, 40*time.Second, jobBackoff(10*time.Second, 3))
+	assert.Equal(t, maxJobBackoff, jobBackoff(10*time.Second, 20))
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Number of medias loaded at once by the key rotation
+const keyRotationBatchSize = 100
+
+// KeyRotationService re-encrypts with the current key the objects of the medias encrypted with an older key
+// or stored before encryption was enabled
+type KeyRotationService struct {
+	mediaRepository repositories.IMediaRepository
+	storage         IStorageService
+}
+
+func NewKeyRotationService(mediaRepository repositories.IMediaRepository, storage IStorageService) *KeyRotationService {
+	return &KeyRotationService{
+		mediaRepository: mediaRepository,
+		storage:         storage,
+	}
+}
+
+// Run re-encrypts the objects of every media not encrypted with the current key and returns the number of medias
+// re-encrypted. Medias failing to be re-encrypted are logged and retried on the next run.
+func (service *KeyRotationService) Run(ctx context.Context) (int, error) {
+	storage, ok := service.storage.(IEncryptedStorage)
+	if !ok || storage.EncryptionKeyID() == "" {
+		return 0, nil
+	}
+	keyID := storage.EncryptionKeyID()
+	// the objects of every organization are encrypted with the same key
+	ctx = repositories.WithAllTenants(ctx)
+	rotated := 0
+	var lastID uint
+	for {
+		medias, err := service.mediaRepository.FindNotEncryptedWith(ctx, keyID, lastID, keyRotationBatchSize)
+		if err != nil {
+			return rotated, err
+		}
+		for i := range medias {
+			if err := ctx.Err(); err != nil {
+				return rotated, err
+			}
+			lastID = medias[i].ID
+			if err := service.rotate(ctx, storage, &medias[i], keyID); err != nil {
+				log.Printf("unable to re-encrypt media %d: %s\n", medias[i].ID, err)
+				continue
+			}
+			rotated++
+		}
+		if len(medias) < keyRotationBatchSize {
+			return rotated, nil
+		}
+	}
+}
+
+// rotate re-encrypts the original file, renditions and rendered images of a media and records the key
+func (service *KeyRotationService) rotate(ctx context.Context, storage IEncryptedStorage, media *models.Media, keyID string) error {
+	ctx = WithEncryptionKeyID(ctx, media.EncryptionKeyID)
+	objectKeys := []string{media.ObjectKey}
+	for _, renditionKey := range media.Renditions {
+		objectKeys = append(objectKeys, renditionKey)
+	}
+	if media.ObjectKey != "" {
+		derived, err := service.storage.ListObjects(ctx, "derived/"+strings.TrimSuffix(media.ObjectKey, path.Ext(media.ObjectKey))+"/")
+		if err != nil {
+			return err
+		}
+		for _, object := range derived {
+			objectKeys = append(objectKeys, object.Key)
+		}
+	}
+	for _, objectKey := range objectKeys {
+		if objectKey == "" {
+			continue
+		}
+		if err := storage.Reencrypt(ctx, objectKey); err != nil {
+			return err
+		}
+	}
+	if err := service.mediaRepository.UpdateEncryptionKeyID(ctx, media.ID, keyID); err != nil {
+		return err
+	}
+	media.EncryptionKeyID = keyID
+	return nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyRotationRepository keeps medias in memory, only the methods used by the key rotation are implemented
+type keyRotationRepository struct {
+	repositories.IMediaRepository
+	medias []models.Media
+}
+
+func (repository *keyRotationRepository) FindNotEncryptedWith(ctx context.Context, keyID string, afterID uint, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	for _, media := range repository.medias {
+		if media.ID > afterID && media.EncryptionKeyID != keyID && len(medias) < limit {
+			medias = append(medias, media)
+		}
+	}
+	return medias, nil
+}
+
+func (repository *keyRotationRepository) UpdateEncryptionKeyID(ctx context.Context, id uint, keyID string) error {
+	for i := range repository.medias {
+		if repository.medias[i].ID == id {
+			repository.medias[i].EncryptionKeyID = keyID
+		}
+	}
+	return nil
+}
+
+// keyedStorage records the key encrypting each object of a memory storage
+type keyedStorage struct {
+	*MemoryStorage
+	current string
+	keys    map[string]string
+}
+
+func (storage *keyedStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	storage.keys[objectName] = storage.current
+	return storage.MemoryStorage.PutObject(ctx, objectName, reader, size, contentType)
+}
+
+func (storage *keyedStorage) EncryptionKeyID() string {
+	return storage.current
+}
+
+func (storage *keyedStorage) Reencrypt(ctx context.Context, objectName string) error {
+	if _, err := storage.StatObject(ctx, objectName); err != nil {
+		return err
+	}
+	storage.keys[objectName] = storage.current
+	return nil
+}
+
+func TestKeyRotationService(t *testing.T) {
+	ctx := context.Background()
+	storage := &keyedStorage{MemoryStorage: NewMemoryStorage(StorageOptions{}), current: "2024-01", keys: map[string]string{}}
+	require.NoError(t, storage.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+	require.NoError(t, storage.PutObject(ctx, "renditions/goal/thumb.jpg", strings.NewReader("thumb"), 5, "image/jpeg"))
+	require.NoError(t, storage.PutObject(ctx, "derived/goal/0123.jpg", strings.NewReader("small"), 5, "image/jpeg"))
+	storage.current = "2024-06"
+	require.NoError(t, storage.PutObject(ctx, "kickoff.jpg", strings.NewReader("kickoff"), 7, "image/jpeg"))
+	repository := &keyRotationRepository{medias: []models.Media{
+		{
+			ID: 1,
+			MediaFiles: models.MediaFiles{
+				ObjectKey:  "goal.jpg",
+				Renditions: models.RenditionMap{"thumb": "renditions/goal/thumb.jpg"},
+			},
+			EncryptionKeyID: "2024-01",
+		},
+		{ID: 2, MediaFiles: models.MediaFiles{ObjectKey: "kickoff.jpg"}, EncryptionKeyID: "2024-06"},
+		{ID: 3, MediaFiles: models.MediaFiles{ObjectKey: "missing.jpg"}},
+	}}
+	service := NewKeyRotationService(repository, storage)
+
+	rotated, err := service.Run(ctx)
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, rotated)
+	assert.Equal(t, "2024-06", repository.medias[0].EncryptionKeyID)
+	assert.Equal(t, map[string]string{
+		"goal.jpg":                  "2024-06",
+		"renditions/goal/thumb.jpg": "2024-06",
+		"derived/goal/0123.jpg":     "2024-06",
+		"kickoff.jpg":               "2024-06",
+	}, storage.keys)
+	// the objects of media 3 cannot be re-encrypted, it is retried on the next run
+	assert.Equal(t, "", repository.medias[2].EncryptionKeyID)
+}
+
+func TestKeyRotationServiceWithoutEncryption(t *testing.T) {
+	repository := &keyRotationRepository{medias: []models.Media{{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "goal.jpg"}}}}
+	service := NewKeyRotationService(repository, NewMemoryStorage(StorageOptions{}))
+
+	rotated, err := service.Run(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, rotated)
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+)
+
+const defaultMaintenanceInterval = time.Hour
+
+// MaintenanceOptions configures the maintenance of the medias
+type MaintenanceOptions struct {
+	// Delay between two maintenance runs
+	Interval time.Duration
+}
+
+// LoadMaintenanceOptions reads MAINTENANCE_INTERVAL
+func LoadMaintenanceOptions() (MaintenanceOptions, error) {
+	options := MaintenanceOptions{Interval: defaultMaintenanceInterval}
+	if value := config.Config("MAINTENANCE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return options, fmt.Errorf("invalid MAINTENANCE_INTERVAL: %s", value)
+		}
+		options.Interval = interval
+	}
+	return options, nil
+}
+
+// MaintenanceService brings the medias of every organization up to date with the configuration: renditions created
+// with previous presets are regenerated, images uploaded before hashing are hashed and objects encrypted with a
+// previous key are re-encrypted. Medias failing to be updated are retried on the next run.
+type MaintenanceService struct {
+	renditionService   *RenditionService
+	mediaService       *MediaService
+	keyRotationService *KeyRotationService
+	options            MaintenanceOptions
+}
+
+func NewMaintenanceService(renditionService *RenditionService, mediaService *MediaService, keyRotationService *KeyRotationService, options MaintenanceOptions) *MaintenanceService {
+	if options.Interval <= 0 {
+		options.Interval = defaultMaintenanceInterval
+	}
+	return &MaintenanceService{
+		renditionService:   renditionService,
+		mediaService:       mediaService,
+		keyRotationService: keyRotationService,
+		options:            options,
+	}
+}
+
+// Run maintains the medias at startup, then every interval until ctx is done
+func (service *MaintenanceService) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.options.Interval)
+	defer ticker.Stop()
+	for {
+		service.Maintain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain regenerates the stale renditions, computes the missing perceptual hashes and re-encrypts the objects
+// encrypted with a previous key
+func (service *MaintenanceService) Maintain(ctx context.Context) {
+	if err := service.renditionService.RegenerateStale(ctx); err != nil {
+		log.Printf("unable to regenerate renditions: %s\n", err)
+	}
+	if err := service.mediaService.BackfillPerceptualHashes(ctx); err != nil {
+		log.Printf("unable to compute perceptual hashes: %s\n", err)
+	}
+	if rotated, err := service.keyRotationService.Run(ctx); err != nil {
+		log.Printf("unable to re-encrypt medias: %s\n", err)
+	} else if rotated > 0 {
+		log.Printf("%d media(s) re-encrypted with the current key\n", rotated)
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+)
+
+// maintenanceRepository counts the lookups of the medias to maintain, it has none
+type maintenanceRepository struct {
+	repositories.IMediaRepository
+	staleRenditions atomic.Int32
+	missingHashes   atomic.Int32
+}
+
+func (repository *maintenanceRepository) FindWithStaleRenditions(ctx context.Context, version string) ([]models.Media, error) {
+	repository.staleRenditions.Add(1)
+	return nil, nil
+}
+
+func (repository *maintenanceRepository) FindWithoutPerceptualHash(ctx context.Context) ([]models.Media, error) {
+	repository.missingHashes.Add(1)
+	return nil, nil
+}
+
+func TestMaintenanceServiceRunsPeriodically(t *testing.T) {
+	repository := &maintenanceRepository{}
+	storage := NewMemoryStorage(StorageOptions{})
+	service := NewMaintenanceService(
+		NewRenditionService(repository, storage, nil),
+		NewMediaService(repository, nil, storage, nil, nil, nil, nil, nil),
+		NewKeyRotationService(repository, storage),
+		MaintenanceOptions{Interval: 10 * time.Millisecond},
+	)
+	ctx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		service.Run(ctx)
+		close(stopped)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return repository.staleRenditions.Load() >= 3 && repository.missingHashes.Load() >= 3
+	}, time.Second, 5*time.Millisecond, "the medias are maintained at startup then every interval")
+	stop()
+	<-stopped
+}
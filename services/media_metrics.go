@@ -0,0 +1,24 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Values of the result label of the upload metrics
+const (
+	uploadSucceeded = "success"
+	uploadFailed    = "failure"
+)
+
+var (
+	mediaUploadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "media_upload_bytes_total",
+		Help: "Number of bytes of the media files uploaded to the storage.",
+	})
+	mediaUploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "media_upload_duration_seconds",
+		Help:    "Duration of the uploads of media files to the storage, by result: success or failure.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"result"})
+)
@@ -2,52 +2,633 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/mich31/scoreplay-media-api/imaging"
 	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/mp4"
 	"github.com/mich31/scoreplay-media-api/repositories"
 )
 
+// Maximum number of different bits between the perceptual hashes of two near-duplicate images
+const DefaultSimilarityDistance = 10
+
+// Type of the jobs processing the file of a media
+const JobProcessMedia = "process-media"
+
+var (
+	ErrInvalidFocalPoint = errors.New("invalid focal point")
+	ErrNoPerceptualHash  = errors.New("media has no perceptual hash")
+	ErrMediaNotFailed    = errors.New("media processing has not failed")
+)
+
 type MediaService struct {
 	mediaRepository repositories.IMediaRepository
 	tagRepository   repositories.ITagRepository // TODO
 	storage         IStorageService
+	renditions      *RenditionService
+	replication     *ReplicationService
+	// medias are processed by the workers of the queue when set, otherwise while they are uploaded
+	jobs *JobQueue
+	// uploads are checked against the quotas of their organization and counted when set
+	usage *UsageService
+	// changes made by callers are recorded in the audit log when set
+	audit *AuditService
 }
 
-func NewMediaService(mediaRepository repositories.IMediaRepository, tagRepository repositories.ITagRepository, storageService IStorageService) *MediaService {
+func NewMediaService(mediaRepository repositories.IMediaRepository, tagRepository repositories.ITagRepository, storageService IStorageService, renditionService *RenditionService, replicationService *ReplicationService, jobQueue *JobQueue, usageService *UsageService, auditService *AuditService) *MediaService {
 	return &MediaService{
 		mediaRepository: mediaRepository,
 		tagRepository:   tagRepository,
 		storage:         storageService,
+		renditions:      renditionService,
+		replication:     replicationService,
+		jobs:            jobQueue,
+		usage:           usageService,
+		audit:           auditService,
 	}
 }
 
 func (service *MediaService) CreateMedia(ctx context.Context, name string, tagIDs []uint, file *multipart.FileHeader) (uint, error) {
-	fileUrl, err := service.storage.UploadObject(ctx, file)
-	if err != nil {
-		return 0, err
-	}
-	fmt.Printf("File uploaded at: %s\n", fileUrl)
+	// the media is created first so that names already taken are rejected before uploading
 	media := &models.Media{
-		Name:     name,
-		FileUrl:  fileUrl,
-		FileSize: file.Size,
+		Name:        name,
+		FileName:    file.Filename,
+		FileSize:    file.Size,
+		ContentType: contentType(file),
+		Processing:  models.Processing{Status: models.MediaUploading},
 	}
-	id, err := service.mediaRepository.Create(media, tagIDs)
+	if identity := IdentityFromContext(ctx); identity != nil {
+		media.UploadedBy = identity.Subject
+	}
+	if service.usage != nil {
+		if err := service.usage.Reserve(ctx, media.FileSize); err != nil {
+			fmt.Printf("unable to upload media %s: %s\n", name, err.Error())
+			return 0, err
+		}
+	}
+	id, err := service.mediaRepository.Create(ctx, media, tagIDs)
 	if err != nil {
 		fmt.Printf("unable to create media %s: %s\n", name, err.Error())
+		service.releaseUsage(ctx, media)
 		return 0, err
 	}
 	fmt.Printf("Media %s created\n", name)
+
+	start := time.Now()
+	objectKey, err := service.storage.UploadObject(ctx, file)
+	if err != nil {
+		mediaUploadDuration.WithLabelValues(uploadFailed).Observe(time.Since(start).Seconds())
+		// nothing can be processed without the file, the name is released for another upload
+		if deleteErr := service.mediaRepository.Purge(ctx, media.ID); deleteErr != nil {
+			fmt.Printf("unable to delete media %s: %s\n", name, deleteErr.Error())
+		}
+		service.releaseUsage(ctx, media)
+		return 0, err
+	}
+	mediaUploadDuration.WithLabelValues(uploadSucceeded).Observe(time.Since(start).Seconds())
+	mediaUploadBytes.Add(float64(file.Size))
+	fmt.Printf("File uploaded as: %s\n", objectKey)
+	service.recordUsage(ctx, media, tagIDs)
+	media.ObjectKey = objectKey
+	if err := service.mediaRepository.UpdateObjectKey(ctx, media.ID, objectKey, nil); err != nil {
+		return 0, err
+	}
+	if media.EncryptionKeyID = storageEncryptionKeyID(service.storage); media.EncryptionKeyID != "" {
+		if err := service.mediaRepository.UpdateEncryptionKeyID(ctx, media.ID, media.EncryptionKeyID); err != nil {
+			return 0, err
+		}
+	}
+	service.audit.Record(ctx, models.AuditCreate, models.AuditMedia, auditID(media.ID), nil, media)
+	for _, tagID := range tagIDs {
+		association := map[string]uint{"mediaId": media.ID, "tagId": tagID}
+		service.audit.Record(ctx, models.AuditCreate, models.AuditMediaTag, auditID(media.ID)+":"+auditID(tagID), nil, association)
+	}
+
+	if service.jobs != nil {
+		service.enqueueProcessing(ctx, media, JobPriorityNormal)
+		return id, nil
+	}
+	service.process(ctx, media, func() (io.ReadSeekCloser, error) { return file.Open() })
 	return id, nil
 }
 
-func (service *MediaService) GetMediasByTag(tag string) ([]models.MediaWithTagNames, error) {
-	medias, err := service.mediaRepository.FindByTag(tag)
+// releaseUsage removes a media which could not be uploaded from the usage of its organization
+func (service *MediaService) releaseUsage(ctx context.Context, media *models.Media) {
+	if service.usage == nil {
+		return
+	}
+	if err := service.usage.Release(ctx, media.FileSize); err != nil {
+		fmt.Printf("unable to release usage of media %s: %s\n", media.Name, err.Error())
+	}
+}
+
+// recordUsage adds an uploaded media to the usage counters of its organization, by tag name and content type
+func (service *MediaService) recordUsage(ctx context.Context, media *models.Media, tagIDs []uint) {
+	if service.usage == nil {
+		return
+	}
+	tags, err := service.tagRepository.Find(ctx)
+	if err != nil {
+		fmt.Printf("unable to count usage of media %d: %s\n", media.ID, err.Error())
+		return
+	}
+	var tagNames []string
+	for _, tag := range tags {
+		if slices.Contains(tagIDs, tag.ID) {
+			tagNames = append(tagNames, tag.Name)
+		}
+	}
+	if err := service.usage.Record(ctx, media, tagNames); err != nil {
+		fmt.Printf("unable to count usage of media %d: %s\n", media.ID, err.Error())
+	}
+}
+
+// RetryProcessing processes again a media whose processing failed, from its stored file
+func (service *MediaService) RetryProcessing(ctx context.Context, id string) (*models.Media, error) {
+	media, err := service.mediaRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if media.Status != models.MediaFailed {
+		return nil, fmt.Errorf("%w: media %d is %s", ErrMediaNotFailed, media.ID, media.Status)
+	}
+	before := *media
+	if service.jobs != nil {
+		service.enqueueProcessing(ctx, media, JobPriorityHigh)
+	} else {
+		service.process(ctx, media, func() (io.ReadSeekCloser, error) { return service.openStoredFile(ctx, media) })
+	}
+	service.audit.Record(ctx, models.AuditUpdate, models.AuditMedia, auditID(media.ID), &before, media)
+	if err := service.presign(ctx, &media.MediaFiles); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// ProcessMediaPayload is the payload of JobProcessMedia jobs
+type ProcessMediaPayload struct {
+	MediaID        uint `json:"mediaId"`
+	OrganizationID uint `json:"organizationId"`
+}
+
+// enqueueProcessing marks a media as processing and enqueues its processing. The media is processed
+// at once when the job cannot be enqueued.
+func (service *MediaService) enqueueProcessing(ctx context.Context, media *models.Media, priority int) {
+	if err := service.transition(ctx, media, models.MediaProcessing, ""); err != nil {
+		fmt.Printf("unable to update status of media %d: %s\n", media.ID, err.Error())
+	}
+	payload := ProcessMediaPayload{MediaID: media.ID, OrganizationID: media.OrganizationID}
+	if _, err := service.jobs.Enqueue(JobProcessMedia, payload, priority); err != nil {
+		fmt.Printf("unable to enqueue processing of media %d: %s\n", media.ID, err.Error())
+		ctx := context.WithoutCancel(ctx)
+		service.process(ctx, media, func() (io.ReadSeekCloser, error) { return service.openStoredFile(ctx, media) })
+	}
+}
+
+// HandleProcessMedia processes a media from its stored file, it is the handler of JobProcessMedia jobs.
+// A failed processing is returned to be retried by the queue.
+func (service *MediaService) HandleProcessMedia(ctx context.Context, job *models.Job) error {
+	var payload ProcessMediaPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: %w", ErrPermanentJobFailure, err)
+	}
+	if payload.OrganizationID != 0 {
+		ctx = repositories.WithTenant(ctx, payload.OrganizationID)
+	} else {
+		// jobs enqueued before organizations
+		ctx = repositories.WithAllTenants(ctx)
+	}
+	media, err := service.mediaRepository.FindByID(ctx, strconv.FormatUint(uint64(payload.MediaID), 10))
+	if errors.Is(err, repositories.ErrMediaNotFound) {
+		return fmt.Errorf("%w: %w", ErrPermanentJobFailure, err)
+	}
+	if err != nil {
+		return err
+	}
+	return service.process(ctx, media, func() (io.ReadSeekCloser, error) { return service.openStoredFile(ctx, media) })
+}
+
+// process extracts the metadata of the file of a media and generates its renditions, then marks the media
+// ready or failed with the reason of the failure, which is returned
+func (service *MediaService) process(ctx context.Context, media *models.Media, open func() (io.ReadSeekCloser, error)) error {
+	if err := service.transition(ctx, media, models.MediaProcessing, ""); err != nil {
+		fmt.Printf("unable to update status of media %d: %s\n", media.ID, err.Error())
+	}
+	processErr := service.processFile(ctx, media, open)
+	var err error
+	if processErr != nil {
+		fmt.Printf("unable to process media %s: %s\n", media.Name, processErr.Error())
+		err = service.transition(ctx, media, models.MediaFailed, processErr.Error())
+	} else {
+		err = service.transition(ctx, media, models.MediaReady, "")
+	}
 	if err != nil {
+		fmt.Printf("unable to update status of media %d: %s\n", media.ID, err.Error())
+	}
+	service.replicate(ctx, media)
+	return processErr
+}
+
+func (service *MediaService) processFile(ctx context.Context, media *models.Media, open func() (io.ReadSeekCloser, error)) error {
+	if !imaging.IsImage(media.ContentType) && !isMP4(media.ContentType) {
+		return nil
+	}
+	reader, err := open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if imaging.IsImage(media.ContentType) {
+		return service.processImage(ctx, media, reader)
+	}
+	return service.processVideo(ctx, media, reader)
+}
+
+// transition moves a media to a processing status and records when
+func (service *MediaService) transition(ctx context.Context, media *models.Media, status string, failureReason string) error {
+	now := time.Now()
+	media.Status, media.FailureReason = status, failureReason
+	switch status {
+	case models.MediaProcessing:
+		if media.UploadedAt == nil {
+			media.UploadedAt = &now
+		}
+		media.ProcessingStartedAt = &now
+	case models.MediaReady:
+		media.ReadyAt = &now
+	case models.MediaFailed:
+		media.FailedAt = &now
+	}
+	return service.mediaRepository.UpdateProcessing(ctx, media.ID, media.Processing)
+}
+
+// openStoredFile copies the stored file of a media to a temporary file, removed once closed
+func (service *MediaService) openStoredFile(ctx context.Context, media *models.Media) (io.ReadSeekCloser, error) {
+	object, err := service.storage.GetObject(WithEncryptionKeyID(ctx, media.EncryptionKeyID), media.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	file, err := os.CreateTemp("", "media-*")
+	if err != nil {
+		return nil, err
+	}
+	temporary := &temporaryFile{file}
+	if _, err := io.Copy(file, object); err != nil {
+		temporary.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		temporary.Close()
 		return nil, err
 	}
+	return temporary, nil
+}
+
+// temporaryFile is removed when it is closed
+type temporaryFile struct {
+	*os.File
+}
+
+func (file *temporaryFile) Close() error {
+	err := file.File.Close()
+	os.Remove(file.Name())
+	return err
+}
+
+// replicate copies the objects of a media to the secondary storage in the background, when replication is enabled
+func (service *MediaService) replicate(ctx context.Context, media *models.Media) {
+	if service.replication == nil {
+		return
+	}
+	if err := service.replication.Enqueue(ctx, media); err != nil {
+		fmt.Printf("unable to enqueue replication of media %d: %s\n", media.ID, err.Error())
+	}
+}
+
+// processVideo reads the metadata of an MP4 or MOV video from its container
+func (service *MediaService) processVideo(ctx context.Context, media *models.Media, reader io.ReadSeeker) error {
+	metadata, err := mp4.Parse(reader)
+	if err != nil {
+		return err
+	}
+
+	media.VideoMetadata = videoMetadata(metadata)
+	return service.mediaRepository.UpdateVideoMetadata(ctx, media.ID, media.VideoMetadata)
+}
+
+func isMP4(contentType string) bool {
+	switch contentType {
+	case "video/mp4", "video/quicktime", "video/x-m4v", "audio/mp4":
+		return true
+	}
+	return false
+}
+
+func videoMetadata(metadata *mp4.Metadata) models.VideoMetadata {
+	result := models.VideoMetadata{
+		Duration:   &metadata.Duration,
+		FrameRate:  &metadata.FrameRate,
+		VideoCodec: metadata.VideoCodec,
+		AudioCodec: metadata.AudioCodec,
+		HasAudio:   &metadata.HasAudio,
+	}
+	if metadata.Width > 0 && metadata.Height > 0 {
+		result.Width, result.Height = &metadata.Width, &metadata.Height
+	}
+	if metadata.VideoCodec == "" {
+		result.FrameRate = nil
+	}
+	if !metadata.CreatedAt.IsZero() {
+		result.RecordedAt = &metadata.CreatedAt
+	}
+	return result
+}
+
+// processImage computes the perceptual hash of an image and generates its renditions
+func (service *MediaService) processImage(ctx context.Context, media *models.Media, reader io.Reader) error {
+	img, format, err := imaging.Decode(reader)
+	if err != nil {
+		return err
+	}
+
+	hash := int64(imaging.DHash(img))
+	if err := service.mediaRepository.UpdatePerceptualHash(ctx, media.ID, hash); err != nil {
+		return err
+	}
+	media.PerceptualHash = &hash
+
+	if service.renditions == nil {
+		return nil
+	}
+	return service.renditions.Generate(ctx, media, img, format)
+}
+
+// contentType returns the mime type sent by the client, falling back on the file extension
+func contentType(file *multipart.FileHeader) string {
+	if value := file.Header.Get("Content-Type"); value != "" && value != "application/octet-stream" {
+		return value
+	}
+	if value := mime.TypeByExtension(filepath.Ext(file.Filename)); value != "" {
+		return strings.Split(value, ";")[0]
+	}
+	return "application/octet-stream"
+}
+
+func (service *MediaService) GetMedias(ctx context.Context, filter repositories.MediaFilter) ([]models.MediaWithTagNames, error) {
+	medias, err := service.mediaRepository.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range medias {
+		if err := service.presign(ctx, &medias[i].MediaFiles); err != nil {
+			return nil, err
+		}
+	}
 
 	return medias, nil
 }
+
+// GetMediaContent returns a media and the metadata of its stored file
+func (service *MediaService) GetMediaContent(ctx context.Context, id string) (*models.Media, ObjectInfo, error) {
+	media, err := service.mediaRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	info, err := service.storage.StatObject(WithEncryptionKeyID(ctx, media.EncryptionKeyID), media.ObjectKey)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	if info.ContentType == "" || info.ContentType == "application/octet-stream" {
+		info.ContentType = media.ContentType
+	}
+	return media, info, nil
+}
+
+// ReadMediaContent reads length bytes of the stored file of a media starting at offset
+func (service *MediaService) ReadMediaContent(ctx context.Context, media *models.Media, offset int64, length int64) (io.ReadCloser, error) {
+	return service.storage.GetObjectRange(WithEncryptionKeyID(ctx, media.EncryptionKeyID), media.ObjectKey, offset, length)
+}
+
+// CountDownload increments the download counter of a media
+func (service *MediaService) CountDownload(ctx context.Context, media *models.Media) error {
+	return service.mediaRepository.IncrementDownloadCount(ctx, media.ID)
+}
+
+// DeleteMedia moves a media to the trash, it is purged with its files after the retention of the trash
+func (service *MediaService) DeleteMedia(ctx context.Context, id string) error {
+	media, err := service.mediaRepository.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := service.mediaRepository.Delete(ctx, media.ID); err != nil {
+		return err
+	}
+	service.audit.Record(ctx, models.AuditDelete, models.AuditMedia, auditID(media.ID), media, nil)
+	return nil
+}
+
+// RestoreMedia moves a media out of the trash, with its tags
+func (service *MediaService) RestoreMedia(ctx context.Context, id string) (*models.Media, error) {
+	media, err := service.mediaRepository.Restore(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	service.audit.Record(ctx, models.AuditRestore, models.AuditMedia, auditID(media.ID), nil, media)
+	if err := service.presign(ctx, &media.MediaFiles); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// SetFocalPoint saves the focal point and crop boxes of a media and regenerates its renditions
+func (service *MediaService) SetFocalPoint(ctx context.Context, id string, focalPoint models.FocalPoint) (*models.Media, error) {
+	if err := validateFocalPoint(focalPoint); err != nil {
+		return nil, err
+	}
+	return service.updateFocalPoint(ctx, id, focalPoint)
+}
+
+// ClearFocalPoint removes the focal point and crop boxes of a media
+func (service *MediaService) ClearFocalPoint(ctx context.Context, id string) (*models.Media, error) {
+	return service.updateFocalPoint(ctx, id, models.FocalPoint{})
+}
+
+func (service *MediaService) updateFocalPoint(ctx context.Context, id string, focalPoint models.FocalPoint) (*models.Media, error) {
+	media, err := service.mediaRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.mediaRepository.UpdateFocalPoint(ctx, media.ID, focalPoint); err != nil {
+		return nil, err
+	}
+	before := *media
+	media.FocalX, media.FocalY, media.Crops = focalPoint.X, focalPoint.Y, focalPoint.Crops
+	service.audit.Record(ctx, models.AuditUpdate, models.AuditMedia, auditID(media.ID), &before, media)
+
+	if service.renditions != nil && imaging.IsImage(media.ContentType) {
+		if err := service.renditions.Regenerate(ctx, media); err != nil {
+			fmt.Printf("unable to regenerate renditions for media %d: %s\n", media.ID, err.Error())
+		}
+		service.replicate(ctx, media)
+	}
+	if err := service.presign(ctx, &media.MediaFiles); err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+func validateFocalPoint(focalPoint models.FocalPoint) error {
+	if (focalPoint.X == nil) != (focalPoint.Y == nil) {
+		return fmt.Errorf("%w: x and y must be set together", ErrInvalidFocalPoint)
+	}
+	if focalPoint.X != nil && (*focalPoint.X < 0 || *focalPoint.X > 1 || *focalPoint.Y < 0 || *focalPoint.Y > 1) {
+		return fmt.Errorf("%w: x and y must be between 0 and 1", ErrInvalidFocalPoint)
+	}
+	for aspect, box := range focalPoint.Crops {
+		if _, err := parseAspectRatio(aspect); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidFocalPoint, err)
+		}
+		if box.X < 0 || box.Y < 0 || box.Width <= 0 || box.Height <= 0 || box.X+box.Width > 1 || box.Y+box.Height > 1 {
+			return fmt.Errorf("%w: crop box %s must be inside the image", ErrInvalidFocalPoint, aspect)
+		}
+	}
+	return nil
+}
+
+// GetSimilarMedias returns the medias whose perceptual hash is within distance bits of the hash of a media
+func (service *MediaService) GetSimilarMedias(ctx context.Context, id string, distance int) ([]models.SimilarMedia, error) {
+	media, err := service.mediaRepository.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if media.PerceptualHash == nil {
+		return nil, fmt.Errorf("%w: %d", ErrNoPerceptualHash, media.ID)
+	}
+	medias, err := service.mediaRepository.FindSimilar(ctx, media.ID, *media.PerceptualHash, distance)
+	if err != nil {
+		return nil, err
+	}
+	for i := range medias {
+		if err := service.presign(ctx, &medias[i].MediaFiles); err != nil {
+			return nil, err
+		}
+	}
+	return medias, nil
+}
+
+// GetDuplicatesByTag groups the medias associated to a tag whose perceptual hashes are within distance bits.
+// Medias without near-duplicates are not returned.
+func (service *MediaService) GetDuplicatesByTag(ctx context.Context, tag string, distance int) ([][]models.Media, error) {
+	medias, err := service.mediaRepository.FindHashesByTag(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// union-find of medias within the distance
+	parents := make([]int, len(medias))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	for i := range medias {
+		for j := i + 1; j < len(medias); j++ {
+			if imaging.HammingDistance(uint64(*medias[i].PerceptualHash), uint64(*medias[j].PerceptualHash)) <= distance {
+				parents[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := map[int][]models.Media{}
+	var roots []int
+	for i, media := range medias {
+		root := find(i)
+		if _, found := groups[root]; !found {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], media)
+	}
+	duplicates := [][]models.Media{}
+	for _, root := range roots {
+		if len(groups[root]) < 2 {
+			continue
+		}
+		for i := range groups[root] {
+			if err := service.presign(ctx, &groups[root][i].MediaFiles); err != nil {
+				return nil, err
+			}
+		}
+		duplicates = append(duplicates, groups[root])
+	}
+	return duplicates, nil
+}
+
+// BackfillPerceptualHashes computes the perceptual hash of the images of every organization uploaded before
+// hashing was available
+func (service *MediaService) BackfillPerceptualHashes(ctx context.Context) error {
+	ctx = repositories.WithAllTenants(ctx)
+	medias, err := service.mediaRepository.FindWithoutPerceptualHash(ctx)
+	if err != nil {
+		return err
+	}
+	for _, media := range medias {
+		object, err := service.storage.GetObject(WithEncryptionKeyID(ctx, media.EncryptionKeyID), media.ObjectKey)
+		if err != nil {
+			fmt.Printf("unable to fetch media %d: %s\n", media.ID, err.Error())
+			continue
+		}
+		img, _, err := imaging.Decode(object)
+		object.Close()
+		if err != nil {
+			fmt.Printf("unable to decode media %d: %s\n", media.ID, err.Error())
+			continue
+		}
+		if err := service.mediaRepository.UpdatePerceptualHash(ctx, media.ID, int64(imaging.DHash(img))); err != nil {
+			fmt.Printf("unable to save perceptual hash of media %d: %s\n", media.ID, err.Error())
+		}
+	}
+	return nil
+}
+
+// presign sets the time-limited urls of the original file and renditions of a media
+func (service *MediaService) presign(ctx context.Context, files *models.MediaFiles) error {
+	if files.ObjectKey == "" {
+		return nil
+	}
+	fileUrl, err := service.storage.PresignedGetObject(ctx, files.ObjectKey)
+	if err != nil {
+		return err
+	}
+	files.FileUrl = fileUrl
+
+	if len(files.Renditions) == 0 {
+		return nil
+	}
+	files.RenditionUrls = models.RenditionMap{}
+	for size, objectKey := range files.Renditions {
+		renditionUrl, err := service.storage.PresignedGetObject(ctx, objectKey)
+		if err != nil {
+			return err
+		}
+		files.RenditionUrls[size] = renditionUrl
+	}
+	return nil
+}
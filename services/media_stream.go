@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultStreamHistory      = time.Hour
+	defaultStreamPollInterval = 10 * time.Second
+	// Number of events read at once from the outbox
+	streamBatchSize = 500
+	// Maximum number of events sent again to a subscriber resuming the stream
+	maxStreamBacklog = 1000
+	// Number of events waiting to be sent to a subscriber, slower subscribers are disconnected
+	streamBufferSize = 256
+	// Delay between two prunings of the history
+	streamPruneInterval = time.Minute
+	// Outbox ids are allocated when the events are inserted but the events are only read once their transaction is
+	// committed, possibly after events with greater ids: events are read again for this window to catch the late ones
+	streamReorderWindow = 30 * time.Second
+)
+
+// StreamEvents lists the types of events sent by the live feed of medias
+var StreamEvents = []string{models.WebhookMediaCreated, models.WebhookMediaUpdated}
+
+// EventListener returns when events may have been added to the outbox
+type EventListener interface {
+	Wait(ctx context.Context) error
+}
+
+// MediaStreamOptions configures the live feed of medias
+type MediaStreamOptions struct {
+	// Duration the events are kept to resume the feed with Last-Event-ID
+	History time.Duration
+	// Delay between two reads of the outbox when no notification is received
+	PollInterval time.Duration
+}
+
+// LoadMediaStreamOptions reads STREAM_HISTORY and STREAM_POLL_INTERVAL
+func LoadMediaStreamOptions() (MediaStreamOptions, error) {
+	options := MediaStreamOptions{
+		History:      defaultStreamHistory,
+		PollInterval: defaultStreamPollInterval,
+	}
+	durations := map[string]*time.Duration{
+		"STREAM_HISTORY":       &options.History,
+		"STREAM_POLL_INTERVAL": &options.PollInterval,
+	}
+	for key, target := range durations {
+		if value := config.Config(key); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return options, fmt.Errorf("invalid %s: %s", key, value)
+			}
+			*target = duration
+		}
+	}
+	return options, nil
+}
+
+// MediaEvent is a creation or an update of a media sent by the live feed
+type MediaEvent struct {
+	ID             uint
+	Type           string
+	OrganizationID uint
+	TagIDs         []uint
+	// JSON representation of the media
+	Data json.RawMessage
+}
+
+// MediaSubscription receives the events of the medias of an organization with one of its tags
+type MediaSubscription struct {
+	organizationID uint
+	tagIDs         []uint
+	// Events added after the Last-Event-ID of the subscriber, and within the reorder window before it, to send
+	// before Events. Events can be both in Backlog and in Events.
+	Backlog []MediaEvent
+	// Closed when the subscriber is too slow or when the stream stops
+	Events <-chan MediaEvent
+	events chan MediaEvent
+	stream *MediaStream
+}
+
+// matches reports whether an event is sent to the subscription
+func (subscription *MediaSubscription) matches(event MediaEvent) bool {
+	if event.OrganizationID != subscription.organizationID {
+		return false
+	}
+	if len(subscription.tagIDs) == 0 {
+		return true
+	}
+	for _, tagID := range event.TagIDs {
+		if slices.Contains(subscription.tagIDs, tagID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the subscription
+func (subscription *MediaSubscription) Close() {
+	subscription.stream.unsubscribe(subscription)
+}
+
+// MediaStream sends the creations and updates of medias recorded in the outbox to the subscribers of the instance.
+// Every instance reads the outbox when Postgres notifies it of new events, so that the subscribers of every instance
+// receive every event.
+type MediaStream struct {
+	repository repositories.IEventRepository
+	// notifications of new events, the outbox is only read every poll interval when nil
+	listener      EventListener
+	options       MediaStreamOptions
+	mu            sync.Mutex
+	subscriptions map[*MediaSubscription]struct{}
+	// every event up to floor was read or given up, the events after it are read again
+	floor uint
+	// time the events read after floor were first read
+	seen map[uint]time.Time
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewMediaStream(repository repositories.IEventRepository, listener EventListener, options MediaStreamOptions) *MediaStream {
+	if options.History <= 0 {
+		options.History = defaultStreamHistory
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultStreamPollInterval
+	}
+	return &MediaStream{
+		repository:    repository,
+		listener:      listener,
+		options:       options,
+		subscriptions: map[*MediaSubscription]struct{}{},
+		seen:          map[uint]time.Time{},
+		now:           time.Now,
+	}
+}
+
+// Subscribe returns a subscription to the events of the medias of the organization of ctx having one of tagIDs, of
+// every media when tagIDs is empty. When lastEventID is set, the events added after it within the history of the
+// stream are returned in its backlog, with the events added within the reorder window before it which may have
+// been committed after it.
+func (stream *MediaStream) Subscribe(ctx context.Context, tagIDs []uint, lastEventID uint) (*MediaSubscription, error) {
+	organizationID, found := repositories.TenantFromContext(ctx)
+	if !found {
+		return nil, repositories.ErrMissingTenant
+	}
+	events := make(chan MediaEvent, streamBufferSize)
+	subscription := &MediaSubscription{
+		organizationID: organizationID,
+		tagIDs:         tagIDs,
+		Events:         events,
+		events:         events,
+		stream:         stream,
+	}
+	// the subscription receives the new events while its backlog is read, the subscriber skips the events received twice
+	stream.mu.Lock()
+	stream.subscriptions[subscription] = struct{}{}
+	stream.mu.Unlock()
+	if lastEventID == 0 {
+		return subscription, nil
+	}
+	outboxEvents, err := stream.repository.FindSince(ctx, lastEventID, streamReorderWindow, StreamEvents, maxStreamBacklog)
+	if err != nil {
+		subscription.Close()
+		return nil, err
+	}
+	for _, outboxEvent := range outboxEvents {
+		if event := mediaEvent(outboxEvent); subscription.matches(event) {
+			subscription.Backlog = append(subscription.Backlog, event)
+		}
+	}
+	return subscription, nil
+}
+
+func (stream *MediaStream) unsubscribe(subscription *MediaSubscription) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if _, found := stream.subscriptions[subscription]; found {
+		delete(stream.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Run sends the new events of the outbox to the subscribers and prunes the history until ctx is done, then closes
+// every subscription
+func (stream *MediaStream) Run(ctx context.Context) {
+	ctx = repositories.WithAllTenants(ctx)
+	defer stream.closeAll()
+	notifications := make(chan struct{}, 1)
+	if stream.listener != nil {
+		go stream.listen(ctx, notifications)
+	}
+	ticker := time.NewTicker(stream.options.PollInterval)
+	defer ticker.Stop()
+
+	// only the events added from now on are sent, subscribers resume the older ones from the history
+	lastID, err := stream.repository.LastID(ctx)
+	for err != nil {
+		log.Printf("unable to read the outbox: %s\n", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lastID, err = stream.repository.LastID(ctx)
+	}
+	stream.floor = lastID
+	var prunedAt time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-notifications:
+		}
+		if err := stream.Broadcast(ctx); err != nil {
+			log.Printf("unable to send the events of medias: %s\n", err)
+		}
+		if now := stream.now(); now.Sub(prunedAt) >= streamPruneInterval {
+			prunedAt = now
+			if _, err := stream.repository.Prune(ctx, now.Add(-stream.options.History)); err != nil {
+				log.Printf("unable to prune the history of events: %s\n", err)
+			}
+		}
+	}
+}
+
+// listen forwards the notifications of the listener until ctx is done, waiting a poll interval after each error
+func (stream *MediaStream) listen(ctx context.Context, notifications chan<- struct{}) {
+	for ctx.Err() == nil {
+		err := stream.listener.Wait(ctx)
+		select {
+		case notifications <- struct{}{}:
+		default:
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("unable to listen to the outbox: %s\n", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(stream.options.PollInterval):
+			}
+		}
+	}
+}
+
+// Broadcast sends the events not sent yet to the subscribers. The events of the reorder window are read again, so
+// that events committed after events with greater ids are sent too, once. Subscribers whose buffer is full are
+// disconnected, to resume the stream from the history. It is not safe for concurrent use.
+func (stream *MediaStream) Broadcast(ctx context.Context) error {
+	now := stream.now()
+	cursor := stream.floor
+	for {
+		outboxEvents, err := stream.repository.FindAfter(ctx, cursor, StreamEvents, streamBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, outboxEvent := range outboxEvents {
+			if _, sent := stream.seen[outboxEvent.ID]; !sent {
+				stream.seen[outboxEvent.ID] = now
+				stream.publish(mediaEvent(outboxEvent))
+			}
+			cursor = outboxEvent.ID
+		}
+		if len(outboxEvents) < streamBatchSize {
+			break
+		}
+	}
+	// events missing before the events read for longer than the window are not expected anymore
+	for id, seenAt := range stream.seen {
+		if id > stream.floor && now.Sub(seenAt) >= streamReorderWindow {
+			stream.floor = id
+		}
+	}
+	for id := range stream.seen {
+		if id <= stream.floor {
+			delete(stream.seen, id)
+		}
+	}
+	return nil
+}
+
+func (stream *MediaStream) publish(event MediaEvent) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	for subscription := range stream.subscriptions {
+		if !subscription.matches(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			delete(stream.subscriptions, subscription)
+			close(subscription.events)
+		}
+	}
+}
+
+func (stream *MediaStream) closeAll() {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	for subscription := range stream.subscriptions {
+		delete(stream.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// mediaEvent reads the tags of the media of an event of the outbox
+func mediaEvent(outboxEvent models.OutboxEvent) MediaEvent {
+	event := MediaEvent{
+		ID:             outboxEvent.ID,
+		Type:           outboxEvent.Type,
+		OrganizationID: outboxEvent.OrganizationID,
+		Data:           outboxEvent.Payload,
+	}
+	var media models.Media
+	if err := json.Unmarshal(outboxEvent.Payload, &media); err == nil {
+		for _, tag := range media.Tags {
+			event.TagIDs = append(event.TagIDs, tag.ID)
+		}
+	}
+	return event
+}
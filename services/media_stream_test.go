@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventRepository keeps the outbox in memory
+type eventRepository struct {
+	repositories.IEventRepository
+	events []models.OutboxEvent
+}
+
+func (repository *eventRepository) add(organizationID uint, eventType string, media models.Media) {
+	repository.commit(uint(len(repository.events)+1), organizationID, eventType, media)
+}
+
+// commit makes an event visible, events can be committed in another order than their ids
+func (repository *eventRepository) commit(id uint, organizationID uint, eventType string, media models.Media) {
+	payload, _ := json.Marshal(media)
+	repository.events = append(repository.events, models.OutboxEvent{
+		ID: id, OrganizationID: organizationID, Type: eventType, Payload: payload,
+	})
+	slices.SortFunc(repository.events, func(a, b models.OutboxEvent) int { return int(a.ID) - int(b.ID) })
+}
+
+func (repository *eventRepository) FindAfter(ctx context.Context, afterID uint, types []string, limit int) ([]models.OutboxEvent, error) {
+	organizationID, scoped := repositories.TenantFromContext(ctx)
+	var events []models.OutboxEvent
+	for _, event := range repository.events {
+		if event.ID > afterID && slices.Contains(types, event.Type) && (!scoped || event.OrganizationID == organizationID) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (repository *eventRepository) FindSince(ctx context.Context, lastID uint, window time.Duration, types []string, limit int) ([]models.OutboxEvent, error) {
+	var lastCreatedAt time.Time
+	for _, event := range repository.events {
+		if event.ID == lastID {
+			lastCreatedAt = event.CreatedAt
+		}
+	}
+	organizationID, scoped := repositories.TenantFromContext(ctx)
+	var events []models.OutboxEvent
+	for _, event := range repository.events {
+		recent := event.ID > lastID || (!lastCreatedAt.IsZero() && !event.CreatedAt.Before(lastCreatedAt.Add(-window)))
+		if event.ID != lastID && recent && slices.Contains(types, event.Type) && (!scoped || event.OrganizationID == organizationID) && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (repository *eventRepository) LastID(ctx context.Context) (uint, error) {
+	return uint(len(repository.events)), nil
+}
+
+func (repository *eventRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+func receivedIDs(subscription *MediaSubscription) []uint {
+	var ids []uint
+	for {
+		select {
+		case event, open := <-subscription.Events:
+			if !open {
+				return ids
+			}
+			ids = append(ids, event.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestMediaStreamBroadcastsToMatchingSubscriptions(t *testing.T) {
+	repository := &eventRepository{}
+	stream := NewMediaStream(repository, nil, MediaStreamOptions{})
+	ctx := repositories.WithTenant(context.Background(), 7)
+
+	everyMedia, err := stream.Subscribe(ctx, nil, 0)
+	require.NoError(t, err)
+	goals, err := stream.Subscribe(ctx, []uint{2, 5}, 0)
+	require.NoError(t, err)
+	otherOrganization, err := stream.Subscribe(repositories.WithTenant(context.Background(), 8), nil, 0)
+	require.NoError(t, err)
+
+	repository.add(7, models.WebhookMediaCreated, models.Media{ID: 1, Tags: []models.Tag{{ID: 2}}})
+	repository.add(7, models.WebhookMediaDeleted, models.Media{ID: 1, Tags: []models.Tag{{ID: 2}}})
+	repository.add(7, models.WebhookMediaUpdated, models.Media{ID: 2, Tags: []models.Tag{{ID: 3}}})
+	repository.add(8, models.WebhookMediaCreated, models.Media{ID: 3})
+	require.NoError(t, stream.Broadcast(repositories.WithAllTenants(context.Background())))
+
+	assert.Equal(t, []uint{1, 3}, receivedIDs(everyMedia), "deletions are not streamed")
+	assert.Equal(t, []uint{1}, receivedIDs(goals), "medias without one of the tags are filtered")
+	assert.Equal(t, []uint{4}, receivedIDs(otherOrganization))
+
+	goals.Close()
+	repository.add(7, models.WebhookMediaUpdated, models.Media{ID: 1, Tags: []models.Tag{{ID: 5}}})
+	require.NoError(t, stream.Broadcast(repositories.WithAllTenants(context.Background())))
+	assert.Equal(t, []uint{5}, receivedIDs(everyMedia), "events are sent once")
+	_, open := <-goals.Events
+	assert.False(t, open, "closed subscriptions stop receiving events")
+}
+
+func TestMediaStreamSendsEventsCommittedOutOfOrder(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	repository := &eventRepository{}
+	stream := NewMediaStream(repository, nil, MediaStreamOptions{})
+	stream.now = func() time.Time { return now }
+	ctx := repositories.WithAllTenants(context.Background())
+	subscription, err := stream.Subscribe(repositories.WithTenant(context.Background(), 7), nil, 0)
+	require.NoError(t, err)
+
+	// event 10 is inserted before event 11 but committed after it
+	repository.commit(11, 7, models.WebhookMediaCreated, models.Media{ID: 2})
+	require.NoError(t, stream.Broadcast(ctx))
+	assert.Equal(t, []uint{11}, receivedIDs(subscription))
+
+	now = now.Add(time.Second)
+	repository.commit(10, 7, models.WebhookMediaCreated, models.Media{ID: 1})
+	repository.commit(12, 7, models.WebhookMediaUpdated, models.Media{ID: 2})
+	require.NoError(t, stream.Broadcast(ctx))
+	assert.Equal(t, []uint{10, 12}, receivedIDs(subscription), "late events are sent, and every event once")
+
+	now = now.Add(streamReorderWindow)
+	require.NoError(t, stream.Broadcast(ctx))
+	assert.Empty(t, receivedIDs(subscription))
+	assert.Equal(t, uint(12), stream.floor, "events are not read again after the window")
+	assert.Empty(t, stream.seen)
+}
+
+func TestMediaStreamResumesFromLastEventID(t *testing.T) {
+	repository := &eventRepository{}
+	repository.add(7, models.WebhookMediaCreated, models.Media{ID: 1, Tags: []models.Tag{{ID: 2}}})
+	repository.add(8, models.WebhookMediaCreated, models.Media{ID: 2, Tags: []models.Tag{{ID: 2}}})
+	repository.add(7, models.WebhookMediaCreated, models.Media{ID: 3})
+	repository.add(7, models.WebhookMediaUpdated, models.Media{ID: 1, Tags: []models.Tag{{ID: 2}}})
+	stream := NewMediaStream(repository, nil, MediaStreamOptions{})
+
+	subscription, err := stream.Subscribe(repositories.WithTenant(context.Background(), 7), []uint{2}, 1)
+	require.NoError(t, err)
+	require.Len(t, subscription.Backlog, 1)
+	assert.Equal(t, uint(4), subscription.Backlog[0].ID)
+	assert.Equal(t, models.WebhookMediaUpdated, subscription.Backlog[0].Type)
+	assert.JSONEq(t, string(repository.events[3].Payload), string(subscription.Backlog[0].Data))
+
+	_, err = stream.Subscribe(context.Background(), nil, 0)
+	assert.ErrorIs(t, err, repositories.ErrMissingTenant)
+}
+
+func TestMediaStreamResumesWithEventsCommittedAfterLastEventID(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	repository := &eventRepository{}
+	repository.commit(1, 7, models.WebhookMediaCreated, models.Media{ID: 1})
+	repository.commit(3, 7, models.WebhookMediaCreated, models.Media{ID: 3})
+	// event 2 was committed after the subscriber received event 3
+	repository.commit(2, 7, models.WebhookMediaCreated, models.Media{ID: 2})
+	for i, createdAt := range []time.Time{now.Add(-time.Hour), now.Add(-time.Second), now} {
+		repository.events[i].CreatedAt = createdAt
+	}
+	stream := NewMediaStream(repository, nil, MediaStreamOptions{})
+
+	subscription, err := stream.Subscribe(repositories.WithTenant(context.Background(), 7), nil, 3)
+	require.NoError(t, err)
+	require.Len(t, subscription.Backlog, 1)
+	assert.Equal(t, uint(2), subscription.Backlog[0].ID)
+}
+
+func TestMediaStreamDisconnectsSlowSubscriptions(t *testing.T) {
+	repository := &eventRepository{}
+	stream := NewMediaStream(repository, nil, MediaStreamOptions{})
+	subscription, err := stream.Subscribe(repositories.WithTenant(context.Background(), 7), nil, 0)
+	require.NoError(t, err)
+
+	for i := 0; i <= streamBufferSize; i++ {
+		repository.add(7, models.WebhookMediaCreated, models.Media{ID: uint(i)})
+	}
+	require.NoError(t, stream.Broadcast(repositories.WithAllTenants(context.Background())))
+
+	assert.Len(t, receivedIDs(subscription), streamBufferSize)
+	_, open := <-subscription.Events
+	assert.False(t, open, "the subscription is closed once its buffer is full")
+	subscription.Close()
+}
+
+func TestMediaStreamClosesSubscriptionsWhenStopped(t *testing.T) {
+	stream := NewMediaStream(&eventRepository{}, nil, MediaStreamOptions{PollInterval: time.Millisecond})
+	subscription, err := stream.Subscribe(repositories.WithTenant(context.Background(), 7), nil, 0)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream.Run(ctx)
+
+	_, open := <-subscription.Events
+	assert.False(t, open)
+}
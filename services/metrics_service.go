@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultMetricsRefreshInterval = 30 * time.Second
+
+var (
+	mediaCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "medias",
+		Help: "Number of medias of every organization which are not in the trash, by processing status.",
+	}, []string{"status"})
+	tagCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "tags",
+		Help: "Number of tags of every organization which are not in the trash.",
+	})
+	jobQueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "job_queue_depth",
+		Help: "Number of jobs waiting or running in the queue, by type and status: pending or running.",
+	}, []string{"type", "status"})
+)
+
+// MetricsOptions configures the gauges counting the rows of the database
+type MetricsOptions struct {
+	// Delay between two counts, the gauges are as old as this delay at most
+	RefreshInterval time.Duration
+}
+
+// LoadMetricsOptions reads METRICS_REFRESH_INTERVAL
+func LoadMetricsOptions() (MetricsOptions, error) {
+	options := MetricsOptions{RefreshInterval: defaultMetricsRefreshInterval}
+	if value := config.Config("METRICS_REFRESH_INTERVAL"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			return options, fmt.Errorf("invalid METRICS_REFRESH_INTERVAL: %s", value)
+		}
+		options.RefreshInterval = duration
+	}
+	return options, nil
+}
+
+// MetricsService counts the medias, tags and jobs exposed on /metrics. They are counted periodically rather than
+// when /metrics is scraped, so that scrapes do not query the database.
+type MetricsService struct {
+	repository repositories.IMetricsRepository
+	options    MetricsOptions
+}
+
+func NewMetricsService(repository repositories.IMetricsRepository, options MetricsOptions) *MetricsService {
+	if options.RefreshInterval <= 0 {
+		options.RefreshInterval = defaultMetricsRefreshInterval
+	}
+	return &MetricsService{
+		repository: repository,
+		options:    options,
+	}
+}
+
+// Run refreshes the gauges every refresh interval until ctx is done
+func (service *MetricsService) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.options.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := service.Refresh(ctx); err != nil {
+			log.Printf("unable to refresh the metrics: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh counts the medias and tags of every organization and the jobs of the queue
+func (service *MetricsService) Refresh(ctx context.Context) error {
+	ctx = repositories.WithAllTenants(ctx)
+	medias, err := service.repository.CountMedias(ctx)
+	if err != nil {
+		return err
+	}
+	tags, err := service.repository.CountTags(ctx)
+	if err != nil {
+		return err
+	}
+	jobs, err := service.repository.CountJobs(ctx)
+	if err != nil {
+		return err
+	}
+	// statuses and types without rows anymore are removed rather than kept at their last value
+	mediaCount.Reset()
+	for _, count := range medias {
+		mediaCount.WithLabelValues(count.Status).Set(float64(count.Count))
+	}
+	tagCount.Set(float64(tags))
+	jobQueueDepth.Reset()
+	for _, count := range jobs {
+		jobQueueDepth.WithLabelValues(count.Type, count.Status).Set(float64(count.Count))
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// metricsRepository returns fixed counts, checking that every organization is counted
+type metricsRepository struct {
+	t      *testing.T
+	medias []repositories.StatusCount
+	tags   int64
+	jobs   []repositories.StatusCount
+}
+
+func (repository *metricsRepository) allTenants(ctx context.Context) {
+	_, scoped := repositories.TenantFromContext(ctx)
+	assert.False(repository.t, scoped, "every organization is counted")
+}
+
+func (repository *metricsRepository) CountMedias(ctx context.Context) ([]repositories.StatusCount, error) {
+	repository.allTenants(ctx)
+	return repository.medias, nil
+}
+
+func (repository *metricsRepository) CountTags(ctx context.Context) (int64, error) {
+	repository.allTenants(ctx)
+	return repository.tags, nil
+}
+
+func (repository *metricsRepository) CountJobs(ctx context.Context) ([]repositories.StatusCount, error) {
+	return repository.jobs, nil
+}
+
+func TestMetricsServiceRefreshesGauges(t *testing.T) {
+	repository := &metricsRepository{
+		t:      t,
+		medias: []repositories.StatusCount{{Status: models.MediaReady, Count: 12}, {Status: models.MediaProcessing, Count: 2}},
+		tags:   5,
+		jobs:   []repositories.StatusCount{{Type: JobProcessMedia, Status: models.JobPending, Count: 3}, {Type: JobProcessMedia, Status: models.JobRunning, Count: 1}},
+	}
+	service := NewMetricsService(repository, MetricsOptions{})
+
+	require.NoError(t, service.Refresh(context.Background()))
+	assert.Equal(t, 12.0, testutil.ToFloat64(mediaCount.WithLabelValues(models.MediaReady)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(mediaCount.WithLabelValues(models.MediaProcessing)))
+	assert.Equal(t, 5.0, testutil.ToFloat64(tagCount))
+	assert.Equal(t, 3.0, testutil.ToFloat64(jobQueueDepth.WithLabelValues(JobProcessMedia, models.JobPending)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(jobQueueDepth.WithLabelValues(JobProcessMedia, models.JobRunning)))
+
+	repository.jobs = []repositories.StatusCount{{Type: JobProcessMedia, Status: models.JobPending, Count: 1}}
+	require.NoError(t, service.Refresh(context.Background()))
+	assert.Equal(t, 1, testutil.CollectAndCount(jobQueueDepth), "statuses without jobs are removed")
+	assert.Equal(t, 1.0, testutil.ToFloat64(jobQueueDepth.WithLabelValues(JobProcessMedia, models.JobPending)))
+}
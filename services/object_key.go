@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mich31/scoreplay-media-api/models"
+)
+
+// ObjectKeyTemplate lays out the object keys of uploaded files in the bucket.
+// Placeholders: {tenant}, {yyyy}, {mm}, {dd} (upload date), {uuid} and {ext} (extension of the original file).
+type ObjectKeyTemplate string
+
+const DefaultObjectKeyTemplate ObjectKeyTemplate = "{tenant}/{yyyy}/{mm}/{dd}/{uuid}{ext}"
+
+// Tenant of the objects whose organization is unknown
+const defaultTenant = models.DefaultOrganization
+
+var objectKeyPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ParseObjectKeyTemplate validates an object key template, an empty value returns the default template
+func ParseObjectKeyTemplate(value string) (ObjectKeyTemplate, error) {
+	if value == "" {
+		return DefaultObjectKeyTemplate, nil
+	}
+	for _, placeholder := range objectKeyPlaceholder.FindAllString(value, -1) {
+		switch placeholder {
+		case "{tenant}", "{yyyy}", "{mm}", "{dd}", "{uuid}", "{ext}":
+		default:
+			return "", fmt.Errorf("unknown placeholder %s in object key template %q", placeholder, value)
+		}
+	}
+	// keys must be unique and must not collide with the renditions & rendered images stored next to them
+	if !strings.Contains(value, "{uuid}") {
+		return "", fmt.Errorf("object key template %q must contain {uuid}", value)
+	}
+	if strings.HasPrefix(value, "/") || strings.Contains(value, "//") || strings.Contains(value, "..") ||
+		strings.HasPrefix(value, "renditions/") || strings.HasPrefix(value, "derived/") {
+		return "", fmt.Errorf("invalid object key template %q", value)
+	}
+	return ObjectKeyTemplate(value), nil
+}
+
+// objectTenant returns the tenant of the objects uploaded with ctx: the slug of the organization of the caller
+func objectTenant(ctx context.Context) string {
+	if identity := IdentityFromContext(ctx); identity != nil && identity.Organization != "" {
+		return identity.Organization
+	}
+	return defaultTenant
+}
+
+// mediaTenant returns the tenant of the objects of a media loaded with its organization
+func mediaTenant(media *models.Media) string {
+	if media.Organization != nil {
+		return media.Organization.Slug
+	}
+	return defaultTenant
+}
+
+// ObjectKey returns the key of a file uploaded at date
+func (template ObjectKeyTemplate) ObjectKey(tenant string, date time.Time, id uuid.UUID, extension string) string {
+	if template == "" {
+		template = DefaultObjectKeyTemplate
+	}
+	replacer := strings.NewReplacer(
+		"{tenant}", tenant,
+		"{yyyy}", date.Format("2006"),
+		"{mm}", date.Format("01"),
+		"{dd}", date.Format("02"),
+		"{uuid}", id.String(),
+		"{ext}", extension,
+	)
+	return replacer.Replace(string(template))
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Number of medias loaded at once by the object key migration
+const objectKeyMigrationBatchSize = 100
+
+// ObjectKeyMigration moves the objects of existing medias to the layout of an object key template
+type ObjectKeyMigration struct {
+	mediaRepository repositories.IMediaRepository
+	storage         IStorageService
+	template        ObjectKeyTemplate
+}
+
+// ObjectKeyMigrationResult counts the medias processed by a migration
+type ObjectKeyMigrationResult struct {
+	Moved   int
+	Skipped int
+	Failed  int
+}
+
+func NewObjectKeyMigration(mediaRepository repositories.IMediaRepository, storageService IStorageService, template ObjectKeyTemplate) *ObjectKeyMigration {
+	return &ObjectKeyMigration{
+		mediaRepository: mediaRepository,
+		storage:         storageService,
+		template:        template,
+	}
+}
+
+// Run moves the original file and renditions of every media whose key does not follow the template.
+// Medias are updated one by one, an interrupted migration can be run again.
+func (migration *ObjectKeyMigration) Run(ctx context.Context, dryRun bool) (ObjectKeyMigrationResult, error) {
+	ctx = repositories.WithAllTenants(ctx)
+	result := ObjectKeyMigrationResult{}
+	var lastID uint
+	for {
+		medias, err := migration.mediaRepository.FindAfter(ctx, lastID, objectKeyMigrationBatchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(medias) == 0 {
+			return result, nil
+		}
+		for i := range medias {
+			media := &medias[i]
+			lastID = media.ID
+			objectKey := templateObjectKey(migration.template, media)
+			if media.ObjectKey == "" || objectKey == media.ObjectKey {
+				result.Skipped++
+				continue
+			}
+			log.Printf("media %d: %s -> %s\n", media.ID, media.ObjectKey, objectKey)
+			if !dryRun {
+				if err := migration.move(ctx, media, objectKey); err != nil {
+					log.Printf("unable to move media %d: %s\n", media.ID, err)
+					result.Failed++
+					continue
+				}
+			}
+			result.Moved++
+		}
+	}
+}
+
+// templateObjectKey returns the key of a media following a template, dated from its creation.
+// The uuid of the current key is kept so that running a migration again gives the same key.
+func templateObjectKey(template ObjectKeyTemplate, media *models.Media) string {
+	extension := path.Ext(media.ObjectKey)
+	id, err := uuid.Parse(strings.TrimSuffix(path.Base(media.ObjectKey), extension))
+	if err != nil {
+		id = uuid.NewSHA1(uuid.NameSpaceURL, []byte(media.ObjectKey))
+	}
+	return template.ObjectKey(mediaTenant(media), media.CreatedAt.UTC(), id, extension)
+}
+
+// movedRenditions returns the keys of the renditions of a media stored under a new object key
+func movedRenditions(renditions models.RenditionMap, objectKey string) models.RenditionMap {
+	if renditions == nil {
+		return nil
+	}
+	base := strings.TrimSuffix(objectKey, path.Ext(objectKey))
+	moved := models.RenditionMap{}
+	for size, renditionKey := range renditions {
+		moved[size] = fmt.Sprintf("renditions/%s/%s", base, path.Base(renditionKey))
+	}
+	return moved
+}
+
+// move copies the objects of a media to their new keys, updates the media then deletes the previous objects
+func (migration *ObjectKeyMigration) move(ctx context.Context, media *models.Media, objectKey string) error {
+	if err := copyObject(ctx, migration.storage, media.ObjectKey, objectKey); err != nil {
+		return err
+	}
+	renditions := movedRenditions(media.Renditions, objectKey)
+	for size, renditionKey := range media.Renditions {
+		if err := copyObject(ctx, migration.storage, renditionKey, renditions[size]); err != nil {
+			return err
+		}
+	}
+	if err := migration.mediaRepository.UpdateObjectKey(ctx, media.ID, objectKey, renditions); err != nil {
+		return err
+	}
+
+	// rendered images are cached under the previous key, they are rendered again on demand
+	previousKeys := []string{media.ObjectKey}
+	for _, renditionKey := range media.Renditions {
+		previousKeys = append(previousKeys, renditionKey)
+	}
+	derived, err := migration.storage.ListObjects(ctx, "derived/"+strings.TrimSuffix(media.ObjectKey, path.Ext(media.ObjectKey))+"/")
+	if err != nil {
+		log.Printf("unable to list rendered images of media %d: %s\n", media.ID, err)
+	}
+	for _, object := range derived {
+		previousKeys = append(previousKeys, object.Key)
+	}
+	for _, previousKey := range previousKeys {
+		if err := migration.storage.DeleteObject(ctx, previousKey); err != nil {
+			log.Printf("unable to delete object %s: %s\n", previousKey, err)
+		}
+	}
+	media.ObjectKey, media.Renditions = objectKey, renditions
+	return nil
+}
+
+// copyObject copies an object to another key of the same storage
+func copyObject(ctx context.Context, storage IStorageService, source string, target string) error {
+	info, err := storage.StatObject(ctx, source)
+	if err != nil {
+		return err
+	}
+	object, err := storage.GetObject(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	return storage.PutObject(ctx, target, object, info.Size, info.ContentType)
+}
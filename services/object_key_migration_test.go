@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keyMigrationRepository keeps medias in memory, only the methods used by the migration are implemented
+type keyMigrationRepository struct {
+	repositories.IMediaRepository
+	mu     sync.Mutex
+	medias []models.Media
+}
+
+func (repository *keyMigrationRepository) FindAfter(ctx context.Context, id uint, limit int) ([]models.Media, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	var medias []models.Media
+	for _, media := range repository.medias {
+		if media.ID > id && len(medias) < limit {
+			medias = append(medias, media)
+		}
+	}
+	return medias, nil
+}
+
+func (repository *keyMigrationRepository) UpdateObjectKey(ctx context.Context, id uint, objectKey string, renditions models.RenditionMap) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	for i := range repository.medias {
+		if repository.medias[i].ID == id {
+			repository.medias[i].ObjectKey, repository.medias[i].Renditions = objectKey, renditions
+		}
+	}
+	return nil
+}
+
+func TestObjectKeyMigration(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage(StorageOptions{})
+	objects := map[string]string{
+		"611e175c-c0bc-488e-b4b7-f5d005e4fa5b.jpg":                  "photo",
+		"renditions/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/thumb.jpg": "thumb",
+		"derived/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/0a1b2c.jpg":   "render",
+		"kickoff.mp4": "video",
+		"default/2024/03/10/ab2b9e4c-4f2e-4a57-9b55-6a3c1e0b5f7e.png": "moved",
+	}
+	for key, content := range objects {
+		require.NoError(t, storage.PutObject(ctx, key, strings.NewReader(content), int64(len(content)), "application/octet-stream"))
+	}
+	repository := &keyMigrationRepository{medias: []models.Media{
+		{
+			ID:        1,
+			CreatedAt: time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC),
+			MediaFiles: models.MediaFiles{
+				ObjectKey:  "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.jpg",
+				Renditions: models.RenditionMap{"thumb": "renditions/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/thumb.jpg"},
+			},
+		},
+		{
+			ID:           2,
+			CreatedAt:    time.Date(2024, time.March, 10, 9, 0, 0, 0, time.UTC),
+			Organization: &models.Organization{ID: 2, Slug: "fc-nantes"},
+			MediaFiles:   models.MediaFiles{ObjectKey: "kickoff.mp4"},
+		},
+		{
+			ID:         3,
+			CreatedAt:  time.Date(2024, time.March, 10, 9, 0, 0, 0, time.UTC),
+			MediaFiles: models.MediaFiles{ObjectKey: "default/2024/03/10/ab2b9e4c-4f2e-4a57-9b55-6a3c1e0b5f7e.png"},
+		},
+		{
+			ID:         4,
+			MediaFiles: models.MediaFiles{ObjectKey: "missing.jpg"},
+		},
+	}}
+	migration := NewObjectKeyMigration(repository, storage, DefaultObjectKeyTemplate)
+
+	result, err := migration.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, ObjectKeyMigrationResult{Moved: 2, Skipped: 1, Failed: 1}, result)
+
+	photo := repository.medias[0]
+	assert.Equal(t, "default/2024/03/09/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.jpg", photo.ObjectKey)
+	assert.Equal(t, models.RenditionMap{"thumb": "renditions/default/2024/03/09/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/thumb.jpg"}, photo.Renditions)
+	assert.Equal(t, "photo", readObject(t, storage, photo.ObjectKey))
+	assert.Equal(t, "thumb", readObject(t, storage, photo.Renditions["thumb"]))
+	video := repository.medias[1]
+	assert.True(t, strings.HasPrefix(video.ObjectKey, "fc-nantes/2024/03/10/"), "objects are stored under the slug of their organization: %s", video.ObjectKey)
+	assert.Equal(t, "video", readObject(t, storage, video.ObjectKey))
+	assert.Equal(t, "missing.jpg", repository.medias[3].ObjectKey)
+
+	for _, key := range []string{"611e175c-c0bc-488e-b4b7-f5d005e4fa5b.jpg", "renditions/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/thumb.jpg", "derived/611e175c-c0bc-488e-b4b7-f5d005e4fa5b/0a1b2c.jpg", "kickoff.mp4"} {
+		_, err := storage.StatObject(ctx, key)
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "%s should be deleted: %v", key, err)
+	}
+
+	// keys are stable, running the migration again moves nothing
+	result, err = migration.Run(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, ObjectKeyMigrationResult{Moved: 0, Skipped: 3, Failed: 1}, result)
+}
+
+func readObject(t *testing.T, storage IStorageService, key string) string {
+	object, err := storage.GetObject(context.Background(), key)
+	require.NoError(t, err)
+	defer object.Close()
+	data, err := io.ReadAll(object)
+	require.NoError(t, err)
+	return string(data)
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectKeyTemplate(t *testing.T) {
+	id := uuid.MustParse("611e175c-c0bc-488e-b4b7-f5d005e4fa5b")
+	date := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description   string
+		template      string
+		expectedKey   string
+		expectedError bool
+	}{
+		{
+			description: "Object key template should default to a layout per tenant and day",
+			template:    "",
+			expectedKey: "default/2024/03/09/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.JPG",
+		},
+		{
+			description: "Object key template should replace every placeholder",
+			template:    "uploads/{yyyy}-{mm}/{dd}/{uuid}{ext}",
+			expectedKey: "uploads/2024-03/09/611e175c-c0bc-488e-b4b7-f5d005e4fa5b.JPG",
+		},
+		{
+			description: "Object key template should keep files at the bucket root",
+			template:    "{uuid}{ext}",
+			expectedKey: "611e175c-c0bc-488e-b4b7-f5d005e4fa5b.JPG",
+		},
+		{
+			description:   "Object key template should require {uuid}",
+			template:      "{tenant}/{yyyy}/{ext}",
+			expectedError: true,
+		},
+		{
+			description:   "Object key template should reject unknown placeholders",
+			template:      "{team}/{uuid}{ext}",
+			expectedError: true,
+		},
+		{
+			description:   "Object key template should reject keys colliding with renditions",
+			template:      "renditions/{uuid}{ext}",
+			expectedError: true,
+		},
+		{
+			description:   "Object key template should reject absolute keys",
+			template:      "/{uuid}{ext}",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			template, err := ParseObjectKeyTemplate(tt.template)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedKey, template.ObjectKey(defaultTenant, date, id, ".JPG"))
+		})
+	}
+}
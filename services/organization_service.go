@@ -0,0 +1,70 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+var ErrInvalidOrganization = errors.New("invalid organization")
+
+var organizationSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type OrganizationService struct {
+	repository repositories.IOrganizationRepository
+	// organizations by slug, they are neither renamed nor deleted
+	cache sync.Map
+}
+
+func NewOrganizationService(repository repositories.IOrganizationRepository) *OrganizationService {
+	return &OrganizationService{repository: repository}
+}
+
+// CreateOrganization creates an organization identified by a slug made of lowercase letters, digits and dashes
+func (service *OrganizationService) CreateOrganization(name string, slug string) (*models.Organization, error) {
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidOrganization)
+	}
+	if !organizationSlug.MatchString(slug) {
+		return nil, fmt.Errorf("%w: slug %q must be made of lowercase letters, digits and dashes", ErrInvalidOrganization, slug)
+	}
+	organization := &models.Organization{Name: name, Slug: slug}
+	if err := service.repository.Create(organization); err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// GetOrganization returns the organization of a slug
+func (service *OrganizationService) GetOrganization(slug string) (*models.Organization, error) {
+	if organization, found := service.cache.Load(slug); found {
+		return organization.(*models.Organization), nil
+	}
+	organization, err := service.repository.FindBySlug(slug)
+	if err != nil {
+		return nil, err
+	}
+	service.cache.Store(slug, organization)
+	return organization, nil
+}
+
+func (service *OrganizationService) GetOrganizations() ([]models.Organization, error) {
+	return service.repository.Find()
+}
+
+// SetQuotas limits the bytes stored and the number of medias of an organization, 0 for unlimited
+func (service *OrganizationService) SetQuotas(slug string, storageQuota int64, mediaQuota int64) (*models.Organization, error) {
+	if storageQuota < 0 || mediaQuota < 0 {
+		return nil, fmt.Errorf("%w: quotas must be positive, or 0 for unlimited", ErrInvalidOrganization)
+	}
+	organization, err := service.repository.SetQuotas(slug, storageQuota, mediaQuota)
+	if err != nil {
+		return nil, err
+	}
+	service.cache.Store(slug, organization)
+	return organization, nil
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+)
+
+// Classes of routes, each class has its own buckets
+const (
+	RateLimitRead   = "read"
+	RateLimitUpload = "upload"
+)
+
+// Stores of the token buckets
+const (
+	RateLimitStoreMemory   = "memory"
+	RateLimitStorePostgres = "postgres"
+)
+
+// Interval between two removals of the idle buckets
+const rateLimitCleanupInterval = 5 * time.Minute
+
+// Default limits: requests per period and burst
+var defaultRateLimits = map[string]string{
+	"RATE_LIMIT_READ":      "600/m:100",
+	"RATE_LIMIT_READ_IP":   "1200/m:200",
+	"RATE_LIMIT_UPLOAD":    "30/m:10",
+	"RATE_LIMIT_UPLOAD_IP": "60/m:20",
+}
+
+// RateLimit is a token bucket: it holds Burst tokens at most, refilled with Requests tokens every Period.
+// Each request takes a token, requests finding the bucket empty are rejected.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// ParseRateLimit parses a limit formatted as <requests>/<period>[:<burst>], the period being s, m, h or
+// a duration (example: 30/m:10, 100/10s). The burst defaults to the number of requests. off disables the limit.
+func ParseRateLimit(value string) (RateLimit, error) {
+	if value == "off" {
+		return RateLimit{}, nil
+	}
+	rate, burst, hasBurst := strings.Cut(value, ":")
+	requests, period, found := strings.Cut(rate, "/")
+	if !found {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected <requests>/<period>[:<burst>]", value)
+	}
+	limit := RateLimit{}
+	var err error
+	if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: invalid number of requests %q", value, requests)
+	}
+	switch period {
+	case "s":
+		limit.Period = time.Second
+	case "m":
+		limit.Period = time.Minute
+	case "h":
+		limit.Period = time.Hour
+	default:
+		if limit.Period, err = time.ParseDuration(period); err != nil || limit.Period <= 0 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: invalid period %q", value, period)
+		}
+	}
+	limit.Burst = limit.Requests
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit %q: invalid burst %q", value, burst)
+		}
+	}
+	return limit, nil
+}
+
+// Enabled reports whether the limit is set
+func (limit RateLimit) Enabled() bool {
+	return limit.Requests > 0
+}
+
+// rate returns the tokens added to the bucket per second
+func (limit RateLimit) rate() float64 {
+	return float64(limit.Requests) / limit.Period.Seconds()
+}
+
+// RateLimitOptions configures the limits of each class of routes, per credential and per IP
+type RateLimitOptions struct {
+	// Store of the buckets: memory for a single instance, postgres to share them between instances
+	Store   string
+	Limits  map[string]RateLimit
+	IPLimit map[string]RateLimit
+}
+
+// LoadRateLimitOptions reads RATE_LIMIT_STORE, RATE_LIMIT_READ, RATE_LIMIT_READ_IP, RATE_LIMIT_UPLOAD and
+// RATE_LIMIT_UPLOAD_IP
+func LoadRateLimitOptions() (RateLimitOptions, error) {
+	options := RateLimitOptions{
+		Store:   config.Config("RATE_LIMIT_STORE"),
+		Limits:  map[string]RateLimit{},
+		IPLimit: map[string]RateLimit{},
+	}
+	switch options.Store {
+	case "":
+		options.Store = RateLimitStoreMemory
+	case RateLimitStoreMemory, RateLimitStorePostgres:
+	default:
+		return options, fmt.Errorf("invalid RATE_LIMIT_STORE %q (available: memory, postgres)", options.Store)
+	}
+	for _, class := range []string{RateLimitRead, RateLimitUpload} {
+		for key, limits := range map[string]map[string]RateLimit{"": options.Limits, "_IP": options.IPLimit} {
+			key = "RATE_LIMIT_" + strings.ToUpper(class) + key
+			value := config.Config(key)
+			if value == "" {
+				value = defaultRateLimits[key]
+			}
+			limit, err := ParseRateLimit(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			limits[class] = limit
+		}
+	}
+	return options, nil
+}
+
+// RateLimitStore keeps the token buckets
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, refilled with rate tokens per second up to burst tokens, and returns
+	// the tokens left in the bucket and whether a token was taken
+	Take(ctx context.Context, key string, rate float64, burst float64, now time.Time) (float64, bool, error)
+	// DeleteIdle removes the buckets unused since before
+	DeleteIdle(ctx context.Context, before time.Time) error
+}
+
+// RateLimitDecision is the outcome of the limits of a request
+type RateLimitDecision struct {
+	Allowed bool
+	// Limit closest to reject the request, and the requests it still accepts
+	Limit     RateLimit
+	Remaining int
+	// Delay before the bucket is full again
+	Reset time.Duration
+	// Delay before a request is accepted again, when the request is rejected
+	RetryAfter time.Duration
+}
+
+// RateLimiter limits the requests of each credential and each IP address with token buckets
+type RateLimiter struct {
+	store   RateLimitStore
+	options RateLimitOptions
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewRateLimiter(store RateLimitStore, options RateLimitOptions) *RateLimiter {
+	return &RateLimiter{store: store, options: options, now: time.Now}
+}
+
+// Allow takes a token from the buckets of the credential and of the IP address of a request of a class of routes,
+// skipping the empty ones. The request is rejected when one of them is empty. Rejected requests still take a token
+// from the other bucket: the attempts of a credential flooding the API count against its IP address.
+func (limiter *RateLimiter) Allow(ctx context.Context, class string, credential string, ip string) (*RateLimitDecision, error) {
+	now := limiter.now()
+	type bucket struct {
+		key   string
+		limit RateLimit
+	}
+	var buckets []bucket
+	if ip != "" {
+		buckets = append(buckets, bucket{class + ":ip:" + ip, limiter.options.IPLimit[class]})
+	}
+	if credential != "" {
+		buckets = append(buckets, bucket{class + ":credential:" + credential, limiter.options.Limits[class]})
+	}
+	var decision *RateLimitDecision
+	for _, bucket := range buckets {
+		limit := bucket.limit
+		if !limit.Enabled() {
+			continue
+		}
+		rate, burst := limit.rate(), float64(limit.Burst)
+		tokens, allowed, err := limiter.store.Take(ctx, bucket.key, rate, burst, now)
+		if err != nil {
+			return nil, err
+		}
+		current := &RateLimitDecision{
+			Allowed:   allowed,
+			Limit:     limit,
+			Remaining: int(math.Max(0, math.Floor(tokens))),
+			Reset:     secondsDuration((burst - tokens) / rate),
+		}
+		if !allowed {
+			current.RetryAfter = secondsDuration((1 - tokens) / rate)
+		}
+		decision = decision.Closest(current)
+	}
+	return decision, nil
+}
+
+// Closest returns the decision rejecting the request, or the one closest to reject it, among decision and other.
+// Nil decisions are ignored.
+func (decision *RateLimitDecision) Closest(other *RateLimitDecision) *RateLimitDecision {
+	if decision == nil || (other != nil && ((decision.Allowed && (!other.Allowed || other.Remaining < decision.Remaining)) ||
+		(!decision.Allowed && !other.Allowed && other.RetryAfter > decision.RetryAfter))) {
+		return other
+	}
+	return decision
+}
+
+// Run removes the buckets left idle long enough to be full again, until ctx is done
+func (limiter *RateLimiter) Run(ctx context.Context) {
+	// buckets idle for longer than the slowest refill are full, removing them changes nothing
+	var idle time.Duration
+	for _, limits := range []map[string]RateLimit{limiter.options.Limits, limiter.options.IPLimit} {
+		for _, limit := range limits {
+			if limit.Enabled() {
+				idle = max(idle, time.Duration(float64(limit.Burst)/limit.rate()*float64(time.Second)))
+			}
+		}
+	}
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := limiter.store.DeleteIdle(ctx, limiter.now().Add(-idle)); err != nil {
+				log.Printf("unable to remove idle rate limit buckets: %s", err)
+			}
+		}
+	}
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(math.Max(0, seconds) * float64(time.Second))
+}
+
+// refillBucket returns the tokens of a bucket refilled since updatedAt, and takes a token when there is one
+func refillBucket(tokens float64, updatedAt time.Time, now time.Time, rate float64, burst float64) (float64, bool) {
+	tokens = math.Min(burst, tokens+now.Sub(updatedAt).Seconds()*rate)
+	if tokens < 1 {
+		return tokens, false
+	}
+	return tokens - 1, true
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimitStore keeps the token buckets in memory, for a single instance of the API
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}}
+}
+
+func (store *MemoryRateLimitStore) Take(ctx context.Context, key string, rate float64, burst float64, now time.Time) (float64, bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bucket, found := store.buckets[key]
+	if !found {
+		bucket = &memoryBucket{tokens: burst, updatedAt: now}
+		store.buckets[key] = bucket
+	}
+	tokens, allowed := refillBucket(bucket.tokens, bucket.updatedAt, now, rate, burst)
+	bucket.tokens, bucket.updatedAt = tokens, now
+	return tokens, allowed, nil
+}
+
+func (store *MemoryRateLimitStore) DeleteIdle(ctx context.Context, before time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for key, bucket := range store.buckets {
+		if bucket.updatedAt.Before(before) {
+			delete(store.buckets, key)
+		}
+	}
+	return nil
+}
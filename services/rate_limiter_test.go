@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		value         string
+		expectedLimit RateLimit
+		expectedError bool
+	}{
+		{value: "30/m:10", expectedLimit: RateLimit{Requests: 30, Period: time.Minute, Burst: 10}},
+		{value: "100/10s", expectedLimit: RateLimit{Requests: 100, Period: 10 * time.Second, Burst: 100}},
+		{value: "5000/h", expectedLimit: RateLimit{Requests: 5000, Period: time.Hour, Burst: 5000}},
+		{value: "off", expectedLimit: RateLimit{}},
+		{value: "30", expectedError: true},
+		{value: "30/week", expectedError: true},
+		{value: "-1/m", expectedError: true},
+		{value: "30/m:0", expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			limit, err := ParseRateLimit(tt.value)
+			if tt.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLimit, limit)
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), RateLimitOptions{
+		Limits:  map[string]RateLimit{RateLimitUpload: {Requests: 6, Period: time.Minute, Burst: 2}, RateLimitRead: {Requests: 60, Period: time.Minute, Burst: 60}},
+		IPLimit: map[string]RateLimit{RateLimitUpload: {Requests: 6, Period: time.Minute, Burst: 3}},
+	})
+	limiter.now = func() time.Time { return now }
+
+	// the bucket of the credential holds 2 tokens, refilled with a token every 10s
+	decision, err := limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:1", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, &RateLimitDecision{Allowed: true, Limit: RateLimit{Requests: 6, Period: time.Minute, Burst: 2}, Remaining: 1, Reset: 10 * time.Second}, decision)
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:1", "10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, 0, decision.Remaining)
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:1", "10.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, 10*time.Second, decision.RetryAfter)
+
+	// reads have their own buckets
+	decision, err = limiter.Allow(ctx, RateLimitRead, "fc-nantes/api-key:1", "10.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// the bucket of the IP address, holding 3 tokens, is emptied by the requests of every credential, rejected or not
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:2", "10.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, RateLimit{Requests: 6, Period: time.Minute, Burst: 3}, decision.Limit, "the limit rejecting the request is reported")
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:2", "10.0.0.2")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.Equal(t, RateLimit{Requests: 6, Period: time.Minute, Burst: 2}, decision.Limit, "the limit closest to reject the request is reported")
+	assert.Equal(t, 0, decision.Remaining, "the rejected request took a token of the credential")
+
+	// tokens are added back over time
+	now = now.Add(10 * time.Second)
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:1", "10.0.0.3")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	decision, err = limiter.Allow(ctx, RateLimitUpload, "fc-nantes/api-key:1", "10.0.0.3")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestMemoryRateLimitStoreDeletesIdleBuckets(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+	store.Take(ctx, "upload:ip:10.0.0.1", 1, 1, now.Add(-time.Hour))
+	store.Take(ctx, "upload:ip:10.0.0.2", 1, 1, now)
+
+	require.NoError(t, store.DeleteIdle(ctx, now.Add(-time.Minute)))
+	assert.Len(t, store.buckets, 1)
+	assert.Contains(t, store.buckets, "upload:ip:10.0.0.2")
+}
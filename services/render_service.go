@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/imaging"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultRenderMaxWidth  = 4096
+	defaultRenderMaxHeight = 4096
+)
+
+var (
+	ErrInvalidRenderParams  = errors.New("invalid render parameters")
+	ErrInvalidSignature     = errors.New("invalid render signature")
+	ErrMediaNotRenderable   = errors.New("media is not an image")
+	ErrRenderNotConfigured  = errors.New("render signing key is not configured")
+	ErrRenderSizeNotAllowed = errors.New("requested size exceeds the maximum output size")
+)
+
+// RenderParams describes how an image should be resized.
+// Fit is one of contain (default), cover or fill. Format is one of jpeg, png, gif or empty to keep the original format.
+type RenderParams struct {
+	Width     int
+	Height    int
+	Fit       string
+	Format    string
+	Signature string
+}
+
+// RenderOptions configures the render endpoint
+type RenderOptions struct {
+	SigningKey string
+	MaxWidth   int
+	MaxHeight  int
+}
+
+// RenderedImage is a rendered image ready to be sent to the client.
+// ETag changes with the parameters, the focal point and the crop boxes of the media.
+type RenderedImage struct {
+	Reader      io.ReadCloser
+	Size        int64
+	ContentType string
+	ETag        string
+}
+
+type RenderService struct {
+	mediaRepository repositories.IMediaRepository
+	storage         IStorageService
+	options         RenderOptions
+}
+
+func NewRenderService(mediaRepository repositories.IMediaRepository, storageService IStorageService, options RenderOptions) *RenderService {
+	return &RenderService{
+		mediaRepository: mediaRepository,
+		storage:         storageService,
+		options:         options,
+	}
+}
+
+// LoadRenderOptions reads RENDER_SIGNING_KEY, RENDER_MAX_WIDTH and RENDER_MAX_HEIGHT
+func LoadRenderOptions() (RenderOptions, error) {
+	options := RenderOptions{
+		SigningKey: config.Config("RENDER_SIGNING_KEY"),
+		MaxWidth:   defaultRenderMaxWidth,
+		MaxHeight:  defaultRenderMaxHeight,
+	}
+	if value := config.Config("RENDER_MAX_WIDTH"); value != "" {
+		width, err := strconv.Atoi(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid RENDER_MAX_WIDTH: %w", err)
+		}
+		options.MaxWidth = width
+	}
+	if value := config.Config("RENDER_MAX_HEIGHT"); value != "" {
+		height, err := strconv.Atoi(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid RENDER_MAX_HEIGHT: %w", err)
+		}
+		options.MaxHeight = height
+	}
+	return options, nil
+}
+
+// Sign returns the signature expected for a render request of a media
+func (service *RenderService) Sign(id string, params RenderParams) string {
+	mac := hmac.New(sha256.New, []byte(service.options.SigningKey))
+	mac.Write([]byte(params.canonical(id)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (params RenderParams) canonical(id string) string {
+	return fmt.Sprintf("%s:%d:%d:%s:%s", id, params.Width, params.Height, params.Fit, params.Format)
+}
+
+func (params RenderParams) validate(options RenderOptions) error {
+	if params.Width < 0 || params.Height < 0 || (params.Width == 0 && params.Height == 0) {
+		return fmt.Errorf("%w: width or height must be set", ErrInvalidRenderParams)
+	}
+	if params.Width > options.MaxWidth || params.Height > options.MaxHeight {
+		return fmt.Errorf("%w: %dx%d maximum", ErrRenderSizeNotAllowed, options.MaxWidth, options.MaxHeight)
+	}
+	switch params.Fit {
+	case "", "contain", "cover", "fill":
+	default:
+		return fmt.Errorf("%w: unknown fit %q", ErrInvalidRenderParams, params.Fit)
+	}
+	switch params.Format {
+	case "", "jpeg", "png", "gif":
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidRenderParams, params.Format)
+	}
+	return nil
+}
+
+// Render returns a resized version of a media. Results are cached in the derived area of the bucket.
+func (service *RenderService) Render(ctx context.Context, id string, params RenderParams) (*RenderedImage, error) {
+	if service.options.SigningKey == "" {
+		return nil, ErrRenderNotConfigured
+	}
+	if err := params.validate(service.options); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(service.Sign(id, params)), []byte(params.Signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	// the signature authorizes the render of the media, whatever its organization
+	media, err := service.mediaRepository.FindByID(repositories.WithAllTenants(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+	if !imaging.IsImage(media.ContentType) {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotRenderable, media.ContentType)
+	}
+
+	format := params.Format
+	if format == "" {
+		format = strings.TrimPrefix(media.ContentType, "image/")
+	}
+	hash := renderHash(media, params)
+	objectName := derivedObjectName(media, hash, format)
+	ctx = WithEncryptionKeyID(ctx, media.EncryptionKeyID)
+
+	// cached result
+	if info, err := service.storage.StatObject(ctx, objectName); err == nil {
+		object, err := service.storage.GetObject(ctx, objectName)
+		if err == nil {
+			return &RenderedImage{Reader: object, Size: info.Size, ContentType: imaging.ContentType(format), ETag: hash}, nil
+		}
+	} else if !errors.Is(err, ErrObjectNotFound) {
+		return nil, err
+	}
+
+	original, err := service.storage.GetObject(ctx, media.ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	defer original.Close()
+	img, _, err := imaging.Decode(original)
+	if errors.Is(err, imaging.ErrImageTooLarge) {
+		return nil, fmt.Errorf("%w: %w", ErrMediaNotRenderable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err := imaging.Encode(&buffer, transform(img, media, params), format); err != nil {
+		return nil, err
+	}
+	content := buffer.Bytes()
+	if err := service.storage.PutObject(ctx, objectName, bytes.NewReader(content), int64(len(content)), imaging.ContentType(format)); err != nil {
+		return nil, err
+	}
+
+	return &RenderedImage{
+		Reader:      io.NopCloser(bytes.NewReader(content)),
+		Size:        int64(len(content)),
+		ContentType: imaging.ContentType(format),
+		ETag:        hash,
+	}, nil
+}
+
+func transform(img image.Image, media *models.Media, params RenderParams) image.Image {
+	bounds := img.Bounds()
+	width, height := params.Width, params.Height
+	if width == 0 {
+		width = max(1, height*bounds.Dx()/bounds.Dy())
+	}
+	if height == 0 {
+		height = max(1, width*bounds.Dy()/bounds.Dx())
+	}
+
+	switch params.Fit {
+	case "cover":
+		return cropToFill(img, media, width, height)
+	case "fill":
+		return imaging.Resize(img, width, height)
+	default:
+		return imaging.Fit(img, width, height)
+	}
+}
+
+// renderHash identifies a rendered image. It includes the focal point and crop boxes so that crops are rendered
+// again when they change.
+func renderHash(media *models.Media, params RenderParams) string {
+	hash := sha256.Sum256([]byte(params.canonical(strconv.FormatUint(uint64(media.ID), 10)) + "|" + focusKey(media)))
+	return hex.EncodeToString(hash[:16])
+}
+
+// derivedObjectName stores rendered images under derived/<original key>/<render hash>.<ext>
+func derivedObjectName(media *models.Media, hash string, format string) string {
+	original := strings.TrimSuffix(media.ObjectKey, filepath.Ext(media.ObjectKey))
+	return fmt.Sprintf("derived/%s/%s%s", original, hash, imaging.Extension(format))
+}
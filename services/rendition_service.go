@@ -0,0 +1,212 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/imaging"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const defaultRenditionPresets = "thumb:200x200:cover,small:640x640,medium:1280x1280"
+
+// RenditionPreset describes a fixed size an image is scaled down to.
+// Cover presets are cropped to fill the size using the focal point of the media.
+type RenditionPreset struct {
+	Name   string
+	Width  int
+	Height int
+	Cover  bool
+}
+
+type RenditionService struct {
+	mediaRepository repositories.IMediaRepository
+	storage         IStorageService
+	presets         []RenditionPreset
+	version         string
+}
+
+func NewRenditionService(mediaRepository repositories.IMediaRepository, storageService IStorageService, presets []RenditionPreset) *RenditionService {
+	return &RenditionService{
+		mediaRepository: mediaRepository,
+		storage:         storageService,
+		presets:         presets,
+		version:         renditionsVersion(presets),
+	}
+}
+
+// LoadRenditionPresets reads presets from RENDITION_PRESETS (example: thumb:200x200:cover,small:640x640)
+func LoadRenditionPresets() ([]RenditionPreset, error) {
+	value := config.Config("RENDITION_PRESETS")
+	if value == "" {
+		value = defaultRenditionPresets
+	}
+	return ParseRenditionPresets(value)
+}
+
+// ConfigureImageDecoding reads IMAGE_MAX_PIXELS, the number of pixels of the largest image decoded for renditions,
+// renders and perceptual hashes (default: 50000000)
+func ConfigureImageDecoding() error {
+	value := config.Config("IMAGE_MAX_PIXELS")
+	if value == "" {
+		imaging.SetMaxPixels(imaging.DefaultMaxPixels)
+		return nil
+	}
+	pixels, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || pixels <= 0 {
+		return fmt.Errorf("invalid IMAGE_MAX_PIXELS: %s", value)
+	}
+	imaging.SetMaxPixels(pixels)
+	return nil
+}
+
+func ParseRenditionPresets(value string) ([]RenditionPreset, error) {
+	var presets []RenditionPreset
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid rendition preset %q", entry)
+		}
+		name, size := parts[0], parts[1]
+		cover := false
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "cover":
+				cover = true
+			case "contain":
+			default:
+				return nil, fmt.Errorf("invalid rendition preset fit %q", entry)
+			}
+		}
+		widthStr, heightStr, found := strings.Cut(size, "x")
+		if !found {
+			return nil, fmt.Errorf("invalid rendition preset size %q", entry)
+		}
+		width, err := strconv.Atoi(widthStr)
+		if err != nil || width <= 0 {
+			return nil, fmt.Errorf("invalid rendition preset width %q", entry)
+		}
+		height, err := strconv.Atoi(heightStr)
+		if err != nil || height <= 0 {
+			return nil, fmt.Errorf("invalid rendition preset height %q", entry)
+		}
+		presets = append(presets, RenditionPreset{Name: name, Width: width, Height: height, Cover: cover})
+	}
+	return presets, nil
+}
+
+// renditionsVersion identifies a set of presets so that renditions are regenerated when it changes
+func renditionsVersion(presets []RenditionPreset) string {
+	parts := make([]string, len(presets))
+	for i, preset := range presets {
+		parts[i] = fmt.Sprintf("%s:%dx%d", preset.Name, preset.Width, preset.Height)
+		if preset.Cover {
+			parts[i] += ":cover"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Generate uploads one rendition of the original image per preset and saves them on the media
+func (service *RenditionService) Generate(ctx context.Context, media *models.Media, img image.Image, format string) error {
+	renditions := models.RenditionMap{}
+	for _, preset := range service.presets {
+		objectName, err := service.upload(ctx, media, preset, img, format)
+		if err != nil {
+			return fmt.Errorf("unable to generate rendition %s for media %d: %w", preset.Name, media.ID, err)
+		}
+		renditions[preset.Name] = objectName
+	}
+
+	if err := service.mediaRepository.UpdateRenditions(ctx, media.ID, renditions, service.version); err != nil {
+		return err
+	}
+	// renditions of removed presets, or stored in another format, are no longer referenced
+	kept := map[string]bool{}
+	for _, objectName := range renditions {
+		kept[objectName] = true
+	}
+	for _, objectName := range media.Renditions {
+		if kept[objectName] {
+			continue
+		}
+		if err := service.storage.DeleteObject(ctx, objectName); err != nil {
+			log.Printf("unable to delete rendition %s of media %d: %s\n", objectName, media.ID, err)
+		}
+	}
+	media.Renditions = renditions
+	media.RenditionsVersion = service.version
+	return nil
+}
+
+func (service *RenditionService) upload(ctx context.Context, media *models.Media, preset RenditionPreset, img image.Image, format string) (string, error) {
+	// jpeg is kept for photos, other formats are stored as png to preserve transparency
+	if format != "jpeg" {
+		format = "png"
+	}
+	var buffer bytes.Buffer
+	resized := imaging.Fit(img, preset.Width, preset.Height)
+	if preset.Cover {
+		resized = cropToFill(img, media, preset.Width, preset.Height)
+	}
+	if err := imaging.Encode(&buffer, resized, format); err != nil {
+		return "", err
+	}
+
+	objectName := renditionObjectName(media, preset.Name, format)
+	if err := service.storage.PutObject(ctx, objectName, &buffer, int64(buffer.Len()), imaging.ContentType(format)); err != nil {
+		return "", err
+	}
+	return objectName, nil
+}
+
+// renditionObjectName stores renditions next to the original: renditions/<original key>/<size>.<ext>
+func renditionObjectName(media *models.Media, size string, format string) string {
+	original := strings.TrimSuffix(media.ObjectKey, filepath.Ext(media.ObjectKey))
+	return fmt.Sprintf("renditions/%s/%s%s", original, size, imaging.Extension(format))
+}
+
+// RegenerateStale regenerates renditions of the images of every organization created with a different set of presets
+func (service *RenditionService) RegenerateStale(ctx context.Context) error {
+	ctx = repositories.WithAllTenants(ctx)
+	medias, err := service.mediaRepository.FindWithStaleRenditions(ctx, service.version)
+	if err != nil {
+		return err
+	}
+	if len(medias) > 0 {
+		log.Printf("regenerating renditions of %d media(s)\n", len(medias))
+	}
+
+	for i := range medias {
+		if err := service.Regenerate(ctx, &medias[i]); err != nil {
+			log.Printf("unable to regenerate renditions of media %d: %s\n", medias[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// Regenerate fetches the original image of a media from storage and generates its renditions again
+func (service *RenditionService) Regenerate(ctx context.Context, media *models.Media) error {
+	object, err := service.storage.GetObject(WithEncryptionKeyID(ctx, media.EncryptionKeyID), media.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	img, format, err := imaging.Decode(object)
+	if err != nil {
+		return err
+	}
+	return service.Generate(ctx, media, img, format)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// renditionRepository records the renditions saved, only the methods used by the rendition service are implemented
+type renditionRepository struct {
+	repositories.IMediaRepository
+	renditions models.RenditionMap
+}
+
+func (repository *renditionRepository) UpdateRenditions(ctx context.Context, id uint, renditions models.RenditionMap, version string) error {
+	repository.renditions = renditions
+	return nil
+}
+
+func TestGenerateDeletesPreviousRenditions(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage(StorageOptions{})
+	previous := models.RenditionMap{
+		"thumb": "renditions/kickoff/thumb.jpg",
+		"large": "renditions/kickoff/large.jpg",
+	}
+	for _, objectName := range previous {
+		require.NoError(t, storage.PutObject(ctx, objectName, strings.NewReader("rendition"), 9, "image/jpeg"))
+	}
+	media := &models.Media{ID: 1, MediaFiles: models.MediaFiles{ObjectKey: "kickoff.jpg", Renditions: previous}}
+	repository := &renditionRepository{}
+	service := NewRenditionService(repository, storage, []RenditionPreset{{Name: "thumb", Width: 20, Height: 20}})
+
+	require.NoError(t, service.Generate(ctx, media, image.NewRGBA(image.Rect(0, 0, 64, 64)), "jpeg"))
+
+	assert.Equal(t, models.RenditionMap{"thumb": "renditions/kickoff/thumb.jpg"}, repository.renditions)
+	_, err := storage.StatObject(ctx, "renditions/kickoff/thumb.jpg")
+	assert.NoError(t, err, "the regenerated rendition should be kept")
+	_, err = storage.StatObject(ctx, "renditions/kickoff/large.jpg")
+	assert.ErrorIs(t, err, ErrObjectNotFound, "the rendition of the removed preset should be deleted")
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultReplicationMaxAttempts = 10
+	defaultReplicationInterval    = 30 * time.Second
+	// Maximum delay between two attempts to replicate a media
+	maxReplicationBackoff = time.Hour
+	// Number of medias loaded at once by the replication
+	replicationBatchSize = 100
+	// Delay during which the medias claimed by an instance are not copied by the other instances
+	replicationLockDuration = time.Hour
+)
+
+// ReplicationOptions configures the replication of objects to the secondary storage
+type ReplicationOptions struct {
+	// Number of attempts to copy the objects of a media before giving up
+	MaxAttempts int
+	// Delay between two checks of the medias to replicate, also the delay before the first retry
+	Interval time.Duration
+}
+
+// ReplicationService copies the objects of every media from the primary storage to the secondary storage
+type ReplicationService struct {
+	mediaRepository repositories.IMediaRepository
+	primary         IStorageService
+	secondary       IStorageService
+	options         ReplicationOptions
+	wake            chan struct{}
+}
+
+func NewReplicationService(mediaRepository repositories.IMediaRepository, primary IStorageService, secondary IStorageService, options ReplicationOptions) *ReplicationService {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultReplicationMaxAttempts
+	}
+	if options.Interval <= 0 {
+		options.Interval = defaultReplicationInterval
+	}
+	return &ReplicationService{
+		mediaRepository: mediaRepository,
+		primary:         primary,
+		secondary:       secondary,
+		options:         options,
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// LoadReplicationOptions reads REPLICATION_MAX_ATTEMPTS and REPLICATION_INTERVAL
+func LoadReplicationOptions() (ReplicationOptions, error) {
+	options := ReplicationOptions{
+		MaxAttempts: defaultReplicationMaxAttempts,
+		Interval:    defaultReplicationInterval,
+	}
+	if value := config.Config("REPLICATION_MAX_ATTEMPTS"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid REPLICATION_MAX_ATTEMPTS: %w", err)
+		}
+		options.MaxAttempts = attempts
+	}
+	if value := config.Config("REPLICATION_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid REPLICATION_INTERVAL: %w", err)
+		}
+		options.Interval = interval
+	}
+	return options, nil
+}
+
+// Enqueue marks the objects of a media to be copied to the secondary storage, after an upload or a change of its renditions
+func (service *ReplicationService) Enqueue(ctx context.Context, media *models.Media) error {
+	media.Replication = models.Replication{ReplicationStatus: models.ReplicationPending}
+	if err := service.mediaRepository.UpdateReplication(ctx, media.ID, media.Replication); err != nil {
+		return err
+	}
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run replicates the pending medias as soon as they are enqueued and retries failed copies until ctx is done
+func (service *ReplicationService) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.options.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := service.ReplicateDue(ctx); err != nil {
+			log.Printf("unable to replicate medias: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-service.wake:
+		}
+	}
+}
+
+// ReplicateDue copies the objects of the medias waiting for replication and returns the number of medias replicated
+func (service *ReplicationService) ReplicateDue(ctx context.Context) (int, error) {
+	// the medias of every organization are replicated to the same storage
+	ctx = repositories.WithAllTenants(ctx)
+	replicated := 0
+	for {
+		// the medias are locked for as long as a batch of copies can take
+		now := time.Now()
+		medias, err := service.mediaRepository.ClaimReplicationDue(ctx, service.options.MaxAttempts, now, now.Add(replicationLockDuration), replicationBatchSize)
+		if err != nil {
+			return replicated, err
+		}
+		for i := range medias {
+			if err := ctx.Err(); err != nil {
+				return replicated, err
+			}
+			if err := service.Replicate(ctx, &medias[i]); err != nil {
+				log.Printf("unable to replicate media %d (attempt %d): %s\n", medias[i].ID, medias[i].ReplicationAttempts, err)
+				continue
+			}
+			replicated++
+		}
+		// failed copies are retried later, medias left are due in a next batch
+		if len(medias) < replicationBatchSize {
+			return replicated, nil
+		}
+	}
+}
+
+// Replicate copies the original file and renditions of a media to the secondary storage and records the result
+func (service *ReplicationService) Replicate(ctx context.Context, media *models.Media) error {
+	objectKeys := []string{media.ObjectKey}
+	for _, renditionKey := range media.Renditions {
+		objectKeys = append(objectKeys, renditionKey)
+	}
+	var err error
+	for _, objectKey := range objectKeys {
+		if objectKey == "" {
+			continue
+		}
+		if _, err = copyVerified(ctx, service.primary, service.secondary, objectKey, objectKey); err != nil {
+			break
+		}
+	}
+
+	now := time.Now()
+	if err != nil {
+		attempts := media.ReplicationAttempts + 1
+		next := now.Add(replicationBackoff(service.options.Interval, attempts))
+		media.Replication = models.Replication{
+			ReplicationStatus:   models.ReplicationFailed,
+			ReplicationAttempts: attempts,
+			ReplicationError:    err.Error(),
+			NextReplicationAt:   &next,
+		}
+	} else {
+		media.Replication = models.Replication{
+			ReplicationStatus:   models.ReplicationReplicated,
+			ReplicationAttempts: media.ReplicationAttempts + 1,
+			ReplicatedAt:        &now,
+		}
+	}
+	if updateErr := service.mediaRepository.UpdateReplication(ctx, media.ID, media.Replication); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+// replicationBackoff doubles the delay before each new attempt
+func replicationBackoff(interval time.Duration, attempts int) time.Duration {
+	delay := interval
+	for i := 1; i < attempts && delay < maxReplicationBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxReplicationBackoff)
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replicationRepository keeps medias in memory, only the methods used by the replication are implemented
+type replicationRepository struct {
+	repositories.IMediaRepository
+	medias []models.Media
+}
+
+func (repository *replicationRepository) ClaimReplicationDue(ctx context.Context, maxAttempts int, now time.Time, lockedUntil time.Time, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	for i, media := range repository.medias {
+		replication := media.Replication
+		due := (replication.NextReplicationAt == nil || !replication.NextReplicationAt.After(now)) && (replication.ReplicationStatus == "" || replication.ReplicationStatus == models.ReplicationPending ||
+			(replication.ReplicationStatus == models.ReplicationFailed && replication.ReplicationAttempts < maxAttempts))
+		if due && len(medias) < limit {
+			medias = append(medias, media)
+			repository.medias[i].NextReplicationAt = &lockedUntil
+		}
+	}
+	return medias, nil
+}
+
+func (repository *replicationRepository) UpdateReplication(ctx context.Context, id uint, replication models.Replication) error {
+	for i := range repository.medias {
+		if repository.medias[i].ID == id {
+			repository.medias[i].Replication = replication
+		}
+	}
+	return nil
+}
+
+func TestReplicationService(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := NewMemoryStorage(StorageOptions{}), NewMemoryStorage(StorageOptions{})
+	require.NoError(t, primary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+	require.NoError(t, primary.PutObject(ctx, "renditions/goal/thumb.jpg", strings.NewReader("thumb"), 5, "image/jpeg"))
+	repository := &replicationRepository{medias: []models.Media{
+		{
+			ID: 1,
+			MediaFiles: models.MediaFiles{
+				ObjectKey:  "goal.jpg",
+				Renditions: models.RenditionMap{"thumb": "renditions/goal/thumb.jpg"},
+			},
+		},
+		{
+			ID:          2,
+			MediaFiles:  models.MediaFiles{ObjectKey: "kickoff.mp4"},
+			Replication: models.Replication{ReplicationStatus: models.ReplicationPending},
+		},
+		{
+			ID:          3,
+			MediaFiles:  models.MediaFiles{ObjectKey: "replicated.jpg"},
+			Replication: models.Replication{ReplicationStatus: models.ReplicationReplicated},
+		},
+	}}
+	service := NewReplicationService(repository, primary, secondary, ReplicationOptions{MaxAttempts: 2, Interval: time.Minute})
+
+	replicated, err := service.ReplicateDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replicated)
+	assert.Equal(t, models.ReplicationReplicated, repository.medias[0].ReplicationStatus)
+	assert.NotNil(t, repository.medias[0].ReplicatedAt)
+	assert.Equal(t, "goal", readObject(t, secondary, "goal.jpg"))
+	assert.Equal(t, "thumb", readObject(t, secondary, "renditions/goal/thumb.jpg"))
+
+	// kickoff.mp4 is not uploaded yet: the copy fails and is retried after a delay
+	failed := repository.medias[1].Replication
+	assert.Equal(t, models.ReplicationFailed, failed.ReplicationStatus)
+	assert.Equal(t, 1, failed.ReplicationAttempts)
+	assert.NotEmpty(t, failed.ReplicationError)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), *failed.NextReplicationAt, 5*time.Second)
+	replicated, err = service.ReplicateDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, replicated)
+	assert.Equal(t, 1, repository.medias[1].ReplicationAttempts)
+
+	require.NoError(t, primary.PutObject(ctx, "kickoff.mp4", strings.NewReader("video"), 5, "video/mp4"))
+	past := time.Now().Add(-time.Second)
+	repository.medias[1].NextReplicationAt = &past
+	replicated, err = service.ReplicateDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replicated)
+	assert.Equal(t, models.ReplicationReplicated, repository.medias[1].ReplicationStatus)
+	assert.Equal(t, "video", readObject(t, secondary, "kickoff.mp4"))
+}
+
+func TestReplicationBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, replicationBackoff(30*time.Second, 1))
+	assert.Equal(t, 2*time.Minute, replicationBackoff(30*time.Second, 3))
+	assert.Equal(t, time.Hour, replicationBackoff(30*time.Second, 20))
+}
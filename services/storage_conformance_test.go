@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorageConformance checks that a storage driver implements IStorageService like every other driver
+func testStorageConformance(t *testing.T, newStorage func(t *testing.T) IStorageService) {
+	ctx := context.Background()
+
+	t.Run("Put then stat and get an object", func(t *testing.T) {
+		storage := newStorage(t)
+		content := []byte("kick-off at 21:00")
+		err := storage.PutObject(ctx, "matches/kickoff.txt", bytes.NewReader(content), int64(len(content)), "text/plain")
+		require.NoError(t, err)
+
+		info, err := storage.StatObject(ctx, "matches/kickoff.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "matches/kickoff.txt", info.Key)
+		assert.Equal(t, int64(len(content)), info.Size)
+		assert.Equal(t, "text/plain", info.ContentType)
+		assert.NotEmpty(t, info.ETag)
+		assert.False(t, info.LastModified.IsZero())
+
+		object, err := storage.GetObject(ctx, "matches/kickoff.txt")
+		require.NoError(t, err)
+		defer object.Close()
+		data, err := io.ReadAll(object)
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+	})
+
+	t.Run("Put overwrites an existing object", func(t *testing.T) {
+		storage := newStorage(t)
+		err := storage.PutObject(ctx, "score.txt", strings.NewReader("0-0"), 3, "text/plain")
+		require.NoError(t, err)
+		before, err := storage.StatObject(ctx, "score.txt")
+		require.NoError(t, err)
+		err = storage.PutObject(ctx, "score.txt", strings.NewReader("1-0 (89')"), 9, "text/plain")
+		require.NoError(t, err)
+
+		after, err := storage.StatObject(ctx, "score.txt")
+		require.NoError(t, err)
+		assert.Equal(t, int64(9), after.Size)
+		assert.NotEqual(t, before.ETag, after.ETag)
+	})
+
+	t.Run("Same content has the same etag", func(t *testing.T) {
+		storage := newStorage(t)
+		err := storage.PutObject(ctx, "a.txt", strings.NewReader("goal"), 4, "text/plain")
+		require.NoError(t, err)
+		err = storage.PutObject(ctx, "b.txt", strings.NewReader("goal"), 4, "text/plain")
+		require.NoError(t, err)
+
+		a, err := storage.StatObject(ctx, "a.txt")
+		require.NoError(t, err)
+		b, err := storage.StatObject(ctx, "b.txt")
+		require.NoError(t, err)
+		assert.Equal(t, a.ETag, b.ETag)
+	})
+
+	t.Run("Get a range of an object", func(t *testing.T) {
+		storage := newStorage(t)
+		err := storage.PutObject(ctx, "clip.mp4", strings.NewReader("0123456789"), 10, "video/mp4")
+		require.NoError(t, err)
+
+		object, err := storage.GetObjectRange(ctx, "clip.mp4", 2, 5)
+		require.NoError(t, err)
+		data, err := io.ReadAll(object)
+		object.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "23456", string(data))
+
+		object, err = storage.GetObjectRange(ctx, "clip.mp4", 9, 1)
+		require.NoError(t, err)
+		data, err = io.ReadAll(object)
+		object.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "9", string(data))
+
+		_, err = storage.GetObjectRange(ctx, "missing.mp4", 0, 1)
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "unexpected error: %v", err)
+	})
+
+	t.Run("Missing objects are reported with ErrObjectNotFound", func(t *testing.T) {
+		storage := newStorage(t)
+		_, err := storage.StatObject(ctx, "missing.png")
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "unexpected error: %v", err)
+		_, err = storage.GetObject(ctx, "missing.png")
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "unexpected error: %v", err)
+	})
+
+	t.Run("Delete removes an object and ignores missing objects", func(t *testing.T) {
+		storage := newStorage(t)
+		err := storage.PutObject(ctx, "tmp/file.bin", bytes.NewReader([]byte{1, 2, 3}), 3, "")
+		require.NoError(t, err)
+
+		require.NoError(t, storage.DeleteObject(ctx, "tmp/file.bin"))
+		_, err = storage.StatObject(ctx, "tmp/file.bin")
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "unexpected error: %v", err)
+		assert.NoError(t, storage.DeleteObject(ctx, "tmp/file.bin"))
+	})
+
+	t.Run("List returns the objects starting with a prefix sorted by key", func(t *testing.T) {
+		storage := newStorage(t)
+		for _, name := range []string{"renditions/a/thumb.jpg", "a.jpg", "renditions/a/small.jpg", "renditions/b/thumb.jpg"} {
+			err := storage.PutObject(ctx, name, strings.NewReader(name), int64(len(name)), "image/jpeg")
+			require.NoError(t, err)
+		}
+
+		objects, err := storage.ListObjects(ctx, "renditions/a/")
+		require.NoError(t, err)
+		var keys []string
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+			assert.Equal(t, int64(len(object.Key)), object.Size)
+		}
+		assert.Equal(t, []string{"renditions/a/small.jpg", "renditions/a/thumb.jpg"}, keys)
+
+		objects, err = storage.ListObjects(ctx, "")
+		require.NoError(t, err)
+		assert.Len(t, objects, 4)
+	})
+
+	t.Run("Presigned urls give access to an object", func(t *testing.T) {
+		storage := newStorage(t)
+		err := storage.PutObject(ctx, "renditions/a b/thumb.jpg", strings.NewReader("thumb"), 5, "image/jpeg")
+		require.NoError(t, err)
+
+		presignedUrl, err := storage.PresignedGetObject(ctx, "renditions/a b/thumb.jpg")
+		require.NoError(t, err)
+		parsed, err := url.Parse(presignedUrl)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(parsed.Path, "/renditions/a b/thumb.jpg"), parsed.Path)
+		assert.NotEmpty(t, parsed.RawQuery)
+	})
+
+	t.Run("Upload stores a multipart file under a random name keeping its extension", func(t *testing.T) {
+		storage := newStorage(t)
+		fileHeader := multipartFile(t, "Mbappé goal.mp4", "video/mp4", []byte("video content"))
+
+		objectKey, err := storage.UploadObject(ctx, fileHeader)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(objectKey, ".mp4"), objectKey)
+
+		info, err := storage.StatObject(ctx, objectKey)
+		require.NoError(t, err)
+		assert.Equal(t, int64(13), info.Size)
+		assert.Equal(t, "video/mp4", info.ContentType)
+	})
+}
+
+func multipartFile(t *testing.T, filename string, contentType string, content []byte) *multipart.FileHeader {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	require.NoError(t, err)
+	part.Write(content)
+	writer.Close()
+
+	form, err := multipart.NewReader(body, writer.Boundary()).ReadForm(1 << 20)
+	require.NoError(t, err)
+	return form.File["file"][0]
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) IStorageService {
+		storage, err := NewStorage(context.Background(), StorageOptions{Driver: "memory", BucketName: "medias"})
+		require.NoError(t, err)
+		return storage
+	})
+}
+
+func TestFilesystemStorage(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) IStorageService {
+		storage, err := NewStorage(context.Background(), StorageOptions{Driver: "filesystem", BucketName: "medias", Path: t.TempDir()})
+		require.NoError(t, err)
+		return storage
+	})
+
+	t.Run("Object names cannot escape the bucket directory", func(t *testing.T) {
+		root := t.TempDir()
+		storage, err := NewStorage(context.Background(), StorageOptions{Driver: "filesystem", BucketName: "medias", Path: root})
+		require.NoError(t, err)
+		err = storage.PutObject(context.Background(), "../../outside.txt", strings.NewReader("x"), 1, "text/plain")
+		require.NoError(t, err)
+		_, err = os.Stat(root + "/medias/outside.txt")
+		assert.NoError(t, err)
+	})
+}
+
+// TestMinioStorage runs the conformance suite against a MinIO server when STORAGE_TEST_ENDPOINT is set
+// (example: STORAGE_TEST_ENDPOINT=localhost:9000 STORAGE_TEST_ACCESS_KEY_ID=... STORAGE_TEST_SECRET_ACCESS_KEY=...)
+func TestMinioStorage(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("STORAGE_TEST_ENDPOINT is not set")
+	}
+	testStorageConformance(t, func(t *testing.T) IStorageService {
+		storage, err := NewStorage(context.Background(), StorageOptions{
+			Driver:          "minio",
+			Endpoint:        endpoint,
+			AccessKeyID:     os.Getenv("STORAGE_TEST_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("STORAGE_TEST_SECRET_ACCESS_KEY"),
+			BucketName:      "conformance-" + uuid.NewString()[:8],
+			Region:          "us-east-1",
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			objects, _ := storage.ListObjects(context.Background(), "")
+			for _, object := range objects {
+				storage.DeleteObject(context.Background(), object.Key)
+			}
+		})
+		return storage
+	})
+}
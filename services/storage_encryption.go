@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Server-side encryption modes of the minio driver
+const (
+	// Objects are encrypted with keys managed by the storage
+	EncryptionSSES3 = "sse-s3"
+	// Objects are encrypted with keys of a local keyring sent along with every request
+	EncryptionSSEC = "sse-c"
+)
+
+var ErrInvalidKeyring = errors.New("invalid keyring")
+
+// IEncryptedStorage is implemented by the storages encrypting objects on the server side
+type IEncryptedStorage interface {
+	// EncryptionKeyID returns the id of the key encrypting new objects
+	EncryptionKeyID() string
+	// Reencrypt encrypts an object again with the current key
+	Reencrypt(ctx context.Context, objectName string) error
+}
+
+// Keyring holds the SSE-C keys by id. New objects are encrypted with the current key,
+// the other keys are kept to read objects until they are re-encrypted.
+type Keyring struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+// LoadKeyring reads a keyring file holding base64 encoded 256 bits keys:
+//
+//	{"current": "2024-06", "keys": {"2024-06": "<base64 key>", "2023-11": "<base64 key>"}}
+func LoadKeyring(path string) (*Keyring, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keyring: %w", err)
+	}
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKeyring, err)
+	}
+	keyring := &Keyring{Current: file.Current, Keys: map[string][]byte{}}
+	for id, value := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%w: key %s must be 32 bytes encoded in base64", ErrInvalidKeyring, id)
+		}
+		keyring.Keys[id] = key
+	}
+	if _, found := keyring.Keys[keyring.Current]; !found {
+		return nil, fmt.Errorf("%w: current key %q not found", ErrInvalidKeyring, keyring.Current)
+	}
+	return keyring, nil
+}
+
+// KeyIDs returns the id of the current key followed by the ids of the older keys
+func (keyring *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(keyring.Keys))
+	for id := range keyring.Keys {
+		if id != keyring.Current {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return append([]string{keyring.Current}, ids...)
+}
+
+type encryptionKeyContextKey struct{}
+
+// WithEncryptionKeyID tells the storage which key encrypted the objects read with ctx, to avoid trying every key
+func WithEncryptionKeyID(ctx context.Context, keyID string) context.Context {
+	if keyID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, encryptionKeyContextKey{}, keyID)
+}
+
+func encryptionKeyID(ctx context.Context) string {
+	keyID, _ := ctx.Value(encryptionKeyContextKey{}).(string)
+	return keyID
+}
+
+// storageEncryptionKeyID returns the id of the key encrypting new objects of storage, empty when it does not encrypt them
+func storageEncryptionKeyID(storage IStorageService) string {
+	if encrypted, ok := storage.(IEncryptedStorage); ok {
+		return encrypted.EncryptionKeyID()
+	}
+	return ""
+}
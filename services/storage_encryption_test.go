@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyring writes a keyring file with a random key for each id
+func writeKeyring(t *testing.T, path string, current string, ids ...string) {
+	keys := map[string]string{}
+	for _, id := range ids {
+		key := make([]byte, 32)
+		rand.Read(key)
+		keys[id] = base64.StdEncoding.EncodeToString(key)
+	}
+	writeKeyringFile(t, path, current, keys)
+}
+
+func writeKeyringFile(t *testing.T, path string, current string, keys map[string]string) {
+	content, err := json.Marshal(map[string]any{"current": current, "keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, content, 0o600))
+}
+
+func TestLoadKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		description   string
+		current       string
+		keys          map[string]string
+		expectedError bool
+		expectedIDs   []string
+	}{
+		{
+			description: "Load keyring should return the current key first, then the older keys from the most recent",
+			current:     "2024-06",
+			keys:        map[string]string{"2023-11": key, "2024-06": key, "2024-01": key},
+			expectedIDs: []string{"2024-06", "2024-01", "2023-11"},
+		},
+		{
+			description:   "Load keyring should return an error when the current key is missing",
+			current:       "2024-06",
+			keys:          map[string]string{"2023-11": key},
+			expectedError: true,
+		},
+		{
+			description:   "Load keyring should return an error for a key which is not 256 bits long",
+			current:       "2024-06",
+			keys:          map[string]string{"2024-06": base64.StdEncoding.EncodeToString(make([]byte, 16))},
+			expectedError: true,
+		},
+		{
+			description:   "Load keyring should return an error for a key which is not base64 encoded",
+			current:       "2024-06",
+			keys:          map[string]string{"2024-06": "not a key"},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keyring.json")
+			writeKeyringFile(t, path, tt.current, tt.keys)
+
+			keyring, err := LoadKeyring(path)
+
+			if tt.expectedError {
+				assert.ErrorIs(t, err, ErrInvalidKeyring)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedIDs, keyring.KeyIDs())
+		})
+	}
+}
+
+func TestNewStorageEncryption(t *testing.T) {
+	_, err := NewStorage(context.Background(), StorageOptions{Driver: "memory", Encryption: EncryptionSSES3})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	writeKeyring(t, path, "2024-06", "2024-06")
+	_, err = NewStorageService(StorageOptions{Endpoint: "localhost:9000", Encryption: EncryptionSSEC, KeyringPath: path})
+	assert.Error(t, err, "SSE-C keys must not be sent without TLS")
+}
+
+// TestMinioEncryption encrypts objects with SSE-C keys when STORAGE_TEST_ENDPOINT is set to a MinIO server
+// accepting TLS connections (STORAGE_TEST_USE_SSL=true)
+func TestMinioEncryption(t *testing.T) {
+	endpoint := os.Getenv("STORAGE_TEST_ENDPOINT")
+	if endpoint == "" || os.Getenv("STORAGE_TEST_USE_SSL") != "true" {
+		t.Skip("STORAGE_TEST_ENDPOINT is not set or STORAGE_TEST_USE_SSL is not true")
+	}
+	ctx := context.Background()
+	bucketName := "encryption-" + uuid.NewString()[:8]
+	keyringPath := filepath.Join(t.TempDir(), "keyring.json")
+	newEncryptedStorage := func() IStorageService {
+		options := StorageOptions{
+			Driver:          "minio",
+			Endpoint:        endpoint,
+			AccessKeyID:     os.Getenv("STORAGE_TEST_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("STORAGE_TEST_SECRET_ACCESS_KEY"),
+			UseSSL:          true,
+			BucketName:      bucketName,
+			Region:          "us-east-1",
+			Encryption:      EncryptionSSEC,
+			KeyringPath:     keyringPath,
+		}
+		storage, err := NewStorage(ctx, options)
+		require.NoError(t, err)
+		return storage
+	}
+
+	writeKeyring(t, keyringPath, "2024-01", "2024-01")
+	storage := newEncryptedStorage()
+	require.NoError(t, storage.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+	assert.Equal(t, "goal", readObject(t, storage, "goal.jpg"))
+
+	// a new key is added: objects encrypted with the previous one stay readable until they are re-encrypted
+	content, err := os.ReadFile(keyringPath)
+	require.NoError(t, err)
+	var keyring struct {
+		Keys map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(content, &keyring))
+	keyring.Keys["2024-06"] = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	writeKeyringFile(t, keyringPath, "2024-06", keyring.Keys)
+	storage = newEncryptedStorage()
+	assert.Equal(t, "2024-06", storage.(IEncryptedStorage).EncryptionKeyID())
+	assert.Equal(t, "goal", readObject(t, storage, "goal.jpg"))
+
+	require.NoError(t, storage.(IEncryptedStorage).Reencrypt(WithEncryptionKeyID(ctx, "2024-01"), "goal.jpg"))
+	delete(keyring.Keys, "2024-01")
+	writeKeyringFile(t, keyringPath, "2024-06", keyring.Keys)
+	storage = newEncryptedStorage()
+	assert.Equal(t, "goal", readObject(t, storage, "goal.jpg"))
+	info, err := storage.StatObject(ctx, "goal.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", info.ContentType)
+	storage.DeleteObject(ctx, "goal.jpg")
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Directory holding the metadata (content type, etag) of the objects of a bucket
+const filesystemMetadataDir = ".metadata"
+
+// FilesystemStorage stores objects as files in a directory per bucket
+type FilesystemStorage struct {
+	root        string
+	signer      *ObjectUrlSigner
+	keyTemplate ObjectKeyTemplate
+	bucketPath  string
+	bucketName  string
+}
+
+type filesystemMetadata struct {
+	ContentType string `json:"contentType"`
+	ETag        string `json:"etag"`
+}
+
+func init() {
+	RegisterStorageDriver("filesystem", func(options StorageOptions) (IStorageService, error) {
+		return NewFilesystemStorage(options)
+	})
+}
+
+func NewFilesystemStorage(options StorageOptions) (*FilesystemStorage, error) {
+	root := options.Path
+	if root == "" {
+		root = "data"
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	return &FilesystemStorage{
+		root:        root,
+		signer:      NewObjectUrlSigner(options),
+		keyTemplate: options.KeyTemplate,
+	}, nil
+}
+
+func (storage *FilesystemStorage) CreateBucket(ctx context.Context, bucketName string) error {
+	if bucketName == "" || strings.ContainsAny(bucketName, `/\`) || bucketName == "." || bucketName == ".." {
+		return fmt.Errorf("invalid bucket name %q", bucketName)
+	}
+	bucketPath := filepath.Join(storage.root, bucketName)
+	if err := os.MkdirAll(filepath.Join(bucketPath, filesystemMetadataDir), 0o755); err != nil {
+		return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+	}
+	storage.bucketName = bucketName
+	storage.bucketPath = bucketPath
+	return nil
+}
+
+func (storage *FilesystemStorage) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
+	return uploadFile(ctx, storage, fileHeader, storage.keyTemplate)
+}
+
+func (storage *FilesystemStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	filePath, err := storage.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that readers never see a partial object
+	file, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write object %s: %w", objectName, err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("unable to write object %s: wrote %d bytes, expected %d", objectName, written, size)
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	metadata := filesystemMetadata{ContentType: contentType, ETag: hex.EncodeToString(hash.Sum(nil))}
+	if err := storage.writeMetadata(objectName, metadata); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filePath)
+}
+
+func (storage *FilesystemStorage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	filePath, err := storage.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+		}
+		return nil, fmt.Errorf("unable to get object %s: %w", objectName, err)
+	}
+	return file, nil
+}
+
+// GetObjectRange reads length bytes of an object starting at offset
+func (storage *FilesystemStorage) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	object, err := storage.GetObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	file := object.(*os.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to seek object %s: %w", objectName, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (storage *FilesystemStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	filePath, err := storage.objectPath(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+		}
+		return ObjectInfo{}, fmt.Errorf("unable to stat object %s: %w", objectName, err)
+	}
+	return storage.objectInfo(objectName, info), nil
+}
+
+func (storage *FilesystemStorage) DeleteObject(ctx context.Context, objectName string) error {
+	filePath, err := storage.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to delete object %s: %w", objectName, err)
+	}
+	if err := os.Remove(storage.metadataPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("unable to delete metadata of object %s: %s\n", objectName, err)
+	}
+	return nil
+}
+
+func (storage *FilesystemStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	err := filepath.WalkDir(storage.bucketPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == filesystemMetadataDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		relative, err := filepath.Rel(storage.bucketPath, filePath)
+		if err != nil {
+			return err
+		}
+		objectName := filepath.ToSlash(relative)
+		if !strings.HasPrefix(objectName, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, storage.objectInfo(objectName, info))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list objects: %w", err)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// objectPath returns the path of an object, rejecting names escaping the bucket directory
+func (storage *FilesystemStorage) objectPath(objectName string) (string, error) {
+	if storage.bucketPath == "" {
+		return "", errors.New("bucket is not created")
+	}
+	cleaned := path.Clean("/" + objectName)
+	if objectName == "" || cleaned == "/" || strings.HasPrefix(cleaned, "/"+filesystemMetadataDir+"/") {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+	return filepath.Join(storage.bucketPath, filepath.FromSlash(cleaned)), nil
+}
+
+func (storage *FilesystemStorage) metadataPath(filePath string) string {
+	relative, _ := filepath.Rel(storage.bucketPath, filePath)
+	return filepath.Join(storage.bucketPath, filesystemMetadataDir, relative+".json")
+}
+
+func (storage *FilesystemStorage) writeMetadata(objectName string, metadata filesystemMetadata) error {
+	filePath, err := storage.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	metadataPath := storage.metadataPath(filePath)
+	if err := os.MkdirAll(filepath.Dir(metadataPath), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataPath, data, 0o644)
+}
+
+func (storage *FilesystemStorage) objectInfo(objectName string, info fs.FileInfo) ObjectInfo {
+	result := ObjectInfo{
+		Key:          objectName,
+		Size:         info.Size(),
+		ContentType:  "application/octet-stream",
+		LastModified: info.ModTime(),
+	}
+	filePath, _ := storage.objectPath(objectName)
+	if data, err := os.ReadFile(storage.metadataPath(filePath)); err == nil {
+		metadata := filesystemMetadata{}
+		if err := json.Unmarshal(data, &metadata); err == nil {
+			result.ContentType = metadata.ContentType
+			result.ETag = metadata.ETag
+		}
+	}
+	return result
+}
+
+func (storage *FilesystemStorage) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	if _, err := storage.objectPath(objectName); err != nil {
+		return "", err
+	}
+	return storage.signer.Sign(storage.bucketName, objectName), nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory, for tests and local development
+type MemoryStorage struct {
+	mu          sync.RWMutex
+	objects     map[string]memoryObject
+	bucketName  string
+	signer      *ObjectUrlSigner
+	keyTemplate ObjectKeyTemplate
+}
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified time.Time
+}
+
+func init() {
+	RegisterStorageDriver("memory", func(options StorageOptions) (IStorageService, error) {
+		return NewMemoryStorage(options), nil
+	})
+}
+
+func NewMemoryStorage(options StorageOptions) *MemoryStorage {
+	return &MemoryStorage{
+		objects:     map[string]memoryObject{},
+		signer:      NewObjectUrlSigner(options),
+		keyTemplate: options.KeyTemplate,
+	}
+}
+
+func (storage *MemoryStorage) CreateBucket(ctx context.Context, bucketName string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.bucketName = bucketName
+	return nil
+}
+
+func (storage *MemoryStorage) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
+	return uploadFile(ctx, storage, fileHeader, storage.keyTemplate)
+}
+
+func (storage *MemoryStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	if objectName == "" {
+		return fmt.Errorf("invalid object name %q", objectName)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("unable to read object %s: %w", objectName, err)
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("unable to write object %s: read %d bytes, expected %d", objectName, len(data), size)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	hash := md5.Sum(data)
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.objects[objectName] = memoryObject{
+		data:         data,
+		contentType:  contentType,
+		etag:         hex.EncodeToString(hash[:]),
+		lastModified: time.Now(),
+	}
+	return nil
+}
+
+func (storage *MemoryStorage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	object, found := storage.objects[objectName]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+// GetObjectRange reads length bytes of an object starting at offset
+func (storage *MemoryStorage) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	object, found := storage.objects[objectName]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+	}
+	start := min(offset, int64(len(object.data)))
+	end := min(start+length, int64(len(object.data)))
+	return io.NopCloser(bytes.NewReader(object.data[start:end])), nil
+}
+
+func (storage *MemoryStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	object, found := storage.objects[objectName]
+	if !found {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+	}
+	return object.info(objectName), nil
+}
+
+func (storage *MemoryStorage) DeleteObject(ctx context.Context, objectName string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	delete(storage.objects, objectName)
+	return nil
+}
+
+func (storage *MemoryStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	objects := []ObjectInfo{}
+	for name, object := range storage.objects {
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, object.info(name))
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (storage *MemoryStorage) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return storage.signer.Sign(storage.bucketName, objectName), nil
+}
+
+func (object memoryObject) info(objectName string) ObjectInfo {
+	return ObjectInfo{
+		Key:          objectName,
+		Size:         int64(len(object.data)),
+		ContentType:  object.contentType,
+		ETag:         object.etag,
+		LastModified: object.lastModified,
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Values of the storage_circuit_breaker_state gauge
+var circuitStateValues = map[string]float64{
+	CircuitClosed:   0,
+	CircuitHalfOpen: 1,
+	CircuitOpen:     2,
+}
+
+var (
+	storageCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "storage_circuit_breaker_state",
+		Help: "State of the circuit breaker of a storage: 0 closed, 1 half-open, 2 open.",
+	}, []string{"storage"})
+	storageCircuitOpened = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_circuit_breaker_opened_total",
+		Help: "Number of times the circuit breaker of a storage opened.",
+	}, []string{"storage"})
+	storageRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_retries_total",
+		Help: "Number of storage operations retried after a failure.",
+	}, []string{"storage", "operation"})
+	storageTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_timeouts_total",
+		Help: "Number of storage operations which did not complete within their timeout.",
+	}, []string{"storage", "operation"})
+	storageOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "storage_operation_duration_seconds",
+		Help:    "Duration of the storage operations with their retries, until the object starts streaming for reads.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 4, 9),
+	}, []string{"storage", "operation"})
+	storageOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_operation_errors_total",
+		Help: "Number of storage operations which failed after their retries. Missing objects are not counted.",
+	}, []string{"storage", "operation"})
+)
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// StorageMigrationOptions configures a copy of the medias from a storage to another
+type StorageMigrationOptions struct {
+	// Name of the migration in the progress table, a migration with the same name resumes it
+	Name string
+	// Number of medias copied concurrently
+	Workers int
+	// Layout of the object keys in the target storage, keys are kept when empty
+	KeyTemplate ObjectKeyTemplate
+}
+
+// StorageMigrationResult counts the medias processed by a storage migration
+type StorageMigrationResult struct {
+	Copied  int
+	Skipped int
+	Failed  int
+}
+
+// StorageMigration copies every object referenced by medias from a source storage to a target storage
+type StorageMigration struct {
+	mediaRepository    repositories.IMediaRepository
+	progressRepository repositories.IStorageMigrationRepository
+	source             IStorageService
+	target             IStorageService
+	options            StorageMigrationOptions
+}
+
+func NewStorageMigration(mediaRepository repositories.IMediaRepository, progressRepository repositories.IStorageMigrationRepository, source IStorageService, target IStorageService, options StorageMigrationOptions) *StorageMigration {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	return &StorageMigration{
+		mediaRepository:    mediaRepository,
+		progressRepository: progressRepository,
+		source:             source,
+		target:             target,
+		options:            options,
+	}
+}
+
+// Run copies the original file and renditions of every media not copied yet by the migration.
+// A media points to its new objects only once all of them are copied and verified.
+func (migration *StorageMigration) Run(ctx context.Context) (StorageMigrationResult, error) {
+	ctx = repositories.WithAllTenants(ctx)
+	var (
+		result StorageMigrationResult
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	medias := make(chan models.Media)
+	for i := 0; i < migration.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for media := range medias {
+				err := migration.migrate(ctx, &media)
+				mu.Lock()
+				if err != nil {
+					log.Printf("unable to copy media %d: %s\n", media.ID, err)
+					result.Failed++
+				} else {
+					result.Copied++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	skipped, err := migration.enqueue(ctx, medias)
+	close(medias)
+	wg.Wait()
+	result.Skipped = skipped
+	return result, err
+}
+
+// enqueue goes through the medias in batches, sends those not copied yet by the migration
+// and returns the number of medias skipped
+func (migration *StorageMigration) enqueue(ctx context.Context, medias chan<- models.Media) (int, error) {
+	skipped := 0
+	var lastID uint
+	for {
+		if err := ctx.Err(); err != nil {
+			return skipped, err
+		}
+		batch, err := migration.mediaRepository.FindAfter(ctx, lastID, objectKeyMigrationBatchSize)
+		if err != nil {
+			return skipped, err
+		}
+		if len(batch) == 0 {
+			return skipped, nil
+		}
+		ids := make([]uint, len(batch))
+		for i, media := range batch {
+			ids[i] = media.ID
+		}
+		copiedIDs, err := migration.progressRepository.FindCopied(migration.options.Name, ids)
+		if err != nil {
+			return skipped, err
+		}
+		copied := map[uint]bool{}
+		for _, id := range copiedIDs {
+			copied[id] = true
+		}
+
+		for _, media := range batch {
+			lastID = media.ID
+			if copied[media.ID] || media.ObjectKey == "" {
+				skipped++
+				continue
+			}
+			medias <- media
+		}
+	}
+}
+
+// migrate copies the objects of a media and records the result in the progress table
+func (migration *StorageMigration) migrate(ctx context.Context, media *models.Media) error {
+	progress := &models.StorageMigrationProgress{Migration: migration.options.Name, MediaID: media.ID}
+	objectKey, renditions := media.ObjectKey, media.Renditions
+	if migration.options.KeyTemplate != "" {
+		objectKey = templateObjectKey(migration.options.KeyTemplate, media)
+		renditions = movedRenditions(media.Renditions, objectKey)
+	}
+
+	checksum, err := copyVerified(ctx, migration.source, migration.target, media.ObjectKey, objectKey)
+	for size, renditionKey := range media.Renditions {
+		if err != nil {
+			break
+		}
+		_, err = copyVerified(ctx, migration.source, migration.target, renditionKey, renditions[size])
+	}
+	if err != nil {
+		progress.Error = err.Error()
+		if err := migration.progressRepository.Fail(progress); err != nil {
+			log.Printf("unable to save progress of media %d: %s\n", media.ID, err)
+		}
+		return err
+	}
+
+	progress.Checksum = checksum
+	return migration.progressRepository.Complete(progress, objectKey, renditions)
+}
+
+// copyVerified copies an object from a storage to another, reads the copy back to compare its checksum
+// and returns the hex encoded SHA-256 of the object
+func copyVerified(ctx context.Context, source IStorageService, target IStorageService, sourceKey string, targetKey string) (string, error) {
+	info, err := source.StatObject(ctx, sourceKey)
+	if err != nil {
+		return "", err
+	}
+	object, err := source.GetObject(ctx, sourceKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	err = target.PutObject(ctx, targetKey, io.TeeReader(object, hash), info.Size, info.ContentType)
+	object.Close()
+	if err != nil {
+		return "", err
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	copied, err := target.GetObject(ctx, targetKey)
+	if err != nil {
+		return "", err
+	}
+	defer copied.Close()
+	copyHash := sha256.New()
+	size, err := io.Copy(copyHash, copied)
+	if err != nil {
+		return "", fmt.Errorf("unable to read copy of %s: %w", sourceKey, err)
+	}
+	if size != info.Size || hex.EncodeToString(copyHash.Sum(nil)) != checksum {
+		return "", fmt.Errorf("%w: %s copied to %s", ErrChecksumMismatch, sourceKey, targetKey)
+	}
+	return checksum, nil
+}
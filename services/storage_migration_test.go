@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// progressRepository keeps the progress of storage migrations in memory
+type progressRepository struct {
+	mu       sync.Mutex
+	medias   *keyMigrationRepository
+	progress map[uint]models.StorageMigrationProgress
+}
+
+func (repository *progressRepository) FindCopied(migration string, mediaIDs []uint) ([]uint, error) {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	var ids []uint
+	for _, id := range mediaIDs {
+		if progress, found := repository.progress[id]; found && progress.Migration == migration && progress.Status == models.StorageMigrationCopied {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (repository *progressRepository) Complete(progress *models.StorageMigrationProgress, objectKey string, renditions models.RenditionMap) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	progress.Status = models.StorageMigrationCopied
+	repository.progress[progress.MediaID] = *progress
+	return repository.medias.UpdateObjectKey(context.Background(), progress.MediaID, objectKey, renditions)
+}
+
+func (repository *progressRepository) Fail(progress *models.StorageMigrationProgress) error {
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	progress.Status = models.StorageMigrationFailed
+	repository.progress[progress.MediaID] = *progress
+	return nil
+}
+
+// corruptingStorage alters the content of an object when it is written
+type corruptingStorage struct {
+	*MemoryStorage
+	objectName string
+}
+
+func (storage *corruptingStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	if objectName != storage.objectName {
+		return storage.MemoryStorage.PutObject(ctx, objectName, reader, size, contentType)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	data[0] ^= 0xff
+	return storage.MemoryStorage.PutObject(ctx, objectName, strings.NewReader(string(data)), size, contentType)
+}
+
+func TestStorageMigration(t *testing.T) {
+	ctx := context.Background()
+	source := NewMemoryStorage(StorageOptions{})
+	objects := map[string]string{
+		"goal.jpg":                  "goal photo",
+		"renditions/goal/thumb.jpg": "goal thumbnail",
+		"kickoff.mp4":               "kick-off video",
+	}
+	for key, content := range objects {
+		require.NoError(t, source.PutObject(ctx, key, strings.NewReader(content), int64(len(content)), "application/octet-stream"))
+	}
+	medias := &keyMigrationRepository{medias: []models.Media{
+		{
+			ID:        1,
+			CreatedAt: time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC),
+			MediaFiles: models.MediaFiles{
+				ObjectKey:  "goal.jpg",
+				Renditions: models.RenditionMap{"thumb": "renditions/goal/thumb.jpg"},
+			},
+		},
+		{
+			ID:         2,
+			CreatedAt:  time.Date(2024, time.March, 10, 9, 0, 0, 0, time.UTC),
+			MediaFiles: models.MediaFiles{ObjectKey: "kickoff.mp4"},
+		},
+		{
+			ID:         3,
+			MediaFiles: models.MediaFiles{ObjectKey: "missing.jpg"},
+		},
+	}}
+	progress := &progressRepository{medias: medias, progress: map[uint]models.StorageMigrationProgress{}}
+	target := &corruptingStorage{MemoryStorage: NewMemoryStorage(StorageOptions{}), objectName: "kickoff.mp4"}
+	options := StorageMigrationOptions{Name: "minio->s3", Workers: 2}
+
+	result, err := NewStorageMigration(medias, progress, source, target, options).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, StorageMigrationResult{Copied: 1, Failed: 2}, result)
+
+	assert.Equal(t, "goal photo", readObject(t, target, "goal.jpg"))
+	assert.Equal(t, "goal thumbnail", readObject(t, target, "renditions/goal/thumb.jpg"))
+	checksum := sha256.Sum256([]byte("goal photo"))
+	assert.Equal(t, models.StorageMigrationProgress{Migration: "minio->s3", MediaID: 1, Status: models.StorageMigrationCopied, Checksum: hex.EncodeToString(checksum[:])}, progress.progress[1])
+	assert.Equal(t, models.StorageMigrationFailed, progress.progress[2].Status)
+	assert.Contains(t, progress.progress[2].Error, ErrChecksumMismatch.Error())
+	assert.Equal(t, models.StorageMigrationFailed, progress.progress[3].Status)
+
+	// the migration is resumed: copied medias are skipped and failed ones are copied again
+	target.objectName = ""
+	options.KeyTemplate = DefaultObjectKeyTemplate
+	result, err = NewStorageMigration(medias, progress, source, target, options).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, StorageMigrationResult{Copied: 1, Skipped: 1, Failed: 1}, result)
+	assert.Equal(t, "goal.jpg", medias.medias[0].ObjectKey)
+	assert.True(t, strings.HasPrefix(medias.medias[1].ObjectKey, "default/2024/03/10/"), medias.medias[1].ObjectKey)
+	assert.Equal(t, "kick-off video", readObject(t, target, medias.medias[1].ObjectKey))
+	assert.Equal(t, "missing.jpg", medias.medias[2].ObjectKey)
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+)
+
+// StorageOptions configures a storage driver. Drivers only use the options they need.
+type StorageOptions struct {
+	// Name of the storage in logs, health and metrics (default: the driver)
+	Name            string
+	Driver          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	BucketName      string
+	Region          string
+	// Root directory of the filesystem driver
+	Path string
+	// Base url of the objects served by the API for the filesystem and memory drivers
+	PublicUrl string
+	// Lifetime of presigned urls
+	PresignTTL time.Duration
+	// Key signing the urls of the filesystem and memory drivers
+	UrlSigningKey string
+	// Layout of the object keys of uploaded files
+	KeyTemplate ObjectKeyTemplate
+	// Server-side encryption of the minio driver: empty, sse-s3 or sse-c
+	Encryption string
+	// Keyring file holding the SSE-C keys
+	KeyringPath string
+	// Timeouts, retries and circuit breaker of the storage operations
+	Resilience ResilienceOptions
+}
+
+const defaultPresignTTL = 15 * time.Minute
+
+// StorageDriver creates a storage service from its options
+type StorageDriver func(options StorageOptions) (IStorageService, error)
+
+var (
+	storageDriversMu sync.RWMutex
+	storageDrivers   = map[string]StorageDriver{}
+)
+
+// RegisterStorageDriver makes a storage driver available by name
+func RegisterStorageDriver(name string, driver StorageDriver) {
+	storageDriversMu.Lock()
+	defer storageDriversMu.Unlock()
+	storageDrivers[name] = driver
+}
+
+// StorageDrivers returns the names of the registered storage drivers
+func StorageDrivers() []string {
+	storageDriversMu.RLock()
+	defer storageDriversMu.RUnlock()
+	names := make([]string, 0, len(storageDrivers))
+	for name := range storageDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadStorageOptions reads the storage options from the environment variables starting with prefix
+// (example: STORAGE_DRIVER, STORAGE_ENDPOINT... for the STORAGE prefix)
+func LoadStorageOptions(prefix string) (StorageOptions, error) {
+	options := StorageOptions{
+		Name:            strings.ToLower(prefix),
+		Driver:          config.Config(prefix + "_DRIVER"),
+		Endpoint:        config.Config(prefix + "_ENDPOINT"),
+		AccessKeyID:     config.Config(prefix + "_ACCESS_KEY_ID"),
+		SecretAccessKey: config.Config(prefix + "_SECRET_ACCESS_KEY"),
+		BucketName:      config.Config(prefix + "_BUCKET_NAME"),
+		Region:          config.Config(prefix + "_BUCKET_REGION"),
+		Path:            config.Config(prefix + "_PATH"),
+		PublicUrl:       config.Config(prefix + "_PUBLIC_URL"),
+		UrlSigningKey:   config.Config(prefix + "_URL_SIGNING_KEY"),
+		Encryption:      config.Config(prefix + "_ENCRYPTION"),
+		KeyringPath:     config.Config(prefix + "_KEYRING"),
+		PresignTTL:      defaultPresignTTL,
+	}
+	options.UseSSL, _ = strconv.ParseBool(config.Config(prefix + "_USE_SSL"))
+	if options.Driver == "" {
+		options.Driver = "minio"
+	}
+	keyTemplate, err := ParseObjectKeyTemplate(config.Config(prefix + "_KEY_TEMPLATE"))
+	if err != nil {
+		return options, fmt.Errorf("invalid %s_KEY_TEMPLATE: %w", prefix, err)
+	}
+	options.KeyTemplate = keyTemplate
+	switch options.Encryption {
+	case "", EncryptionSSES3:
+	case EncryptionSSEC:
+		if options.KeyringPath == "" {
+			return options, fmt.Errorf("%s_KEYRING is required with %s encryption", prefix, EncryptionSSEC)
+		}
+	default:
+		return options, fmt.Errorf("invalid %s_ENCRYPTION %q (available: %s, %s)", prefix, options.Encryption, EncryptionSSES3, EncryptionSSEC)
+	}
+	if value := config.Config(prefix + "_PRESIGN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid %s_PRESIGN_TTL: %w", prefix, err)
+		}
+		options.PresignTTL = ttl
+	}
+	resilience, err := loadResilienceOptions(prefix)
+	if err != nil {
+		return options, err
+	}
+	options.Resilience = resilience
+	return options, nil
+}
+
+// NewStorage creates a storage service with the driver selected in the options, wrapped in a ResilientStorage,
+// and creates its bucket
+func NewStorage(ctx context.Context, options StorageOptions) (IStorageService, error) {
+	storageDriversMu.RLock()
+	driver, found := storageDrivers[options.Driver]
+	storageDriversMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown storage driver %q (available: %v)", options.Driver, StorageDrivers())
+	}
+	if options.Encryption != "" && options.Driver != "minio" {
+		return nil, fmt.Errorf("server-side encryption is not supported by the %s storage driver", options.Driver)
+	}
+
+	if options.PresignTTL <= 0 {
+		options.PresignTTL = defaultPresignTTL
+	}
+	if options.KeyTemplate == "" {
+		options.KeyTemplate = DefaultObjectKeyTemplate
+	}
+	if options.Name == "" {
+		options.Name = options.Driver
+	}
+	driverStorage, err := driver(options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize %s storage: %w", options.Driver, err)
+	}
+	storage := NewResilientStorage(options.Name, driverStorage, options.Resilience)
+	if err := storage.CreateBucket(ctx, options.BucketName); err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+)
+
+// ReplicatedStorage writes objects to a primary storage and reads them from a secondary storage
+// holding their replicas when the primary one fails. Objects are copied by the ReplicationService.
+type ReplicatedStorage struct {
+	primary   IStorageService
+	secondary IStorageService
+}
+
+func NewReplicatedStorage(primary IStorageService, secondary IStorageService) *ReplicatedStorage {
+	return &ReplicatedStorage{
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+func (storage *ReplicatedStorage) CreateBucket(ctx context.Context, bucketName string) error {
+	return storage.primary.CreateBucket(ctx, bucketName)
+}
+
+func (storage *ReplicatedStorage) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
+	return storage.primary.UploadObject(ctx, fileHeader)
+}
+
+func (storage *ReplicatedStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	return storage.primary.PutObject(ctx, objectName, reader, size, contentType)
+}
+
+func (storage *ReplicatedStorage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	object, err := storage.primary.GetObject(ctx, objectName)
+	if err == nil {
+		return object, nil
+	}
+	replica, replicaErr := storage.secondary.GetObject(ctx, objectName)
+	if replicaErr != nil {
+		return nil, err
+	}
+	log.Printf("unable to read object %s on the primary storage, using its replica: %s\n", objectName, err)
+	return replica, nil
+}
+
+func (storage *ReplicatedStorage) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	object, err := storage.primary.GetObjectRange(ctx, objectName, offset, length)
+	if err == nil {
+		return object, nil
+	}
+	replica, replicaErr := storage.secondary.GetObjectRange(ctx, objectName, offset, length)
+	if replicaErr != nil {
+		return nil, err
+	}
+	log.Printf("unable to read object %s on the primary storage, using its replica: %s\n", objectName, err)
+	return replica, nil
+}
+
+func (storage *ReplicatedStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	info, err := storage.primary.StatObject(ctx, objectName)
+	if err == nil {
+		return info, nil
+	}
+	replica, replicaErr := storage.secondary.StatObject(ctx, objectName)
+	if replicaErr != nil {
+		return ObjectInfo{}, err
+	}
+	log.Printf("unable to stat object %s on the primary storage, using its replica: %s\n", objectName, err)
+	return replica, nil
+}
+
+// DeleteObject deletes an object and its replica
+func (storage *ReplicatedStorage) DeleteObject(ctx context.Context, objectName string) error {
+	if err := storage.primary.DeleteObject(ctx, objectName); err != nil {
+		return err
+	}
+	if err := storage.secondary.DeleteObject(ctx, objectName); err != nil {
+		log.Printf("unable to delete replica of object %s: %s\n", objectName, err)
+	}
+	return nil
+}
+
+func (storage *ReplicatedStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := storage.primary.ListObjects(ctx, prefix)
+	if err != nil {
+		if replicas, replicaErr := storage.secondary.ListObjects(ctx, prefix); replicaErr == nil {
+			return replicas, nil
+		}
+	}
+	return objects, err
+}
+
+// PresignedGetObject signs a URL of the primary storage without checking that the object exists, or of the secondary
+// storage while the primary one is unavailable (its circuit breaker is open)
+func (storage *ReplicatedStorage) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	presignedUrl, err := storage.primary.PresignedGetObject(ctx, objectName)
+	if err == nil || !errors.Is(err, ErrStorageUnavailable) {
+		return presignedUrl, err
+	}
+	replicaUrl, replicaErr := storage.secondary.PresignedGetObject(ctx, objectName)
+	if replicaErr != nil {
+		return "", err
+	}
+	log.Printf("unable to presign object %s on the primary storage, using its replica: %s\n", objectName, err)
+	return replicaUrl, nil
+}
+
+// EncryptionKeyID returns the id of the key encrypting new objects on the primary storage
+func (storage *ReplicatedStorage) EncryptionKeyID() string {
+	return storageEncryptionKeyID(storage.primary)
+}
+
+// Reencrypt encrypts an object of the primary storage again with its current key. Replicas are encrypted
+// with the keys of the secondary storage when they are copied.
+func (storage *ReplicatedStorage) Reencrypt(ctx context.Context, objectName string) error {
+	if encrypted, ok := storage.primary.(IEncryptedStorage); ok {
+		return encrypted.Reencrypt(ctx, objectName)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errStorageUnavailable = errors.New("storage unavailable")
+
+// unavailableStorage fails every read, like an unreachable MinIO server
+type unavailableStorage struct {
+	IStorageService
+}
+
+func (storage *unavailableStorage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return nil, errStorageUnavailable
+}
+
+func (storage *unavailableStorage) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	return nil, errStorageUnavailable
+}
+
+func (storage *unavailableStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	return ObjectInfo{}, errStorageUnavailable
+}
+
+// statCountingStorage counts the calls to StatObject and fails to presign urls while closed is false
+type statCountingStorage struct {
+	IStorageService
+	stats  int
+	closed bool
+}
+
+func (storage *statCountingStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	storage.stats++
+	return storage.IStorageService.StatObject(ctx, objectName)
+}
+
+func (storage *statCountingStorage) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	if !storage.closed {
+		return "", &CircuitOpenError{Name: "primary", RetryAfter: time.Second}
+	}
+	return storage.IStorageService.PresignedGetObject(ctx, objectName)
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	testStorageConformance(t, func(t *testing.T) IStorageService {
+		return NewReplicatedStorage(NewMemoryStorage(StorageOptions{}), NewMemoryStorage(StorageOptions{}))
+	})
+
+	t.Run("Reads fail over to the replica when the primary storage fails", func(t *testing.T) {
+		ctx := context.Background()
+		primary, secondary := NewMemoryStorage(StorageOptions{}), NewMemoryStorage(StorageOptions{})
+		require.NoError(t, primary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+		require.NoError(t, secondary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+		storage := NewReplicatedStorage(&unavailableStorage{IStorageService: primary}, secondary)
+
+		info, err := storage.StatObject(ctx, "goal.jpg")
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), info.Size)
+		assert.Equal(t, "goal", readObject(t, storage, "goal.jpg"))
+		object, err := storage.GetObjectRange(ctx, "goal.jpg", 1, 2)
+		require.NoError(t, err)
+		data, _ := io.ReadAll(object)
+		object.Close()
+		assert.Equal(t, "oa", string(data))
+
+		// objects without replica report the error of the primary storage
+		_, err = storage.StatObject(ctx, "kickoff.mp4")
+		assert.True(t, errors.Is(err, errStorageUnavailable), "unexpected error: %v", err)
+		_, err = storage.GetObject(ctx, "kickoff.mp4")
+		assert.True(t, errors.Is(err, errStorageUnavailable), "unexpected error: %v", err)
+	})
+
+	t.Run("Urls are presigned on the primary storage until its circuit breaker opens", func(t *testing.T) {
+		ctx := context.Background()
+		primary := &statCountingStorage{IStorageService: NewMemoryStorage(StorageOptions{}), closed: true}
+		secondary := NewMemoryStorage(StorageOptions{})
+		require.NoError(t, primary.CreateBucket(ctx, "primary"))
+		require.NoError(t, secondary.CreateBucket(ctx, "replica"))
+		storage := NewReplicatedStorage(primary, secondary)
+
+		presignedUrl, err := storage.PresignedGetObject(ctx, "goal.jpg")
+		require.NoError(t, err)
+		assert.Contains(t, presignedUrl, "/primary/goal.jpg")
+		assert.Zero(t, primary.stats, "objects are not looked up to presign their url")
+
+		primary.closed = false
+		presignedUrl, err = storage.PresignedGetObject(ctx, "goal.jpg")
+		require.NoError(t, err)
+		assert.Contains(t, presignedUrl, "/replica/goal.jpg")
+		assert.Zero(t, primary.stats)
+	})
+
+	t.Run("Delete removes the object and its replica", func(t *testing.T) {
+		ctx := context.Background()
+		primary, secondary := NewMemoryStorage(StorageOptions{}), NewMemoryStorage(StorageOptions{})
+		require.NoError(t, primary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+		require.NoError(t, secondary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+
+		require.NoError(t, NewReplicatedStorage(primary, secondary).DeleteObject(ctx, "goal.jpg"))
+		_, err := secondary.StatObject(ctx, "goal.jpg")
+		assert.True(t, errors.Is(err, ErrObjectNotFound), "unexpected error: %v", err)
+	})
+}
+
+// TestMinioReplication replicates objects between two MinIO servers when STORAGE_TEST_ENDPOINT and
+// REPLICA_STORAGE_TEST_ENDPOINT are set (example: the storage and storage-replica services of docker-compose.yaml)
+func TestMinioReplication(t *testing.T) {
+	endpoint, replicaEndpoint := os.Getenv("STORAGE_TEST_ENDPOINT"), os.Getenv("REPLICA_STORAGE_TEST_ENDPOINT")
+	if endpoint == "" || replicaEndpoint == "" {
+		t.Skip("STORAGE_TEST_ENDPOINT or REPLICA_STORAGE_TEST_ENDPOINT is not set")
+	}
+	ctx := context.Background()
+	bucketName := "replication-" + uuid.NewString()[:8]
+	newMinioStorage := func(endpoint string, prefix string) IStorageService {
+		storage, err := NewStorage(ctx, StorageOptions{
+			Driver:          "minio",
+			Endpoint:        endpoint,
+			AccessKeyID:     os.Getenv(prefix + "_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv(prefix + "_SECRET_ACCESS_KEY"),
+			BucketName:      bucketName,
+			Region:          "us-east-1",
+		})
+		require.NoError(t, err)
+		return storage
+	}
+	primary := newMinioStorage(endpoint, "STORAGE_TEST")
+	secondary := newMinioStorage(replicaEndpoint, "REPLICA_STORAGE_TEST")
+	require.NoError(t, primary.PutObject(ctx, "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+
+	_, err := copyVerified(ctx, primary, secondary, "goal.jpg", "goal.jpg")
+	require.NoError(t, err)
+	require.NoError(t, primary.DeleteObject(ctx, "goal.jpg"))
+	assert.Equal(t, "goal", readObject(t, NewReplicatedStorage(primary, secondary), "goal.jpg"))
+	secondary.DeleteObject(ctx, "goal.jpg")
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+)
+
+// Storage operations, used to configure their timeout
+const (
+	StorageOpBucket    = "bucket"
+	StorageOpUpload    = "upload"
+	StorageOpPut       = "put"
+	StorageOpGet       = "get"
+	StorageOpStat      = "stat"
+	StorageOpDelete    = "delete"
+	StorageOpList      = "list"
+	StorageOpPresign   = "presign"
+	StorageOpReencrypt = "reencrypt"
+)
+
+const (
+	defaultStorageTimeout         = 10 * time.Second
+	defaultStorageTransferTimeout = 5 * time.Minute
+	defaultStorageMaxRetries      = 3
+	defaultStorageRetryDelay      = 100 * time.Millisecond
+	maxStorageRetryDelay          = 5 * time.Second
+	defaultBreakerThreshold       = 5
+	defaultBreakerCooldown        = 30 * time.Second
+)
+
+var ErrStorageTimeout = errors.New("storage operation timed out")
+
+// ResilienceOptions configures the timeouts, retries and circuit breaker of a storage
+type ResilienceOptions struct {
+	// Maximum duration of each operation. Reads are only bounded until the object starts streaming.
+	Timeouts map[string]time.Duration
+	// Number of retries of the idempotent operations
+	MaxRetries int
+	// Base delay before a retry, doubled after each attempt and jittered
+	RetryDelay time.Duration
+	// Number of consecutive failures opening the circuit breaker
+	BreakerThreshold int
+	// Duration during which calls fail fast once the circuit breaker is open
+	BreakerCooldown time.Duration
+}
+
+// DefaultResilienceOptions returns 10s timeouts (5m for uploads), 3 retries and a breaker opening
+// for 30s after 5 consecutive failures
+func DefaultResilienceOptions() ResilienceOptions {
+	return ResilienceOptions{
+		Timeouts: map[string]time.Duration{
+			StorageOpBucket:    defaultStorageTimeout,
+			StorageOpUpload:    defaultStorageTransferTimeout,
+			StorageOpPut:       defaultStorageTransferTimeout,
+			StorageOpGet:       defaultStorageTimeout,
+			StorageOpStat:      defaultStorageTimeout,
+			StorageOpDelete:    defaultStorageTimeout,
+			StorageOpList:      defaultStorageTimeout,
+			StorageOpPresign:   defaultStorageTimeout,
+			StorageOpReencrypt: defaultStorageTransferTimeout,
+		},
+		MaxRetries:       defaultStorageMaxRetries,
+		RetryDelay:       defaultStorageRetryDelay,
+		BreakerThreshold: defaultBreakerThreshold,
+		BreakerCooldown:  defaultBreakerCooldown,
+	}
+}
+
+// loadResilienceOptions reads <PREFIX>_TIMEOUTS (example: stat:2s,get:5s,put:10m), <PREFIX>_MAX_RETRIES,
+// <PREFIX>_RETRY_DELAY, <PREFIX>_BREAKER_THRESHOLD and <PREFIX>_BREAKER_COOLDOWN
+func loadResilienceOptions(prefix string) (ResilienceOptions, error) {
+	options := DefaultResilienceOptions()
+	if value := config.Config(prefix + "_TIMEOUTS"); value != "" {
+		timeouts, err := ParseStorageTimeouts(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid %s_TIMEOUTS: %w", prefix, err)
+		}
+		for operation, timeout := range timeouts {
+			options.Timeouts[operation] = timeout
+		}
+	}
+	for name, target := range map[string]*int{"MAX_RETRIES": &options.MaxRetries, "BREAKER_THRESHOLD": &options.BreakerThreshold} {
+		if value := config.Config(prefix + "_" + name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 0 {
+				return options, fmt.Errorf("invalid %s_%s %q", prefix, name, value)
+			}
+			*target = number
+		}
+	}
+	for name, target := range map[string]*time.Duration{"RETRY_DELAY": &options.RetryDelay, "BREAKER_COOLDOWN": &options.BreakerCooldown} {
+		if value := config.Config(prefix + "_" + name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s_%s: %w", prefix, name, err)
+			}
+			*target = duration
+		}
+	}
+	return options, nil
+}
+
+// ParseStorageTimeouts parses a comma separated list of <operation>:<duration>
+func ParseStorageTimeouts(value string) (map[string]time.Duration, error) {
+	defaults := DefaultResilienceOptions().Timeouts
+	timeouts := map[string]time.Duration{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		operation, durationStr, found := strings.Cut(entry, ":")
+		if _, known := defaults[operation]; !found || !known {
+			return nil, fmt.Errorf("invalid storage timeout %q", entry)
+		}
+		duration, err := time.ParseDuration(durationStr)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid storage timeout duration %q", entry)
+		}
+		timeouts[operation] = duration
+	}
+	return timeouts, nil
+}
+
+// ResilientStorage bounds the duration of the operations of a storage, retries the idempotent ones
+// with a jittered exponential backoff and fails fast with a CircuitOpenError while the storage keeps failing
+type ResilientStorage struct {
+	storage IStorageService
+	name    string
+	options ResilienceOptions
+	breaker *CircuitBreaker
+}
+
+func NewResilientStorage(name string, storage IStorageService, options ResilienceOptions) *ResilientStorage {
+	defaults := DefaultResilienceOptions()
+	if options.Timeouts == nil {
+		options.Timeouts = defaults.Timeouts
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = defaults.RetryDelay
+	}
+	if options.BreakerThreshold <= 0 {
+		options.BreakerThreshold = defaults.BreakerThreshold
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = defaults.BreakerCooldown
+	}
+	return &ResilientStorage{
+		storage: storage,
+		name:    name,
+		options: options,
+		breaker: NewCircuitBreaker(name, options.BreakerThreshold, options.BreakerCooldown),
+	}
+}
+
+func (storage *ResilientStorage) CreateBucket(ctx context.Context, bucketName string) error {
+	return storage.call(ctx, StorageOpBucket, true, func(ctx context.Context) error {
+		return storage.storage.CreateBucket(ctx, bucketName)
+	})
+}
+
+// UploadObject is not retried: each upload is stored under a new key
+func (storage *ResilientStorage) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
+	var objectName string
+	err := storage.call(ctx, StorageOpUpload, false, func(ctx context.Context) error {
+		var err error
+		objectName, err = storage.storage.UploadObject(ctx, fileHeader)
+		return err
+	})
+	return objectName, err
+}
+
+// PutObject is only retried when the content can be read again from the start
+func (storage *ResilientStorage) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	seeker, rewindable := reader.(io.Seeker)
+	var start int64
+	if rewindable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			rewindable = false
+		}
+	}
+	return storage.call(ctx, StorageOpPut, rewindable, func(ctx context.Context) error {
+		if rewindable {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		return storage.storage.PutObject(ctx, objectName, reader, size, contentType)
+	})
+}
+
+func (storage *ResilientStorage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return storage.open(ctx, func(ctx context.Context) (io.ReadCloser, error) {
+		return storage.storage.GetObject(ctx, objectName)
+	})
+}
+
+func (storage *ResilientStorage) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	return storage.open(ctx, func(ctx context.Context) (io.ReadCloser, error) {
+		return storage.storage.GetObjectRange(ctx, objectName, offset, length)
+	})
+}
+
+func (storage *ResilientStorage) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	var info ObjectInfo
+	err := storage.call(ctx, StorageOpStat, true, func(ctx context.Context) error {
+		var err error
+		info, err = storage.storage.StatObject(ctx, objectName)
+		return err
+	})
+	return info, err
+}
+
+func (storage *ResilientStorage) DeleteObject(ctx context.Context, objectName string) error {
+	return storage.call(ctx, StorageOpDelete, true, func(ctx context.Context) error {
+		return storage.storage.DeleteObject(ctx, objectName)
+	})
+}
+
+func (storage *ResilientStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := storage.call(ctx, StorageOpList, true, func(ctx context.Context) error {
+		var err error
+		objects, err = storage.storage.ListObjects(ctx, prefix)
+		return err
+	})
+	return objects, err
+}
+
+func (storage *ResilientStorage) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	var presignedUrl string
+	err := storage.call(ctx, StorageOpPresign, true, func(ctx context.Context) error {
+		var err error
+		presignedUrl, err = storage.storage.PresignedGetObject(ctx, objectName)
+		return err
+	})
+	return presignedUrl, err
+}
+
+func (storage *ResilientStorage) EncryptionKeyID() string {
+	return storageEncryptionKeyID(storage.storage)
+}
+
+func (storage *ResilientStorage) Reencrypt(ctx context.Context, objectName string) error {
+	encrypted, ok := storage.storage.(IEncryptedStorage)
+	if !ok {
+		return nil
+	}
+	return storage.call(ctx, StorageOpReencrypt, true, func(ctx context.Context) error {
+		return encrypted.Reencrypt(ctx, objectName)
+	})
+}
+
+// call runs an operation returning no stream
+func (storage *ResilientStorage) call(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) error) error {
+	_, err := storage.do(ctx, operation, idempotent, func(ctx context.Context) (io.ReadCloser, error) {
+		return nil, fn(ctx)
+	})
+	return err
+}
+
+// open runs a read operation, the object is then streamed until it is closed
+func (storage *ResilientStorage) open(ctx context.Context, fn func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	return storage.do(ctx, StorageOpGet, true, fn)
+}
+
+func (storage *ResilientStorage) do(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := storage.retry(ctx, operation, idempotent, fn)
+	storageOperationDuration.WithLabelValues(storage.name, operation).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		storageOperationErrors.WithLabelValues(storage.name, operation).Inc()
+	}
+	return reader, err
+}
+
+// retry runs an operation until it succeeds, up to MaxRetries more times when it is idempotent
+func (storage *ResilientStorage) retry(ctx context.Context, operation string, idempotent bool, fn func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	attempts := 1
+	if idempotent {
+		attempts += storage.options.MaxRetries
+	}
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			storageRetries.WithLabelValues(storage.name, operation).Inc()
+			if sleepErr := sleepContext(ctx, retryDelay(storage.options.RetryDelay, attempt)); sleepErr != nil {
+				return nil, err
+			}
+		}
+		if breakerErr := storage.breaker.Allow(); breakerErr != nil {
+			return nil, breakerErr
+		}
+		var reader io.ReadCloser
+		reader, err = storage.attempt(ctx, operation, fn)
+		switch {
+		case err == nil || errors.Is(err, ErrObjectNotFound):
+			storage.breaker.Success()
+			return reader, err
+		case ctx.Err() != nil:
+			// canceled by the caller, the storage is not at fault
+			storage.breaker.Release()
+			return nil, err
+		}
+		storage.breaker.Failure()
+	}
+	return nil, err
+}
+
+// attempt runs an operation within its timeout. The context of a returned stream is canceled when it is closed.
+func (storage *ResilientStorage) attempt(ctx context.Context, operation string, fn func(ctx context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	timeout := storage.options.Timeouts[operation]
+	if timeout <= 0 {
+		timeout = defaultStorageTimeout
+	}
+	attemptCtx, cancel := context.WithCancelCause(ctx)
+	timeoutErr := fmt.Errorf("%w: %s on %s after %s", ErrStorageTimeout, operation, storage.name, timeout)
+	timer := time.AfterFunc(timeout, func() { cancel(timeoutErr) })
+	reader, err := fn(attemptCtx)
+	if !timer.Stop() {
+		storageTimeouts.WithLabelValues(storage.name, operation).Inc()
+		if reader != nil {
+			reader.Close()
+		}
+		cancel(nil)
+		return nil, timeoutErr
+	}
+	if err != nil || reader == nil {
+		cancel(nil)
+		return reader, err
+	}
+	return &cancelOnClose{ReadCloser: reader, cancel: func() { cancel(nil) }}, nil
+}
+
+// retryDelay returns a random delay between half and all of base * 2^(attempt-1), to spread the retries of concurrent calls
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxStorageRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxStorageRetryDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cancelOnClose releases the context of a stream when it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	once   sync.Once
+	cancel func()
+}
+
+func (reader *cancelOnClose) Close() error {
+	err := reader.ReadCloser.Close()
+	reader.once.Do(reader.cancel)
+	return err
+}
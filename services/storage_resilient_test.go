@@ -0,0 +1,246 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves objects of a bucket like an S3 server and injects faults: failed or slow requests
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	requests int
+	// number of next requests answered with an internal error, -1 to fail every request
+	failures int
+	// delay before answering each request
+	delay time.Duration
+}
+
+func newFakeS3(t *testing.T, objects map[string]string) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: objects}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (fake *fakeS3) inject(failures int, delay time.Duration) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	fake.failures, fake.delay = failures, delay
+}
+
+func (fake *fakeS3) requestCount() int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	return fake.requests
+}
+
+func (fake *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	fake.requests++
+	fail := fake.failures != 0
+	if fake.failures > 0 {
+		fake.failures--
+	}
+	delay := fake.delay
+	fake.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	if fail {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<Error><Code>InternalError</Code><Message>injected fault</Message></Error>`)
+		return
+	}
+
+	// path style requests: /<bucket>/<key>
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if key == "" {
+		// bucket creation and existence check
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	fake.mu.Lock()
+	content, found := fake.objects[key]
+	if r.Method == http.MethodDelete {
+		delete(fake.objects, key)
+	}
+	fake.mu.Unlock()
+	switch {
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	case !found:
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message><Key>%s</Key></Error>`, key)
+		}
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("ETag", `"`+fmt.Sprintf("%x", len(content))+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			io.WriteString(w, content)
+		}
+	}
+}
+
+func newFakeS3Storage(t *testing.T, server *httptest.Server, resilience ResilienceOptions) IStorageService {
+	storage, err := NewStorage(context.Background(), StorageOptions{
+		Name:            t.Name(),
+		Driver:          "minio",
+		Endpoint:        strings.TrimPrefix(server.URL, "http://"),
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		BucketName:      "medias",
+		Region:          "us-east-1",
+		Resilience:      resilience,
+	})
+	require.NoError(t, err)
+	return storage
+}
+
+func breakerStatus(t *testing.T, name string) CircuitBreakerStatus {
+	for _, status := range CircuitBreakers() {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("circuit breaker %s not found", name)
+	return CircuitBreakerStatus{}
+}
+
+func TestResilientStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Idempotent operations are retried after transient errors", func(t *testing.T) {
+		fake, server := newFakeS3(t, map[string]string{"goal.jpg": "goal"})
+		storage := newFakeS3Storage(t, server, ResilienceOptions{MaxRetries: 3, RetryDelay: time.Millisecond})
+		fake.inject(2, 0)
+		before := fake.requestCount()
+
+		info, err := storage.StatObject(ctx, "goal.jpg")
+
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), info.Size)
+		assert.Equal(t, 3, fake.requestCount()-before)
+		assert.Equal(t, CircuitClosed, breakerStatus(t, t.Name()).State)
+		assert.Zero(t, testutil.ToFloat64(storageOperationErrors.WithLabelValues(t.Name(), StorageOpStat)), "operations succeeding after a retry are not errors")
+	})
+
+	t.Run("Operations fail once their timeout is over", func(t *testing.T) {
+		fake, server := newFakeS3(t, map[string]string{"goal.jpg": "goal"})
+		storage := newFakeS3Storage(t, server, ResilienceOptions{Timeouts: map[string]time.Duration{StorageOpStat: 50 * time.Millisecond}})
+		fake.inject(0, time.Second)
+
+		start := time.Now()
+		_, err := storage.StatObject(ctx, "goal.jpg")
+
+		assert.ErrorIs(t, err, ErrStorageTimeout)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, 1.0, testutil.ToFloat64(storageOperationErrors.WithLabelValues(t.Name(), StorageOpStat)))
+	})
+
+	t.Run("Objects keep streaming after the timeout of the read", func(t *testing.T) {
+		_, server := newFakeS3(t, map[string]string{"goal.jpg": "goal"})
+		storage := newFakeS3Storage(t, server, ResilienceOptions{Timeouts: map[string]time.Duration{StorageOpGet: 50 * time.Millisecond}})
+
+		object, err := storage.GetObject(ctx, "goal.jpg")
+		require.NoError(t, err)
+		defer object.Close()
+		time.Sleep(100 * time.Millisecond)
+		data, err := io.ReadAll(object)
+
+		require.NoError(t, err)
+		assert.Equal(t, "goal", string(data))
+	})
+
+	t.Run("Circuit breaker fails fast after consecutive failures and closes once the storage is back", func(t *testing.T) {
+		fake, server := newFakeS3(t, map[string]string{"goal.jpg": "goal"})
+		storage := newFakeS3Storage(t, server, ResilienceOptions{BreakerThreshold: 2, BreakerCooldown: 100 * time.Millisecond})
+		fake.inject(-1, 0)
+
+		for i := 0; i < 2; i++ {
+			_, err := storage.StatObject(ctx, "goal.jpg")
+			require.Error(t, err)
+			assert.False(t, errors.Is(err, ErrStorageUnavailable))
+		}
+		before := fake.requestCount()
+		_, err := storage.StatObject(ctx, "goal.jpg")
+
+		var circuitErr *CircuitOpenError
+		require.ErrorAs(t, err, &circuitErr)
+		assert.ErrorIs(t, err, ErrStorageUnavailable)
+		assert.Positive(t, circuitErr.RetryAfter)
+		assert.Equal(t, before, fake.requestCount(), "the storage must not be called while the breaker is open")
+		assert.Equal(t, CircuitOpen, breakerStatus(t, t.Name()).State)
+
+		fake.inject(0, 0)
+		time.Sleep(100 * time.Millisecond)
+		_, err = storage.StatObject(ctx, "goal.jpg")
+		require.NoError(t, err)
+		assert.Equal(t, CircuitClosed, breakerStatus(t, t.Name()).State)
+	})
+
+	t.Run("Missing objects do not open the circuit breaker", func(t *testing.T) {
+		_, server := newFakeS3(t, map[string]string{})
+		storage := newFakeS3Storage(t, server, ResilienceOptions{BreakerThreshold: 1})
+
+		for i := 0; i < 3; i++ {
+			_, err := storage.StatObject(ctx, "missing.jpg")
+			assert.ErrorIs(t, err, ErrObjectNotFound)
+		}
+		assert.Equal(t, CircuitClosed, breakerStatus(t, t.Name()).State)
+		assert.Zero(t, testutil.ToFloat64(storageOperationErrors.WithLabelValues(t.Name(), StorageOpStat)))
+	})
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(t.Name(), 1, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	assert.Error(t, breaker.Allow())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.Allow(), "a probe goes through once the cooldown is over")
+	assert.Error(t, breaker.Allow(), "a single probe runs at once")
+	breaker.Failure()
+	assert.Equal(t, CircuitOpen, breaker.Status().State)
+
+	now = now.Add(time.Minute)
+	require.NoError(t, breaker.Allow())
+	breaker.Success()
+	assert.Equal(t, CircuitClosed, breaker.Status().State)
+	assert.NoError(t, breaker.Allow())
+}
+
+func TestParseStorageTimeouts(t *testing.T) {
+	timeouts, err := ParseStorageTimeouts("stat:2s, put:10m")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{StorageOpStat: 2 * time.Second, StorageOpPut: 10 * time.Minute}, timeouts)
+
+	_, err = ParseStorageTimeouts("copy:2s")
+	assert.Error(t, err)
+	_, err = ParseStorageTimeouts("stat:soon")
+	assert.Error(t, err)
+}
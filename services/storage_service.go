@@ -2,64 +2,111 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"mime/multipart"
+	"net/url"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/mich31/scoreplay-media-api/config"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
-type StorageService struct {
-	Client     *minio.Client
-	BucketName string
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo holds the metadata of a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
 }
 
 type IStorageService interface {
 	CreateBucket(ctx context.Context, bucketName string) error
 	UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error)
+	PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error
+	GetObject(ctx context.Context, objectName string) (io.ReadCloser, error)
+	GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error)
+	StatObject(ctx context.Context, objectName string) (ObjectInfo, error)
+	DeleteObject(ctx context.Context, objectName string) error
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	PresignedGetObject(ctx context.Context, objectName string) (string, error)
+}
+
+// StorageService stores objects in a MinIO (or any S3 compatible) bucket
+type StorageService struct {
+	Client     *minio.Client
+	BucketName string
+	options    StorageOptions
+	// SSE-C keys, nil without SSE-C encryption
+	keyring *Keyring
+	// SSE-C objects cannot be read with presigned urls, their urls are served by the API which holds the keys
+	signer *ObjectUrlSigner
+}
+
+// objectEncryption is an encryption an object may be stored with
+type objectEncryption struct {
+	keyID string
+	sse   encrypt.ServerSide
+}
+
+func init() {
+	RegisterStorageDriver("minio", func(options StorageOptions) (IStorageService, error) {
+		return NewStorageService(options)
+	})
 }
 
-func NewStorageService() (*StorageService, error) {
+func NewStorageService(options StorageOptions) (*StorageService, error) {
 	fmt.Println("initializing storage service..")
-	client, err := minio.New(config.Config("STORAGE_ENDPOINT"), &minio.Options{
-		Creds:  credentials.NewStaticV4(config.Config("STORAGE_ACCESS_KEY_ID"), config.Config("STORAGE_SECRET_ACCESS_KEY"), ""),
-		Secure: false,
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.AccessKeyID, options.SecretAccessKey, ""),
+		Secure: options.UseSSL,
+		Region: options.Region,
+		// requests are retried by ResilientStorage, within the timeout of each operation
+		MaxRetries: 1,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize storage client: %w", err)
 	}
 
-	fmt.Println("storage service initialized")
-
-	return &StorageService{
-		Client: client,
-	}, nil
-}
-
-func InitStorageService() *StorageService {
-	ctx := context.Background()
-	service, err := NewStorageService()
-	if err != nil {
-		log.Fatalf("unable to initialize storage service: %s", err)
+	service := &StorageService{
+		Client:  client,
+		options: options,
 	}
-	err = service.CreateBucket(ctx, config.Config("STORAGE_BUCKET_NAME"))
-	if err != nil {
-		log.Fatal(err)
+	if options.Encryption == EncryptionSSEC {
+		// keys are sent along with every request
+		if !options.UseSSL {
+			return nil, fmt.Errorf("%s encryption requires a TLS connection to the storage", EncryptionSSEC)
+		}
+		if service.keyring, err = LoadKeyring(options.KeyringPath); err != nil {
+			return nil, err
+		}
+		service.signer = NewObjectUrlSigner(options)
 	}
-	return service
+
+	fmt.Println("storage service initialized")
+
+	return service, nil
 }
 
 func (service *StorageService) CreateBucket(ctx context.Context, bucketName string) error {
-	err := service.Client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: config.Config("STORAGE_BUCKET_REGION")})
+	err := service.Client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: service.options.Region})
 	service.BucketName = bucketName
 	if err != nil {
 		exists, errBucketExists := service.Client.BucketExists(ctx, bucketName)
 		if errBucketExists == nil && exists {
 			log.Printf("bucket %s already exists\n", bucketName)
+			// buckets used to be public, objects are now only readable with presigned urls
+			if err := service.Client.SetBucketPolicy(ctx, bucketName, ""); err != nil {
+				log.Printf("unable to remove policy of bucket %s: %s", bucketName, err.Error())
+			}
 			return nil
 		} else {
 			return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
@@ -67,27 +114,250 @@ func (service *StorageService) CreateBucket(ctx context.Context, bucketName stri
 	}
 
 	log.Printf("bucket %s created!\n", bucketName)
-	policy := fmt.Sprintf(`{"Version": "2012-10-17","Statement": [{"Action": ["s3:*"],"Effect": "Allow","Principal": {"AWS": ["*"]},"Resource": ["arn:aws:s3:::%s/*"]}]}`, bucketName)
-	err = service.Client.SetBucketPolicy(ctx, bucketName, policy)
+	return nil
+}
+
+func (service *StorageService) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
+	return uploadFile(ctx, service, fileHeader, service.options.KeyTemplate)
+}
+
+func (service *StorageService) PutObject(ctx context.Context, objectName string, reader io.Reader, size int64, contentType string) error {
+	_, err := service.Client.PutObject(ctx, service.BucketName, objectName, reader, size, minio.PutObjectOptions{
+		ContentType:          contentType,
+		ServerSideEncryption: service.writeEncryption().sse,
+	})
 	if err != nil {
-		log.Printf("unable to set policy for bucket %s: %s", bucketName, err.Error())
+		return fmt.Errorf("unable to put object %s: %w", objectName, err)
 	}
 	return nil
 }
 
-func (service *StorageService) UploadObject(ctx context.Context, fileHeader *multipart.FileHeader) (string, error) {
-	fileExtension := filepath.Ext(fileHeader.Filename)
-	objectName := fmt.Sprintf("%s%s", uuid.New(), fileExtension)
+func (service *StorageService) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	var object *minio.Object
+	err := service.withReadEncryption(ctx, func(encryption objectEncryption) error {
+		var err error
+		object, err = service.getObject(ctx, objectName, minio.GetObjectOptions{ServerSideEncryption: encryption.sse})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// GetObjectRange reads length bytes of an object starting at offset
+func (service *StorageService) GetObjectRange(ctx context.Context, objectName string, offset int64, length int64) (io.ReadCloser, error) {
+	var object *minio.Object
+	err := service.withReadEncryption(ctx, func(encryption objectEncryption) error {
+		options := minio.GetObjectOptions{ServerSideEncryption: encryption.sse}
+		if err := options.SetRange(offset, offset+length-1); err != nil {
+			return fmt.Errorf("invalid range of object %s: %w", objectName, err)
+		}
+		var err error
+		object, err = service.getObject(ctx, objectName, options)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func (service *StorageService) getObject(ctx context.Context, objectName string, options minio.GetObjectOptions) (*minio.Object, error) {
+	object, err := service.Client.GetObject(ctx, service.BucketName, objectName, options)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get object %s: %w", objectName, err)
+	}
+	// errors are only returned on the first read, stat the object to report missing objects now
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, minioError(objectName, err)
+	}
+	return object, nil
+}
+
+func (service *StorageService) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	var info minio.ObjectInfo
+	err := service.withReadEncryption(ctx, func(encryption objectEncryption) error {
+		var err error
+		info, err = service.Client.StatObject(ctx, service.BucketName, objectName, minio.StatObjectOptions{ServerSideEncryption: encryption.sse})
+		if err != nil {
+			return minioError(objectName, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return minioObjectInfo(info), nil
+}
+
+func (service *StorageService) DeleteObject(ctx context.Context, objectName string) error {
+	err := service.Client.RemoveObject(ctx, service.BucketName, objectName, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to delete object %s: %w", objectName, err)
+	}
+	return nil
+}
+
+func (service *StorageService) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	for object := range service.Client.ListObjects(ctx, service.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("unable to list objects: %w", object.Err)
+		}
+		objects = append(objects, minioObjectInfo(object))
+	}
+	return objects, nil
+}
+
+func (service *StorageService) PresignedGetObject(ctx context.Context, objectName string) (string, error) {
+	if service.signer != nil {
+		return service.signer.Sign(service.BucketName, objectName), nil
+	}
+	presignedUrl, err := service.Client.PresignedGetObject(ctx, service.BucketName, objectName, service.options.PresignTTL, url.Values{})
+	if err != nil {
+		return "", fmt.Errorf("unable to presign object %s: %w", objectName, err)
+	}
+	return presignedUrl.String(), nil
+}
+
+// EncryptionKeyID returns the id of the SSE-C key encrypting new objects, sse-s3 with SSE-S3 encryption
+// and an empty id without encryption
+func (service *StorageService) EncryptionKeyID() string {
+	return service.writeEncryption().keyID
+}
+
+// Reencrypt copies an object onto itself, on the storage side, to encrypt it with the current key.
+// Objects already encrypted with the current key are left untouched.
+func (service *StorageService) Reencrypt(ctx context.Context, objectName string) error {
+	target := service.writeEncryption()
+	if target.sse == nil {
+		return nil
+	}
+	var source objectEncryption
+	var info minio.ObjectInfo
+	err := service.withReadEncryption(ctx, func(encryption objectEncryption) error {
+		var err error
+		info, err = service.Client.StatObject(ctx, service.BucketName, objectName, minio.StatObjectOptions{ServerSideEncryption: encryption.sse})
+		if err != nil {
+			return minioError(objectName, err)
+		}
+		source = encryption
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if source.sse != nil && source.keyID == target.keyID {
+		return nil
+	}
+	if target.sse.Type() == encrypt.S3 && info.Metadata.Get(encrypt.SseGenericHeader) == "AES256" {
+		return nil
+	}
+
+	// objects over 5GiB are copied part by part, which does not keep their metadata unless replaced
+	metadata := map[string]string{"Content-Type": info.ContentType}
+	for key, value := range info.UserMetadata {
+		metadata[key] = value
+	}
+	_, err = service.Client.ComposeObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          service.BucketName,
+			Object:          objectName,
+			Encryption:      target.sse,
+			ReplaceMetadata: true,
+			UserMetadata:    metadata,
+		},
+		minio.CopySrcOptions{Bucket: service.BucketName, Object: objectName, Encryption: source.sse},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to re-encrypt object %s: %w", objectName, err)
+	}
+	return nil
+}
+
+// writeEncryption returns the encryption of new objects
+func (service *StorageService) writeEncryption() objectEncryption {
+	switch service.options.Encryption {
+	case EncryptionSSES3:
+		return objectEncryption{keyID: EncryptionSSES3, sse: encrypt.NewSSE()}
+	case EncryptionSSEC:
+		return service.keyEncryption(service.keyring.Current)
+	}
+	return objectEncryption{}
+}
+
+func (service *StorageService) keyEncryption(keyID string) objectEncryption {
+	// keys are checked when the keyring is loaded
+	sse, _ := encrypt.NewSSEC(service.keyring.Keys[keyID])
+	return objectEncryption{keyID: keyID, sse: sse}
+}
+
+// readEncryptions returns the encryptions to try to read an object. SSE-S3 and unencrypted objects are read
+// without key. SSE-C objects are only readable with their key: the key of ctx is tried first, then the current key,
+// the older keys and no key for the objects stored before encryption was enabled.
+func (service *StorageService) readEncryptions(ctx context.Context) []objectEncryption {
+	if service.keyring == nil {
+		return []objectEncryption{{}}
+	}
+	encryptions := []objectEncryption{}
+	hint := encryptionKeyID(ctx)
+	if _, found := service.keyring.Keys[hint]; found {
+		encryptions = append(encryptions, service.keyEncryption(hint))
+	}
+	for _, keyID := range service.keyring.KeyIDs() {
+		if keyID != hint {
+			encryptions = append(encryptions, service.keyEncryption(keyID))
+		}
+	}
+	return append(encryptions, objectEncryption{})
+}
+
+// withReadEncryption calls read with each encryption of readEncryptions until one of them can read the object
+// and returns the error of the first attempt otherwise
+func (service *StorageService) withReadEncryption(ctx context.Context, read func(encryption objectEncryption) error) error {
+	var firstErr error
+	for _, encryption := range service.readEncryptions(ctx) {
+		err := read(encryption)
+		if err == nil || errors.Is(err, ErrObjectNotFound) || ctx.Err() != nil {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func minioObjectInfo(info minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}
+}
+
+func minioError(objectName string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %s", ErrObjectNotFound, objectName)
+	}
+	return fmt.Errorf("unable to stat object %s: %w", objectName, err)
+}
+
+// uploadFile stores an uploaded file under a key laid out by the template and returns its object key
+func uploadFile(ctx context.Context, storage IStorageService, fileHeader *multipart.FileHeader, keyTemplate ObjectKeyTemplate) (string, error) {
+	objectName := keyTemplate.ObjectKey(objectTenant(ctx), time.Now().UTC(), uuid.New(), filepath.Ext(fileHeader.Filename))
 	file, err := fileHeader.Open()
 	if err != nil {
-		log.Fatalln("unable to open file: ", err)
-		return "", err
+		return "", fmt.Errorf("unable to open file: %w", err)
 	}
 	defer file.Close()
 
-	_, err = service.Client.PutObject(ctx, service.BucketName, objectName, file, fileHeader.Size, minio.PutObjectOptions{})
-	if err != nil {
+	if err := storage.PutObject(ctx, objectName, file, fileHeader.Size, fileHeader.Header.Get("Content-Type")); err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("http://%s/%s/%s", config.Config("STORAGE_ENDPOINT"), config.Config("STORAGE_BUCKET_NAME"), objectName), nil
+	return objectName, nil
 }
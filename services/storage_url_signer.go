@@ -0,0 +1,82 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidObjectUrl = errors.New("invalid or expired object url")
+
+const defaultObjectPublicUrl = "http://localhost:3000/objects"
+
+var (
+	fallbackUrlSigningKey     []byte
+	fallbackUrlSigningKeyOnce sync.Once
+)
+
+// ObjectUrlSigner creates time-limited urls for the drivers without presigned urls (filesystem and memory)
+// and the objects encrypted with SSE-C keys.
+// These urls are served by the API under /objects/<bucket>/<key>.
+type ObjectUrlSigner struct {
+	key       []byte
+	publicUrl string
+	ttl       time.Duration
+}
+
+// NewObjectUrlSigner creates a signer from the storage options. Without signing key, a random key
+// shared by the whole process is used: urls are then invalidated when the service restarts.
+func NewObjectUrlSigner(options StorageOptions) *ObjectUrlSigner {
+	key := []byte(options.UrlSigningKey)
+	if len(key) == 0 {
+		fallbackUrlSigningKeyOnce.Do(func() {
+			fallbackUrlSigningKey = make([]byte, 32)
+			rand.Read(fallbackUrlSigningKey)
+		})
+		key = fallbackUrlSigningKey
+	}
+	publicUrl := strings.TrimSuffix(options.PublicUrl, "/")
+	if publicUrl == "" {
+		publicUrl = defaultObjectPublicUrl
+	}
+	ttl := options.PresignTTL
+	if ttl <= 0 {
+		ttl = defaultPresignTTL
+	}
+	return &ObjectUrlSigner{key: key, publicUrl: publicUrl, ttl: ttl}
+}
+
+// Sign returns the url of an object valid until the end of the signer's time-to-live
+func (signer *ObjectUrlSigner) Sign(bucketName string, objectName string) string {
+	expires := time.Now().Add(signer.ttl).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", signer.signature(bucketName, objectName, expires))
+	return fmt.Sprintf("%s/%s/%s?%s", signer.publicUrl, bucketName, (&url.URL{Path: objectName}).EscapedPath(), query.Encode())
+}
+
+// Verify checks the expiration and signature of an object url
+func (signer *ObjectUrlSigner) Verify(bucketName string, objectName string, expires string, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return ErrInvalidObjectUrl
+	}
+	if !hmac.Equal([]byte(signer.signature(bucketName, objectName, expiresAt)), []byte(signature)) {
+		return ErrInvalidObjectUrl
+	}
+	return nil
+}
+
+func (signer *ObjectUrlSigner) signature(bucketName string, objectName string, expires int64) string {
+	mac := hmac.New(sha256.New, signer.key)
+	fmt.Fprintf(mac, "%s\n%s\n%d", bucketName, objectName, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
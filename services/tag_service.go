@@ -1,27 +1,33 @@
 package services
 
 import (
+	"context"
+	"errors"
+
 	"github.com/mich31/scoreplay-media-api/models"
 	"github.com/mich31/scoreplay-media-api/repositories"
 )
 
 type TagService struct {
 	repository repositories.ITagRepository
+	// creations and deletions are recorded in the audit log when set
+	audit *AuditService
 }
 
-func NewTagService(tagRepository repositories.ITagRepository) *TagService {
+func NewTagService(tagRepository repositories.ITagRepository, auditService *AuditService) *TagService {
 	return &TagService{
 		repository: tagRepository,
+		audit:      auditService,
 	}
 }
 
-func (service *TagService) GetTags(name string) ([]*models.Tag, error) {
+func (service *TagService) GetTags(ctx context.Context, name string) ([]*models.Tag, error) {
 	var tags []*models.Tag
 	var err error
 	if name != "" {
-		tags, err = service.repository.FindByName(name)
+		tags, err = service.repository.FindByName(ctx, name)
 	} else {
-		tags, err = service.repository.Find()
+		tags, err = service.repository.Find(ctx)
 	}
 
 	if err != nil {
@@ -30,18 +36,39 @@ func (service *TagService) GetTags(name string) ([]*models.Tag, error) {
 	return tags, nil
 }
 
-func (service *TagService) CreateTag(tag *models.Tag) (uint, error) {
-	id, err := service.repository.Create(tag)
+func (service *TagService) CreateTag(ctx context.Context, tag *models.Tag) (uint, error) {
+	id, created, err := service.repository.Create(ctx, tag)
 	if err != nil {
 		return 0, err
 	}
+	if created {
+		service.audit.Record(ctx, models.AuditCreate, models.AuditTag, auditID(id), nil, tag)
+	}
 	return id, nil
 }
 
-func (service *TagService) DeleteTag(id string) error {
-	err := service.repository.Delete(id)
+// DeleteTag moves a tag to the trash, deleting a missing tag does nothing
+func (service *TagService) DeleteTag(ctx context.Context, id string) error {
+	tag, err := service.repository.FindByID(ctx, id)
 	if err != nil {
+		if errors.Is(err, repositories.ErrTagNotFound) {
+			return nil
+		}
 		return err
 	}
+	if err := service.repository.Delete(ctx, id); err != nil {
+		return err
+	}
+	service.audit.Record(ctx, models.AuditDelete, models.AuditTag, auditID(tag.ID), tag, nil)
 	return nil
 }
+
+// RestoreTag moves a tag out of the trash, with its associations to medias
+func (service *TagService) RestoreTag(ctx context.Context, id string) (*models.Tag, error) {
+	tag, err := service.repository.Restore(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	service.audit.Record(ctx, models.AuditRestore, models.AuditTag, auditID(tag.ID), nil, tag)
+	return tag, nil
+}
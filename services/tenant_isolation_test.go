@@ -0,0 +1,344 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// Organizations of the isolation tests: the data belongs to fc-nantes, psg reads it
+const (
+	fcNantes uint = 1
+	psg      uint = 2
+)
+
+// tenantStore keeps the medias, tags, webhooks and deliveries of several organizations. Like the repositories, its
+// queries only see the rows of the organization of their context and fail without organization.
+type tenantStore struct {
+	medias     []models.Media
+	mediaTags  map[uint][]uint
+	tags       []models.Tag
+	webhooks   []models.Webhook
+	deliveries []models.WebhookDelivery
+}
+
+func (store *tenantStore) visible(ctx context.Context, organizationID uint) (bool, error) {
+	tenant, scoped := repositories.TenantFromContext(ctx)
+	if !scoped {
+		return false, repositories.ErrMissingTenant
+	}
+	return tenant == organizationID, nil
+}
+
+type isolationMediaRepository struct {
+	repositories.IMediaRepository
+	store *tenantStore
+}
+
+func (repository *isolationMediaRepository) find(ctx context.Context, id string, deleted bool) (*models.Media, error) {
+	for i, media := range repository.store.medias {
+		visible, err := repository.store.visible(ctx, media.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if visible && strconv.FormatUint(uint64(media.ID), 10) == id && media.DeletedAt.Valid == deleted {
+			return &repository.store.medias[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", repositories.ErrMediaNotFound, id)
+}
+
+func (repository *isolationMediaRepository) FindByID(ctx context.Context, id string) (*models.Media, error) {
+	return repository.find(ctx, id, false)
+}
+
+func (repository *isolationMediaRepository) Find(ctx context.Context, filter repositories.MediaFilter) ([]models.MediaWithTagNames, error) {
+	medias := []models.MediaWithTagNames{}
+	for _, media := range repository.store.medias {
+		visible, err := repository.store.visible(ctx, media.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if !visible || media.DeletedAt.Valid ||
+			(filter.Tag != "" && !slices.ContainsFunc(repository.store.mediaTags[media.ID], func(tagID uint) bool {
+				return strconv.FormatUint(uint64(tagID), 10) == filter.Tag
+			})) {
+			continue
+		}
+		medias = append(medias, models.MediaWithTagNames{ID: media.ID, Name: media.Name, MediaFiles: media.MediaFiles})
+	}
+	return medias, nil
+}
+
+func (repository *isolationMediaRepository) Delete(ctx context.Context, id uint) error {
+	media, err := repository.find(ctx, strconv.FormatUint(uint64(id), 10), false)
+	if err != nil {
+		return err
+	}
+	media.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (repository *isolationMediaRepository) Restore(ctx context.Context, id string) (*models.Media, error) {
+	media, err := repository.find(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	media.DeletedAt = gorm.DeletedAt{}
+	return media, nil
+}
+
+type isolationTagRepository struct {
+	repositories.ITagRepository
+	store *tenantStore
+}
+
+func (repository *isolationTagRepository) find(ctx context.Context, id string, deleted bool) (*models.Tag, error) {
+	for i, tag := range repository.store.tags {
+		visible, err := repository.store.visible(ctx, tag.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if visible && strconv.FormatUint(uint64(tag.ID), 10) == id && tag.DeletedAt.Valid == deleted {
+			return &repository.store.tags[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", repositories.ErrTagNotFound, id)
+}
+
+func (repository *isolationTagRepository) FindByID(ctx context.Context, id string) (*models.Tag, error) {
+	return repository.find(ctx, id, false)
+}
+
+func (repository *isolationTagRepository) Find(ctx context.Context) ([]*models.Tag, error) {
+	tags := []*models.Tag{}
+	for i, tag := range repository.store.tags {
+		visible, err := repository.store.visible(ctx, tag.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if visible && !tag.DeletedAt.Valid {
+			tags = append(tags, &repository.store.tags[i])
+		}
+	}
+	return tags, nil
+}
+
+func (repository *isolationTagRepository) FindByName(ctx context.Context, name string) ([]*models.Tag, error) {
+	tags, err := repository.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(tags, func(tag *models.Tag) bool { return !strings.Contains(tag.Name, name) }), nil
+}
+
+func (repository *isolationTagRepository) Delete(ctx context.Context, id string) error {
+	tag, err := repository.find(ctx, id, false)
+	if err != nil {
+		return err
+	}
+	tag.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (repository *isolationTagRepository) Restore(ctx context.Context, id string) (*models.Tag, error) {
+	tag, err := repository.find(ctx, id, true)
+	if err != nil {
+		return nil, err
+	}
+	tag.DeletedAt = gorm.DeletedAt{}
+	return tag, nil
+}
+
+type isolationWebhookRepository struct {
+	repositories.IWebhookRepository
+	store *tenantStore
+}
+
+func (repository *isolationWebhookRepository) FindDeliveries(ctx context.Context, webhookID uint, limit int) ([]models.WebhookDelivery, error) {
+	for _, webhook := range repository.store.webhooks {
+		visible, err := repository.store.visible(ctx, webhook.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if !visible || webhook.ID != webhookID {
+			continue
+		}
+		deliveries := []models.WebhookDelivery{}
+		for _, delivery := range repository.store.deliveries {
+			if delivery.WebhookID == webhookID && len(deliveries) < limit {
+				deliveries = append(deliveries, delivery)
+			}
+		}
+		return deliveries, nil
+	}
+	return nil, fmt.Errorf("%w: %d", repositories.ErrWebhookNotFound, webhookID)
+}
+
+func (repository *isolationWebhookRepository) Replay(ctx context.Context, deliveryID uint, now time.Time) (*models.WebhookDelivery, error) {
+	for _, delivery := range repository.store.deliveries {
+		visible, err := repository.store.visible(ctx, delivery.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+		if visible && delivery.ID == deliveryID {
+			replay := delivery
+			replay.ID, replay.Status, replay.NextAttemptAt, replay.ReplayOf = uint(len(repository.store.deliveries)+1), models.DeliveryPending, &now, &delivery.ID
+			repository.store.deliveries = append(repository.store.deliveries, replay)
+			return &replay, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", repositories.ErrDeliveryNotFound, deliveryID)
+}
+
+// newIsolatedServices returns the media, tag and webhook services reading the data of fc-nantes: a media tagged
+// with a tag, a media and a tag in the trash, and a webhook with a delivery
+func newIsolatedServices(t *testing.T) (*MediaService, *TagService, *WebhookService, *tenantStore) {
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	store := &tenantStore{
+		medias: []models.Media{
+			{ID: 1, OrganizationID: fcNantes, Name: "goal", MediaFiles: models.MediaFiles{ObjectKey: "goal.jpg"}},
+			{ID: 2, OrganizationID: fcNantes, Name: "kick-off", MediaFiles: models.MediaFiles{ObjectKey: "kick-off.jpg"}, DeletedAt: deleted},
+		},
+		mediaTags: map[uint][]uint{1: {1}},
+		tags: []models.Tag{
+			{ID: 1, OrganizationID: fcNantes, Name: "fc-nantes"},
+			{ID: 2, OrganizationID: fcNantes, Name: "ligue-1", DeletedAt: deleted},
+		},
+		webhooks:   []models.Webhook{{ID: 1, OrganizationID: fcNantes, URL: "https://fc-nantes.example.com/webhook"}},
+		deliveries: []models.WebhookDelivery{{ID: 1, OrganizationID: fcNantes, WebhookID: 1, EventType: "media.created", Status: models.DeliverySucceeded}},
+	}
+	storage := NewMemoryStorage(StorageOptions{})
+	require.NoError(t, storage.PutObject(context.Background(), "goal.jpg", strings.NewReader("goal"), 4, "image/jpeg"))
+	mediaService := NewMediaService(&isolationMediaRepository{store: store}, &isolationTagRepository{store: store}, storage, nil, nil, nil, nil, nil)
+	tagService := NewTagService(&isolationTagRepository{store: store}, nil)
+	webhookService := NewWebhookService(&isolationWebhookRepository{store: store}, WebhookOptions{})
+	return mediaService, tagService, webhookService, store
+}
+
+// caller returns the context of a request authenticated in an organization
+func caller(organizationID uint) context.Context {
+	return WithIdentity(context.Background(), &Identity{Subject: "user-1", OrganizationID: organizationID, Scopes: Scopes})
+}
+
+func TestOrganizationsCannotReadEachOtherMedias(t *testing.T) {
+	mediaService, _, _, store := newIsolatedServices(t)
+
+	tests := []struct {
+		description    string
+		organizationID uint
+		expectedError  error
+	}{
+		{description: "The organization of a media should read it", organizationID: fcNantes},
+		{description: "Another organization should not find it", organizationID: psg, expectedError: repositories.ErrMediaNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			ctx := caller(tt.organizationID)
+
+			media, _, err := mediaService.GetMediaContent(ctx, "1")
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+				assert.Nil(t, media)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "goal", media.Name)
+			}
+
+			medias, err := mediaService.GetMedias(ctx, repositories.MediaFilter{Tag: "1"})
+			require.NoError(t, err)
+			if tt.expectedError != nil {
+				assert.Empty(t, medias, "the tags of another organization match no media")
+			} else {
+				assert.Len(t, medias, 1)
+			}
+
+			err = mediaService.DeleteMedia(ctx, "1")
+			assert.ErrorIs(t, err, tt.expectedError)
+			if tt.expectedError != nil {
+				assert.False(t, store.medias[0].DeletedAt.Valid, "medias are not deleted by another organization")
+			}
+			store.medias[0].DeletedAt = gorm.DeletedAt{}
+		})
+	}
+}
+
+func TestOrganizationsCannotRestoreEachOtherMedias(t *testing.T) {
+	mediaService, _, _, store := newIsolatedServices(t)
+
+	_, err := mediaService.RestoreMedia(caller(psg), "2")
+	assert.ErrorIs(t, err, repositories.ErrMediaNotFound)
+	assert.True(t, store.medias[1].DeletedAt.Valid, "medias are not restored by another organization")
+
+	media, err := mediaService.RestoreMedia(caller(fcNantes), "2")
+	require.NoError(t, err)
+	assert.Equal(t, "kick-off", media.Name)
+	assert.False(t, store.medias[1].DeletedAt.Valid)
+}
+
+func TestOrganizationsCannotReadEachOtherTags(t *testing.T) {
+	_, tagService, _, store := newIsolatedServices(t)
+
+	tags, err := tagService.GetTags(caller(psg), "")
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+	tags, err = tagService.GetTags(caller(psg), "nantes")
+	require.NoError(t, err)
+	assert.Empty(t, tags)
+
+	require.NoError(t, tagService.DeleteTag(caller(psg), "1"), "deleting a missing tag does nothing")
+	assert.False(t, store.tags[0].DeletedAt.Valid, "tags are not deleted by another organization")
+	_, err = tagService.RestoreTag(caller(psg), "2")
+	assert.ErrorIs(t, err, repositories.ErrTagNotFound)
+	assert.True(t, store.tags[1].DeletedAt.Valid, "tags are not restored by another organization")
+
+	tags, err = tagService.GetTags(caller(fcNantes), "")
+	require.NoError(t, err)
+	require.Len(t, tags, 1)
+	assert.Equal(t, "fc-nantes", tags[0].Name)
+	tag, err := tagService.RestoreTag(caller(fcNantes), "2")
+	require.NoError(t, err)
+	assert.Equal(t, "ligue-1", tag.Name)
+}
+
+func TestOrganizationsCannotReadEachOtherWebhookDeliveries(t *testing.T) {
+	_, _, webhookService, store := newIsolatedServices(t)
+
+	_, err := webhookService.GetDeliveries(caller(psg), 1, 10)
+	assert.ErrorIs(t, err, repositories.ErrWebhookNotFound)
+	_, err = webhookService.ReplayDelivery(caller(psg), 1)
+	assert.ErrorIs(t, err, repositories.ErrDeliveryNotFound)
+	assert.Len(t, store.deliveries, 1, "deliveries are not replayed by another organization")
+
+	deliveries, err := webhookService.GetDeliveries(caller(fcNantes), 1, 10)
+	require.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+	replay, err := webhookService.ReplayDelivery(caller(fcNantes), 1)
+	require.NoError(t, err)
+	assert.Equal(t, fcNantes, replay.OrganizationID)
+}
+
+func TestRequestsWithoutOrganizationFail(t *testing.T) {
+	mediaService, tagService, webhookService, _ := newIsolatedServices(t)
+	ctx := context.Background()
+
+	_, _, err := mediaService.GetMediaContent(ctx, "1")
+	assert.ErrorIs(t, err, repositories.ErrMissingTenant)
+	_, err = mediaService.GetMedias(ctx, repositories.MediaFilter{})
+	assert.ErrorIs(t, err, repositories.ErrMissingTenant)
+	_, err = tagService.GetTags(ctx, "")
+	assert.ErrorIs(t, err, repositories.ErrMissingTenant)
+	_, err = webhookService.GetDeliveries(ctx, 1, 10)
+	assert.ErrorIs(t, err, repositories.ErrMissingTenant)
+}
@@ -0,0 +1,414 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultRolesClaim        = "roles"
+	defaultOrganizationClaim = "org"
+	defaultJWKSRefresh       = time.Hour
+	// Minimum delay between two downloads of the keys when a token is signed by an unknown key
+	minJWKSRefresh = time.Minute
+	jwksTimeout    = 10 * time.Second
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Signing algorithms accepted for tokens, symmetric algorithms are rejected
+var tokenSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// TokenOptions configures the validation of the tokens issued by the identity provider
+type TokenOptions struct {
+	// Path or http(s) url of the JSON Web Key Set holding the public keys of the identity provider
+	JWKS string
+	// Expected iss and aud claims, not checked when empty
+	Issuer   string
+	Audience string
+	// Claim holding the roles of the user, nested claims are separated by dots (example: realm_access.roles)
+	RolesClaim string
+	// Claim holding the slug of the organization of the user
+	OrganizationClaim string
+	// Roles granted by the values of the roles claim which are not role names (example: media-admins -> admin)
+	RoleMapping map[string]string
+	// Delay before the keys downloaded from a url are downloaded again
+	Refresh time.Duration
+}
+
+// LoadTokenOptions reads JWT_JWKS, JWT_ISSUER, JWT_AUDIENCE, JWT_ROLES_CLAIM, JWT_ORGANIZATION_CLAIM,
+// JWT_ROLE_MAPPING and JWT_JWKS_REFRESH. Tokens are not accepted when JWT_JWKS is not set.
+func LoadTokenOptions() (TokenOptions, error) {
+	options := TokenOptions{
+		JWKS:              config.Config("JWT_JWKS"),
+		Issuer:            config.Config("JWT_ISSUER"),
+		Audience:          config.Config("JWT_AUDIENCE"),
+		RolesClaim:        config.Config("JWT_ROLES_CLAIM"),
+		OrganizationClaim: config.Config("JWT_ORGANIZATION_CLAIM"),
+		Refresh:           defaultJWKSRefresh,
+	}
+	if options.RolesClaim == "" {
+		options.RolesClaim = defaultRolesClaim
+	}
+	if options.OrganizationClaim == "" {
+		options.OrganizationClaim = defaultOrganizationClaim
+	}
+	if value := config.Config("JWT_ROLE_MAPPING"); value != "" {
+		mapping, err := ParseRoleMapping(value)
+		if err != nil {
+			return options, err
+		}
+		options.RoleMapping = mapping
+	}
+	if value := config.Config("JWT_JWKS_REFRESH"); value != "" {
+		refresh, err := time.ParseDuration(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid JWT_JWKS_REFRESH: %w", err)
+		}
+		options.Refresh = refresh
+	}
+	return options, nil
+}
+
+// ParseRoleMapping parses a comma separated list of <claim value>:<role> (example: media-admins:admin,staff:viewer)
+func ParseRoleMapping(value string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		claim, role, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || claim == "" {
+			return nil, fmt.Errorf("invalid JWT_ROLE_MAPPING entry %q, expected <claim value>:<role>", entry)
+		}
+		if _, known := RoleScopes[role]; !known {
+			return nil, fmt.Errorf("invalid JWT_ROLE_MAPPING entry %q: unknown role %s", entry, role)
+		}
+		mapping[claim] = role
+	}
+	return mapping, nil
+}
+
+// TokenVerifier validates the JWTs signed by the identity provider and returns the identity of their user
+type TokenVerifier struct {
+	options       TokenOptions
+	client        *http.Client
+	organizations *OrganizationService
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+	// requests needing new keys wait for the same download
+	downloads singleflight.Group
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// NewTokenVerifier loads the keys of the JWKS of options. The organizations of the users are looked up in organizations.
+func NewTokenVerifier(ctx context.Context, options TokenOptions, organizations *OrganizationService) (*TokenVerifier, error) {
+	if options.JWKS == "" {
+		return nil, errors.New("missing JWKS path or url")
+	}
+	if options.RolesClaim == "" {
+		options.RolesClaim = defaultRolesClaim
+	}
+	if options.OrganizationClaim == "" {
+		options.OrganizationClaim = defaultOrganizationClaim
+	}
+	if options.Refresh <= 0 {
+		options.Refresh = defaultJWKSRefresh
+	}
+	verifier := &TokenVerifier{
+		options:       options,
+		client:        &http.Client{Timeout: jwksTimeout},
+		organizations: organizations,
+		now:           time.Now,
+	}
+	if err := verifier.loadKeys(ctx); err != nil {
+		return nil, err
+	}
+	return verifier, nil
+}
+
+// Verify checks the signature, expiration, issuer and audience of a token and returns the identity of its user,
+// in the organization of its organization claim
+func (verifier *TokenVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(tokenSigningMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(verifier.now),
+	}
+	if verifier.options.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(verifier.options.Issuer))
+	}
+	if verifier.options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(verifier.options.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return verifier.key(kid)
+	}, parserOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+	slug, _ := claims[verifier.options.OrganizationClaim].(string)
+	if slug == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, verifier.options.OrganizationClaim)
+	}
+	organization, err := verifier.organizations.GetOrganization(slug)
+	if errors.Is(err, repositories.ErrOrganizationNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewUserIdentity(subject, displayName(claims), organization, verifier.roles(claims)), nil
+}
+
+// key returns the public key of a key id, keys are downloaded again when they are stale or the id is unknown.
+// Downloads are not bound to the request which started them, and the keys are only locked to be swapped, so that
+// the requests with known keys are not held by a slow identity provider.
+func (verifier *TokenVerifier) key(kid string) (crypto.PublicKey, error) {
+	verifier.mu.Lock()
+	key, found := verifier.lookup(kid)
+	sinceLoad := verifier.now().Sub(verifier.loadedAt)
+	verifier.mu.Unlock()
+	if isURL(verifier.options.JWKS) && (sinceLoad >= verifier.options.Refresh || (!found && sinceLoad >= minJWKSRefresh)) {
+		_, err, _ := verifier.downloads.Do(verifier.options.JWKS, func() (any, error) {
+			// the keys may have been downloaded by requests which needed them meanwhile
+			verifier.mu.Lock()
+			sinceLoad := verifier.now().Sub(verifier.loadedAt)
+			verifier.mu.Unlock()
+			if sinceLoad < minJWKSRefresh {
+				return nil, nil
+			}
+			return nil, verifier.loadKeys(context.Background())
+		})
+		if err != nil {
+			log.Printf("unable to refresh the keys of %s: %s\n", verifier.options.JWKS, err)
+		}
+		verifier.mu.Lock()
+		key, found = verifier.lookup(kid)
+		verifier.mu.Unlock()
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// lookup returns the key of an id, or the only key of the set for tokens without key id
+func (verifier *TokenVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(verifier.keys) == 1 {
+		for _, key := range verifier.keys {
+			return key, true
+		}
+	}
+	key, found := verifier.keys[kid]
+	return key, found
+}
+
+// loadKeys reads the keys of the JWKS and replaces the current keys with them
+func (verifier *TokenVerifier) loadKeys(ctx context.Context) error {
+	var data []byte
+	var err error
+	if isURL(verifier.options.JWKS) {
+		data, err = verifier.download(ctx)
+	} else {
+		data, err = os.ReadFile(verifier.options.JWKS)
+	}
+	verifier.mu.Lock()
+	defer verifier.mu.Unlock()
+	// a failed download is retried after the same delays as a successful one, not for every token
+	verifier.loadedAt = verifier.now()
+	if err != nil {
+		return fmt.Errorf("unable to read JWKS %s: %w", verifier.options.JWKS, err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	verifier.keys = keys
+	return nil
+}
+
+func (verifier *TokenVerifier) download(ctx context.Context) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, verifier.options.JWKS, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := verifier.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// roles returns the roles granted by the roles claim, values which are neither roles nor mapped are ignored
+func (verifier *TokenVerifier) roles(claims jwt.MapClaims) []string {
+	var value any = map[string]any(claims)
+	for _, name := range strings.Split(verifier.options.RolesClaim, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	var values []string
+	switch typed := value.(type) {
+	case string:
+		values = strings.FieldsFunc(typed, func(r rune) bool { return r == ' ' || r == ',' })
+	case []any:
+		for _, item := range typed {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+	}
+	var roles []string
+	for _, value := range values {
+		role, mapped := verifier.options.RoleMapping[value]
+		if !mapped {
+			role = value
+		}
+		if _, known := RoleScopes[role]; known {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// displayName returns the name of the user of a token, falling back on its email
+func displayName(claims jwt.MapClaims) string {
+	for _, claim := range []string{"name", "preferred_username", "email"} {
+		if value, ok := claims[claim].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "https://") || strings.HasPrefix(source, "http://")
+}
+
+// jsonWebKey holds the fields of the RSA and EC public keys of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS returns the RSA and EC signing keys of a JSON Web Key Set by key id, other keys are ignored
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		var publicKey crypto.PublicKey
+		var err error
+		switch key.Kty {
+		case "RSA":
+			publicKey, err = key.rsa()
+		case "EC":
+			publicKey, err = key.ecdsa()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", key.Kid, err)
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("invalid JWKS: no RSA or EC signing key")
+	}
+	return keys, nil
+}
+
+func (key jsonWebKey) rsa() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(key.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(key.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (key jsonWebKey) ecdsa() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch key.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+	}
+	x, err := decodeBigInt(key.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(key.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("point not on curve")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,340 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityProvider stands in for the identity provider: it signs tokens and publishes its public key
+type identityProvider struct {
+	t   *testing.T
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newIdentityProvider(t *testing.T, kid string) *identityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return &identityProvider{t: t, kid: kid, key: key}
+}
+
+func (provider *identityProvider) jwks() []byte {
+	data, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": provider.kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(provider.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.E)).Bytes()),
+	}}})
+	require.NoError(provider.t, err)
+	return data
+}
+
+// jwksFile writes the public key of the provider to a JWKS file
+func (provider *identityProvider) jwksFile() string {
+	path := filepath.Join(provider.t.TempDir(), "jwks.json")
+	require.NoError(provider.t, os.WriteFile(path, provider.jwks(), 0o600))
+	return path
+}
+
+func (provider *identityProvider) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = provider.kid
+	signed, err := token.SignedString(provider.key)
+	require.NoError(provider.t, err)
+	return signed
+}
+
+// organizationRepository keeps organizations in memory
+type organizationRepository struct {
+	organizations []models.Organization
+}
+
+func (repository *organizationRepository) Create(organization *models.Organization) error {
+	organization.ID = uint(len(repository.organizations) + 1)
+	repository.organizations = append(repository.organizations, *organization)
+	return nil
+}
+
+func (repository *organizationRepository) FindBySlug(slug string) (*models.Organization, error) {
+	for _, organization := range repository.organizations {
+		if organization.Slug == slug {
+			return &organization, nil
+		}
+	}
+	return nil, repositories.ErrOrganizationNotFound
+}
+
+func (repository *organizationRepository) Find() ([]models.Organization, error) {
+	return repository.organizations, nil
+}
+
+func (repository *organizationRepository) SetQuotas(slug string, storageQuota int64, mediaQuota int64) (*models.Organization, error) {
+	for i := range repository.organizations {
+		if repository.organizations[i].Slug == slug {
+			repository.organizations[i].StorageQuota, repository.organizations[i].MediaQuota = storageQuota, mediaQuota
+			return &repository.organizations[i], nil
+		}
+	}
+	return nil, repositories.ErrOrganizationNotFound
+}
+
+func newOrganizationService(t *testing.T, slugs ...string) *OrganizationService {
+	service := NewOrganizationService(&organizationRepository{})
+	for _, slug := range slugs {
+		_, err := service.CreateOrganization(slug, slug)
+		require.NoError(t, err)
+	}
+	return service
+}
+
+func TestVerifyToken(t *testing.T) {
+	provider := newIdentityProvider(t, "key-1")
+	other := newIdentityProvider(t, "key-1")
+	expiresAt := time.Now().Add(time.Hour).Unix()
+	verifier, err := NewTokenVerifier(context.Background(), TokenOptions{
+		JWKS:        provider.jwksFile(),
+		Issuer:      "https://id.scoreplay.test",
+		Audience:    "media-api",
+		RolesClaim:  "realm_access.roles",
+		RoleMapping: map[string]string{"media-admins": RoleAdmin},
+	}, newOrganizationService(t, "fc-nantes"))
+	require.NoError(t, err)
+
+	claims := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"sub":          "user-1",
+			"iss":          "https://id.scoreplay.test",
+			"aud":          "media-api",
+			"exp":          expiresAt,
+			"email":        "jane@scoreplay.test",
+			"org":          "fc-nantes",
+			"realm_access": map[string]any{"roles": []string{RoleEditor, "offline_access"}},
+		}
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil)).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		description      string
+		token            string
+		expectedIdentity *Identity
+	}{
+		{
+			description: "Tokens of the identity provider should grant the scopes of their roles",
+			token:       provider.sign(claims(nil)),
+			expectedIdentity: &Identity{
+				Subject:        "user-1",
+				Name:           "jane@scoreplay.test",
+				OrganizationID: 1,
+				Organization:   "fc-nantes",
+				Roles:          []string{RoleEditor},
+				Scopes:         []string{ScopeMediaRead, ScopeMediaWrite},
+			},
+		},
+		{
+			description: "Mapped claim values should grant their role",
+			token: provider.sign(claims(jwt.MapClaims{
+				"name":         "Jane",
+				"realm_access": map[string]any{"roles": []string{"media-admins"}},
+			})),
+			expectedIdentity: &Identity{
+				Subject:        "user-1",
+				Name:           "Jane",
+				OrganizationID: 1,
+				Organization:   "fc-nantes",
+				Roles:          []string{RoleAdmin},
+				Scopes:         Scopes,
+			},
+		},
+		{
+			description:      "Tokens without roles should not grant any scope",
+			token:            provider.sign(claims(jwt.MapClaims{"realm_access": nil})),
+			expectedIdentity: &Identity{Subject: "user-1", Name: "jane@scoreplay.test", OrganizationID: 1, Organization: "fc-nantes"},
+		},
+		{
+			description: "Tokens without organization should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"org": nil})),
+		},
+		{
+			description: "Tokens of an unknown organization should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"org": "stade-rennais"})),
+		},
+		{
+			description: "Expired tokens should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()})),
+		},
+		{
+			description: "Tokens without expiration should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"exp": nil})),
+		},
+		{
+			description: "Tokens of another issuer should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"iss": "https://id.example.com"})),
+		},
+		{
+			description: "Tokens for another audience should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"aud": "billing-api"})),
+		},
+		{
+			description: "Tokens without subject should be rejected",
+			token:       provider.sign(claims(jwt.MapClaims{"sub": nil})),
+		},
+		{
+			description: "Tokens signed by another key should be rejected",
+			token:       other.sign(claims(nil)),
+		},
+		{
+			description: "Tokens signed with a shared secret should be rejected",
+			token:       hmacToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			identity, err := verifier.Verify(context.Background(), tt.token)
+			if tt.expectedIdentity == nil {
+				assert.ErrorIs(t, err, ErrInvalidToken)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedIdentity, identity)
+		})
+	}
+}
+
+func TestTokenVerifierRefreshesKeys(t *testing.T) {
+	provider := newIdentityProvider(t, "key-1")
+	var current atomic.Pointer[identityProvider]
+	current.Store(provider)
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		w.Write(current.Load().jwks())
+	}))
+	defer server.Close()
+
+	verifier, err := NewTokenVerifier(context.Background(), TokenOptions{JWKS: server.URL}, newOrganizationService(t, "fc-nantes"))
+	require.NoError(t, err)
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "user-1", "exp": now.Add(time.Hour).Unix(), "roles": "viewer", "org": "fc-nantes"}
+	}
+
+	identity, err := verifier.Verify(context.Background(), provider.sign(claims()))
+	require.NoError(t, err)
+	assert.Equal(t, []string{ScopeMediaRead}, identity.Scopes)
+	assert.Equal(t, int32(1), downloads.Load())
+
+	// the provider rotates its key, tokens signed by the new key are accepted once the keys are downloaded again
+	rotated := newIdentityProvider(t, "key-2")
+	current.Store(rotated)
+	_, err = verifier.Verify(context.Background(), rotated.sign(claims()))
+	assert.ErrorIs(t, err, ErrInvalidToken, "keys are not downloaded again for every unknown key id")
+	assert.Equal(t, int32(1), downloads.Load())
+
+	now = now.Add(minJWKSRefresh)
+	_, err = verifier.Verify(context.Background(), rotated.sign(claims()))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), downloads.Load())
+
+	_, err = verifier.Verify(context.Background(), provider.sign(claims()))
+	assert.ErrorIs(t, err, ErrInvalidToken, "tokens signed by the previous key are rejected")
+}
+
+func TestTokenVerifierDownloadsKeysWithoutHoldingRequests(t *testing.T) {
+	provider := newIdentityProvider(t, "key-1")
+	rotated := newIdentityProvider(t, "key-2")
+	var downloads atomic.Int32
+	downloading, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if downloads.Add(1) == 1 {
+			w.Write(provider.jwks())
+			return
+		}
+		close(downloading)
+		<-release
+		w.Write(rotated.jwks())
+	}))
+	defer server.Close()
+
+	verifier, err := NewTokenVerifier(context.Background(), TokenOptions{JWKS: server.URL}, newOrganizationService(t, "fc-nantes"))
+	require.NoError(t, err)
+	now := time.Now().Add(minJWKSRefresh)
+	verifier.now = func() time.Time { return now }
+	claims := jwt.MapClaims{"sub": "user-1", "exp": now.Add(time.Hour).Unix(), "roles": "viewer", "org": "fc-nantes"}
+
+	// the requests signed by the new key are canceled by their clients, the download they started goes on
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := verifier.Verify(canceled, rotated.sign(claims))
+			errs <- err
+		}()
+	}
+	<-downloading
+
+	verified := make(chan error)
+	go func() {
+		_, err := verifier.Verify(context.Background(), provider.sign(claims))
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		assert.NoError(t, err, "tokens signed by a known key are verified during the download")
+	case <-time.After(5 * time.Second):
+		t.Fatal("tokens signed by a known key wait for the download")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), downloads.Load(), "the keys are downloaded once for the concurrent requests")
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping("media-admins:admin, staff:viewer")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"media-admins": RoleAdmin, "staff": RoleViewer}, mapping)
+
+	_, err = ParseRoleMapping("staff:owner")
+	assert.EqualError(t, err, `invalid JWT_ROLE_MAPPING entry "staff:owner": unknown role owner`)
+	_, err = ParseRoleMapping("staff")
+	assert.EqualError(t, err, `invalid JWT_ROLE_MAPPING entry "staff", expected <claim value>:<role>`)
+}
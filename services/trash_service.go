@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+	// Number of items loaded at once by the purge
+	trashPurgeBatchSize = 100
+	// Number of medias and of tags listed in the trash
+	maxTrashItems = 1000
+)
+
+// TrashOptions configures the retention of the deleted medias and tags
+type TrashOptions struct {
+	// Delay after which the items of the trash are deleted permanently
+	Retention time.Duration
+	// Delay between two purges of the trash
+	PurgeInterval time.Duration
+}
+
+// LoadTrashOptions reads TRASH_RETENTION and TRASH_PURGE_INTERVAL
+func LoadTrashOptions() (TrashOptions, error) {
+	options := TrashOptions{
+		Retention:     defaultTrashRetention,
+		PurgeInterval: defaultTrashPurgeInterval,
+	}
+	durations := map[string]*time.Duration{
+		"TRASH_RETENTION":      &options.Retention,
+		"TRASH_PURGE_INTERVAL": &options.PurgeInterval,
+	}
+	for key, target := range durations {
+		if value := config.Config(key); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return options, fmt.Errorf("invalid %s: %s", key, value)
+			}
+			*target = duration
+		}
+	}
+	return options, nil
+}
+
+// TrashService lists the medias and tags moved to the trash and deletes them permanently, with the files of the
+// medias, once their retention is over
+type TrashService struct {
+	mediaRepository repositories.IMediaRepository
+	tagRepository   repositories.ITagRepository
+	storage         IStorageService
+	// purged medias are removed from the usage of their organization when set
+	usage   *UsageService
+	audit   *AuditService
+	options TrashOptions
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewTrashService(mediaRepository repositories.IMediaRepository, tagRepository repositories.ITagRepository, storage IStorageService, usageService *UsageService, auditService *AuditService, options TrashOptions) *TrashService {
+	if options.Retention <= 0 {
+		options.Retention = defaultTrashRetention
+	}
+	if options.PurgeInterval <= 0 {
+		options.PurgeInterval = defaultTrashPurgeInterval
+	}
+	return &TrashService{
+		mediaRepository: mediaRepository,
+		tagRepository:   tagRepository,
+		storage:         storage,
+		usage:           usageService,
+		audit:           auditService,
+		options:         options,
+		now:             time.Now,
+	}
+}
+
+// GetTrash returns the medias and tags of the trash of the organization of ctx, last deleted first
+func (service *TrashService) GetTrash(ctx context.Context) ([]models.TrashItem, error) {
+	now := service.now()
+	medias, err := service.mediaRepository.FindDeleted(ctx, now, maxTrashItems)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := service.tagRepository.FindDeleted(ctx, now, maxTrashItems)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.TrashItem, 0, len(medias)+len(tags))
+	for _, media := range medias {
+		items = append(items, service.trashItem(models.TrashMedia, media.ID, media.Name, media.DeletedAt.Time))
+	}
+	for _, tag := range tags {
+		items = append(items, service.trashItem(models.TrashTag, tag.ID, tag.Name, tag.DeletedAt.Time))
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	return items, nil
+}
+
+func (service *TrashService) trashItem(itemType string, id uint, name string, deletedAt time.Time) models.TrashItem {
+	return models.TrashItem{
+		Type:      itemType,
+		ID:        id,
+		Name:      name,
+		DeletedAt: deletedAt,
+		PurgeAt:   deletedAt.Add(service.options.Retention),
+	}
+}
+
+// Run purges the trash every purge interval until ctx is done
+func (service *TrashService) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.options.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		if purged, err := service.Purge(ctx); err != nil {
+			log.Printf("unable to purge the trash: %s\n", err)
+		} else if purged > 0 {
+			log.Printf("%d item(s) purged from the trash\n", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes permanently the medias, with their files, and the tags of every organization deleted for longer
+// than the retention, and returns the number of items purged. Medias whose files cannot be deleted are kept for
+// the next purge.
+func (service *TrashService) Purge(ctx context.Context) (int, error) {
+	ctx = repositories.WithAllTenants(ctx)
+	before := service.now().Add(-service.options.Retention)
+	purged := 0
+	for {
+		medias, err := service.mediaRepository.FindDeleted(ctx, before, trashPurgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		progress := 0
+		for i := range medias {
+			if err := service.purgeMedia(ctx, &medias[i]); err != nil {
+				log.Printf("unable to purge media %d: %s\n", medias[i].ID, err)
+				continue
+			}
+			progress++
+		}
+		purged += progress
+		if len(medias) < trashPurgeBatchSize || progress == 0 {
+			break
+		}
+	}
+	for {
+		tags, err := service.tagRepository.FindDeleted(ctx, before, trashPurgeBatchSize)
+		if err != nil {
+			return purged, err
+		}
+		for i := range tags {
+			tenant := repositories.WithTenant(ctx, tags[i].OrganizationID)
+			if err := service.tagRepository.Purge(tenant, tags[i].ID); err != nil {
+				return purged, err
+			}
+			service.audit.Record(tenant, models.AuditPurge, models.AuditTag, auditID(tags[i].ID), &tags[i], nil)
+			purged++
+		}
+		if len(tags) < trashPurgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// purgeMedia deletes the files of a media and its rendered images, then the media and its associations to tags
+func (service *TrashService) purgeMedia(ctx context.Context, media *models.Media) error {
+	objectKeys := []string{}
+	if media.ObjectKey != "" {
+		objectKeys = append(objectKeys, media.ObjectKey)
+	}
+	for _, objectKey := range media.Renditions {
+		objectKeys = append(objectKeys, objectKey)
+	}
+	if media.ObjectKey != "" {
+		derived, err := service.storage.ListObjects(ctx, "derived/"+strings.TrimSuffix(media.ObjectKey, path.Ext(media.ObjectKey))+"/")
+		if err != nil {
+			return err
+		}
+		for _, object := range derived {
+			objectKeys = append(objectKeys, object.Key)
+		}
+	}
+	for _, objectKey := range objectKeys {
+		if err := service.storage.DeleteObject(ctx, objectKey); err != nil {
+			return err
+		}
+	}
+	tenant := repositories.WithTenant(ctx, media.OrganizationID)
+	if err := service.mediaRepository.Purge(tenant, media.ID); err != nil {
+		return err
+	}
+	if service.usage != nil {
+		tags := make([]string, 0, len(media.Tags))
+		for _, tag := range media.Tags {
+			tags = append(tags, tag.Name)
+		}
+		if err := service.usage.Remove(tenant, media, tags); err != nil {
+			log.Printf("unable to remove media %d from the usage: %s\n", media.ID, err)
+		}
+	}
+	service.audit.Record(tenant, models.AuditPurge, models.AuditMedia, auditID(media.ID), media, nil)
+	return nil
+}
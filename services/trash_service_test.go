@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// trashMediaRepository keeps medias in memory, only the methods used by the trash are implemented
+type trashMediaRepository struct {
+	repositories.IMediaRepository
+	medias []models.Media
+}
+
+func (repository *trashMediaRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Media, error) {
+	var medias []models.Media
+	for _, media := range repository.medias {
+		if media.DeletedAt.Valid && media.DeletedAt.Time.Before(before) && len(medias) < limit {
+			medias = append(medias, media)
+		}
+	}
+	return medias, nil
+}
+
+func (repository *trashMediaRepository) Purge(ctx context.Context, id uint) error {
+	if organizationID, _ := repositories.TenantFromContext(ctx); organizationID == 0 {
+		return repositories.ErrMissingTenant
+	}
+	for i, media := range repository.medias {
+		if media.ID == id {
+			repository.medias = append(repository.medias[:i], repository.medias[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// trashTagRepository keeps tags in memory, only the methods used by the trash are implemented
+type trashTagRepository struct {
+	repositories.ITagRepository
+	tags []models.Tag
+}
+
+func (repository *trashTagRepository) FindDeleted(ctx context.Context, before time.Time, limit int) ([]models.Tag, error) {
+	var tags []models.Tag
+	for _, tag := range repository.tags {
+		if tag.DeletedAt.Valid && tag.DeletedAt.Time.Before(before) && len(tags) < limit {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (repository *trashTagRepository) Purge(ctx context.Context, id uint) error {
+	for i, tag := range repository.tags {
+		if tag.ID == id {
+			repository.tags = append(repository.tags[:i], repository.tags[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// trashUsageRepository records the usage removed by the purges
+type trashUsageRepository struct {
+	repositories.IUsageRepository
+	released int64
+	counters []models.UsageCounter
+}
+
+func (repository *trashUsageRepository) Release(ctx context.Context, bytes int64) error {
+	repository.released += bytes
+	return nil
+}
+
+func (repository *trashUsageRepository) Record(ctx context.Context, counters []models.UsageCounter) error {
+	repository.counters = append(repository.counters, counters...)
+	return nil
+}
+
+// undeletableStorage cannot delete the objects whose name starts with locked
+type undeletableStorage struct {
+	*MemoryStorage
+}
+
+func (storage *undeletableStorage) DeleteObject(ctx context.Context, objectName string) error {
+	if strings.HasPrefix(objectName, "locked") {
+		return errors.New("access denied")
+	}
+	return storage.MemoryStorage.DeleteObject(ctx, objectName)
+}
+
+func deletedAt(date time.Time) gorm.DeletedAt {
+	return gorm.DeletedAt{Time: date, Valid: true}
+}
+
+func TestTrashServicePurgesExpiredItems(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	storage := &undeletableStorage{NewMemoryStorage(StorageOptions{})}
+	for _, objectName := range []string{"kick-off.png", "kick-off_thumb.webp", "derived/kick-off/0a1b2c.webp", "derived/kick-off/3d4e5f.jpg", "derived/kick-off-2/6a7b8c.jpg", "goal.png", "derived/goal/0a1b2c.webp", "locked.png"} {
+		require.NoError(t, storage.PutObject(ctx, objectName, strings.NewReader("image"), 5, "image/png"))
+	}
+	mediaRepository := &trashMediaRepository{medias: []models.Media{
+		{ID: 1, OrganizationID: 1, Name: "kick-off", FileSize: 5, ContentType: "image/png", MediaFiles: models.MediaFiles{ObjectKey: "kick-off.png", Renditions: models.RenditionMap{"thumb": "kick-off_thumb.webp"}},
+			Tags: []models.Tag{{ID: 1, Name: "fc-nantes"}}, DeletedAt: deletedAt(now.AddDate(0, 0, -31))},
+		{ID: 2, OrganizationID: 1, Name: "goal", FileSize: 5, MediaFiles: models.MediaFiles{ObjectKey: "goal.png"}, DeletedAt: deletedAt(now.AddDate(0, 0, -1))},
+		{ID: 3, OrganizationID: 2, Name: "locked", FileSize: 5, MediaFiles: models.MediaFiles{ObjectKey: "locked.png"}, DeletedAt: deletedAt(now.AddDate(0, 0, -40))},
+		{ID: 4, OrganizationID: 1, Name: "stadium"},
+	}}
+	tagRepository := &trashTagRepository{tags: []models.Tag{
+		{ID: 1, OrganizationID: 1, Name: "fc-nantes", DeletedAt: deletedAt(now.AddDate(0, 0, -35))},
+		{ID: 2, OrganizationID: 1, Name: "psg", DeletedAt: deletedAt(now.AddDate(0, 0, -2))},
+	}}
+	usageRepository := &trashUsageRepository{}
+	service := NewTrashService(mediaRepository, tagRepository, storage, NewUsageService(usageRepository), nil, TrashOptions{Retention: 30 * 24 * time.Hour})
+	service.now = func() time.Time { return now }
+	service.usage.now = service.now
+
+	purged, err := service.Purge(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, []uint{2, 3, 4}, mediaIDs(mediaRepository.medias), "medias are purged after the retention, unless their files cannot be deleted")
+	assert.Len(t, tagRepository.tags, 1)
+	assert.Equal(t, "psg", tagRepository.tags[0].Name)
+	objects, err := storage.ListObjects(ctx, "")
+	require.NoError(t, err)
+	var objectNames []string
+	for _, object := range objects {
+		objectNames = append(objectNames, object.Key)
+	}
+	assert.ElementsMatch(t, []string{"derived/kick-off-2/6a7b8c.jpg", "goal.png", "derived/goal/0a1b2c.webp", "locked.png"}, objectNames,
+		"the original file, renditions and rendered images of the purged medias are deleted")
+	assert.Equal(t, int64(5), usageRepository.released)
+	assert.Contains(t, usageRepository.counters, models.UsageCounter{Day: usageDay(now), Dimension: models.UsageTag, Key: "fc-nantes", Bytes: -5, Medias: -1})
+}
+
+func TestTrashServiceListsTrash(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	mediaRepository := &trashMediaRepository{medias: []models.Media{
+		{ID: 1, Name: "kick-off", DeletedAt: deletedAt(now.Add(-3 * time.Hour))},
+		{ID: 2, Name: "stadium"},
+	}}
+	tagRepository := &trashTagRepository{tags: []models.Tag{{ID: 5, Name: "goal", DeletedAt: deletedAt(now.Add(-time.Hour))}}}
+	service := NewTrashService(mediaRepository, tagRepository, nil, nil, nil, TrashOptions{Retention: 24 * time.Hour})
+	service.now = func() time.Time { return now }
+
+	items, err := service.GetTrash(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []models.TrashItem{
+		{Type: models.TrashTag, ID: 5, Name: "goal", DeletedAt: now.Add(-time.Hour), PurgeAt: now.Add(23 * time.Hour)},
+		{Type: models.TrashMedia, ID: 1, Name: "kick-off", DeletedAt: now.Add(-3 * time.Hour), PurgeAt: now.Add(21 * time.Hour)},
+	}, items)
+}
+
+func mediaIDs(medias []models.Media) []uint {
+	ids := []uint{}
+	for _, media := range medias {
+		ids = append(ids, media.ID)
+	}
+	return ids
+}
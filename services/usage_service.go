@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+// Longest period of the usage reports, in days
+const maxUsagePeriod = 366
+
+var ErrInvalidUsagePeriod = errors.New("invalid usage period")
+
+type UsageService struct {
+	repository repositories.IUsageRepository
+	now        func() time.Time
+}
+
+func NewUsageService(repository repositories.IUsageRepository) *UsageService {
+	return &UsageService{repository: repository, now: time.Now}
+}
+
+// Reserve adds a media of size bytes to the usage of the organization of ctx before it is uploaded. It fails with
+// ErrStorageQuotaExceeded or ErrMediaQuotaExceeded when the media does not fit in the quotas of the organization.
+func (service *UsageService) Reserve(ctx context.Context, bytes int64) error {
+	return service.repository.Reserve(ctx, bytes)
+}
+
+// Release removes a media from the usage of the organization of ctx, when its upload fails
+func (service *UsageService) Release(ctx context.Context, bytes int64) error {
+	return service.repository.Release(ctx, bytes)
+}
+
+// Record adds an uploaded media to the usage counters of the day: in total, for each of its tags and for its
+// content type
+func (service *UsageService) Record(ctx context.Context, media *models.Media, tags []string) error {
+	return service.repository.Record(ctx, service.counters(media, tags, 1))
+}
+
+// Remove removes a purged media from the usage of the organization of ctx, and from the usage counters of the day
+// in total, for each of its tags and for its content type
+func (service *UsageService) Remove(ctx context.Context, media *models.Media, tags []string) error {
+	if err := service.repository.Release(ctx, media.FileSize); err != nil {
+		return err
+	}
+	return service.repository.Record(ctx, service.counters(media, tags, -1))
+}
+
+// counters returns the usage counters of the day of a media, added or removed when sign is -1
+func (service *UsageService) counters(media *models.Media, tags []string, sign int64) []models.UsageCounter {
+	day := usageDay(service.now())
+	bytes := sign * media.FileSize
+	counters := []models.UsageCounter{
+		{Day: day, Dimension: models.UsageTotal, Bytes: bytes, Medias: sign},
+		{Day: day, Dimension: models.UsageContentType, Key: media.ContentType, Bytes: bytes, Medias: sign},
+	}
+	for _, tag := range tags {
+		counters = append(counters, models.UsageCounter{Day: day, Dimension: models.UsageTag, Key: tag, Bytes: bytes, Medias: sign})
+	}
+	return counters
+}
+
+// GetUsage returns the usage and quotas of the organization of ctx, and its usage at the end of each day
+// from `from` to `to`, in total, by tag and by content type
+func (service *UsageService) GetUsage(ctx context.Context, from time.Time, to time.Time) (*models.UsageReport, error) {
+	from, to = usageDay(from), usageDay(to)
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w: %s is before %s", ErrInvalidUsagePeriod, to.Format(time.DateOnly), from.Format(time.DateOnly))
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxUsagePeriod {
+		return nil, fmt.Errorf("%w: %d days requested, at most %d", ErrInvalidUsagePeriod, days, maxUsagePeriod)
+	}
+	usage, err := service.repository.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := service.repository.FindCounters(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.UsageReport{
+		Bytes:        usage.Bytes,
+		Medias:       usage.Medias,
+		Total:        []models.UsagePoint{},
+		Tags:         []models.UsageSeries{},
+		ContentTypes: []models.UsageSeries{},
+	}
+	if usage.Organization != nil {
+		report.Organization = usage.Organization.Slug
+		report.StorageQuota = usage.Organization.StorageQuota
+		report.MediaQuota = usage.Organization.MediaQuota
+	}
+	// counters are sorted by dimension and key, each run of counters is a series
+	for start := 0; start < len(counters); {
+		end := start
+		for end < len(counters) && counters[end].Dimension == counters[start].Dimension && counters[end].Key == counters[start].Key {
+			end++
+		}
+		points := usagePoints(counters[start:end], from, to)
+		switch counters[start].Dimension {
+		case models.UsageTotal:
+			report.Total = points
+		case models.UsageTag:
+			report.Tags = append(report.Tags, models.UsageSeries{Key: counters[start].Key, Points: points})
+		case models.UsageContentType:
+			report.ContentTypes = append(report.ContentTypes, models.UsageSeries{Key: counters[start].Key, Points: points})
+		}
+		start = end
+	}
+	return report, nil
+}
+
+// usagePoints sums the counters of a series, sorted by day, into its usage at the end of each day of a period
+func usagePoints(counters []models.UsageCounter, from time.Time, to time.Time) []models.UsagePoint {
+	var points []models.UsagePoint
+	var bytes, medias int64
+	next := 0
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		for next < len(counters) && counters[next].Day.UTC().Format(time.DateOnly) <= date {
+			bytes += counters[next].Bytes
+			medias += counters[next].Medias
+			next++
+		}
+		points = append(points, models.UsagePoint{Date: date, Bytes: bytes, Medias: medias})
+	}
+	return points
+}
+
+// usageDay returns the UTC day of a time
+func usageDay(date time.Time) time.Time {
+	year, month, day := date.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
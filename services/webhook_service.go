@@ -0,0 +1,424 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/config"
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+)
+
+const (
+	defaultWebhookMaxAttempts  = 8
+	defaultWebhookRetryDelay   = 30 * time.Second
+	defaultWebhookPollInterval = 5 * time.Second
+	defaultWebhookTimeout      = 10 * time.Second
+	// Maximum delay between two attempts of a delivery
+	maxWebhookBackoff = time.Hour
+	// Number of events or deliveries loaded at once
+	webhookBatchSize = 100
+	// Minimum length of the secrets chosen by the callers
+	minWebhookSecretLength = 16
+	// Maximum number of deliveries returned at once
+	MaxWebhookDeliveries = 1000
+)
+
+// Headers of the deliveries
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret of the webhook>
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+var (
+	ErrInvalidWebhook = errors.New("invalid webhook")
+	// Webhooks cannot reach the network of the API (metadata services, storage, database) unless allowed
+	ErrForbiddenWebhookAddress = errors.New("webhook address not allowed")
+)
+
+// WebhookOptions configures the deliveries of the webhooks
+type WebhookOptions struct {
+	// Number of attempts of a delivery before it fails
+	MaxAttempts int
+	// Delay before the first retry of a failed attempt, doubled at each new attempt
+	RetryDelay time.Duration
+	// Delay between two checks of the outbox and of the deliveries due
+	PollInterval time.Duration
+	// Maximum duration of an attempt
+	Timeout time.Duration
+	// Allows the webhooks to reach loopback, private and link-local addresses, for local development
+	AllowPrivateNetworks bool
+}
+
+// LoadWebhookOptions reads WEBHOOK_MAX_ATTEMPTS, WEBHOOK_RETRY_DELAY, WEBHOOK_POLL_INTERVAL, WEBHOOK_TIMEOUT and
+// WEBHOOK_ALLOW_PRIVATE_NETWORKS
+func LoadWebhookOptions() (WebhookOptions, error) {
+	options := WebhookOptions{
+		MaxAttempts:  defaultWebhookMaxAttempts,
+		RetryDelay:   defaultWebhookRetryDelay,
+		PollInterval: defaultWebhookPollInterval,
+		Timeout:      defaultWebhookTimeout,
+	}
+	if value := config.Config("WEBHOOK_MAX_ATTEMPTS"); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts <= 0 {
+			return options, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS: %s", value)
+		}
+		options.MaxAttempts = attempts
+	}
+	durations := map[string]*time.Duration{
+		"WEBHOOK_RETRY_DELAY":   &options.RetryDelay,
+		"WEBHOOK_POLL_INTERVAL": &options.PollInterval,
+		"WEBHOOK_TIMEOUT":       &options.Timeout,
+	}
+	for key, target := range durations {
+		if value := config.Config(key); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return options, fmt.Errorf("invalid %s: %s", key, value)
+			}
+			*target = duration
+		}
+	}
+	if value := config.Config("WEBHOOK_ALLOW_PRIVATE_NETWORKS"); value != "" {
+		allow, err := strconv.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("invalid WEBHOOK_ALLOW_PRIVATE_NETWORKS: %s", value)
+		}
+		options.AllowPrivateNetworks = allow
+	}
+	return options, nil
+}
+
+// WebhookService manages the webhooks of the organizations and sends them the events of the outbox, retrying
+// failed deliveries with an exponential backoff
+type WebhookService struct {
+	repository repositories.IWebhookRepository
+	options    WebhookOptions
+	client     *http.Client
+	wake       chan struct{}
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+func NewWebhookService(repository repositories.IWebhookRepository, options WebhookOptions) *WebhookService {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = defaultWebhookRetryDelay
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultWebhookPollInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultWebhookTimeout
+	}
+	return &WebhookService{
+		repository: repository,
+		options:    options,
+		client:     newWebhookClient(options),
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// CreateWebhook subscribes a URL to events of the organization of ctx. A secret is generated when none is given.
+func (service *WebhookService) CreateWebhook(ctx context.Context, webhookURL string, events []string, secret string) (*models.Webhook, error) {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https url", ErrInvalidWebhook)
+	}
+	if ip, err := netip.ParseAddr(strings.Trim(parsed.Hostname(), "[]")); err == nil && !service.options.AllowPrivateNetworks && forbiddenWebhookIP(ip) {
+		return nil, fmt.Errorf("%w: url must not target a loopback, private or link-local address", ErrInvalidWebhook)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w: at least one event is required", ErrInvalidWebhook)
+	}
+	subscribed := []string{}
+	for _, event := range events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			return nil, fmt.Errorf("%w: unknown event %s (available: %s)", ErrInvalidWebhook, event, strings.Join(models.WebhookEvents, ", "))
+		}
+		if !slices.Contains(subscribed, event) {
+			subscribed = append(subscribed, event)
+		}
+	}
+	if secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(random)
+	} else if len(secret) < minWebhookSecretLength {
+		return nil, fmt.Errorf("%w: secret must be at least %d characters", ErrInvalidWebhook, minWebhookSecretLength)
+	}
+	webhook := &models.Webhook{URL: webhookURL, Events: subscribed, Secret: secret}
+	if err := service.repository.Create(ctx, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// GetWebhooks returns the webhooks of the organization of ctx, without their secret
+func (service *WebhookService) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	webhooks, err := service.repository.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (service *WebhookService) DeleteWebhook(ctx context.Context, id uint) error {
+	return service.repository.Delete(ctx, id)
+}
+
+// GetDeliveries returns the last limit deliveries of a webhook, newest first
+func (service *WebhookService) GetDeliveries(ctx context.Context, webhookID uint, limit int) ([]models.WebhookDelivery, error) {
+	if limit < 1 || limit > MaxWebhookDeliveries {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidWebhook, MaxWebhookDeliveries)
+	}
+	return service.repository.FindDeliveries(ctx, webhookID, limit)
+}
+
+// ReplayDelivery sends again the event of a delivery to its webhook, as a new delivery
+func (service *WebhookService) ReplayDelivery(ctx context.Context, id uint) (*models.WebhookDelivery, error) {
+	delivery, err := service.repository.Replay(ctx, id, service.now())
+	if err != nil {
+		return nil, err
+	}
+	service.Wake()
+	return delivery, nil
+}
+
+// Wake checks the outbox and the deliveries due without waiting for the poll interval
+func (service *WebhookService) Wake() {
+	select {
+	case service.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches the events of the outbox and sends the deliveries due every poll interval until ctx is done
+func (service *WebhookService) Run(ctx context.Context) {
+	ticker := time.NewTicker(service.options.PollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := service.Dispatch(ctx); err != nil {
+			log.Printf("unable to dispatch webhook events: %s\n", err)
+		}
+		if _, err := service.DeliverDue(ctx); err != nil {
+			log.Printf("unable to send webhook deliveries: %s\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-service.wake:
+		}
+	}
+}
+
+// Dispatch moves the events of the outbox of every organization to the deliveries of their webhooks and returns
+// the number of events dispatched
+func (service *WebhookService) Dispatch(ctx context.Context) (int, error) {
+	ctx = repositories.WithAllTenants(ctx)
+	dispatched := 0
+	for {
+		count, err := service.repository.Dispatch(ctx, webhookBatchSize, service.now())
+		dispatched += count
+		if err != nil || count < webhookBatchSize {
+			return dispatched, err
+		}
+	}
+}
+
+// DeliverDue sends the deliveries whose next attempt is due and returns the number of deliveries which succeeded
+func (service *WebhookService) DeliverDue(ctx context.Context) (int, error) {
+	ctx = repositories.WithAllTenants(ctx)
+	delivered := 0
+	for {
+		now := service.now()
+		// the deliveries claimed are sent again after the timeout of every attempt of the batch if the instance stops
+		lockedUntil := now.Add(service.options.Timeout*webhookBatchSize + service.options.RetryDelay)
+		deliveries, err := service.repository.ClaimDue(ctx, now, lockedUntil, webhookBatchSize)
+		if err != nil {
+			return delivered, err
+		}
+		for i := range deliveries {
+			if err := service.Deliver(ctx, &deliveries[i]); err != nil {
+				log.Printf("unable to send webhook delivery %d (attempt %d): %s\n", deliveries[i].ID, deliveries[i].Attempts, err)
+				continue
+			}
+			delivered++
+		}
+		if len(deliveries) < webhookBatchSize {
+			return delivered, nil
+		}
+	}
+}
+
+// webhookBody is the body of the deliveries
+type webhookBody struct {
+	ID         uint            `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Deliver sends a delivery to its webhook and records the result of the attempt. A failed attempt is retried after
+// a delay doubled at each attempt, until the attempts are exhausted.
+func (service *WebhookService) Deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if delivery.Webhook == nil {
+		return fmt.Errorf("%w: %d", repositories.ErrWebhookNotFound, delivery.WebhookID)
+	}
+	responseStatus, err := service.send(ctx, delivery)
+
+	now := service.now()
+	delivery.Attempts++
+	delivery.ResponseStatus = responseStatus
+	if err != nil {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= service.options.MaxAttempts {
+			delivery.Status, delivery.NextAttemptAt = models.DeliveryFailed, nil
+		} else {
+			next := now.Add(webhookBackoff(service.options.RetryDelay, delivery.Attempts))
+			delivery.Status, delivery.NextAttemptAt = models.DeliveryPending, &next
+		}
+	} else {
+		delivery.Status, delivery.NextAttemptAt, delivery.LastError, delivery.DeliveredAt = models.DeliverySucceeded, nil, "", &now
+	}
+	tenant := repositories.WithTenant(ctx, delivery.OrganizationID)
+	if updateErr := service.repository.UpdateDelivery(tenant, delivery); updateErr != nil {
+		return updateErr
+	}
+	return err
+}
+
+// send posts the event of a delivery to its webhook and returns the HTTP status code of the response
+func (service *WebhookService) send(ctx context.Context, delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(webhookBody{
+		ID:         delivery.EventID,
+		Type:       delivery.EventType,
+		OccurredAt: delivery.OccurredAt,
+		Data:       delivery.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := service.now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "ScorePlay-Webhooks/1.0")
+	request.Header.Set(WebhookEventHeader, delivery.EventType)
+	request.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Webhook.Secret, timestamp, body))
+
+	response, err := service.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// the connection is reused once the body is read
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// newWebhookClient returns the client sending the deliveries. Redirects are not followed, and the addresses the
+// client connects to are checked once resolved, so that webhooks cannot reach the internal network through a DNS
+// name or a redirect.
+func newWebhookClient(options WebhookOptions) *http.Client {
+	dialer := &net.Dialer{Timeout: options.Timeout, KeepAlive: 30 * time.Second}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = webhookDialControl
+	}
+	return &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			// a proxy would be checked instead of the webhook
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: options.Timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookDialControl rejects the connections to the addresses webhooks must not reach
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenWebhookAddress, host)
+	}
+	if forbiddenWebhookIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenWebhookAddress, ip)
+	}
+	return nil
+}
+
+// Shared address space of carrier-grade NATs, not covered by netip.Addr.IsPrivate
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// forbiddenWebhookIP reports whether ip is a loopback, private, link-local, multicast or unspecified address
+func forbiddenWebhookIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// SignWebhook returns the signature of a delivery, sent in WebhookSignatureHeader: the HMAC-SHA256 of the timestamp
+// and the body of the delivery, keyed by the secret of the webhook
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff doubles the delay before each new attempt
+func webhookBackoff(delay time.Duration, attempts int) time.Duration {
+	for i := 1; i < attempts && delay < maxWebhookBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxWebhookBackoff)
+}
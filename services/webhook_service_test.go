@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mich31/scoreplay-media-api/models"
+	"github.com/mich31/scoreplay-media-api/repositories"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookRepository keeps deliveries in memory, only the methods used to send them are implemented
+type webhookRepository struct {
+	repositories.IWebhookRepository
+	deliveries []models.WebhookDelivery
+	webhooks   []models.Webhook
+}
+
+func (repository *webhookRepository) Create(ctx context.Context, webhook *models.Webhook) error {
+	webhook.ID = uint(len(repository.webhooks) + 1)
+	repository.webhooks = append(repository.webhooks, *webhook)
+	return nil
+}
+
+func (repository *webhookRepository) ClaimDue(ctx context.Context, now time.Time, lockedUntil time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	for i, delivery := range repository.deliveries {
+		if delivery.Status == models.DeliveryPending && !delivery.NextAttemptAt.After(now) && len(due) < limit {
+			repository.deliveries[i].NextAttemptAt = &lockedUntil
+			delivery.NextAttemptAt = &lockedUntil
+			delivery.Webhook = &repository.webhooks[0]
+			due = append(due, delivery)
+		}
+	}
+	return due, nil
+}
+
+func (repository *webhookRepository) UpdateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	for i := range repository.deliveries {
+		if repository.deliveries[i].ID == delivery.ID {
+			updated := *delivery
+			updated.Webhook = nil
+			repository.deliveries[i] = updated
+		}
+	}
+	return nil
+}
+
+func TestWebhookServiceDeliversSignedEvents(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	var received []*http.Request
+	var bodies [][]byte
+	statusCodes := []int{http.StatusServiceUnavailable, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received, bodies = append(received, r), append(bodies, body)
+		w.WriteHeader(statusCodes[len(received)-1])
+	}))
+	defer server.Close()
+
+	repository := &webhookRepository{
+		webhooks: []models.Webhook{{ID: 1, OrganizationID: 7, URL: server.URL, Secret: "0123456789abcdef"}},
+		deliveries: []models.WebhookDelivery{{
+			ID: 3, OrganizationID: 7, WebhookID: 1, EventID: 42, EventType: models.WebhookMediaCreated,
+			Payload: json.RawMessage(`{"id":1,"name":"kick-off"}`), OccurredAt: now.Add(-time.Minute),
+			Status: models.DeliveryPending, NextAttemptAt: &now,
+		}},
+	}
+	// the test server listens on the loopback address
+	service := NewWebhookService(repository, WebhookOptions{MaxAttempts: 3, RetryDelay: 30 * time.Second, AllowPrivateNetworks: true})
+	service.now = func() time.Time { return now }
+
+	delivered, err := service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered)
+	delivery := repository.deliveries[0]
+	assert.Equal(t, models.DeliveryPending, delivery.Status, "a failed attempt is retried")
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+	assert.Equal(t, "unexpected status 503", delivery.LastError)
+	assert.Equal(t, now.Add(30*time.Second), *delivery.NextAttemptAt)
+
+	delivered, err = service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, delivered, "the retry is not due yet")
+
+	now = now.Add(30 * time.Second)
+	delivered, err = service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	delivery = repository.deliveries[0]
+	assert.Equal(t, models.DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Equal(t, now, *delivery.DeliveredAt)
+	assert.Nil(t, delivery.NextAttemptAt)
+	assert.Empty(t, delivery.LastError)
+
+	require.Len(t, received, 2)
+	request, body := received[1], bodies[1]
+	assert.JSONEq(t, `{"id":42,"type":"media.created","occurredAt":"2024-03-09T20:59:00Z","data":{"id":1,"name":"kick-off"}}`, string(body))
+	assert.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	assert.Equal(t, models.WebhookMediaCreated, request.Header.Get(WebhookEventHeader))
+	assert.Equal(t, "3", request.Header.Get(WebhookDeliveryHeader))
+	timestamp, err := strconv.ParseInt(request.Header.Get(WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, now.Unix(), timestamp)
+	assert.Equal(t, SignWebhook("0123456789abcdef", timestamp, body), request.Header.Get(WebhookSignatureHeader))
+}
+
+func TestWebhookServiceStopsAfterMaxAttempts(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	repository := &webhookRepository{
+		webhooks: []models.Webhook{{ID: 1, URL: server.URL, Secret: "0123456789abcdef"}},
+		deliveries: []models.WebhookDelivery{{
+			ID: 3, WebhookID: 1, Payload: json.RawMessage(`{}`), Status: models.DeliveryPending, Attempts: 2, NextAttemptAt: &now,
+		}},
+	}
+	service := NewWebhookService(repository, WebhookOptions{MaxAttempts: 3, AllowPrivateNetworks: true})
+	service.now = func() time.Time { return now }
+
+	_, err := service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, models.DeliveryFailed, repository.deliveries[0].Status)
+	assert.Equal(t, 3, repository.deliveries[0].Attempts)
+	assert.Nil(t, repository.deliveries[0].NextAttemptAt)
+}
+
+func TestWebhookServiceRejectsInternalAddresses(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	called := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer internal.Close()
+	// a public name resolving to the internal server, as a DNS record pointing to 127.0.0.1 would
+	webhookURL := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+
+	repository := &webhookRepository{
+		webhooks: []models.Webhook{{ID: 1, URL: webhookURL, Secret: "0123456789abcdef"}},
+		deliveries: []models.WebhookDelivery{{
+			ID: 3, WebhookID: 1, Payload: json.RawMessage(`{}`), Status: models.DeliveryPending, NextAttemptAt: &now,
+		}},
+	}
+	service := NewWebhookService(repository, WebhookOptions{MaxAttempts: 3})
+	service.now = func() time.Time { return now }
+
+	_, err := service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.False(t, called, "internal addresses are not reached")
+	assert.Equal(t, 1, repository.deliveries[0].Attempts)
+	assert.Contains(t, repository.deliveries[0].LastError, ErrForbiddenWebhookAddress.Error())
+}
+
+func TestWebhookServiceDoesNotFollowRedirects(t *testing.T) {
+	now := time.Date(2024, time.March, 9, 21, 0, 0, 0, time.UTC)
+	redirected := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	repository := &webhookRepository{
+		webhooks: []models.Webhook{{ID: 1, URL: server.URL, Secret: "0123456789abcdef"}},
+		deliveries: []models.WebhookDelivery{{
+			ID: 3, WebhookID: 1, Payload: json.RawMessage(`{}`), Status: models.DeliveryPending, NextAttemptAt: &now,
+		}},
+	}
+	service := NewWebhookService(repository, WebhookOptions{MaxAttempts: 3, AllowPrivateNetworks: true})
+	service.now = func() time.Time { return now }
+
+	_, err := service.DeliverDue(context.Background())
+	require.NoError(t, err)
+	assert.False(t, redirected)
+	assert.Equal(t, models.DeliveryPending, repository.deliveries[0].Status, "redirects are failed attempts")
+	assert.Equal(t, http.StatusTemporaryRedirect, repository.deliveries[0].ResponseStatus)
+}
+
+func TestForbiddenWebhookIP(t *testing.T) {
+	tests := []struct {
+		ip        string
+		forbidden bool
+	}{
+		{ip: "127.0.0.1", forbidden: true},
+		{ip: "::1", forbidden: true},
+		{ip: "10.0.0.12", forbidden: true},
+		{ip: "172.16.5.4", forbidden: true},
+		{ip: "192.168.1.10", forbidden: true},
+		{ip: "169.254.169.254", forbidden: true},
+		{ip: "fe80::1", forbidden: true},
+		{ip: "fd00::1", forbidden: true},
+		{ip: "0.0.0.0", forbidden: true},
+		{ip: "100.64.0.1", forbidden: true},
+		{ip: "::ffff:10.0.0.1", forbidden: true},
+		{ip: "93.184.216.34", forbidden: false},
+		{ip: "2606:4700::1111", forbidden: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.forbidden, forbiddenWebhookIP(netip.MustParseAddr(tt.ip)))
+		})
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, webhookBackoff(30*time.Second, 1))
+	assert.Equal(t, 2*time.Minute, webhookBackoff(30*time.Second, 3))
+	assert.Equal(t, time.Hour, webhookBackoff(30*time.Second, 20))
+}
+
+func TestCreateWebhook(t *testing.T) {
+	tests := []struct {
+		description   string
+		url           string
+		events        []string
+		secret        string
+		expectedError string
+	}{
+		{description: "A webhook without secret should be given one", url: "https://cms.example.com/hooks", events: []string{models.WebhookMediaCreated, models.WebhookMediaCreated}},
+		{description: "A webhook should keep its secret", url: "http://cms.internal/hooks", events: []string{models.WebhookTagDeleted}, secret: "a-long-enough-secret"},
+		{description: "A relative url should be rejected", url: "/hooks", events: []string{models.WebhookMediaCreated}, expectedError: "invalid webhook: url must be an absolute http or https url"},
+		{description: "Other schemes should be rejected", url: "ftp://cms.example.com", events: []string{models.WebhookMediaCreated}, expectedError: "invalid webhook: url must be an absolute http or https url"},
+		{description: "Internal addresses should be rejected", url: "http://169.254.169.254/latest/meta-data", events: []string{models.WebhookMediaCreated},
+			expectedError: "invalid webhook: url must not target a loopback, private or link-local address"},
+		{description: "Internal IPv6 addresses should be rejected", url: "http://[::1]:9000/medias", events: []string{models.WebhookMediaCreated},
+			expectedError: "invalid webhook: url must not target a loopback, private or link-local address"},
+		{description: "A webhook without event should be rejected", url: "https://cms.example.com/hooks", expectedError: "invalid webhook: at least one event is required"},
+		{description: "Unknown events should be rejected", url: "https://cms.example.com/hooks", events: []string{"media.renamed"},
+			expectedError: "invalid webhook: unknown event media.renamed (available: media.created, media.updated, media.deleted, media.restored, tag.created, tag.deleted, tag.restored)"},
+		{description: "Short secrets should be rejected", url: "https://cms.example.com/hooks", events: []string{models.WebhookMediaCreated}, secret: "secret", expectedError: "invalid webhook: secret must be at least 16 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			service := NewWebhookService(&webhookRepository{}, WebhookOptions{})
+			webhook, err := service.CreateWebhook(context.Background(), tt.url, tt.events, tt.secret)
+			if tt.expectedError != "" {
+				assert.ErrorIs(t, err, ErrInvalidWebhook)
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.url, webhook.URL)
+			assert.Len(t, webhook.Events, 1, "events are subscribed once")
+			if tt.secret != "" {
+				assert.Equal(t, tt.secret, webhook.Secret)
+			} else {
+				assert.Len(t, webhook.Secret, 64)
+			}
+		})
+	}
+}